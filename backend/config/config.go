@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -14,12 +15,16 @@ type Config struct {
 	Database DatabaseConfig
 	JWT      JWTConfig
 	MoMo     MoMoConfig
+	Payment  PaymentConfig
 	SMS      SMSConfig
+	Email    EmailConfig
+	Passes   PassConfig
 	USSD     USSDConfig
 	Upload   UploadConfig
 	Admin    AdminConfig
 	Features FeatureConfig
 	CORS     CORSConfig
+	Live     LiveConfig
 }
 
 type ServerConfig struct {
@@ -42,21 +47,64 @@ type MoMoConfig struct {
 	APISecret   string
 	Environment string
 	CallbackURL string
+	BaseURL     string // overrides the API host derived from Environment, e.g. for a local fake
+}
+
+type PaymentConfig struct {
+	Providers       []string           // enabled payment providers
+	DefaultProvider string             // used when neither the request nor the event picks one
+	DefaultCurrency string             // ISO 4217 code for events that do not set one
+	FeeRates        map[string]float64 // share of each payment a provider keeps, by provider
+
+	ReconcileInterval time.Duration // how often payments are checked against their provider
+	ReconcileAfter    time.Duration // how long a payment waits for its callback before its provider is asked
+	ReconcileLookback time.Duration // how far back settled payments are rechecked
 }
 
 type SMSConfig struct {
-	APIKey    string
-	APISecret string
-	SenderID  string
+	APIKey       string
+	APISecret    string
+	SenderID     string
+	Provider     string             // mtn or africastalking
+	Username     string             // Africa's Talking application username
+	BaseURL      string             // overrides the provider's API host, e.g. for a local fake
+	Workers      int                // messages sent at once by each instance
+	MaxAttempts  int                // tries before a message is marked failed
+	RateLimits   map[string]float64 // messages per second each instance may send, by provider
+	ReceiptToken string             // required on delivery receipts when set
+}
+
+type EmailConfig struct {
+	SMTPHost     string
+	SMTPPort     int // 465 connects over TLS; other ports upgrade with STARTTLS when offered
+	SMTPUsername string
+	SMTPPassword string
+	From         string
+	FromName     string
+}
+
+// PassConfig sets up downloadable tickets. Apple Wallet passes need all the
+// Apple settings and Google Wallet passes the Google ones; each is offered
+// only when configured.
+type PassConfig struct {
+	Brand                 string // name on PDF tickets and wallet passes
+	ApplePassTypeID       string
+	AppleTeamID           string
+	AppleCertFile         string // PEM pass type certificate
+	AppleKeyFile          string // PEM private key of the certificate
+	AppleWWDRCertFile     string // PEM Apple WWDR intermediate certificate
+	GoogleIssuerID        string
+	GoogleCredentialsFile string // service account JSON key
 }
 
 type USSDConfig struct {
 	Code           string
 	SessionTimeout int
+	Gateway        string // request and reply format: json, africastalking or hubtel
 }
 
 type UploadConfig struct {
-	Path       string
+	Path        string
 	MaxFileSize int64
 }
 
@@ -67,17 +115,24 @@ type AdminConfig struct {
 }
 
 type FeatureConfig struct {
-	EnableUSSD bool
-	EnableQR   bool
-	EnableSMS  bool
-	EnableEmail bool
-	CommissionRate float64
+	EnableUSSD         bool
+	EnableQR           bool
+	EnableSMS          bool
+	EnableEmail        bool
+	CommissionRate     float64
+	TicketHoldDuration time.Duration
 }
 
 type CORSConfig struct {
 	AllowedOrigins []string
 }
 
+// LiveConfig sets up the WebSocket live updates
+type LiveConfig struct {
+	Broker     string // how instances share messages: mongo, or memory for a single instance
+	SendBuffer int    // messages queued for a client before it is dropped as too slow
+}
+
 var AppConfig *Config
 
 func LoadConfig() {
@@ -104,18 +159,55 @@ func LoadConfig() {
 			APISecret:   getEnv("MOMO_API_SECRET", ""),
 			Environment: getEnv("MOMO_ENVIRONMENT", "sandbox"),
 			CallbackURL: getEnv("MOMO_CALLBACK_URL", "http://localhost:8080/api/payment/callback"),
+			BaseURL:     getEnv("MOMO_BASE_URL", ""),
+		},
+		Payment: PaymentConfig{
+			Providers:       getStringSliceEnv("PAYMENT_PROVIDERS", []string{"momo", "cash"}),
+			DefaultProvider: getEnv("DEFAULT_PAYMENT_PROVIDER", "momo"),
+			DefaultCurrency: strings.ToUpper(getEnv("DEFAULT_CURRENCY", "EUR")),
+			FeeRates:        getFloatMapEnv("PAYMENT_FEE_RATES", map[string]float64{}),
+
+			ReconcileInterval: getDurationEnv("RECONCILE_INTERVAL", 5*time.Minute),
+			ReconcileAfter:    getDurationEnv("RECONCILE_AFTER", 10*time.Minute),
+			ReconcileLookback: getDurationEnv("RECONCILE_LOOKBACK", 7*24*time.Hour),
 		},
 		SMS: SMSConfig{
-			APIKey:    getEnv("SMS_API_KEY", ""),
-			APISecret: getEnv("SMS_API_SECRET", ""),
-			SenderID:  getEnv("SMS_SENDER_ID", "EventTix"),
+			APIKey:       getEnv("SMS_API_KEY", ""),
+			APISecret:    getEnv("SMS_API_SECRET", ""),
+			SenderID:     getEnv("SMS_SENDER_ID", "EventTix"),
+			Provider:     strings.ToLower(getEnv("SMS_PROVIDER", "mtn")),
+			Username:     getEnv("SMS_USERNAME", ""),
+			BaseURL:      getEnv("SMS_BASE_URL", ""),
+			Workers:      getIntEnv("SMS_WORKERS", 4),
+			MaxAttempts:  getIntEnv("SMS_MAX_ATTEMPTS", 6),
+			RateLimits:   getFloatMapEnv("SMS_RATE_LIMITS", map[string]float64{"mtn": 10, "africastalking": 10}),
+			ReceiptToken: getEnv("SMS_RECEIPT_TOKEN", ""),
+		},
+		Email: EmailConfig{
+			SMTPHost:     getEnv("SMTP_HOST", "localhost"),
+			SMTPPort:     getIntEnv("SMTP_PORT", 587),
+			SMTPUsername: getEnv("SMTP_USERNAME", ""),
+			SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+			From:         getEnv("EMAIL_FROM", "tickets@eventticketing.com"),
+			FromName:     getEnv("EMAIL_FROM_NAME", "EventTix"),
+		},
+		Passes: PassConfig{
+			Brand:                 getEnv("TICKET_BRAND", "EventTix"),
+			ApplePassTypeID:       getEnv("APPLE_PASS_TYPE_ID", ""),
+			AppleTeamID:           getEnv("APPLE_TEAM_ID", ""),
+			AppleCertFile:         getEnv("APPLE_PASS_CERT", ""),
+			AppleKeyFile:          getEnv("APPLE_PASS_KEY", ""),
+			AppleWWDRCertFile:     getEnv("APPLE_WWDR_CERT", ""),
+			GoogleIssuerID:        getEnv("GOOGLE_WALLET_ISSUER_ID", ""),
+			GoogleCredentialsFile: getEnv("GOOGLE_WALLET_CREDENTIALS", ""),
 		},
 		USSD: USSDConfig{
 			Code:           getEnv("USSD_CODE", "*123#"),
 			SessionTimeout: getIntEnv("USSD_SESSION_TIMEOUT", 300),
+			Gateway:        strings.ToLower(getEnv("USSD_GATEWAY", "json")),
 		},
 		Upload: UploadConfig{
-			Path:       getEnv("UPLOAD_PATH", "./uploads"),
+			Path:        getEnv("UPLOAD_PATH", "./uploads"),
 			MaxFileSize: getInt64Env("MAX_FILE_SIZE", 5242880), // 5MB
 		},
 		Admin: AdminConfig{
@@ -124,15 +216,20 @@ func LoadConfig() {
 			Phone:    getEnv("ADMIN_PHONE", "+1234567890"),
 		},
 		Features: FeatureConfig{
-			EnableUSSD:      getBoolEnv("ENABLE_USSD", true),
-			EnableQR:        getBoolEnv("ENABLE_QR", true),
-			EnableSMS:       getBoolEnv("ENABLE_SMS", true),
-			EnableEmail:     getBoolEnv("ENABLE_EMAIL", false),
-			CommissionRate:  getFloatEnv("COMMISSION_RATE", 0.05),
+			EnableUSSD:         getBoolEnv("ENABLE_USSD", true),
+			EnableQR:           getBoolEnv("ENABLE_QR", true),
+			EnableSMS:          getBoolEnv("ENABLE_SMS", true),
+			EnableEmail:        getBoolEnv("ENABLE_EMAIL", false),
+			CommissionRate:     getFloatEnv("COMMISSION_RATE", 0.05),
+			TicketHoldDuration: getDurationEnv("TICKET_HOLD_DURATION", 15*time.Minute),
 		},
 		CORS: CORSConfig{
 			AllowedOrigins: getStringSliceEnv("ALLOWED_ORIGINS", []string{"http://localhost:3000", "http://localhost:3001"}),
 		},
+		Live: LiveConfig{
+			Broker:     strings.ToLower(getEnv("LIVE_BROKER", "mongo")),
+			SendBuffer: getIntEnv("WEBSOCKET_SEND_BUFFER", 64),
+		},
 	}
 }
 
@@ -191,8 +288,32 @@ func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
 func getStringSliceEnv(key string, defaultValue []string) []string {
 	if value := os.Getenv(key); value != "" {
 		// Simple comma-separated values
-		// In production, you might want more sophisticated parsing
-		return []string{value}
+		var values []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+		return values
 	}
 	return defaultValue
-} 
\ No newline at end of file
+}
+
+// getFloatMapEnv reads comma-separated name=value pairs, e.g. "momo=0.01,cash=0"
+func getFloatMapEnv(key string, defaultValue map[string]float64) map[string]float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	values := map[string]float64{}
+	for _, item := range strings.Split(value, ",") {
+		name, number, found := strings.Cut(item, "=")
+		if !found {
+			continue
+		}
+		if floatValue, err := strconv.ParseFloat(strings.TrimSpace(number), 64); err == nil {
+			values[strings.TrimSpace(name)] = floatValue
+		}
+	}
+	return values
+}
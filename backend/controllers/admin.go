@@ -2,11 +2,13 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
 	"eventticketing/models"
+	"eventticketing/services"
 	"eventticketing/utils"
 
 	"github.com/gin-gonic/gin"
@@ -17,39 +19,58 @@ import (
 )
 
 type AdminController struct {
-	userCollection    *mongo.Collection
-	eventCollection   *mongo.Collection
-	ticketCollection  *mongo.Collection
-	paymentCollection *mongo.Collection
+	userCollection        *mongo.Collection
+	eventCollection       *mongo.Collection
+	ticketCollection      *mongo.Collection
+	orderCollection       *mongo.Collection
+	paymentCollection     *mongo.Collection
+	callbackLogCollection *mongo.Collection
+	settingsService       *services.SettingsService
+	resendService         *services.TicketResendService
 }
 
+// CurrencyTotals are amounts in minor units keyed by currency code. Amounts in
+// different currencies are never added together.
+type CurrencyTotals map[string]int64
+
 type DashboardStats struct {
-	TotalUsers     int64   `json:"total_users"`
-	TotalEvents    int64   `json:"total_events"`
-	TotalTickets   int64   `json:"total_tickets"`
-	TotalRevenue   float64 `json:"total_revenue"`
-	ActiveEvents   int64   `json:"active_events"`
-	PendingTickets int64   `json:"pending_tickets"`
-	TodaySales     float64 `json:"today_sales"`
-	ThisMonthSales float64 `json:"this_month_sales"`
+	TotalUsers     int64          `json:"total_users"`
+	TotalEvents    int64          `json:"total_events"`
+	TotalTickets   int64          `json:"total_tickets"`
+	TotalRevenue   CurrencyTotals `json:"total_revenue"`
+	ActiveEvents   int64          `json:"active_events"`
+	PendingTickets int64          `json:"pending_tickets"`
+	TodaySales     CurrencyTotals `json:"today_sales"`
+	ThisMonthSales CurrencyTotals `json:"this_month_sales"`
 }
 
 type AnalyticsData struct {
-	DailySales    []DailySale    `json:"daily_sales"`
-	EventStats    []EventStat    `json:"event_stats"`
-	UserStats     []UserStat     `json:"user_stats"`
-	PaymentStats  []PaymentStat  `json:"payment_stats"`
+	DailySales    []DailySale   `json:"daily_sales"`
+	EventStats    []EventStat   `json:"event_stats"`
+	UserStats     []UserStat    `json:"user_stats"`
+	PaymentStats  []PaymentStat `json:"payment_stats"`
+	RevenueByTier []TierRevenue `json:"revenue_by_tier"`
 }
 
 type DailySale struct {
-	Date  string  `json:"date"`
-	Sales float64 `json:"sales"`
+	Date     string `json:"date"`
+	Currency string `json:"currency"`
+	Sales    int64  `json:"sales"`
 }
 
 type EventStat struct {
-	EventTitle string `json:"event_title"`
+	EventTitle  string `json:"event_title"`
 	TicketsSold int64  `json:"tickets_sold"`
-	Revenue     float64 `json:"revenue"`
+	Currency    string `json:"currency"`
+	Revenue     int64  `json:"revenue"`
+}
+
+type TierRevenue struct {
+	EventTitle  string `json:"event_title"`
+	TicketType  string `json:"ticket_type"`
+	TicketsSold int64  `json:"tickets_sold"`
+	Currency    string `json:"currency"`
+	Revenue     int64  `json:"revenue"`
 }
 
 type UserStat struct {
@@ -58,17 +79,22 @@ type UserStat struct {
 }
 
 type PaymentStat struct {
-	Status string `json:"status"`
-	Count  int64  `json:"count"`
-	Amount float64 `json:"amount"`
+	Status   string `json:"status"`
+	Currency string `json:"currency"`
+	Count    int64  `json:"count"`
+	Amount   int64  `json:"amount"`
 }
 
 func NewAdminController() *AdminController {
 	return &AdminController{
-		userCollection:    utils.GetCollection("users"),
-		eventCollection:   utils.GetCollection("events"),
-		ticketCollection:  utils.GetCollection("tickets"),
-		paymentCollection: utils.GetCollection("payments"),
+		userCollection:        utils.GetCollection("users"),
+		eventCollection:       utils.GetCollection("events"),
+		ticketCollection:      utils.GetCollection("tickets"),
+		orderCollection:       utils.GetCollection("orders"),
+		paymentCollection:     utils.GetCollection("payments"),
+		callbackLogCollection: utils.GetCollection("callback_logs"),
+		settingsService:       services.NewSettingsService(),
+		resendService:         services.NewTicketResendService(),
 	}
 }
 
@@ -86,20 +112,7 @@ func (ac *AdminController) GetDashboard(c *gin.Context) {
 	stats.TotalTickets, _ = ac.ticketCollection.CountDocuments(context.Background(), bson.M{})
 
 	// Get total revenue
-	pipeline := []bson.M{
-		{"$match": bson.M{"status": "success"}},
-		{"$group": bson.M{"_id": nil, "total": bson.M{"$sum": "$amount"}}},
-	}
-	cursor, err := ac.paymentCollection.Aggregate(context.Background(), pipeline)
-	if err == nil {
-		defer cursor.Close(context.Background())
-		var result []bson.M
-		if cursor.All(context.Background(), &result) == nil && len(result) > 0 {
-			if total, ok := result[0]["total"].(float64); ok {
-				stats.TotalRevenue = total
-			}
-		}
-	}
+	stats.TotalRevenue = ac.sumPaymentsByCurrency(bson.M{"status": "success"})
 
 	// Get active events
 	stats.ActiveEvents, _ = ac.eventCollection.CountDocuments(context.Background(), bson.M{"status": "active"})
@@ -109,45 +122,44 @@ func (ac *AdminController) GetDashboard(c *gin.Context) {
 
 	// Get today's sales
 	today := time.Now().Truncate(24 * time.Hour)
-	todayPipeline := []bson.M{
-		{"$match": bson.M{
-			"status": "success",
-			"created_at": bson.M{"$gte": today},
-		}},
-		{"$group": bson.M{"_id": nil, "total": bson.M{"$sum": "$amount"}}},
+	stats.TodaySales = ac.sumPaymentsByCurrency(bson.M{
+		"status":     "success",
+		"created_at": bson.M{"$gte": today},
+	})
+
+	// Get this month's sales
+	monthStart := time.Now().Truncate(24*time.Hour).AddDate(0, 0, -time.Now().Day()+1)
+	stats.ThisMonthSales = ac.sumPaymentsByCurrency(bson.M{
+		"status":     "success",
+		"created_at": bson.M{"$gte": monthStart},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"dashboard": stats})
+}
+
+// sumPaymentsByCurrency totals the amounts of payments matching filter in each currency
+func (ac *AdminController) sumPaymentsByCurrency(filter bson.M) CurrencyTotals {
+	totals := CurrencyTotals{}
+	pipeline := []bson.M{
+		{"$match": filter},
+		{"$group": bson.M{"_id": "$currency", "total": bson.M{"$sum": "$amount"}}},
 	}
-	cursor, err = ac.paymentCollection.Aggregate(context.Background(), todayPipeline)
-	if err == nil {
-		defer cursor.Close(context.Background())
-		var result []bson.M
-		if cursor.All(context.Background(), &result) == nil && len(result) > 0 {
-			if total, ok := result[0]["total"].(float64); ok {
-				stats.TodaySales = total
-			}
-		}
+	cursor, err := ac.paymentCollection.Aggregate(context.Background(), pipeline)
+	if err != nil {
+		return totals
 	}
+	defer cursor.Close(context.Background())
 
-	// Get this month's sales
-	monthStart := time.Now().Truncate(24 * time.Hour).AddDate(0, 0, -time.Now().Day()+1)
-	monthPipeline := []bson.M{
-		{"$match": bson.M{
-			"status": "success",
-			"created_at": bson.M{"$gte": monthStart},
-		}},
-		{"$group": bson.M{"_id": nil, "total": bson.M{"$sum": "$amount"}}},
+	var results []struct {
+		Currency string `bson:"_id"`
+		Total    int64  `bson:"total"`
 	}
-	cursor, err = ac.paymentCollection.Aggregate(context.Background(), monthPipeline)
-	if err == nil {
-		defer cursor.Close(context.Background())
-		var result []bson.M
-		if cursor.All(context.Background(), &result) == nil && len(result) > 0 {
-			if total, ok := result[0]["total"].(float64); ok {
-				stats.ThisMonthSales = total
-			}
+	if cursor.All(context.Background(), &results) == nil {
+		for _, result := range results {
+			totals[result.Currency] = result.Total
 		}
 	}
-
-	c.JSON(http.StatusOK, gin.H{"dashboard": stats})
+	return totals
 }
 
 // GetAllUsers returns all users with pagination
@@ -350,7 +362,7 @@ func (ac *AdminController) GetAllTickets(c *gin.Context) {
 		// Get event and user details
 		var event models.Event
 		var user models.User
-		
+
 		err := ac.eventCollection.FindOne(context.Background(), bson.M{"_id": ticket.EventID}).Decode(&event)
 		if err == nil {
 			err = ac.userCollection.FindOne(context.Background(), bson.M{"_id": ticket.UserID}).Decode(&user)
@@ -381,6 +393,50 @@ func (ac *AdminController) GetAllTickets(c *gin.Context) {
 	})
 }
 
+// ResendTicket texts a ticket's code to its buyer, or to the phone given,
+// for support agents helping a customer who lost it
+func (ac *AdminController) ResendTicket(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	ticketID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket ID"})
+		return
+	}
+
+	// The body is optional
+	var req models.ResendTicketRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+	}
+
+	err = ac.resendService.ResendTicket(context.Background(), ticketID, req.Phone, models.ResendChannelAdmin, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrTicketNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Ticket not found"})
+		case errors.Is(err, services.ErrTicketNotResendable):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Only paid, unused tickets can be resent"})
+		case errors.Is(err, services.ErrNoPhoneNumber):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The buyer has no phone number; give one to send the ticket to"})
+		case errors.Is(err, services.ErrSMSDisabled):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "SMS is currently unavailable"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resend ticket"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Ticket resent"})
+}
+
 // GetAllPayments returns all payments with pagination
 func (ac *AdminController) GetAllPayments(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
@@ -417,23 +473,23 @@ func (ac *AdminController) GetAllPayments(c *gin.Context) {
 
 	var responses []models.PaymentResponse
 	for _, payment := range payments {
-		// Get event, ticket, and user details
+		// Get event, order, and user details
 		var event models.Event
-		var ticket models.Ticket
+		var order models.Order
 		var user models.User
-		
+
 		err := ac.eventCollection.FindOne(context.Background(), bson.M{"_id": payment.EventID}).Decode(&event)
 		if err == nil {
-			err = ac.ticketCollection.FindOne(context.Background(), bson.M{"_id": payment.TicketID}).Decode(&ticket)
+			err = ac.orderCollection.FindOne(context.Background(), bson.M{"_id": payment.OrderID}).Decode(&order)
 			if err == nil {
 				err = ac.userCollection.FindOne(context.Background(), bson.M{"_id": payment.UserID}).Decode(&user)
 				if err == nil {
-					responses = append(responses, payment.ToResponseWithDetails(user.ToResponse(), event.ToResponse(), ticket.ToResponse()))
+					responses = append(responses, payment.ToResponseWithDetails(user.ToResponse(), event.ToResponse(), order.ToResponse()))
 				} else {
-					responses = append(responses, payment.ToResponseWithDetails(models.UserResponse{}, event.ToResponse(), ticket.ToResponse()))
+					responses = append(responses, payment.ToResponseWithDetails(models.UserResponse{}, event.ToResponse(), order.ToResponse()))
 				}
 			} else {
-				responses = append(responses, payment.ToResponseWithDetails(models.UserResponse{}, event.ToResponse(), models.TicketResponse{}))
+				responses = append(responses, payment.ToResponseWithDetails(models.UserResponse{}, event.ToResponse(), models.OrderResponse{}))
 			}
 		} else {
 			responses = append(responses, payment.ToResponse())
@@ -457,36 +513,119 @@ func (ac *AdminController) GetAllPayments(c *gin.Context) {
 	})
 }
 
-// GetSettings returns system settings
+// GetCallbackLogs returns the audit log of payment provider callbacks (admin only)
+func (ac *AdminController) GetCallbackLogs(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	outcome := c.Query("outcome")
+	provider := c.Query("provider")
+
+	filter := bson.M{}
+	if outcome != "" {
+		filter["outcome"] = outcome
+	}
+	if provider != "" {
+		filter["provider"] = provider
+	}
+
+	skip := (page - 1) * limit
+	opts := options.Find().
+		SetLimit(int64(limit)).
+		SetSkip(int64(skip)).
+		SetSort(bson.M{"received_at": -1})
+
+	cursor, err := ac.callbackLogCollection.Find(context.Background(), filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch callback logs"})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var logs []models.CallbackLog
+	if err = cursor.All(context.Background(), &logs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode callback logs"})
+		return
+	}
+
+	total, err := ac.callbackLogCollection.CountDocuments(context.Background(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count callback logs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"callbacks": logs,
+		"pagination": gin.H{
+			"page":  page,
+			"limit": limit,
+			"total": total,
+			"pages": (int(total) + limit - 1) / limit,
+		},
+	})
+}
+
+// GetSettings returns the system settings in effect
 func (ac *AdminController) GetSettings(c *gin.Context) {
-	settings := gin.H{
-		"commission_rate": config.AppConfig.Features.CommissionRate,
-		"enable_ussd":     config.AppConfig.Features.EnableUSSD,
-		"enable_qr":       config.AppConfig.Features.EnableQR,
-		"enable_sms":      config.AppConfig.Features.EnableSMS,
-		"enable_email":    config.AppConfig.Features.EnableEmail,
+	settings, err := ac.settingsService.Load(context.Background())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch settings"})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"settings": settings})
 }
 
-// UpdateSettings updates system settings
+// UpdateSettings changes the system settings named in the request. Every
+// instance picks up the new version without a restart.
 func (ac *AdminController) UpdateSettings(c *gin.Context) {
-	var req struct {
-		CommissionRate float64 `json:"commission_rate"`
-		EnableUSSD     bool    `json:"enable_ussd"`
-		EnableQR       bool    `json:"enable_qr"`
-		EnableSMS      bool    `json:"enable_sms"`
-		EnableEmail    bool    `json:"enable_email"`
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
 	}
+
+	var req models.UpdateSettingsRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	settings, err := ac.settingsService.Update(context.Background(), &req, user)
+	if err != nil {
+		if errors.Is(err, services.ErrSettingsConflict) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Settings have changed since that version; reload and try again"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Settings updated successfully",
+		"settings": settings,
+	})
+}
+
+// GetSettingsHistory returns every version of the settings, newest first
+func (ac *AdminController) GetSettingsHistory(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+
+	history, total, err := ac.settingsService.History(context.Background(), page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch settings history"})
 		return
 	}
 
-	// In a real implementation, you would update these settings in a database
-	// For now, we'll just return success
-	c.JSON(http.StatusOK, gin.H{"message": "Settings updated successfully"})
+	c.JSON(http.StatusOK, gin.H{
+		"history": history,
+		"pagination": gin.H{
+			"page":  page,
+			"limit": limit,
+			"total": total,
+			"pages": (int(total) + limit - 1) / limit,
+		},
+	})
 }
 
 // GetAnalytics returns analytics data
@@ -496,27 +635,35 @@ func (ac *AdminController) GetAnalytics(c *gin.Context) {
 	// Get daily sales for the last 30 days
 	dailySalesPipeline := []bson.M{
 		{"$match": bson.M{
-			"status": "success",
+			"status":     "success",
 			"created_at": bson.M{"$gte": time.Now().AddDate(0, 0, -30)},
 		}},
 		{"$group": bson.M{
-			"_id": bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$created_at"}},
+			"_id": bson.M{
+				"date":     bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$created_at"}},
+				"currency": "$currency",
+			},
 			"sales": bson.M{"$sum": "$amount"},
 		}},
-		{"$sort": bson.M{"_id": 1}},
+		{"$sort": bson.D{{Key: "_id.date", Value: 1}, {Key: "_id.currency", Value: 1}}},
 	}
 
 	cursor, err := ac.paymentCollection.Aggregate(context.Background(), dailySalesPipeline)
 	if err == nil {
 		defer cursor.Close(context.Background())
-		var results []bson.M
+		var results []struct {
+			ID struct {
+				Date     string `bson:"date"`
+				Currency string `bson:"currency"`
+			} `bson:"_id"`
+			Sales int64 `bson:"sales"`
+		}
 		if cursor.All(context.Background(), &results) == nil {
 			for _, result := range results {
-				date, _ := result["_id"].(string)
-				sales, _ := result["sales"].(float64)
 				analytics.DailySales = append(analytics.DailySales, DailySale{
-					Date:  date,
-					Sales: sales,
+					Date:     result.ID.Date,
+					Currency: result.ID.Currency,
+					Sales:    result.Sales,
 				})
 			}
 		}
@@ -532,8 +679,9 @@ func (ac *AdminController) GetAnalytics(c *gin.Context) {
 		}},
 		{"$project": bson.M{
 			"title":        1,
+			"currency":     1,
 			"tickets_sold": bson.M{"$size": "$tickets"},
-			"revenue": bson.M{"$sum": "$tickets.price"},
+			"revenue":      bson.M{"$sum": "$tickets.price"},
 		}},
 		{"$sort": bson.M{"tickets_sold": -1}},
 		{"$limit": 10},
@@ -542,16 +690,68 @@ func (ac *AdminController) GetAnalytics(c *gin.Context) {
 	cursor, err = ac.eventCollection.Aggregate(context.Background(), eventStatsPipeline)
 	if err == nil {
 		defer cursor.Close(context.Background())
-		var results []bson.M
+		var results []struct {
+			Title       string `bson:"title"`
+			Currency    string `bson:"currency"`
+			TicketsSold int64  `bson:"tickets_sold"`
+			Revenue     int64  `bson:"revenue"`
+		}
 		if cursor.All(context.Background(), &results) == nil {
 			for _, result := range results {
-				title, _ := result["title"].(string)
-				ticketsSold, _ := result["tickets_sold"].(int64)
-				revenue, _ := result["revenue"].(float64)
 				analytics.EventStats = append(analytics.EventStats, EventStat{
-					EventTitle:  title,
-					TicketsSold: ticketsSold,
-					Revenue:     revenue,
+					EventTitle:  result.Title,
+					TicketsSold: result.TicketsSold,
+					Currency:    result.Currency,
+					Revenue:     result.Revenue,
+				})
+			}
+		}
+	}
+
+	// Get revenue by ticket type; tickets of untiered events count as general admission
+	tierRevenuePipeline := []bson.M{
+		{"$match": bson.M{"status": bson.M{"$in": []string{"paid", "used"}}}},
+		{"$group": bson.M{
+			"_id": bson.M{
+				"event_id":    "$event_id",
+				"ticket_type": bson.M{"$ifNull": bson.A{"$ticket_type_name", models.GeneralAdmission}},
+			},
+			"tickets_sold": bson.M{"$sum": "$quantity"},
+			"revenue":      bson.M{"$sum": "$price"},
+		}},
+		{"$lookup": bson.M{
+			"from":         "events",
+			"localField":   "_id.event_id",
+			"foreignField": "_id",
+			"as":           "event",
+		}},
+		{"$unwind": "$event"},
+		{"$sort": bson.M{"revenue": -1}},
+		{"$limit": 50},
+	}
+
+	cursor, err = ac.ticketCollection.Aggregate(context.Background(), tierRevenuePipeline)
+	if err == nil {
+		defer cursor.Close(context.Background())
+		var results []struct {
+			ID struct {
+				TicketType string `bson:"ticket_type"`
+			} `bson:"_id"`
+			Event struct {
+				Title    string `bson:"title"`
+				Currency string `bson:"currency"`
+			} `bson:"event"`
+			TicketsSold int64 `bson:"tickets_sold"`
+			Revenue     int64 `bson:"revenue"`
+		}
+		if cursor.All(context.Background(), &results) == nil {
+			for _, result := range results {
+				analytics.RevenueByTier = append(analytics.RevenueByTier, TierRevenue{
+					EventTitle:  result.Event.Title,
+					TicketType:  result.ID.TicketType,
+					TicketsSold: result.TicketsSold,
+					Currency:    result.Event.Currency,
+					Revenue:     result.Revenue,
 				})
 			}
 		}
@@ -584,7 +784,7 @@ func (ac *AdminController) GetAnalytics(c *gin.Context) {
 	// Get payment statistics by status
 	paymentStatsPipeline := []bson.M{
 		{"$group": bson.M{
-			"_id":    "$status",
+			"_id":    bson.M{"status": "$status", "currency": "$currency"},
 			"count":  bson.M{"$sum": 1},
 			"amount": bson.M{"$sum": "$amount"},
 		}},
@@ -593,20 +793,25 @@ func (ac *AdminController) GetAnalytics(c *gin.Context) {
 	cursor, err = ac.paymentCollection.Aggregate(context.Background(), paymentStatsPipeline)
 	if err == nil {
 		defer cursor.Close(context.Background())
-		var results []bson.M
+		var results []struct {
+			ID struct {
+				Status   string `bson:"status"`
+				Currency string `bson:"currency"`
+			} `bson:"_id"`
+			Count  int64 `bson:"count"`
+			Amount int64 `bson:"amount"`
+		}
 		if cursor.All(context.Background(), &results) == nil {
 			for _, result := range results {
-				status, _ := result["_id"].(string)
-				count, _ := result["count"].(int64)
-				amount, _ := result["amount"].(float64)
 				analytics.PaymentStats = append(analytics.PaymentStats, PaymentStat{
-					Status: status,
-					Count:  count,
-					Amount: amount,
+					Status:   result.ID.Status,
+					Currency: result.ID.Currency,
+					Count:    result.Count,
+					Amount:   result.Amount,
 				})
 			}
 		}
 	}
 
 	c.JSON(http.StatusOK, gin.H{"analytics": analytics})
-} 
\ No newline at end of file
+}
@@ -2,10 +2,13 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"time"
 
+	"eventticketing/i18n"
 	"eventticketing/models"
+	"eventticketing/services"
 	"eventticketing/utils"
 
 	"github.com/gin-gonic/gin"
@@ -16,11 +19,13 @@ import (
 
 type AuthController struct {
 	userCollection *mongo.Collection
+	accountService *services.AccountService
 }
 
 func NewAuthController() *AuthController {
 	return &AuthController{
 		userCollection: utils.GetCollection("users"),
+		accountService: services.NewAccountService(),
 	}
 }
 
@@ -32,6 +37,14 @@ func (ac *AuthController) Register(c *gin.Context) {
 		return
 	}
 
+	language := ""
+	if req.Language != "" {
+		if language = i18n.Supported(req.Language); language == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported language"})
+			return
+		}
+	}
+
 	// Check if user already exists
 	var existingUser models.User
 	err := ac.userCollection.FindOne(context.Background(), bson.M{
@@ -42,6 +55,10 @@ func (ac *AuthController) Register(c *gin.Context) {
 	}).Decode(&existingUser)
 
 	if err == nil {
+		if existingUser.USSDOnly && existingUser.Phone == req.Phone {
+			c.JSON(http.StatusConflict, gin.H{"error": "This phone number has an account from USSD purchases; verify the number to claim it"})
+			return
+		}
 		c.JSON(http.StatusConflict, gin.H{"error": "User with this email or phone already exists"})
 		return
 	}
@@ -54,6 +71,7 @@ func (ac *AuthController) Register(c *gin.Context) {
 		Password:  req.Password,
 		Role:      req.Role,
 		IsActive:  true,
+		Language:  language,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
@@ -95,9 +113,12 @@ func (ac *AuthController) Login(c *gin.Context) {
 		return
 	}
 
-	// Find user by email
+	// Find user by email; USSD accounts have no password until claimed
 	var user models.User
-	err := ac.userCollection.FindOne(context.Background(), bson.M{"email": req.Email}).Decode(&user)
+	err := ac.userCollection.FindOne(context.Background(), bson.M{
+		"email":     req.Email,
+		"ussd_only": bson.M{"$ne": true},
+	}).Decode(&user)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
@@ -129,6 +150,14 @@ func (ac *AuthController) Login(c *gin.Context) {
 	})
 }
 
+// GetLanguages lists the languages users can choose for USSD and SMS
+func (ac *AuthController) GetLanguages(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"languages": i18n.Languages,
+		"default":   i18n.DefaultLanguage,
+	})
+}
+
 // GetCurrentUser returns the current authenticated user
 func (ac *AuthController) GetCurrentUser(c *gin.Context) {
 	user, exists := utils.GetUserFromContext(c)
@@ -169,11 +198,23 @@ func (ac *AuthController) UpdateProfile(c *gin.Context) {
 			"_id":   bson.M{"$ne": user.ID},
 		}).Decode(&existingUser)
 		if err == nil {
+			if existingUser.USSDOnly {
+				c.JSON(http.StatusConflict, gin.H{"error": "This phone number has tickets bought over USSD; verify the number to add them to your account"})
+				return
+			}
 			c.JSON(http.StatusConflict, gin.H{"error": "Phone number already taken"})
 			return
 		}
 		update["phone"] = req.Phone
 	}
+	if req.Language != "" {
+		language := i18n.Supported(req.Language)
+		if language == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported language"})
+			return
+		}
+		update["language"] = language
+	}
 
 	if len(update) == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No fields to update"})
@@ -205,4 +246,122 @@ func (ac *AuthController) UpdateProfile(c *gin.Context) {
 		"message": "Profile updated successfully",
 		"user":    updatedUser.ToResponse(),
 	})
-} 
\ No newline at end of file
+}
+
+// SendClaimCode texts a verification code to a phone with a USSD account
+func (ac *AuthController) SendClaimCode(c *gin.Context) {
+	var req models.PhoneCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Phone == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	if err := ac.accountService.SendClaimCode(context.Background(), req.Phone); err != nil {
+		respondAccountError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verification code sent"})
+}
+
+// ClaimAccount turns the USSD account on a verified phone into a web
+// account, keeping the tickets bought over USSD
+func (ac *AuthController) ClaimAccount(c *gin.Context) {
+	var req models.ClaimAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+	if req.Phone == "" || req.Code == "" || req.Email == "" || len(req.Name) < 2 || len(req.Password) < 6 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Phone, code, name, email and a password of at least 6 characters are required"})
+		return
+	}
+
+	user, err := ac.accountService.ClaimAccount(context.Background(), &req)
+	if err != nil {
+		respondAccountError(c, err)
+		return
+	}
+
+	token, err := utils.GenerateToken(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Account claimed successfully",
+		"token":   token,
+		"user":    user.ToResponse(),
+	})
+}
+
+// SendPhoneCode texts a verification code to a phone the current user
+// wants to add to their account
+func (ac *AuthController) SendPhoneCode(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req models.PhoneCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Phone == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	if err := ac.accountService.SendVerificationCode(context.Background(), user, req.Phone); err != nil {
+		respondAccountError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verification code sent"})
+}
+
+// VerifyPhone adds a verified phone to the current user's account. Tickets
+// bought over USSD from that phone move to the account.
+func (ac *AuthController) VerifyPhone(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req models.VerifyPhoneRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Phone == "" || req.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	updatedUser, err := ac.accountService.VerifyPhone(context.Background(), user, req.Phone, req.Code)
+	if err != nil {
+		respondAccountError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Phone number verified",
+		"user":    updatedUser.ToResponse(),
+	})
+}
+
+// respondAccountError answers with the status for an account service error
+func respondAccountError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrNoUSSDAccount):
+		c.JSON(http.StatusNotFound, gin.H{"error": "No USSD account for this phone number"})
+	case errors.Is(err, services.ErrPhoneTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": "Phone number already taken"})
+	case errors.Is(err, services.ErrEmailTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already taken"})
+	case errors.Is(err, services.ErrPhoneCodeTooSoon):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "A code was sent recently; wait a minute before asking again"})
+	case errors.Is(err, services.ErrInvalidPhoneCode):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification code"})
+	case errors.Is(err, services.ErrSMSDisabled):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "SMS is currently unavailable"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process request"})
+	}
+}
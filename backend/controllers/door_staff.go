@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"eventticketing/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxDoorStaff caps how many people can check one event's tickets
+const maxDoorStaff = 100
+
+// GetDoorStaff lists the people who check an event's tickets at the door
+// besides its organizer
+func (ec *EventController) GetDoorStaff(c *gin.Context) {
+	event, ok := ec.loadManagedEvent(c)
+	if !ok {
+		return
+	}
+
+	staff := []models.UserResponse{}
+	if len(event.DoorStaff) > 0 {
+		var err error
+		staff, err = ec.findDoorStaff(context.Background(), bson.M{"_id": bson.M{"$in": event.DoorStaff}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch door staff"})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"door_staff": staff})
+}
+
+// UpdateDoorStaff replaces the people who check an event's tickets at the
+// door. They can verify tickets and see door stats for this event only.
+func (ec *EventController) UpdateDoorStaff(c *gin.Context) {
+	event, ok := ec.loadManagedEvent(c)
+	if !ok {
+		return
+	}
+
+	var req models.UpdateDoorStaffRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+	if len(req.Emails) > maxDoorStaff {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Too many door staff"})
+		return
+	}
+
+	emails := make([]string, 0, len(req.Emails))
+	for _, email := range req.Emails {
+		if email = strings.TrimSpace(email); email != "" {
+			emails = append(emails, email)
+		}
+	}
+	staff, err := ec.findDoorStaff(context.Background(), bson.M{"email": bson.M{"$in": emails}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch door staff"})
+		return
+	}
+
+	// Every email must belong to an account the staff member signs in with
+	found := make(map[string]bool, len(staff))
+	ids := make([]primitive.ObjectID, 0, len(staff))
+	for _, user := range staff {
+		found[user.Email] = true
+		ids = append(ids, user.ID)
+	}
+	for _, email := range emails {
+		if !found[email] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No account found for " + email})
+			return
+		}
+	}
+
+	_, err = ec.eventCollection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": event.ID},
+		bson.M{"$set": bson.M{"door_staff": ids, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update door staff"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Door staff updated successfully",
+		"door_staff": staff,
+	})
+}
+
+// findDoorStaff returns the accounts matching filter
+func (ec *EventController) findDoorStaff(ctx context.Context, filter bson.M) ([]models.UserResponse, error) {
+	cursor, err := ec.userCollection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	staff := make([]models.UserResponse, 0, len(users))
+	for _, user := range users {
+		staff = append(staff, user.ToResponse())
+	}
+	return staff, nil
+}
@@ -2,11 +2,15 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"log"
 	"net/http"
 	"strconv"
 	"time"
 
+	"eventticketing/config"
 	"eventticketing/models"
+	"eventticketing/services"
 	"eventticketing/utils"
 
 	"github.com/gin-gonic/gin"
@@ -17,14 +21,22 @@ import (
 )
 
 type EventController struct {
-	eventCollection  *mongo.Collection
-	userCollection   *mongo.Collection
+	eventCollection *mongo.Collection
+	userCollection  *mongo.Collection
+	seatingService  *services.SeatingService
+	providers       *services.PaymentProviders
+	emailService    *services.EmailService
+	pricingService  *services.PricingService
 }
 
 func NewEventController() *EventController {
 	return &EventController{
 		eventCollection: utils.GetCollection("events"),
 		userCollection:  utils.GetCollection("users"),
+		seatingService:  services.NewSeatingService(),
+		providers:       services.DefaultPaymentProviders(),
+		emailService:    services.NewEmailService(),
+		pricingService:  services.NewPricingService(),
 	}
 }
 
@@ -144,21 +156,64 @@ func (ec *EventController) CreateEvent(c *gin.Context) {
 		return
 	}
 
+	currency, ok := eventCurrency(req.Currency)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported currency"})
+		return
+	}
+
 	// Create event
 	event := models.Event{
-		Title:       req.Title,
-		Description: req.Description,
-		Date:        req.Date,
-		Location:    req.Location,
-		Price:       req.Price,
-		MaxTickets:  req.MaxTickets,
-		SoldTickets: 0,
-		Status:      "active",
-		Category:    req.Category,
-		ImageURL:    req.ImageURL,
-		OrganizerID: user.ID,
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
+		Title:            req.Title,
+		Description:      req.Description,
+		Date:             req.Date,
+		Location:         req.Location,
+		Price:            req.Price,
+		Currency:         currency,
+		MaxTickets:       req.MaxTickets,
+		SoldTickets:      0,
+		Status:           "active",
+		Category:         req.Category,
+		ImageURL:         req.ImageURL,
+		OrganizerID:      user.ID,
+		PaymentProviders: req.PaymentProviders,
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
+	}
+
+	if !ec.checkPaymentProviders(c, req.PaymentProviders) {
+		return
+	}
+
+	// Tiers replace the single price and capacity when given
+	if len(req.TicketTypes) > 0 {
+		ticketTypes, err := buildTicketTypes(nil, req.TicketTypes)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		event.TicketTypes = ticketTypes
+		event.SyncTicketTypeTotals()
+	}
+
+	// Reserved-seating events sell one ticket per seat of the venue
+	if !req.VenueID.IsZero() {
+		venue, err := ec.seatingService.LoadVenue(context.Background(), req.VenueID)
+		if err != nil {
+			if errors.Is(err, services.ErrVenueNotFound) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Venue not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch venue"})
+			return
+		}
+		event.VenueID = venue.ID
+		if !event.HasTicketTypes() {
+			event.MaxTickets = venue.SellableSeats()
+		}
+		if !ec.checkSeatCapacity(c, &event) {
+			return
+		}
 	}
 
 	result, err := ec.eventCollection.InsertOne(context.Background(), event)
@@ -227,12 +282,48 @@ func (ec *EventController) UpdateEvent(c *gin.Context) {
 	if req.Location != "" {
 		update["location"] = req.Location
 	}
+	if event.HasTicketTypes() && req.TicketTypes == nil && (req.Price > 0 || req.MaxTickets > 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Price and capacity of a tiered event are set on its ticket types"})
+		return
+	}
+	if event.HasReservedSeating() && req.MaxTickets > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Capacity of a reserved-seating event is set by its venue"})
+		return
+	}
 	if req.Price > 0 {
 		update["price"] = req.Price
 	}
 	if req.MaxTickets > 0 {
 		update["max_tickets"] = req.MaxTickets
 	}
+
+	// Ticket types are replaced as a whole; tiers are matched by ID so their sold counts carry over
+	filter := bson.M{"_id": objectID}
+	if req.TicketTypes != nil {
+		ticketTypes, err := buildTicketTypes(event.TicketTypes, req.TicketTypes)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(ticketTypes) > 0 && !event.HasTicketTypes() && event.SoldTickets > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Ticket types cannot be added after general admission tickets have been sold"})
+			return
+		}
+
+		event.TicketTypes = ticketTypes
+		event.SyncTicketTypeTotals()
+		if event.HasReservedSeating() && !ec.checkSeatCapacity(c, &event) {
+			return
+		}
+		update["ticket_types"] = event.TicketTypes
+		if len(ticketTypes) > 0 {
+			update["price"] = event.Price
+			update["max_tickets"] = event.MaxTickets
+		}
+
+		// Sold counts were read above, so the write only applies if no sale happened since
+		filter["sold_tickets"] = event.SoldTickets
+	}
 	if req.Category != "" {
 		update["category"] = req.Category
 	}
@@ -242,6 +333,28 @@ func (ec *EventController) UpdateEvent(c *gin.Context) {
 	if req.Status != "" {
 		update["status"] = req.Status
 	}
+	if req.Currency != "" {
+		currency, ok := eventCurrency(req.Currency)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported currency"})
+			return
+		}
+		if currency != event.Currency {
+			// Prices already paid were in the old currency
+			if event.SoldTickets > 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "Currency cannot change after tickets have been sold"})
+				return
+			}
+			update["currency"] = currency
+			filter["sold_tickets"] = 0
+		}
+	}
+	if req.PaymentProviders != nil {
+		if !ec.checkPaymentProviders(c, req.PaymentProviders) {
+			return
+		}
+		update["payment_providers"] = req.PaymentProviders
+	}
 
 	if len(update) == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No fields to update"})
@@ -251,15 +364,39 @@ func (ec *EventController) UpdateEvent(c *gin.Context) {
 	update["updated_at"] = time.Now()
 
 	// Update event
-	_, err = ec.eventCollection.UpdateOne(
+	result, err := ec.eventCollection.UpdateOne(
 		context.Background(),
-		bson.M{"_id": objectID},
+		filter,
 		bson.M{"$set": update},
 	)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update event"})
 		return
 	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Tickets were sold while updating, please try again"})
+		return
+	}
+
+	// Let ticket holders know about a new title, date or location, or a cancellation
+	updated := event
+	if title, ok := update["title"].(string); ok {
+		updated.Title = title
+	}
+	if date, ok := update["date"].(time.Time); ok {
+		updated.Date = date
+	}
+	if location, ok := update["location"].(string); ok {
+		updated.Location = location
+	}
+	if status, ok := update["status"].(string); ok {
+		updated.Status = status
+	}
+	go func() {
+		if _, err := ec.emailService.SendEventChangeNotice(context.Background(), &event, &updated); err != nil {
+			log.Printf("Failed to email ticket holders about changes to event %s: %v", event.ID.Hex(), err)
+		}
+	}()
 
 	c.JSON(http.StatusOK, gin.H{"message": "Event updated successfully"})
 }
@@ -369,4 +506,24 @@ func (ec *EventController) GetOrganizerEvents(c *gin.Context) {
 			"pages": (int(total) + limit - 1) / limit,
 		},
 	})
-} 
\ No newline at end of file
+}
+
+// checkPaymentProviders rejects events that would accept providers that are not enabled
+func (ec *EventController) checkPaymentProviders(c *gin.Context, names []string) bool {
+	for _, name := range names {
+		if _, err := ec.providers.Get(name); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown payment provider: " + name})
+			return false
+		}
+	}
+	return true
+}
+
+// eventCurrency normalises a requested currency code, defaulting to DEFAULT_CURRENCY
+func eventCurrency(code string) (string, bool) {
+	if code == "" {
+		code = config.AppConfig.Payment.DefaultCurrency
+	}
+	currency, ok := models.LookupCurrency(code)
+	return currency.Code, ok
+}
@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"eventticketing/models"
+	"eventticketing/services"
+	"eventticketing/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type OrderController struct {
+	orderCollection   *mongo.Collection
+	paymentCollection *mongo.Collection
+	orderService      *services.OrderService
+}
+
+func NewOrderController() *OrderController {
+	return &OrderController{
+		orderCollection:   utils.GetCollection("orders"),
+		paymentCollection: utils.GetCollection("payments"),
+		orderService:      services.NewOrderService(),
+	}
+}
+
+// GetUserOrders returns orders for the current user
+func (oc *OrderController) GetUserOrders(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	// Parse query parameters
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	status := c.Query("status")
+
+	// Build filter
+	filter := bson.M{"user_id": user.ID}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	// Set up pagination
+	skip := (page - 1) * limit
+	opts := options.Find().
+		SetLimit(int64(limit)).
+		SetSkip(int64(skip)).
+		SetSort(bson.M{"created_at": -1})
+
+	cursor, err := oc.orderCollection.Find(context.Background(), filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch orders"})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var orders []models.Order
+	if err = cursor.All(context.Background(), &orders); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode orders"})
+		return
+	}
+
+	var responses []models.OrderResponse
+	for _, order := range orders {
+		tickets, err := oc.orderService.GetTickets(context.Background(), order.ID)
+		if err != nil {
+			continue
+		}
+		responses = append(responses, order.ToResponseWithTickets(tickets))
+	}
+
+	total, err := oc.orderCollection.CountDocuments(context.Background(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count orders"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"orders": responses,
+		"pagination": gin.H{
+			"page":  page,
+			"limit": limit,
+			"total": total,
+			"pages": (int(total) + limit - 1) / limit,
+		},
+	})
+}
+
+// GetOrderByID returns an order with its tickets and payments
+func (oc *OrderController) GetOrderByID(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	order, ok := oc.loadOrder(c, user)
+	if !ok {
+		return
+	}
+
+	tickets, err := oc.orderService.GetTickets(context.Background(), order.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tickets"})
+		return
+	}
+
+	cursor, err := oc.paymentCollection.Find(context.Background(), bson.M{"order_id": order.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payments"})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var payments []models.Payment
+	if err = cursor.All(context.Background(), &payments); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode payments"})
+		return
+	}
+
+	var paymentResponses []models.PaymentResponse
+	for _, payment := range payments {
+		paymentResponses = append(paymentResponses, payment.ToResponse())
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"order":    order.ToResponseWithTickets(tickets),
+		"payments": paymentResponses,
+	})
+}
+
+// CancelOrder cancels an unpaid order and releases all of its tickets
+func (oc *OrderController) CancelOrder(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	order, ok := oc.loadOrder(c, user)
+	if !ok {
+		return
+	}
+
+	if order.Status != "pending" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only unpaid orders can be cancelled; cancel paid tickets individually"})
+		return
+	}
+
+	cancelled, err := oc.orderService.ClosePending(context.Background(), order.ID, "cancelled")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel order"})
+		return
+	}
+	if !cancelled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Order status changed, please try again"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Order cancelled successfully"})
+}
+
+// loadOrder fetches the order in the :id parameter if the user may see it
+func (oc *OrderController) loadOrder(c *gin.Context, user *models.User) (*models.Order, bool) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return nil, false
+	}
+
+	order, err := oc.orderService.GetOrder(context.Background(), objectID)
+	if err != nil {
+		if errors.Is(err, services.ErrOrderNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order"})
+		return nil, false
+	}
+
+	if order.UserID != user.ID && user.Role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return nil, false
+	}
+
+	return order, true
+}
@@ -2,7 +2,10 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"strconv"
 	"time"
@@ -19,22 +22,32 @@ import (
 )
 
 type PaymentController struct {
-	paymentCollection *mongo.Collection
-	ticketCollection  *mongo.Collection
-	eventCollection   *mongo.Collection
-	userCollection    *mongo.Collection
-	momoService       *services.MoMoService
-	smsService        *services.SMSService
+	paymentCollection  *mongo.Collection
+	ticketCollection   *mongo.Collection
+	eventCollection    *mongo.Collection
+	userCollection     *mongo.Collection
+	providers          *services.PaymentProviders
+	inventoryService   *services.InventoryService
+	orderService       *services.OrderService
+	callbackLogService *services.CallbackLogService
+	paymentService     *services.PaymentService
+	queueService       *services.QueueService
+	webSocketService   *services.WebSocketService
 }
 
-func NewPaymentController() *PaymentController {
+func NewPaymentController(webSocketService *services.WebSocketService) *PaymentController {
 	return &PaymentController{
-		paymentCollection: utils.GetCollection("payments"),
-		ticketCollection:  utils.GetCollection("tickets"),
-		eventCollection:   utils.GetCollection("events"),
-		userCollection:    utils.GetCollection("users"),
-		momoService:       services.NewMoMoService(),
-		smsService:        services.NewSMSService(),
+		paymentCollection:  utils.GetCollection("payments"),
+		ticketCollection:   utils.GetCollection("tickets"),
+		eventCollection:    utils.GetCollection("events"),
+		userCollection:     utils.GetCollection("users"),
+		providers:          services.DefaultPaymentProviders(),
+		inventoryService:   services.NewInventoryService(),
+		orderService:       services.NewOrderService(),
+		callbackLogService: services.NewCallbackLogService(),
+		paymentService:     services.NewPaymentService(),
+		queueService:       services.NewQueueService(webSocketService),
+		webSocketService:   webSocketService,
 	}
 }
 
@@ -52,151 +65,348 @@ func (pc *PaymentController) InitiatePayment(c *gin.Context) {
 		return
 	}
 
-	// Check if event exists
-	var event models.Event
-	err := pc.eventCollection.FindOne(context.Background(), bson.M{"_id": req.EventID}).Decode(&event)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch event"})
+	// Buyers of events sold through a queue must have been let through it
+	admissionID, ok := checkQueueAdmission(c, pc.queueService, req.EventID, user.ID, req.QueueToken)
+	if !ok {
 		return
 	}
 
-	// Check if event can accommodate the requested tickets
-	if !event.CanPurchaseTickets(req.Quantity) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Not enough tickets available"})
+	// Claim inventory before creating anything so concurrent buyers cannot oversell
+	reservation, err := pc.inventoryService.Reserve(context.Background(), req.EventID, req.TicketTypeID, req.Quantity)
+	if err != nil {
+		respondReservationError(c, err)
 		return
 	}
+	defer reservation.Rollback(context.Background())
+	reservation.AdmissionID = admissionID
 
-	// Calculate total amount
-	totalAmount := event.Price * float64(req.Quantity)
+	event := reservation.Event
 
-	// Create ticket first
-	ticket := models.Ticket{
-		EventID:    req.EventID,
-		UserID:     user.ID,
-		TicketCode: models.GenerateTicketCode(),
-		Status:     "pending",
-		Price:      totalAmount,
-		Quantity:   req.Quantity,
-		CreatedAt:  time.Now(),
-		UpdatedAt:  time.Now(),
+	// Older clients name the provider in payment_type
+	requested := req.Provider
+	if requested == "" && req.PaymentType != "ussd" {
+		requested = req.PaymentType
+	}
+	provider, err := pc.providers.Select(event, requested)
+	if err != nil {
+		if errors.Is(err, services.ErrProviderNotAllowed) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "This event does not accept that payment method"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown payment provider"})
+		return
 	}
 
-	// Insert ticket into database
-	ticketResult, err := pc.ticketCollection.InsertOne(context.Background(), ticket)
+	// Create the order with one ticket per admission
+	order, tickets, err := reservation.PlaceOrder(context.Background(), user.ID, req.Seats)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create ticket"})
+		respondReservationError(c, err)
 		return
 	}
 
-	ticket.ID = ticketResult.InsertedID.(primitive.ObjectID)
+	paymentType := provider.Name()
+	if req.PaymentType == "ussd" {
+		paymentType = req.PaymentType
+	}
 
 	// Create payment record
 	payment := models.Payment{
 		UserID:      user.ID,
 		EventID:     req.EventID,
-		TicketID:    ticket.ID,
-		Amount:      totalAmount,
+		OrderID:     order.ID,
+		Amount:      order.TotalAmount,
+		Currency:    order.Currency,
 		Status:      "pending",
-		PaymentType: req.PaymentType,
+		PaymentType: paymentType,
+		Provider:    provider.Name(),
 		PhoneNumber: req.PhoneNumber,
-		Description: fmt.Sprintf("Payment for %d ticket(s) - %s", req.Quantity, event.Title),
+		Description: fmt.Sprintf("Payment for %d %s ticket(s) - %s", req.Quantity, tickets[0].GetTicketTypeName(), event.Title),
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
 
+	// The reference is stored with the payment so that, whatever becomes of
+	// the request below, the callback and the reconciler can find it
+	payment.MoMoRef = provider.NewReference()
+
 	// Insert payment into database
-	paymentResult, err := pc.paymentCollection.InsertOne(context.Background(), payment)
-	if err != nil {
+	if err := reservation.InsertPayment(context.Background(), &payment); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment"})
 		return
 	}
 
-	payment.ID = paymentResult.InsertedID.(primitive.ObjectID)
+	// Once the provider has been asked it may take the money, so the order is
+	// kept and left pending for the callback, the reconciler or the hold sweeper
+	reservation.Commit()
 
-	// Initiate MoMo payment
-	if req.PaymentType == "momo" {
-		momoResponse, err := pc.momoService.InitiatePayment(&payment, &event)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initiate payment"})
-			return
+	initiation, err := provider.Initiate(context.Background(), &payment, event)
+	if err != nil {
+		log.Printf("Failed to initiate payment %s: %v", payment.ID.Hex(), err)
+		if errors.Is(err, services.ErrProviderRejected) {
+			// A refused request took nothing, so the tickets go straight back
+			if _, err := pc.paymentService.MarkFailed(context.Background(), &payment); err != nil {
+				log.Printf("Failed to release rejected payment %s: %v", payment.ID.Hex(), err)
+			}
 		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to initiate payment"})
+		return
+	}
 
-		c.JSON(http.StatusOK, gin.H{
-			"message": "Payment initiated successfully",
-			"payment": payment.ToResponse(),
-			"momo":    momoResponse,
-		})
-	} else {
-		// For USSD payments, just return the payment details
-		c.JSON(http.StatusOK, gin.H{
-			"message": "Payment initiated successfully",
-			"payment": payment.ToResponse(),
-		})
+	response := gin.H{
+		"message":  "Payment initiated successfully",
+		"payment":  payment.ToResponse(),
+		"order":    order.ToResponseWithTickets(tickets),
+		"provider": initiation,
+	}
+	if provider.Name() == "momo" {
+		// Kept for clients written before other providers existed
+		response["momo"] = initiation.Response
 	}
+	c.JSON(http.StatusOK, response)
 }
 
-// HandleMoMoCallback handles MoMo payment callbacks
+// maxCallbackBody caps how much of a callback body is read
+const maxCallbackBody = 64 << 10
+
+// HandleMoMoCallback handles MoMo payment callbacks on the original callback route
 func (pc *PaymentController) HandleMoMoCallback(c *gin.Context) {
-	var callback models.MoMoCallbackRequest
-	if err := c.ShouldBindJSON(&callback); err != nil {
+	pc.handleCallback(c, "momo")
+}
+
+// HandleProviderCallback handles payment callbacks on a provider's own route
+func (pc *PaymentController) HandleProviderCallback(c *gin.Context) {
+	pc.handleCallback(c, c.Param("provider"))
+}
+
+// handleCallback handles a provider's payment callback. Every callback is
+// logged raw before anything in it is trusted; only a callback the provider
+// verifies, with an unused nonce, can change a payment, and each payment
+// settles once. A nonce is only spent by a callback that was handled.
+func (pc *PaymentController) handleCallback(c *gin.Context, providerName string) {
+	ctx := context.Background()
+
+	provider, err := pc.providers.Get(providerName)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown payment provider"})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxCallbackBody))
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid callback data"})
 		return
 	}
 
-	// Find payment by reference
+	logID, err := pc.callbackLogService.Record(ctx, provider.Name(), c.Request, body, c.ClientIP())
+	if err != nil {
+		// Unlogged callbacks are refused so the provider retries them once the log is back
+		log.Printf("Failed to log %s callback: %v", provider.Name(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record callback"})
+		return
+	}
+	// A nonce claimed by this request is given back when it is not handled,
+	// so the provider's retry is processed rather than taken for a replay
+	var claimedNonce string
+	outcome := func(status int, result, detail string, paymentID primitive.ObjectID, response gin.H) {
+		if err := pc.callbackLogService.SetOutcome(ctx, logID, result, detail, paymentID); err != nil {
+			log.Printf("Failed to update callback log %s: %v", logID.Hex(), err)
+		}
+		if claimedNonce != "" && status >= http.StatusMultipleChoices {
+			if err := pc.callbackLogService.ReleaseNonce(ctx, provider.Name(), claimedNonce); err != nil {
+				log.Printf("Failed to release %s callback nonce: %v", provider.Name(), err)
+			}
+		}
+		c.JSON(status, response)
+	}
+
+	callback, err := provider.VerifyCallback(c.Request, body)
+	switch {
+	case err == nil:
+	case errors.Is(err, services.ErrStaleCallback):
+		outcome(http.StatusUnauthorized, models.CallbackReplayed, err.Error(), primitive.NilObjectID, gin.H{"error": "Callback expired"})
+		return
+	case errors.Is(err, services.ErrMalformedCallback):
+		outcome(http.StatusBadRequest, models.CallbackRejected, "malformed body", primitive.NilObjectID, gin.H{"error": "Invalid callback data"})
+		return
+	case errors.Is(err, services.ErrNotSupported):
+		outcome(http.StatusNotFound, models.CallbackRejected, err.Error(), primitive.NilObjectID, gin.H{"error": "Provider does not send callbacks"})
+		return
+	default:
+		outcome(http.StatusUnauthorized, models.CallbackInvalidSignature, err.Error(), primitive.NilObjectID, gin.H{"error": "Invalid callback signature"})
+		return
+	}
+
+	if callback.Nonce != "" {
+		if err := pc.callbackLogService.ClaimNonce(ctx, provider.Name(), callback.Nonce); err != nil {
+			if errors.Is(err, services.ErrCallbackReplayed) {
+				outcome(http.StatusConflict, models.CallbackReplayed, err.Error(), primitive.NilObjectID, gin.H{"error": "Callback already received"})
+				return
+			}
+			outcome(http.StatusInternalServerError, models.CallbackError, err.Error(), primitive.NilObjectID, gin.H{"error": "Failed to process callback"})
+			return
+		}
+		claimedNonce = callback.Nonce
+	}
+
+	// Find payment by reference; a provider can only settle its own payments
+	var payment models.Payment
+	err = pc.paymentCollection.FindOne(ctx, bson.M{"momo_ref": callback.Reference}).Decode(&payment)
+	if err != nil || payment.ProviderName() != provider.Name() {
+		outcome(http.StatusNotFound, models.CallbackRejected, "unknown reference", primitive.NilObjectID, gin.H{"error": "Payment not found"})
+		return
+	}
+
+	if callback.Amount != "" {
+		amount, err := models.ParseAmount(callback.Amount, payment.Currency)
+		if err != nil || amount != payment.Amount {
+			outcome(http.StatusBadRequest, models.CallbackRejected, "amount mismatch", payment.ID, gin.H{"error": "Callback amount does not match payment"})
+			return
+		}
+	}
+
+	if callback.Status == services.ProviderPending {
+		outcome(http.StatusOK, models.CallbackProcessed, "payment still pending", payment.ID, gin.H{"message": "Callback processed successfully"})
+		return
+	}
+
+	if callback.Status != services.ProviderSuccess {
+		// Only a payment still waiting can fail; a late failure never undoes a success
+		failed, err := pc.paymentService.MarkFailed(ctx, &payment)
+		if err != nil {
+			outcome(http.StatusInternalServerError, models.CallbackError, err.Error(), payment.ID, gin.H{"error": "Failed to update payment"})
+			return
+		}
+		if !failed {
+			outcome(http.StatusOK, models.CallbackDuplicate, "payment already "+payment.Status, payment.ID, gin.H{"message": "Callback already processed"})
+			return
+		}
+		go pc.publishPayment(payment)
+		outcome(http.StatusOK, models.CallbackProcessed, "payment failed", payment.ID, gin.H{"message": "Callback processed successfully"})
+		return
+	}
+
+	duplicate, err := pc.paymentService.MarkSucceeded(ctx, &payment)
+	if err != nil {
+		if services.IsUnavailable(err) {
+			// The customer has paid but the tickets could no longer be sold after the
+			// hold lapsed; the payment is flagged for a refund
+			log.Printf("Payment %s succeeded after its ticket hold expired: %v", payment.ID.Hex(), err)
+			outcome(http.StatusOK, models.CallbackProcessed, "hold expired and tickets unavailable: "+err.Error(), payment.ID, gin.H{"message": "Payment received but ticket hold expired"})
+			return
+		}
+		outcome(http.StatusInternalServerError, models.CallbackError, err.Error(), payment.ID, gin.H{"error": "Failed to update ticket"})
+		return
+	}
+
+	if duplicate {
+		outcome(http.StatusOK, models.CallbackDuplicate, "payment already success", payment.ID, gin.H{"message": "Callback already processed"})
+		return
+	}
+	go pc.publishPayment(payment)
+	outcome(http.StatusOK, models.CallbackProcessed, "payment succeeded", payment.ID, gin.H{"message": "Callback processed successfully"})
+}
+
+// ConfirmCashPayment records cash taken at the box office for a pending
+// payment. Only the event's organizer or an admin can confirm it.
+func (pc *PaymentController) ConfirmCashPayment(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	paymentID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+		return
+	}
+
 	var payment models.Payment
-	err := pc.paymentCollection.FindOne(context.Background(), bson.M{"momo_ref": callback.Reference}).Decode(&payment)
+	err = pc.paymentCollection.FindOne(context.Background(), bson.M{"_id": paymentID}).Decode(&payment)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payment"})
 		return
 	}
 
-	// Update payment status
-	if callback.Status == "success" {
-		payment.MarkAsSuccessful(callback.Reference)
-	} else {
-		payment.MarkAsFailed()
-	}
-
-	// Update payment in database
-	_, err = pc.paymentCollection.UpdateOne(
-		context.Background(),
-		bson.M{"_id": payment.ID},
-		bson.M{"$set": bson.M{
-			"status":     payment.Status,
-			"momo_ref":   payment.MoMoRef,
-			"updated_at": payment.UpdatedAt,
-		}},
-	)
+	var event models.Event
+	err = pc.eventCollection.FindOne(context.Background(), bson.M{"_id": payment.EventID}).Decode(&event)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update payment"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch event"})
+		return
+	}
+	if event.OrganizerID != user.ID && user.Role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
 		return
 	}
 
-	// If payment successful, update ticket status
-	if payment.IsSuccessful() {
-		_, err = pc.ticketCollection.UpdateOne(
-			context.Background(),
-			bson.M{"_id": payment.TicketID},
-			bson.M{"$set": bson.M{
-				"status":     "paid",
-				"updated_at": time.Now(),
-			}},
-		)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update ticket"})
+	if payment.ProviderName() != services.CashProviderName {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only cash payments are confirmed at the box office"})
+		return
+	}
+	if !payment.IsPending() && !payment.IsSuccessful() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Payment is " + payment.Status + "; start a new purchase"})
+		return
+	}
+
+	duplicate, err := pc.paymentService.MarkSucceeded(context.Background(), &payment)
+	if err != nil {
+		if services.IsUnavailable(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "The ticket hold expired and the tickets are no longer available"})
 			return
 		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to confirm payment"})
+		return
+	}
+
+	message := "Payment confirmed successfully"
+	if duplicate {
+		message = "Payment already confirmed"
+	} else {
+		go pc.publishPayment(payment)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": message,
+		"payment": payment.ToResponse(),
+	})
+}
+
+// publishPayment tells the buyer how their payment went and, for a sale,
+// updates the dashboards following the event
+func (pc *PaymentController) publishPayment(payment models.Payment) {
+	ctx := context.Background()
+	var event models.Event
+	if err := pc.eventCollection.FindOne(ctx, bson.M{"_id": payment.EventID}).Decode(&event); err != nil {
+		log.Printf("Failed to fetch event %s for live updates: %v", payment.EventID.Hex(), err)
+		return
+	}
+
+	status := "successful"
+	if !payment.IsSuccessful() {
+		status = payment.Status
+	}
+	pc.webSocketService.BroadcastPaymentStatus(payment.UserID.Hex(), event.Title, status, payment.Amount, payment.Currency)
+	if !payment.IsSuccessful() {
+		return
+	}
 
-		// Send SMS notification
-		go pc.sendTicketSMS(&payment)
+	tickets, err := pc.orderService.GetTickets(ctx, payment.OrderID)
+	if err != nil {
+		log.Printf("Failed to fetch tickets of order %s for live updates: %v", payment.OrderID.Hex(), err)
+		return
+	}
+	pc.webSocketService.BroadcastTicketPurchase(&event, len(tickets), payment.Amount, payment.Currency)
+	if err := pc.webSocketService.PublishEventStats(ctx, &event); err != nil {
+		log.Printf("Failed to publish live updates for event %s: %v", event.ID.Hex(), err)
 	}
+}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Callback processed successfully"})
+// GetProviders lists the payment providers buyers can choose from
+func (pc *PaymentController) GetProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"providers": pc.providers.Names()})
 }
 
 // GetPayments returns payments for the current user
@@ -249,14 +459,13 @@ func (pc *PaymentController) GetPayments(c *gin.Context) {
 			continue
 		}
 
-		// Get ticket details
-		var ticket models.Ticket
-		err = pc.ticketCollection.FindOne(context.Background(), bson.M{"_id": payment.TicketID}).Decode(&ticket)
+		// Get order details
+		order, err := pc.orderService.GetOrder(context.Background(), payment.OrderID)
 		if err != nil {
 			continue
 		}
 
-		response := payment.ToResponseWithDetails(user.ToResponse(), event.ToResponse(), ticket.ToResponse())
+		response := payment.ToResponseWithDetails(user.ToResponse(), event.ToResponse(), order.ToResponse())
 		responses = append(responses, response)
 	}
 
@@ -277,26 +486,3 @@ func (pc *PaymentController) GetPayments(c *gin.Context) {
 		},
 	})
 }
-
-// sendTicketSMS sends SMS notification for successful ticket purchase
-func (pc *PaymentController) sendTicketSMS(payment *models.Payment) {
-	// Get ticket details
-	var ticket models.Ticket
-	err := pc.ticketCollection.FindOne(context.Background(), bson.M{"_id": payment.TicketID}).Decode(&ticket)
-	if err != nil {
-		return
-	}
-
-	// Get event details
-	var event models.Event
-	err = pc.eventCollection.FindOne(context.Background(), bson.M{"_id": payment.EventID}).Decode(&event)
-	if err != nil {
-		return
-	}
-
-	// Send SMS
-	message := fmt.Sprintf("Your ticket for %s has been confirmed. Ticket Code: %s. Event Date: %s", 
-		event.Title, ticket.TicketCode, event.Date.Format("2006-01-02 15:04"))
-	
-	pc.smsService.SendSMS(payment.PhoneNumber, message)
-} 
\ No newline at end of file
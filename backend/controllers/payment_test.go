@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"eventticketing/config"
+	"eventticketing/models"
+	"eventticketing/services"
+	"eventticketing/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// setupControllerTest connects to a fresh test database, skipping the test
+// when no MongoDB is available
+func setupControllerTest(t *testing.T) {
+	t.Helper()
+
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URI not set; skipping MongoDB-backed controller tests")
+	}
+
+	gin.SetMode(gin.TestMode)
+	config.AppConfig = &config.Config{
+		Database: config.DatabaseConfig{
+			URI: uri,
+			DB:  "eventticketing_test_" + primitive.NewObjectID().Hex(),
+		},
+		Live: config.LiveConfig{Broker: "memory"},
+	}
+	utils.ConnectDB()
+	t.Cleanup(func() {
+		utils.DB.Drop(context.Background())
+		utils.DisconnectDB()
+	})
+}
+
+// testProvider accepts any callback, reading its outcome from the JSON body
+// and its nonce from the X-Nonce header
+type testProvider struct {
+	services.PaymentProvider
+}
+
+func (testProvider) Name() string { return "test" }
+
+func (testProvider) VerifyCallback(r *http.Request, body []byte) (*services.ProviderCallback, error) {
+	var callback services.ProviderCallback
+	if err := json.Unmarshal(body, &callback); err != nil {
+		return nil, services.ErrMalformedCallback
+	}
+	callback.Nonce = r.Header.Get("X-Nonce")
+	return &callback, nil
+}
+
+func TestCallbackRetryAfterFailureIsProcessed(t *testing.T) {
+	setupControllerTest(t)
+	ctx := context.Background()
+
+	pc := NewPaymentController(services.NewWebSocketService())
+	pc.providers = services.NewPaymentProviders(testProvider{})
+	router := gin.New()
+	router.POST("/payments/callback/:provider", pc.HandleProviderCallback)
+
+	event := models.Event{ID: primitive.NewObjectID(), Title: "Callback Test Event", OrganizerID: primitive.NewObjectID()}
+	if _, err := utils.GetCollection("events").InsertOne(ctx, event); err != nil {
+		t.Fatalf("failed to insert event: %v", err)
+	}
+	// The payment's order is missing, so confirming it fails the first time
+	order := models.Order{
+		ID:          primitive.NewObjectID(),
+		UserID:      primitive.NewObjectID(),
+		EventID:     event.ID,
+		Quantity:    1,
+		TotalAmount: 1000,
+		Currency:    "GHS",
+		Status:      "pending",
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	payment := models.Payment{
+		UserID:    order.UserID,
+		EventID:   event.ID,
+		OrderID:   order.ID,
+		Amount:    1000,
+		Currency:  "GHS",
+		Status:    "pending",
+		Provider:  "test",
+		MoMoRef:   "ref-1",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if _, err := utils.GetCollection("payments").InsertOne(ctx, payment); err != nil {
+		t.Fatalf("failed to insert payment: %v", err)
+	}
+
+	deliver := func() int {
+		body := `{"Reference":"ref-1","Status":"success","Amount":"10.00"}`
+		req := httptest.NewRequest(http.MethodPost, "/payments/callback/test", strings.NewReader(body))
+		req.Header.Set("X-Nonce", "nonce-1")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := deliver(); code != http.StatusInternalServerError {
+		t.Fatalf("expected the first attempt to fail, got %d", code)
+	}
+
+	if _, err := utils.GetCollection("orders").InsertOne(ctx, order); err != nil {
+		t.Fatalf("failed to insert order: %v", err)
+	}
+	if code := deliver(); code != http.StatusOK {
+		t.Fatalf("expected the provider's retry to be processed, got %d", code)
+	}
+	confirmed, err := services.NewOrderService().GetOrder(ctx, order.ID)
+	if err != nil || confirmed.Status != "paid" {
+		t.Errorf("expected the retry to pay the order, got %+v, %v", confirmed, err)
+	}
+
+	if code := deliver(); code != http.StatusConflict {
+		t.Errorf("expected a replay of a processed callback to be refused, got %d", code)
+	}
+}
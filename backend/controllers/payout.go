@@ -0,0 +1,394 @@
+package controllers
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"eventticketing/config"
+	"eventticketing/models"
+	"eventticketing/services"
+	"eventticketing/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type PayoutController struct {
+	payoutCollection *mongo.Collection
+	userCollection   *mongo.Collection
+	ledgerService    *services.LedgerService
+}
+
+func NewPayoutController() *PayoutController {
+	return &PayoutController{
+		payoutCollection: utils.GetCollection("payouts"),
+		userCollection:   utils.GetCollection("users"),
+		ledgerService:    services.NewLedgerService(),
+	}
+}
+
+// GetOrganizerBalance returns the current organizer's balance in each currency
+func (pc *PayoutController) GetOrganizerBalance(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	pc.writeBalance(c, user.ID)
+}
+
+// GetOrganizerStatement exports the current organizer's ledger as CSV
+func (pc *PayoutController) GetOrganizerStatement(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	pc.writeStatement(c, user.ID)
+}
+
+// GetBalanceByOrganizer returns an organizer's balance in each currency (admin only)
+func (pc *PayoutController) GetBalanceByOrganizer(c *gin.Context) {
+	organizerID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organizer ID"})
+		return
+	}
+	pc.writeBalance(c, organizerID)
+}
+
+// GetStatementByOrganizer exports an organizer's ledger as CSV (admin only)
+func (pc *PayoutController) GetStatementByOrganizer(c *gin.Context) {
+	organizerID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organizer ID"})
+		return
+	}
+	pc.writeStatement(c, organizerID)
+}
+
+func (pc *PayoutController) writeBalance(c *gin.Context, organizerID primitive.ObjectID) {
+	balances, err := pc.ledgerService.Balances(context.Background(), organizerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch balance"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"organizer_id":    organizerID,
+		"balances":        balances,
+		"commission_rate": services.CurrentSettings().CommissionRate,
+	})
+}
+
+// writeStatement writes an organizer's ledger entries in one currency as CSV
+// with a running balance. The currency, from and to (YYYY-MM-DD, inclusive)
+// come from the query.
+func (pc *PayoutController) writeStatement(c *gin.Context, organizerID primitive.ObjectID) {
+	currency := strings.ToUpper(c.DefaultQuery("currency", config.AppConfig.Payment.DefaultCurrency))
+
+	var from, to time.Time
+	var err error
+	if value := c.Query("from"); value != "" {
+		if from, err = time.Parse("2006-01-02", value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, expected YYYY-MM-DD"})
+			return
+		}
+	}
+	if value := c.Query("to"); value != "" {
+		if to, err = time.Parse("2006-01-02", value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, expected YYYY-MM-DD"})
+			return
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+
+	opening, entries, err := pc.ledgerService.Statement(context.Background(), organizerID, currency, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch statement"})
+		return
+	}
+
+	filename := fmt.Sprintf("statement-%s-%s.csv", organizerID.Hex(), strings.ToLower(currency))
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+
+	writer := csv.NewWriter(c.Writer)
+	writer.Write([]string{"date", "type", "description", "event_id", "reference", "gross", "commission", "provider_fee", "net", "balance"})
+	balance := opening
+	writer.Write([]string{"", "opening_balance", "", "", "", "", "", "", "", models.DecimalAmount(balance, currency)})
+	for _, entry := range entries {
+		balance += entry.OrganizerNet
+		eventID := ""
+		if !entry.EventID.IsZero() {
+			eventID = entry.EventID.Hex()
+		}
+		writer.Write([]string{
+			entry.CreatedAt.Format(time.RFC3339),
+			entry.Type,
+			entry.Description,
+			eventID,
+			entry.Key,
+			models.DecimalAmount(entry.Gross, currency),
+			models.DecimalAmount(entry.Commission, currency),
+			models.DecimalAmount(entry.ProviderFee, currency),
+			models.DecimalAmount(entry.OrganizerNet, currency),
+			models.DecimalAmount(balance, currency),
+		})
+	}
+	writer.Flush()
+}
+
+// GetPayouts returns payouts, optionally filtered by status, organizer or batch (admin only)
+func (pc *PayoutController) GetPayouts(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+
+	filter, ok := payoutFilter(c)
+	if !ok {
+		return
+	}
+
+	skip := (page - 1) * limit
+	opts := options.Find().
+		SetLimit(int64(limit)).
+		SetSkip(int64(skip)).
+		SetSort(bson.M{"created_at": -1})
+
+	cursor, err := pc.payoutCollection.Find(context.Background(), filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payouts"})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var payouts []models.Payout
+	if err = cursor.All(context.Background(), &payouts); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode payouts"})
+		return
+	}
+
+	total, err := pc.payoutCollection.CountDocuments(context.Background(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count payouts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"payouts": payouts,
+		"pagination": gin.H{
+			"page":  page,
+			"limit": limit,
+			"total": total,
+			"pages": (int(total) + limit - 1) / limit,
+		},
+	})
+}
+
+// ExportPayouts exports payouts with the organizer's contact details as CSV,
+// so a batch can be paid outside the system (admin only)
+func (pc *PayoutController) ExportPayouts(c *gin.Context) {
+	filter, ok := payoutFilter(c)
+	if !ok {
+		return
+	}
+
+	cursor, err := pc.payoutCollection.Find(context.Background(), filter, options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payouts"})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var payouts []models.Payout
+	if err = cursor.All(context.Background(), &payouts); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode payouts"})
+		return
+	}
+
+	organizers := map[primitive.ObjectID]models.User{}
+	for _, payout := range payouts {
+		if _, seen := organizers[payout.OrganizerID]; seen {
+			continue
+		}
+		var organizer models.User
+		pc.userCollection.FindOne(context.Background(), bson.M{"_id": payout.OrganizerID}).Decode(&organizer)
+		organizers[payout.OrganizerID] = organizer
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", "attachment; filename=payouts.csv")
+
+	writer := csv.NewWriter(c.Writer)
+	writer.Write([]string{"payout_id", "batch_id", "organizer_id", "organizer_name", "email", "phone", "currency", "amount", "status", "reference", "created_at"})
+	for _, payout := range payouts {
+		organizer := organizers[payout.OrganizerID]
+		writer.Write([]string{
+			payout.ID.Hex(),
+			payout.BatchID.Hex(),
+			payout.OrganizerID.Hex(),
+			organizer.Name,
+			organizer.Email,
+			organizer.Phone,
+			payout.Currency,
+			models.DecimalAmount(payout.Amount, payout.Currency),
+			payout.Status,
+			payout.Reference,
+			payout.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	writer.Flush()
+}
+
+// payoutFilter builds a payout filter from the status, organizer_id and
+// batch_id query parameters, writing a 400 if an ID is invalid
+func payoutFilter(c *gin.Context) (bson.M, bool) {
+	filter := bson.M{}
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+	for _, param := range []string{"organizer_id", "batch_id"} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		id, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param})
+			return nil, false
+		}
+		filter[param] = id
+	}
+	return filter, true
+}
+
+// CreatePayoutBatch batches organizers' balances into pending payouts (admin only)
+func (pc *PayoutController) CreatePayoutBatch(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req models.CreatePayoutBatchRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.Currency != "" {
+		currency, ok := models.LookupCurrency(req.Currency)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported currency"})
+			return
+		}
+		req.Currency = currency.Code
+	}
+	if req.MinimumAmount < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Minimum amount cannot be negative"})
+		return
+	}
+	for _, id := range req.OrganizerIDs {
+		if _, err := primitive.ObjectIDFromHex(id); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organizer ID"})
+			return
+		}
+	}
+
+	payouts, err := pc.ledgerService.CreatePayouts(context.Background(), req, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payouts", "payouts": payouts})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": fmt.Sprintf("%d payouts created", len(payouts)),
+		"payouts": payouts,
+	})
+}
+
+// SettlePayout marks a pending payout as paid (admin only)
+func (pc *PayoutController) SettlePayout(c *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payout ID"})
+		return
+	}
+
+	var req models.SettlePayoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	payout, err := pc.ledgerService.SettlePayout(context.Background(), objectID, req.Reference, req.Note)
+	if err != nil {
+		pc.writePayoutError(c, err, "Failed to settle payout")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Payout settled",
+		"payout":  payout,
+	})
+}
+
+// CancelPayout cancels a pending payout, returning its amount to the organizer's balance (admin only)
+func (pc *PayoutController) CancelPayout(c *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payout ID"})
+		return
+	}
+
+	var req models.CancelPayoutRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	payout, err := pc.ledgerService.CancelPayout(context.Background(), objectID, req.Note)
+	if err != nil {
+		pc.writePayoutError(c, err, "Failed to cancel payout")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Payout cancelled",
+		"payout":  payout,
+	})
+}
+
+func (pc *PayoutController) writePayoutError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrPayoutNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payout not found"})
+	case errors.Is(err, services.ErrPayoutNotPending):
+		c.JSON(http.StatusConflict, gin.H{"error": "Payout is no longer pending"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// GetTrialBalance returns every ledger account's totals per currency (admin only)
+func (pc *PayoutController) GetTrialBalance(c *gin.Context) {
+	balances, err := pc.ledgerService.TrialBalance(context.Background())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trial balance"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"accounts": balances})
+}
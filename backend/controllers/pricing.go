@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"eventticketing/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// UpdatePricing opts an event in or out of dynamic pricing and sets the
+// bounds its prices may move between
+func (ec *EventController) UpdatePricing(c *gin.Context) {
+	event, ok := ec.loadManagedEvent(c)
+	if !ok {
+		return
+	}
+
+	var req models.UpdatePricingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+	if req.Enabled && (req.FloorPercent < 1 || req.CeilingPercent > 1000 || req.FloorPercent > req.CeilingPercent) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Price floor and ceiling must be percentages of the price, with the floor no higher than the ceiling"})
+		return
+	}
+	if req.Enabled && (req.FloorPercent > 100 || req.CeilingPercent < 100) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The price set for the event must lie between the floor and the ceiling"})
+		return
+	}
+
+	// Keep the current price until it is next calculated
+	pricing := &models.EventPricing{
+		Enabled:        req.Enabled,
+		FloorPercent:   req.FloorPercent,
+		CeilingPercent: req.CeilingPercent,
+		Multiplier:     1,
+	}
+	if event.Pricing != nil && event.Pricing.Multiplier > 0 {
+		pricing.Multiplier = event.Pricing.Multiplier
+		pricing.UpdatedAt = event.Pricing.UpdatedAt
+	}
+
+	_, err := ec.eventCollection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": event.ID},
+		bson.M{"$set": bson.M{"pricing": pricing, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update pricing"})
+		return
+	}
+
+	// Bring the price within the new bounds now rather than at the next run
+	event.Pricing = pricing
+	change, err := ec.pricingService.Recalculate(context.Background(), event)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Pricing updated, but failed to recalculate the price"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Pricing updated successfully",
+		"pricing":       event.Pricing,
+		"current_price": event.LivePrice(event.Price),
+		"change":        change,
+	})
+}
+
+// GetPriceHistory returns an event's price changes, newest first, with the
+// factors behind each
+func (ec *EventController) GetPriceHistory(c *gin.Context) {
+	event, ok := ec.loadManagedEvent(c)
+	if !ok {
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	changes, total, err := ec.pricingService.History(context.Background(), event.ID, page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch price history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"pricing":       event.Pricing,
+		"price":         event.Price,
+		"current_price": event.LivePrice(event.Price),
+		"currency":      event.Currency,
+		"history":       changes,
+		"pagination": gin.H{
+			"page":  page,
+			"limit": limit,
+			"total": total,
+			"pages": (int(total) + limit - 1) / limit,
+		},
+	})
+}
@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"eventticketing/models"
+	"eventticketing/services"
+	"eventticketing/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type QueueController struct {
+	queueService *services.QueueService
+}
+
+func NewQueueController(webSocketService *services.WebSocketService) *QueueController {
+	return &QueueController{
+		queueService: services.NewQueueService(webSocketService),
+	}
+}
+
+// respondQueueError maps queue failures to HTTP responses
+func respondQueueError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrEventNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+	case errors.Is(err, services.ErrQueueNotEnabled):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This event is not sold through a queue"})
+	case errors.Is(err, services.ErrQueueNotOpen):
+		c.JSON(http.StatusConflict, gin.H{"error": "The queue for this event is not open yet"})
+	case errors.Is(err, services.ErrNotInQueue):
+		c.JSON(http.StatusNotFound, gin.H{"error": "You are not in the queue for this event"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch queue position"})
+	}
+}
+
+// checkQueueAdmission lets buyers through to buy tickets for an event sold
+// through a queue only with the admission token the queue gave them,
+// writing the error response when they cannot buy. It returns the admission
+// to place the order with.
+func checkQueueAdmission(c *gin.Context, queueService *services.QueueService, eventID, userID primitive.ObjectID, token string) (string, bool) {
+	admissionID, err := queueService.VerifyAdmission(context.Background(), eventID, userID, token)
+	switch {
+	case err == nil:
+		return admissionID, true
+	case errors.Is(err, services.ErrAdmissionRequired):
+		c.JSON(http.StatusForbidden, gin.H{"error": "This event is sold through a queue; join the queue to buy tickets"})
+	case errors.Is(err, services.ErrAdmissionInvalid):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your turn in the queue is not valid or has expired; join the queue again"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check queue admission"})
+	}
+	return "", false
+}
+
+// JoinQueue puts the current user in the queue for an event's tickets
+func (qc *QueueController) JoinQueue(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	eventID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+
+	position, err := qc.queueService.Join(context.Background(), eventID, user.ID)
+	if err != nil {
+		respondQueueError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Joined the queue",
+		"queue":   position,
+	})
+}
+
+// GetQueuePosition returns where the current user stands in an event's queue
+func (qc *QueueController) GetQueuePosition(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	eventID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+
+	position, err := qc.queueService.Status(context.Background(), eventID, user.ID)
+	if err != nil {
+		respondQueueError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"queue": position})
+}
+
+// UpdateQueue sets up, changes or turns off the virtual queue an event's
+// tickets are sold through
+func (ec *EventController) UpdateQueue(c *gin.Context) {
+	event, ok := ec.loadManagedEvent(c)
+	if !ok {
+		return
+	}
+
+	var req models.UpdateQueueRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+	if req.AdmissionRate < 0 || req.AdmissionWindow < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Admission rate and window cannot be negative"})
+		return
+	}
+	if req.Enabled && req.AdmissionRate == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Admission rate is required"})
+		return
+	}
+	if req.Enabled && req.OpensAt.IsZero() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Queue opening time is required"})
+		return
+	}
+
+	queue := &models.EventQueue{
+		Enabled:         req.Enabled,
+		OpensAt:         req.OpensAt,
+		AdmissionRate:   req.AdmissionRate,
+		AdmissionWindow: req.AdmissionWindow,
+	}
+	if queue.AdmissionWindow == 0 {
+		queue.AdmissionWindow = int(services.DefaultAdmissionWindow / time.Minute)
+	}
+
+	_, err := ec.eventCollection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": event.ID},
+		bson.M{"$set": bson.M{"queue": queue, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update queue"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Queue updated successfully",
+		"queue":   queue,
+	})
+}
@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"eventticketing/models"
+	"eventticketing/services"
+	"eventticketing/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ReconciliationController struct {
+	reportCollection *mongo.Collection
+	reconciler       *services.Reconciler
+}
+
+func NewReconciliationController() *ReconciliationController {
+	return &ReconciliationController{
+		reportCollection: utils.GetCollection("reconciliation_reports"),
+		reconciler:       services.NewReconciler(),
+	}
+}
+
+// GetReports returns reconciliation reports, newest first (admin only).
+// With mismatches=true only reports that found something are returned.
+func (rc *ReconciliationController) GetReports(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+
+	filter := bson.M{}
+	if c.Query("mismatches") == "true" {
+		filter["mismatches.0"] = bson.M{"$exists": true}
+	}
+
+	skip := (page - 1) * limit
+	opts := options.Find().
+		SetLimit(int64(limit)).
+		SetSkip(int64(skip)).
+		SetSort(bson.M{"started_at": -1})
+
+	cursor, err := rc.reportCollection.Find(context.Background(), filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch reports"})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var reports []models.ReconciliationReport
+	if err = cursor.All(context.Background(), &reports); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode reports"})
+		return
+	}
+
+	total, err := rc.reportCollection.CountDocuments(context.Background(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count reports"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reports": reports,
+		"pagination": gin.H{
+			"page":  page,
+			"limit": limit,
+			"total": total,
+			"pages": (int(total) + limit - 1) / limit,
+		},
+	})
+}
+
+// GetReportByID returns one reconciliation report (admin only)
+func (rc *ReconciliationController) GetReportByID(c *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid report ID"})
+		return
+	}
+
+	var report models.ReconciliationReport
+	err = rc.reportCollection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&report)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Report not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch report"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"report": report})
+}
+
+// RunReconciliation reconciles payments now instead of waiting for the worker (admin only)
+func (rc *ReconciliationController) RunReconciliation(c *gin.Context) {
+	report, err := rc.reconciler.Run(context.Background())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reconcile payments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"report": report})
+}
@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"eventticketing/models"
+	"eventticketing/services"
+	"eventticketing/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type RefundController struct {
+	refundCollection *mongo.Collection
+	refundService    *services.RefundService
+}
+
+func NewRefundController() *RefundController {
+	return &RefundController{
+		refundCollection: utils.GetCollection("refunds"),
+		refundService:    services.NewRefundService(),
+	}
+}
+
+// GetRefundByID returns a refund and its current status
+func (rc *RefundController) GetRefundByID(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid refund ID"})
+		return
+	}
+
+	refund, err := rc.refundService.GetRefund(context.Background(), objectID)
+	if err != nil {
+		if errors.Is(err, services.ErrRefundNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Refund not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch refund"})
+		return
+	}
+
+	if refund.UserID != user.ID && user.Role != "admin" && user.Role != "organizer" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"refund": refund})
+}
+
+// GetAllRefunds returns all refunds (admin only)
+func (rc *RefundController) GetAllRefunds(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	status := c.Query("status")
+
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	skip := (page - 1) * limit
+	opts := options.Find().
+		SetLimit(int64(limit)).
+		SetSkip(int64(skip)).
+		SetSort(bson.M{"created_at": -1})
+
+	cursor, err := rc.refundCollection.Find(context.Background(), filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch refunds"})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var refunds []models.Refund
+	if err = cursor.All(context.Background(), &refunds); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode refunds"})
+		return
+	}
+
+	total, err := rc.refundCollection.CountDocuments(context.Background(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count refunds"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"refunds": refunds,
+		"pagination": gin.H{
+			"page":  page,
+			"limit": limit,
+			"total": total,
+			"pages": (int(total) + limit - 1) / limit,
+		},
+	})
+}
+
+// RetryRefund resubmits a failed refund to its provider (admin only)
+func (rc *RefundController) RetryRefund(c *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid refund ID"})
+		return
+	}
+
+	refund, err := rc.refundService.Retry(context.Background(), objectID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrRefundNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Refund not found"})
+		case errors.Is(err, services.ErrRefundNotRetryable):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Only failed refunds can be retried"})
+		case errors.Is(err, services.ErrRefundExceedsPayment):
+			c.JSON(http.StatusConflict, gin.H{"error": "Refund exceeds the amount left to refund on the payment"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry refund"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Refund " + refund.Status,
+		"refund":  refund,
+	})
+}
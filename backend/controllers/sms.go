@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"eventticketing/config"
+	"eventticketing/models"
+	"eventticketing/services"
+	"eventticketing/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type SMSController struct {
+	messageCollection *mongo.Collection
+	smsService        *services.SMSService
+	resendService     *services.TicketResendService
+}
+
+func NewSMSController() *SMSController {
+	return &SMSController{
+		messageCollection: utils.GetCollection("sms_messages"),
+		smsService:        services.NewSMSService(),
+		resendService:     services.NewTicketResendService(),
+	}
+}
+
+// inboundSMS is a text forwarded by the SMS gateway, as JSON or form fields
+type inboundSMS struct {
+	From    string `json:"from" form:"from"`
+	Text    string `json:"text" form:"text"`
+	Message string `json:"message" form:"message"` // gateways that call the text message
+}
+
+// HandleInboundSMS answers texts sent to the service number. Replying TIX
+// gets the sender's ticket codes.
+func (sc *SMSController) HandleInboundSMS(c *gin.Context) {
+	var req inboundSMS
+	if err := c.ShouldBind(&req); err != nil || req.From == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+	text := req.Text
+	if text == "" {
+		text = req.Message
+	}
+
+	err := sc.resendService.HandleInboundSMS(context.Background(), req.From, text)
+	switch {
+	case err == nil:
+	case errors.Is(err, services.ErrResendLimited), errors.Is(err, services.ErrSMSDisabled):
+		// Nothing to retry; the sender gets no reply
+		log.Printf("Not answering SMS from %s: %v", req.From, err)
+	default:
+		log.Printf("Failed to answer SMS from %s: %v", req.From, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process message"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Message received"})
+}
+
+// HandleDeliveryReceipt records a provider's report that a message was
+// delivered or could not be
+func (sc *SMSController) HandleDeliveryReceipt(c *gin.Context) {
+	if token := config.AppConfig.SMS.ReceiptToken; token != "" &&
+		subtle.ConstantTimeCompare([]byte(c.Query("token")), []byte(token)) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		return
+	}
+
+	provider, err := services.NewSMSProvider(c.Param("provider"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown SMS provider"})
+		return
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+	receipt, err := provider.ParseReceipt(c.Request, body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid receipt"})
+		return
+	}
+
+	if err := sc.smsService.ApplyReceipt(context.Background(), provider.Name(), receipt); err != nil {
+		if errors.Is(err, services.ErrSMSNotFound) {
+			// Possibly sent moments ago; the provider tries again later
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+			return
+		}
+		log.Printf("Failed to apply %s receipt for %s: %v", provider.Name(), receipt.MessageID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process receipt"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Receipt processed"})
+}
+
+// GetMessages lists the SMS outbox, newest first (admin only)
+func (sc *SMSController) GetMessages(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+
+	filter := bson.M{}
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+	if to := c.Query("to"); to != "" {
+		filter["to"] = to
+	}
+
+	skip := (page - 1) * limit
+	opts := options.Find().
+		SetLimit(int64(limit)).
+		SetSkip(int64(skip)).
+		SetSort(bson.M{"created_at": -1})
+
+	cursor, err := sc.messageCollection.Find(context.Background(), filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messages"})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var messages []models.SMSMessage
+	if err = cursor.All(context.Background(), &messages); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode messages"})
+		return
+	}
+
+	total, err := sc.messageCollection.CountDocuments(context.Background(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count messages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"messages": messages,
+		"pagination": gin.H{
+			"page":  page,
+			"limit": limit,
+			"total": total,
+			"pages": (int(total) + limit - 1) / limit,
+		},
+	})
+}
+
+// GetMessage returns one message from the SMS outbox (admin only)
+func (sc *SMSController) GetMessage(c *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+
+	message, err := sc.smsService.GetMessage(context.Background(), objectID)
+	if err != nil {
+		if errors.Is(err, services.ErrSMSNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch message"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sms": message})
+}
+
+// RetryMessage queues a failed message to be sent again (admin only)
+func (sc *SMSController) RetryMessage(c *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+
+	message, err := sc.smsService.Retry(context.Background(), objectID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrSMSNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		case errors.Is(err, services.ErrSMSNotRetryable):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Only failed messages can be retried"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry message"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Message queued",
+		"sms":     message,
+	})
+}
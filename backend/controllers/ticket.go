@@ -2,6 +2,9 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"io"
+	"log"
 	"net/http"
 	"strconv"
 	"time"
@@ -21,15 +24,79 @@ type TicketController struct {
 	ticketCollection *mongo.Collection
 	eventCollection  *mongo.Collection
 	userCollection   *mongo.Collection
-	qrService        *services.QRService
+	inventoryService *services.InventoryService
+	orderService     *services.OrderService
+	refundService    *services.RefundService
+	emailService     *services.EmailService
+	walletService    *services.WalletService
+	doorService      *services.DoorService
+	queueService     *services.QueueService
+	webSocketService *services.WebSocketService
 }
 
-func NewTicketController() *TicketController {
+func NewTicketController(webSocketService *services.WebSocketService) *TicketController {
 	return &TicketController{
 		ticketCollection: utils.GetCollection("tickets"),
 		eventCollection:  utils.GetCollection("events"),
 		userCollection:   utils.GetCollection("users"),
-		qrService:        services.NewQRService(),
+		inventoryService: services.NewInventoryService(),
+		orderService:     services.NewOrderService(),
+		refundService:    services.NewRefundService(),
+		emailService:     services.NewEmailService(),
+		walletService:    services.NewWalletService(),
+		doorService:      services.NewDoorService(webSocketService),
+		queueService:     services.NewQueueService(webSocketService),
+		webSocketService: webSocketService,
+	}
+}
+
+// respondRefundError maps refund request failures to HTTP responses
+func respondRefundError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrPaymentNotRefundable):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ticket has no successful payment to refund"})
+	case errors.Is(err, services.ErrInvalidRefundAmount):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Refund amount must be more than zero and no more than the ticket price"})
+	case errors.Is(err, services.ErrRefundExceedsPayment):
+		c.JSON(http.StatusConflict, gin.H{"error": "Refund exceeds the amount left to refund on the payment"})
+	case errors.Is(err, services.ErrRefundExceedsTicket):
+		c.JSON(http.StatusConflict, gin.H{"error": "Refund exceeds the amount left to refund on the ticket"})
+	case errors.Is(err, services.ErrIdempotencyKeyRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Partial refunds require an Idempotency-Key header"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request refund"})
+	}
+}
+
+// respondReservationError maps inventory reservation failures to HTTP responses
+func respondReservationError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrEventNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+	case errors.Is(err, services.ErrTicketsUnavailable):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Not enough tickets available"})
+	case errors.Is(err, services.ErrInvalidQuantity):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket quantity"})
+	case errors.Is(err, services.ErrTicketTypeRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Please select a ticket type"})
+	case errors.Is(err, services.ErrTicketTypeNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Ticket type not found"})
+	case errors.Is(err, services.ErrTicketTypeNotOnSale):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ticket type is not on sale"})
+	case errors.Is(err, services.ErrOrderLimitExceeded):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity exceeds the per-order limit for this ticket type"})
+	case errors.Is(err, services.ErrSeatCountMismatch):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Please select one seat per ticket"})
+	case errors.Is(err, services.ErrSeatNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrSeatUnavailable):
+		c.JSON(http.StatusConflict, gin.H{"error": "One or more selected seats are no longer available"})
+	case errors.Is(err, services.ErrSeatingNotAvailable):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This event does not have reserved seating"})
+	case errors.Is(err, services.ErrAdmissionUsed):
+		c.JSON(http.StatusConflict, gin.H{"error": "Your turn in the queue has already been used to place an order"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reserve tickets"})
 	}
 }
 
@@ -47,67 +114,33 @@ func (tc *TicketController) CreateTicket(c *gin.Context) {
 		return
 	}
 
-	// Check if event exists and is available
-	var event models.Event
-	err := tc.eventCollection.FindOne(context.Background(), bson.M{"_id": req.EventID}).Decode(&event)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch event"})
+	// Buyers of events sold through a queue must have been let through it
+	admissionID, ok := checkQueueAdmission(c, tc.queueService, req.EventID, user.ID, req.QueueToken)
+	if !ok {
 		return
 	}
 
-	// Check if event can accommodate the requested tickets
-	if !event.CanPurchaseTickets(req.Quantity) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Not enough tickets available"})
-		return
-	}
-
-	// Create ticket
-	ticket := models.Ticket{
-		EventID:    req.EventID,
-		UserID:     user.ID,
-		TicketCode: models.GenerateTicketCode(),
-		Status:     "pending",
-		Price:      event.Price * float64(req.Quantity),
-		Quantity:   req.Quantity,
-		CreatedAt:  time.Now(),
-		UpdatedAt:  time.Now(),
-	}
-
-	// Generate QR code
-	qrCode, err := tc.qrService.GenerateQRCode(ticket.TicketCode)
+	// Claim inventory before creating anything so concurrent buyers cannot oversell
+	reservation, err := tc.inventoryService.Reserve(context.Background(), req.EventID, req.TicketTypeID, req.Quantity)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate QR code"})
+		respondReservationError(c, err)
 		return
 	}
-	ticket.QRCode = qrCode
+	defer reservation.Rollback(context.Background())
+	reservation.AdmissionID = admissionID
 
-	// Insert ticket into database
-	result, err := tc.ticketCollection.InsertOne(context.Background(), ticket)
+	// Create the order with one ticket, code and QR per admission
+	order, tickets, err := reservation.PlaceOrder(context.Background(), user.ID, req.Seats)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create ticket"})
+		respondReservationError(c, err)
 		return
 	}
 
-	ticket.ID = result.InsertedID.(primitive.ObjectID)
-
-	// Update event sold tickets count
-	_, err = tc.eventCollection.UpdateOne(
-		context.Background(),
-		bson.M{"_id": req.EventID},
-		bson.M{"$inc": bson.M{"sold_tickets": req.Quantity}},
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update event"})
-		return
-	}
+	reservation.Commit()
 
 	c.JSON(http.StatusCreated, gin.H{
-		"message": "Ticket created successfully",
-		"ticket":  ticket.ToResponse(),
+		"message": "Tickets created successfully",
+		"order":   order.ToResponseWithTickets(tickets),
 	})
 }
 
@@ -162,6 +195,111 @@ func (tc *TicketController) GetTicketByID(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"ticket": response})
 }
 
+// DownloadTicketPDF returns a paid ticket as a printable PDF
+func (tc *TicketController) DownloadTicketPDF(c *gin.Context) {
+	ticket, event, ok := tc.loadDownloadableTicket(c)
+	if !ok {
+		return
+	}
+
+	pdf, err := services.TicketPDF(event, []models.Ticket{*ticket})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate ticket"})
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="ticket-`+ticket.TicketCode+`.pdf"`)
+	c.Data(http.StatusOK, "application/pdf", pdf)
+}
+
+// DownloadApplePass returns a paid ticket as an Apple Wallet pass
+func (tc *TicketController) DownloadApplePass(c *gin.Context) {
+	ticket, event, ok := tc.loadDownloadableTicket(c)
+	if !ok {
+		return
+	}
+
+	pass, err := tc.walletService.ApplePass(event, ticket)
+	if errors.Is(err, services.ErrAppleWalletNotConfigured) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Apple Wallet passes are not available"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate pass"})
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="ticket-`+ticket.TicketCode+`.pkpass"`)
+	c.Data(http.StatusOK, "application/vnd.apple.pkpass", pass)
+}
+
+// GetGoogleWalletLink returns the link that adds a paid ticket to Google Wallet
+func (tc *TicketController) GetGoogleWalletLink(c *gin.Context) {
+	ticket, event, ok := tc.loadDownloadableTicket(c)
+	if !ok {
+		return
+	}
+
+	url, err := tc.walletService.GoogleWalletURL(event, ticket)
+	if errors.Is(err, services.ErrGoogleWalletNotConfigured) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Google Wallet passes are not available"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate pass"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"url": url})
+}
+
+// loadDownloadableTicket fetches the paid ticket in the :id parameter and its
+// event, if the user holds it, organizes the event or is an admin
+func (tc *TicketController) loadDownloadableTicket(c *gin.Context) (*models.Ticket, *models.Event, bool) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return nil, nil, false
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket ID"})
+		return nil, nil, false
+	}
+
+	var ticket models.Ticket
+	err = tc.ticketCollection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&ticket)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Ticket not found"})
+			return nil, nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch ticket"})
+		return nil, nil, false
+	}
+
+	var event models.Event
+	err = tc.eventCollection.FindOne(context.Background(), bson.M{"_id": ticket.EventID}).Decode(&event)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch event details"})
+		return nil, nil, false
+	}
+
+	// The download carries a working entry code, so only the holder and the people running the event get it
+	if ticket.UserID != user.ID && event.OrganizerID != user.ID && user.Role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return nil, nil, false
+	}
+
+	if ticket.Status != "paid" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only paid tickets can be downloaded"})
+		return nil, nil, false
+	}
+
+	return &ticket, &event, true
+}
+
 // GetUserTickets returns tickets for the current user
 func (tc *TicketController) GetUserTickets(c *gin.Context) {
 	user, exists := utils.GetUserFromContext(c)
@@ -248,11 +386,36 @@ func (tc *TicketController) VerifyTicket(c *gin.Context) {
 		return
 	}
 
+	eventID, err := primitive.ObjectIDFromHex(req.EventID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+
+	var event models.Event
+	err = tc.eventCollection.FindOne(context.Background(), bson.M{"_id": eventID}).Decode(&event)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch event"})
+		return
+	}
+
+	// Only the people running the event check tickets at its door, so nobody
+	// else can probe codes or add to its door figures
+	if !event.CanCheckTickets(user) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
 	// Find ticket by ticket code
 	var ticket models.Ticket
-	err := tc.ticketCollection.FindOne(context.Background(), bson.M{"ticket_code": req.TicketCode}).Decode(&ticket)
+	err = tc.ticketCollection.FindOne(context.Background(), bson.M{"ticket_code": req.TicketCode}).Decode(&ticket)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
+			tc.recordScan(&req, services.ScanRejected, "Invalid ticket code")
 			c.JSON(http.StatusOK, gin.H{
 				"valid":   false,
 				"message": "Invalid ticket code",
@@ -265,6 +428,7 @@ func (tc *TicketController) VerifyTicket(c *gin.Context) {
 
 	// Check if ticket is for the specified event
 	if req.EventID != ticket.EventID.Hex() {
+		tc.recordScan(&req, services.ScanRejected, "Ticket is not valid for this event")
 		c.JSON(http.StatusOK, gin.H{
 			"valid":   false,
 			"message": "Ticket is not valid for this event",
@@ -275,12 +439,17 @@ func (tc *TicketController) VerifyTicket(c *gin.Context) {
 	// Check if ticket can be used
 	if !ticket.CanBeUsed() {
 		message := "Ticket is not valid"
+		result := services.ScanRejected
 		if ticket.IsUsed() {
 			message = "Ticket has already been used"
+			result = services.ScanDuplicate
+		} else if ticket.Status == "expired" {
+			message = "Ticket hold has expired"
 		} else if ticket.Status != "paid" {
 			message = "Ticket payment is pending"
 		}
 
+		tc.recordScan(&req, result, message)
 		c.JSON(http.StatusOK, gin.H{
 			"valid":   false,
 			"message": message,
@@ -291,10 +460,10 @@ func (tc *TicketController) VerifyTicket(c *gin.Context) {
 	// Mark ticket as used
 	ticket.MarkAsUsed()
 
-	// Update ticket in database
-	_, err = tc.ticketCollection.UpdateOne(
+	// Update ticket in database; only one scan of the same code can succeed
+	result, err := tc.ticketCollection.UpdateOne(
 		context.Background(),
-		bson.M{"_id": ticket.ID},
+		bson.M{"_id": ticket.ID, "status": "paid", "used_at": nil},
 		bson.M{"$set": bson.M{
 			"status":     ticket.Status,
 			"used_at":    ticket.UsedAt,
@@ -305,15 +474,18 @@ func (tc *TicketController) VerifyTicket(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update ticket"})
 		return
 	}
-
-	// Get event and user details for response
-	var event models.Event
-	err = tc.eventCollection.FindOne(context.Background(), bson.M{"_id": ticket.EventID}).Decode(&event)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch event details"})
+	if result.ModifiedCount == 0 {
+		tc.recordScan(&req, services.ScanDuplicate, "Ticket has already been used")
+		c.JSON(http.StatusOK, gin.H{
+			"valid":   false,
+			"message": "Ticket has already been used",
+		})
 		return
 	}
 
+	tc.recordScan(&req, services.ScanAdmitted, "Ticket verified successfully")
+
+	// Get user details for response
 	var ticketUser models.User
 	err = tc.userCollection.FindOne(context.Background(), bson.M{"_id": ticket.UserID}).Decode(&ticketUser)
 	if err != nil {
@@ -324,9 +496,11 @@ func (tc *TicketController) VerifyTicket(c *gin.Context) {
 	response := ticket.ToResponseWithDetails(event.ToResponse(), ticketUser.ToResponse())
 
 	c.JSON(http.StatusOK, gin.H{
-		"valid":   true,
-		"message": "Ticket verified successfully",
-		"ticket":  response,
+		"valid":       true,
+		"message":     "Ticket verified successfully",
+		"ticket_type": ticket.GetTicketTypeName(),
+		"seats":       ticket.SeatLabels(),
+		"ticket":      response,
 	})
 }
 
@@ -356,10 +530,18 @@ func (tc *TicketController) CancelTicket(c *gin.Context) {
 		return
 	}
 
-	// Check if user has permission to cancel this ticket
-	if ticket.UserID != user.ID && user.Role != "admin" && user.Role != "organizer" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
-		return
+	// Holders can cancel their own tickets; otherwise only the event's organizer or an admin can
+	if ticket.UserID != user.ID && user.Role != "admin" {
+		var event models.Event
+		err = tc.eventCollection.FindOne(context.Background(), bson.M{"_id": ticket.EventID}).Decode(&event)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch event details"})
+			return
+		}
+		if event.OrganizerID != user.ID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			return
+		}
 	}
 
 	// Check if ticket can be cancelled
@@ -373,10 +555,35 @@ func (tc *TicketController) CancelTicket(c *gin.Context) {
 		return
 	}
 
-	// Update ticket status to cancelled
-	_, err = tc.ticketCollection.UpdateOne(
+	if ticket.Status == "expired" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ticket hold has already expired"})
+		return
+	}
+
+	// An unpaid order is paid for as a whole, so cancelling one of its tickets cancels all of them
+	if ticket.Status == "pending" && !ticket.OrderID.IsZero() {
+		cancelled, err := tc.orderService.ClosePending(context.Background(), ticket.OrderID, "cancelled")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel order"})
+			return
+		}
+		if !cancelled {
+			c.JSON(http.StatusConflict, gin.H{"error": "Ticket status changed, please try again"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":  "Order cancelled successfully",
+			"order_id": ticket.OrderID,
+			"refunded": false,
+		})
+		return
+	}
+
+	// Update ticket status to cancelled, unless the hold sweeper got to it first
+	result, err := tc.ticketCollection.UpdateOne(
 		context.Background(),
-		bson.M{"_id": objectID},
+		bson.M{"_id": objectID, "status": ticket.Status},
 		bson.M{"$set": bson.M{
 			"status":     "cancelled",
 			"updated_at": time.Now(),
@@ -386,41 +593,44 @@ func (tc *TicketController) CancelTicket(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel ticket"})
 		return
 	}
+	if result.ModifiedCount == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Ticket status changed, please try again"})
+		return
+	}
 
-	// If ticket was paid, initiate refund process
-	if ticket.Status == "paid" {
-		// TODO: Implement refund logic with payment provider
-		// For now, we'll just update the status to refunded
-		_, err = tc.ticketCollection.UpdateOne(
-			context.Background(),
-			bson.M{"_id": objectID},
-			bson.M{"$set": bson.M{
-				"status":     "refunded",
-				"updated_at": time.Now(),
-			}},
-		)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process refund"})
-			return
-		}
+	// Return the cancelled admission to the event's inventory
+	if err := tc.inventoryService.ReleaseTicket(context.Background(), &ticket); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update event"})
+		return
+	}
+
+	if err := tc.orderService.SyncStatus(context.Background(), ticket.OrderID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
+		return
 	}
 
-	// Update event sold tickets count (decrease by cancelled quantity)
+	// A paid ticket is refunded through its provider; it becomes "refunded" once the provider confirms
+	var refund *models.Refund
 	if ticket.Status == "paid" {
-		_, err = tc.eventCollection.UpdateOne(
-			context.Background(),
-			bson.M{"_id": ticket.EventID},
-			bson.M{"$inc": bson.M{"sold_tickets": -ticket.Quantity}},
-		)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update event"})
+		ticket.Status = "cancelled"
+		refund, err = tc.refundService.RefundTicket(context.Background(), &ticket, 0, "Ticket cancelled", user.ID, c.GetHeader("Idempotency-Key"))
+		if err != nil && !errors.Is(err, services.ErrPaymentNotRefundable) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ticket cancelled but the refund could not be requested; please contact support"})
 			return
 		}
+
+		go func() {
+			if err := tc.emailService.SendCancellationNotice(context.Background(), &ticket, refund); err != nil {
+				log.Printf("Failed to email cancellation of ticket %s: %v", ticket.TicketCode, err)
+			}
+		}()
+		go tc.publishEventStats(ticket.EventID)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Ticket cancelled successfully",
-		"refunded": ticket.Status == "paid",
+		"message":  "Ticket cancelled successfully",
+		"refunded": refund != nil && refund.Status == "completed",
+		"refund":   refund,
 	})
 }
 
@@ -456,44 +666,111 @@ func (tc *TicketController) RefundTicket(c *gin.Context) {
 		return
 	}
 
+	// Organizers can only refund tickets to their own events
+	var event models.Event
+	err = tc.eventCollection.FindOne(context.Background(), bson.M{"_id": ticket.EventID}).Decode(&event)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch event details"})
+		return
+	}
+	if event.OrganizerID != user.ID && user.Role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
 	// Check if ticket can be refunded
 	if ticket.Status != "cancelled" && ticket.Status != "paid" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Ticket cannot be refunded"})
 		return
 	}
 
-	// TODO: Implement actual refund logic with payment provider
-	// For now, we'll just update the status
+	// The body is optional; without one the full ticket price is refunded
+	var req models.RefundTicketRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
 
-	// Update ticket status to refunded
-	_, err = tc.ticketCollection.UpdateOne(
-		context.Background(),
-		bson.M{"_id": objectID},
-		bson.M{"$set": bson.M{
-			"status":     "refunded",
-			"updated_at": time.Now(),
-		}},
-	)
+	// A paid ticket stops admitting its holder before the money goes back.
+	// Cancelled tickets already returned their inventory when they were cancelled.
+	if ticket.Status == "paid" {
+		result, err := tc.ticketCollection.UpdateOne(
+			context.Background(),
+			bson.M{"_id": objectID, "status": "paid"},
+			bson.M{"$set": bson.M{
+				"status":     "cancelled",
+				"updated_at": time.Now(),
+			}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel ticket"})
+			return
+		}
+		if result.ModifiedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Ticket status changed, please try again"})
+			return
+		}
+		if err := tc.inventoryService.ReleaseTicket(context.Background(), &ticket); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update event"})
+			return
+		}
+		if err := tc.orderService.SyncStatus(context.Background(), ticket.OrderID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
+			return
+		}
+		ticket.Status = "cancelled"
+		go tc.publishEventStats(ticket.EventID)
+	}
+
+	refund, err := tc.refundService.RefundTicket(context.Background(), &ticket, req.Amount, req.Reason, user.ID, c.GetHeader("Idempotency-Key"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process refund"})
+		respondRefundError(c, err)
 		return
 	}
 
-	// Update event sold tickets count (decrease by refunded quantity)
-	_, err = tc.eventCollection.UpdateOne(
-		context.Background(),
-		bson.M{"_id": ticket.EventID},
-		bson.M{"$inc": bson.M{"sold_tickets": -ticket.Quantity}},
-	)
+	// The ticket is marked refunded when the provider confirms the transfer
+	status := http.StatusAccepted
+	if refund.IsSettled() {
+		status = http.StatusOK
+	}
+	c.JSON(status, gin.H{
+		"message": "Refund " + refund.Status,
+		"refund":  refund,
+	})
+}
+
+// recordScan counts a scan at the door of the event being checked in, and
+// streams it to the dashboards following the event
+func (tc *TicketController) recordScan(req *models.VerifyTicketRequest, result, message string) {
+	eventID, err := primitive.ObjectIDFromHex(req.EventID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update event"})
 		return
 	}
+	scan := &services.TicketScan{
+		EventID:    req.EventID,
+		TicketCode: req.TicketCode,
+		Entrance:   req.Entrance,
+		Result:     result,
+		Message:    message,
+		ScannedAt:  time.Now(),
+	}
+	go func() {
+		if err := tc.doorService.RecordScan(context.Background(), eventID, scan); err != nil {
+			log.Printf("Failed to record scan for event %s: %v", req.EventID, err)
+		}
+	}()
+}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Refund processed successfully",
-		"amount":  ticket.Price,
-	})
+// publishEventStats sends an event's new totals to the dashboards following it
+func (tc *TicketController) publishEventStats(eventID primitive.ObjectID) {
+	var event models.Event
+	if err := tc.eventCollection.FindOne(context.Background(), bson.M{"_id": eventID}).Decode(&event); err != nil {
+		log.Printf("Failed to fetch event %s for live updates: %v", eventID.Hex(), err)
+		return
+	}
+	if err := tc.webSocketService.PublishEventStats(context.Background(), &event); err != nil {
+		log.Printf("Failed to publish live updates for event %s: %v", eventID.Hex(), err)
+	}
 }
 
 // GetEventTickets returns all tickets for a specific event (organizer/admin only)
@@ -597,4 +874,44 @@ func (tc *TicketController) GetEventTickets(c *gin.Context) {
 			"pages": (int(total) + limit - 1) / limit,
 		},
 	})
-} 
\ No newline at end of file
+}
+
+// GetDoorStats returns an event's figures at the door to those who check
+// its tickets
+func (tc *TicketController) GetDoorStats(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(c.Param("eventId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+
+	var event models.Event
+	err = tc.eventCollection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&event)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch event"})
+		return
+	}
+
+	if !event.CanCheckTickets(user) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	stats, err := tc.doorService.Stats(context.Background(), &event, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch door stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"eventticketing/models"
+	"eventticketing/services"
+	"eventticketing/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// asUser serves a handler to user as if they had signed in
+func asUser(handler gin.HandlerFunc, user *models.User) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("user", user)
+		handler(c)
+	}
+}
+
+func TestTicketCheckingPermissions(t *testing.T) {
+	setupControllerTest(t)
+	ctx := context.Background()
+	tc := NewTicketController(services.NewWebSocketService())
+
+	organizer := &models.User{ID: primitive.NewObjectID(), Role: "organizer"}
+	otherOrganizer := &models.User{ID: primitive.NewObjectID(), Role: "organizer"}
+	admin := &models.User{ID: primitive.NewObjectID(), Role: "admin"}
+	staff := &models.User{ID: primitive.NewObjectID(), Role: "user"}
+	otherStaff := &models.User{ID: primitive.NewObjectID(), Role: "user"}
+	buyer := &models.User{ID: primitive.NewObjectID(), Role: "user"}
+
+	event := models.Event{
+		ID:          primitive.NewObjectID(),
+		Title:       "Door Test Event",
+		Status:      "active",
+		MaxTickets:  10,
+		SoldTickets: 1,
+		OrganizerID: organizer.ID,
+		DoorStaff:   []primitive.ObjectID{staff.ID},
+	}
+	other := models.Event{
+		ID:          primitive.NewObjectID(),
+		Title:       "Other Event",
+		Status:      "active",
+		OrganizerID: otherOrganizer.ID,
+		DoorStaff:   []primitive.ObjectID{otherStaff.ID},
+	}
+	if _, err := utils.GetCollection("events").InsertMany(ctx, []interface{}{event, other}); err != nil {
+		t.Fatalf("failed to insert events: %v", err)
+	}
+	ticket := models.Ticket{
+		ID:         primitive.NewObjectID(),
+		EventID:    event.ID,
+		UserID:     buyer.ID,
+		TicketCode: models.GenerateTicketCode(),
+		Status:     "paid",
+		Quantity:   1,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+	if _, err := utils.GetCollection("tickets").InsertOne(ctx, ticket); err != nil {
+		t.Fatalf("failed to insert ticket: %v", err)
+	}
+
+	// Refusals come first, so the ticket is still unused when they are tried
+	for _, tt := range []struct {
+		name string
+		user *models.User
+		want int
+	}{
+		{"buyer", buyer, http.StatusForbidden},
+		{"another event's organizer", otherOrganizer, http.StatusForbidden},
+		{"another event's door staff", otherStaff, http.StatusForbidden},
+		{"door staff", staff, http.StatusOK},
+		{"organizer", organizer, http.StatusOK},
+		{"admin", admin, http.StatusOK},
+	} {
+		for _, request := range []struct {
+			method, path, body string
+			handler            gin.HandlerFunc
+		}{
+			{http.MethodPost, "/tickets/verify", `{"ticket_code":"` + ticket.TicketCode + `","event_id":"` + event.ID.Hex() + `"}`, tc.VerifyTicket},
+			{http.MethodGet, "/tickets/event/" + event.ID.Hex() + "/door", "", tc.GetDoorStats},
+		} {
+			router := gin.New()
+			router.POST("/tickets/verify", asUser(request.handler, tt.user))
+			router.GET("/tickets/event/:eventId/door", asUser(request.handler, tt.user))
+
+			req := httptest.NewRequest(request.method, request.path, strings.NewReader(request.body))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("%s %s as %s: expected %d, got %d", request.method, request.path, tt.name, tt.want, w.Code)
+			}
+		}
+
+		if tt.want == http.StatusForbidden {
+			var stored models.Ticket
+			utils.GetCollection("tickets").FindOne(ctx, bson.M{"_id": ticket.ID}).Decode(&stored)
+			if stored.IsUsed() {
+				t.Fatalf("ticket checked in by %s", tt.name)
+			}
+		}
+	}
+}
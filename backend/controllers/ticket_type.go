@@ -0,0 +1,309 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// buildTicketTypes turns requested tiers into ticket types, carrying over the
+// sold counts of existing tiers with the same ID. Existing tiers missing from
+// the request are dropped, which is only allowed while none of them are sold.
+func buildTicketTypes(existing []models.TicketType, requests []models.TicketTypeRequest) ([]models.TicketType, error) {
+	current := make(map[primitive.ObjectID]models.TicketType, len(existing))
+	for _, tt := range existing {
+		current[tt.ID] = tt
+	}
+
+	kept := make(map[primitive.ObjectID]bool, len(requests))
+	ticketTypes := make([]models.TicketType, 0, len(requests))
+	for i := range requests {
+		req := &requests[i]
+		if err := req.Validate(); err != nil {
+			return nil, err
+		}
+
+		sold := 0
+		if !req.ID.IsZero() {
+			tt, ok := current[req.ID]
+			if !ok {
+				return nil, fmt.Errorf("ticket type %s does not belong to this event", req.ID.Hex())
+			}
+			if kept[req.ID] {
+				return nil, fmt.Errorf("ticket type %s is listed more than once", req.ID.Hex())
+			}
+			if req.Capacity < tt.Sold {
+				return nil, fmt.Errorf("capacity of %s cannot be lower than the %d tickets already sold", tt.Name, tt.Sold)
+			}
+			sold = tt.Sold
+			kept[req.ID] = true
+		}
+
+		ticketTypes = append(ticketTypes, req.ToTicketType(sold))
+	}
+
+	for _, tt := range existing {
+		if !kept[tt.ID] && tt.Sold > 0 {
+			return nil, fmt.Errorf("ticket type %s cannot be removed after tickets have been sold", tt.Name)
+		}
+	}
+
+	return ticketTypes, nil
+}
+
+// loadManagedEvent fetches the event in the :id parameter and checks that the
+// current user may manage it, writing the error response when not
+func (ec *EventController) loadManagedEvent(c *gin.Context) (*models.Event, bool) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return nil, false
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return nil, false
+	}
+
+	var event models.Event
+	err = ec.eventCollection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&event)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch event"})
+		return nil, false
+	}
+
+	// Check if user is organizer or admin
+	if event.OrganizerID != user.ID && user.Role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return nil, false
+	}
+
+	return &event, true
+}
+
+// saveTicketTypes replaces an event's tiers and the totals derived from them.
+// The write is conditional on the sold count read with the event, so a sale
+// that lands in between makes it fail rather than lose the sale's increments.
+func (ec *EventController) saveTicketTypes(c *gin.Context, event *models.Event, ticketTypes []models.TicketType) bool {
+	soldTickets := event.SoldTickets
+	event.TicketTypes = ticketTypes
+	event.SyncTicketTypeTotals()
+	if event.HasReservedSeating() && !ec.checkSeatCapacity(c, event) {
+		return false
+	}
+
+	result, err := ec.eventCollection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": event.ID, "sold_tickets": soldTickets},
+		bson.M{"$set": bson.M{
+			"ticket_types": event.TicketTypes,
+			"price":        event.Price,
+			"max_tickets":  event.MaxTickets,
+			"updated_at":   time.Now(),
+		}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update ticket types"})
+		return false
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Tickets were sold while updating, please try again"})
+		return false
+	}
+	return true
+}
+
+// checkSeatCapacity rejects tiers that would sell more tickets than a reserved-seating venue has seats
+func (ec *EventController) checkSeatCapacity(c *gin.Context, event *models.Event) bool {
+	venue, err := ec.seatingService.LoadVenue(context.Background(), event.VenueID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch venue"})
+		return false
+	}
+	if seats := venue.SellableSeats(); event.MaxTickets > seats {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Ticket capacity of %d exceeds the venue's %d seats", event.MaxTickets, seats)})
+		return false
+	}
+	return true
+}
+
+// ticketTypeRequests converts an event's tiers back into requests so one can be edited in place
+func ticketTypeRequests(ticketTypes []models.TicketType) []models.TicketTypeRequest {
+	requests := make([]models.TicketTypeRequest, 0, len(ticketTypes))
+	for _, tt := range ticketTypes {
+		requests = append(requests, models.TicketTypeRequest{
+			ID:          tt.ID,
+			Name:        tt.Name,
+			Description: tt.Description,
+			Price:       tt.Price,
+			Capacity:    tt.Capacity,
+			SaleStart:   tt.SaleStart,
+			SaleEnd:     tt.SaleEnd,
+			MaxPerOrder: tt.MaxPerOrder,
+		})
+	}
+	return requests
+}
+
+// GetTicketTypes returns the ticket types of an event with their availability
+func (ec *EventController) GetTicketTypes(c *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+
+	var event models.Event
+	err = ec.eventCollection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&event)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch event"})
+		return
+	}
+
+	now := time.Now()
+	var ticketTypes []gin.H
+	for _, tt := range event.TicketTypes {
+		ticketTypes = append(ticketTypes, gin.H{
+			"ticket_type": tt,
+			"available":   tt.GetAvailableTickets(),
+			"on_sale":     tt.IsOnSale(now),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ticket_types": ticketTypes})
+}
+
+// AddTicketType adds a tier to an event
+func (ec *EventController) AddTicketType(c *gin.Context) {
+	event, ok := ec.loadManagedEvent(c)
+	if !ok {
+		return
+	}
+
+	var req models.TicketTypeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+	req.ID = primitive.NilObjectID
+
+	// General admission sales are not attributed to any tier, so the event cannot switch over
+	if !event.HasTicketTypes() && event.SoldTickets > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Ticket types cannot be added after general admission tickets have been sold"})
+		return
+	}
+
+	ticketTypes, err := buildTicketTypes(event.TicketTypes, append(ticketTypeRequests(event.TicketTypes), req))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !ec.saveTicketTypes(c, event, ticketTypes) {
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":     "Ticket type added successfully",
+		"ticket_type": ticketTypes[len(ticketTypes)-1],
+	})
+}
+
+// UpdateTicketType changes a tier's details; its capacity cannot drop below what has been sold
+func (ec *EventController) UpdateTicketType(c *gin.Context) {
+	event, ok := ec.loadManagedEvent(c)
+	if !ok {
+		return
+	}
+
+	ticketTypeID, err := primitive.ObjectIDFromHex(c.Param("typeId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket type ID"})
+		return
+	}
+	if event.FindTicketType(ticketTypeID) == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Ticket type not found"})
+		return
+	}
+
+	var req models.TicketTypeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+	req.ID = ticketTypeID
+
+	requests := ticketTypeRequests(event.TicketTypes)
+	for i := range requests {
+		if requests[i].ID == ticketTypeID {
+			requests[i] = req
+		}
+	}
+
+	ticketTypes, err := buildTicketTypes(event.TicketTypes, requests)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !ec.saveTicketTypes(c, event, ticketTypes) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Ticket type updated successfully",
+		"ticket_type": event.FindTicketType(ticketTypeID),
+	})
+}
+
+// DeleteTicketType removes a tier that has not sold any tickets
+func (ec *EventController) DeleteTicketType(c *gin.Context) {
+	event, ok := ec.loadManagedEvent(c)
+	if !ok {
+		return
+	}
+
+	ticketTypeID, err := primitive.ObjectIDFromHex(c.Param("typeId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket type ID"})
+		return
+	}
+	if event.FindTicketType(ticketTypeID) == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Ticket type not found"})
+		return
+	}
+
+	var requests []models.TicketTypeRequest
+	for _, req := range ticketTypeRequests(event.TicketTypes) {
+		if req.ID != ticketTypeID {
+			requests = append(requests, req)
+		}
+	}
+
+	ticketTypes, err := buildTicketTypes(event.TicketTypes, requests)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if !ec.saveTicketTypes(c, event, ticketTypes) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Ticket type deleted successfully"})
+}
@@ -0,0 +1,165 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"eventticketing/models"
+	"eventticketing/services"
+	"eventticketing/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type VenueController struct {
+	venueCollection *mongo.Collection
+	eventCollection *mongo.Collection
+	seatingService  *services.SeatingService
+}
+
+func NewVenueController() *VenueController {
+	return &VenueController{
+		venueCollection: utils.GetCollection("venues"),
+		eventCollection: utils.GetCollection("events"),
+		seatingService:  services.NewSeatingService(),
+	}
+}
+
+// CreateVenue creates a venue with its seat map
+func (vc *VenueController) CreateVenue(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req models.CreateVenueRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+	if len(req.Name) < 2 || len(req.Name) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Venue name must be between 2 and 100 characters"})
+		return
+	}
+
+	venue := models.Venue{
+		Name:        req.Name,
+		Address:     req.Address,
+		Sections:    req.Sections,
+		OrganizerID: user.ID,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if err := venue.Normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := vc.venueCollection.InsertOne(context.Background(), venue)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create venue"})
+		return
+	}
+
+	venue.ID = result.InsertedID.(primitive.ObjectID)
+	c.JSON(http.StatusCreated, gin.H{
+		"message":        "Venue created successfully",
+		"venue":          venue,
+		"sellable_seats": venue.SellableSeats(),
+	})
+}
+
+// GetVenues returns the venues created by the current organizer, or all venues for admins
+func (vc *VenueController) GetVenues(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	filter := bson.M{}
+	if user.Role != "admin" {
+		filter["organizer_id"] = user.ID
+	}
+
+	opts := options.Find().SetSort(bson.M{"name": 1})
+	cursor, err := vc.venueCollection.Find(context.Background(), filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch venues"})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var venues []models.Venue
+	if err = cursor.All(context.Background(), &venues); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode venues"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"venues": venues})
+}
+
+// GetVenueByID returns a venue's seat map
+func (vc *VenueController) GetVenueByID(c *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid venue ID"})
+		return
+	}
+
+	venue, err := vc.seatingService.LoadVenue(context.Background(), objectID)
+	if err != nil {
+		if errors.Is(err, services.ErrVenueNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Venue not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch venue"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"venue": venue})
+}
+
+// GetEventSeats returns the seat map of a reserved-seating event with each seat's availability
+func (vc *VenueController) GetEventSeats(c *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+
+	var event models.Event
+	err = vc.eventCollection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&event)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch event"})
+		return
+	}
+
+	if !event.HasReservedSeating() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This event does not have reserved seating"})
+		return
+	}
+
+	sections, err := vc.seatingService.Availability(context.Background(), &event)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch seat availability"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"event_id": event.ID,
+		"venue_id": event.VenueID,
+		"sections": sections,
+	})
+}
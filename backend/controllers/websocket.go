@@ -0,0 +1,32 @@
+package controllers
+
+import (
+	"net/http"
+
+	"eventticketing/services"
+	"eventticketing/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebSocketController struct {
+	webSocketService *services.WebSocketService
+}
+
+func NewWebSocketController(webSocketService *services.WebSocketService) *WebSocketController {
+	return &WebSocketController{
+		webSocketService: webSocketService,
+	}
+}
+
+// HandleWebSocket upgrades an authenticated request to a WebSocket for live
+// notifications and event updates
+func (wc *WebSocketController) HandleWebSocket(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	wc.webSocketService.HandleWebSocket(c.Writer, c.Request, user)
+}
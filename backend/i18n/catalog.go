@@ -0,0 +1,118 @@
+// Package i18n holds the text customers see over USSD and SMS in every
+// language the service speaks.
+//
+// Each language is a JSON file in locales mapping message keys to fmt
+// templates. Translations may reorder arguments with explicit indexes such
+// as %[2]s. A message missing from a language falls back to the base
+// language (fr for fr-CI) and then to English, so a translation can be
+// shipped before every message is translated.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// DefaultLanguage is used for callers without a language and for messages a
+// language has not translated
+const DefaultLanguage = "en"
+
+// Language is a language customers can choose
+type Language struct {
+	Code string `json:"code"`
+	Name string `json:"name"` // in the language itself
+}
+
+// Languages are the supported languages, in the order they are offered
+var Languages = []Language{
+	{Code: "en", Name: "English"},
+	{Code: "tw", Name: "Twi"},
+	{Code: "ee", Name: "Eʋegbe"},
+	{Code: "ha", Name: "Hausa"},
+	{Code: "fr", Name: "Français"},
+}
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// catalog maps a language code to its messages
+var catalog = loadCatalog()
+
+func loadCatalog() map[string]map[string]string {
+	catalog := make(map[string]map[string]string)
+	for _, language := range Languages {
+		data, err := localeFiles.ReadFile(path.Join("locales", language.Code+".json"))
+		if err != nil {
+			panic(fmt.Sprintf("i18n: no messages for %s: %v", language.Code, err))
+		}
+		messages := make(map[string]string)
+		if err := json.Unmarshal(data, &messages); err != nil {
+			panic(fmt.Sprintf("i18n: invalid messages for %s: %v", language.Code, err))
+		}
+		catalog[language.Code] = messages
+	}
+	return catalog
+}
+
+// Supported returns the supported language a code such as "fr" or "fr-CI"
+// refers to, or "" when there is none
+func Supported(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if _, ok := catalog[code]; ok {
+		return code
+	}
+	if base, _, found := strings.Cut(code, "-"); found {
+		if _, ok := catalog[base]; ok {
+			return base
+		}
+	}
+	return ""
+}
+
+// Lookup returns a language's template for a message and whether any
+// language has it
+func Lookup(language, key string) (string, bool) {
+	if code := Supported(language); code != "" {
+		if template, ok := catalog[code][key]; ok {
+			return template, true
+		}
+	}
+	template, ok := catalog[DefaultLanguage][key]
+	return template, ok
+}
+
+// T returns a message in the given language with args filled in. Unknown
+// keys come back as the key itself, so a missing message shows up rather
+// than leaving the screen blank.
+func T(language, key string, args ...interface{}) string {
+	template, ok := Lookup(language, key)
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		return template
+	}
+	return fmt.Sprintf(template, args...)
+}
+
+// Date writes a date and time the way the language does
+func Date(language string, t time.Time) string {
+	return t.Format(T(language, "format.date"))
+}
+
+// ShortDate writes a day of the year the way the language does
+func ShortDate(language string, t time.Time) string {
+	return t.Format(T(language, "format.date_short"))
+}
+
+// TicketStatus names a ticket status in the given language
+func TicketStatus(language, status string) string {
+	if name, ok := Lookup(language, "ticket.status."+status); ok {
+		return name
+	}
+	return status
+}
@@ -0,0 +1,82 @@
+package i18n
+
+import (
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+)
+
+var (
+	verbPattern  = regexp.MustCompile(`%(\[\d+\])?[a-z]`)
+	indexPattern = regexp.MustCompile(`\[\d+\]`)
+)
+
+// verbs lists a template's fmt verbs, ignoring the order translations put them in
+func verbs(template string) []string {
+	found := verbPattern.FindAllString(template, -1)
+	for i, verb := range found {
+		found[i] = indexPattern.ReplaceAllString(verb, "")
+	}
+	sort.Strings(found)
+	return found
+}
+
+func TestCatalogMatchesEnglish(t *testing.T) {
+	english := catalog[DefaultLanguage]
+	for _, language := range Languages {
+		for key, template := range catalog[language.Code] {
+			source, ok := english[key]
+			if !ok {
+				t.Errorf("%s has %q, which English does not", language.Code, key)
+				continue
+			}
+			if got, expected := verbs(template), verbs(source); len(got) != len(expected) {
+				t.Errorf("%s %q takes %v, English takes %v", language.Code, key, got, expected)
+			}
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	cases := []struct {
+		language, key string
+		args          []interface{}
+		expected      string
+	}{
+		{"fr", "ussd.quantity.range", []interface{}{4}, "Combien de billets ?\nSaisissez de 1 à 4"},
+		{"fr-CI", "ussd.main.title", nil, "Bienvenue sur EventTix"},
+		{"ha", "ussd.main.title", nil, "Barka da zuwa EventTix"},
+		{"ha", "ussd.purchase.no_seats", nil, "Sorry, no seats available for this event."}, // not yet translated
+		{"", "ussd.main.title", nil, "Welcome to EventTix"},
+		{"xx", "ussd.main.title", nil, "Welcome to EventTix"},
+		{"en", "ussd.no_such_message", nil, "ussd.no_such_message"},
+	}
+	for _, tc := range cases {
+		if got := T(tc.language, tc.key, tc.args...); got != tc.expected {
+			t.Errorf("%s %s: expected %q, got %q", tc.language, tc.key, tc.expected, got)
+		}
+	}
+
+	date := time.Date(2024, time.March, 9, 19, 30, 0, 0, time.UTC)
+	if got := Date("fr", date); got != "09/03/2024 19:30" {
+		t.Errorf("expected a French date, got %q", got)
+	}
+	if got := ShortDate("tw", date); got != "Mar 9" {
+		t.Errorf("expected Twi to fall back to the English short date, got %q", got)
+	}
+	if got := TicketStatus("fr", "paid"); got != "payé" {
+		t.Errorf("expected a French status, got %q", got)
+	}
+	if got := TicketStatus("fr", "transferred"); got != "transferred" {
+		t.Errorf("expected an unknown status to be shown as is, got %q", got)
+	}
+}
+
+func TestSupported(t *testing.T) {
+	for code, expected := range map[string]string{"EN": "en", "fr-CI": "fr", "ee": "ee", "yo": "", "": ""} {
+		if got := Supported(code); got != expected {
+			t.Errorf("Supported(%q): expected %q, got %q", code, expected, got)
+		}
+	}
+}
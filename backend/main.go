@@ -12,6 +12,7 @@ import (
 	"eventticketing/config"
 	"eventticketing/middleware"
 	"eventticketing/routes"
+	"eventticketing/services"
 	"eventticketing/utils"
 
 	"github.com/gin-gonic/gin"
@@ -33,6 +34,25 @@ func main() {
 	// Create indexes
 	utils.CreateIndexes()
 
+	// Load the stored settings, which replace the feature settings from the environment
+	settingsService := services.NewSettingsService()
+	if _, err := settingsService.Load(context.Background()); err != nil {
+		log.Printf("Failed to load settings, using the environment's: %v", err)
+	}
+
+	// Start background workers
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	go settingsService.Start(workerCtx)
+	go services.NewHoldSweeper().Start(workerCtx)
+	go services.NewRefundWorker().Start(workerCtx)
+	go services.NewReconciler().Start(workerCtx)
+	go services.NewSMSWorker().Start(workerCtx)
+	webSocketService := services.NewWebSocketService()
+	go webSocketService.Start(workerCtx)
+	go services.NewQueueService(webSocketService).Start(workerCtx)
+	go services.NewPricingService().Start(workerCtx)
+
 	// Initialize router
 	router := gin.Default()
 
@@ -45,7 +65,7 @@ func main() {
 	authMiddleware := middleware.NewAuthMiddleware()
 
 	// Setup routes
-	routes.SetupRoutes(router, authMiddleware)
+	routes.SetupRoutes(router, authMiddleware, webSocketService)
 
 	// Health check endpoint
 	router.GET("/health", func(c *gin.Context) {
@@ -75,6 +95,7 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Println("Shutting down server...")
+	stopWorkers()
 
 	// Create a deadline for server shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -86,4 +107,4 @@ func main() {
 	}
 
 	log.Println("Server exited")
-} 
\ No newline at end of file
+}
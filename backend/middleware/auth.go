@@ -3,14 +3,12 @@ package middleware
 import (
 	"context"
 	"net/http"
-	"strings"
 
 	"eventticketing/models"
 	"eventticketing/utils"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
@@ -41,32 +39,67 @@ func (am *AuthMiddleware) AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		claims, err := utils.ValidateToken(tokenString)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
-			c.Abort()
-			return
+		if am.authenticate(c, tokenString) {
+			c.Next()
 		}
+	}
+}
 
-		// Get user from database
-		var user models.User
-		err = am.userCollection.FindOne(context.Background(), bson.M{"_id": claims.UserID}).Decode(&user)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+// WebSocketAuth authenticates a WebSocket handshake like AuthMiddleware.
+// Browsers cannot set headers on WebSocket requests, so the token may also
+// be given as the access_token query parameter.
+func (am *AuthMiddleware) WebSocketAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString := c.Query("access_token")
+		if authHeader := c.GetHeader("Authorization"); authHeader != "" {
+			var err error
+			tokenString, err = utils.ExtractTokenFromHeader(authHeader)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header"})
+				c.Abort()
+				return
+			}
+		}
+		if tokenString == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
 			c.Abort()
 			return
 		}
 
-		if !user.IsActive {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User account is deactivated"})
-			c.Abort()
-			return
+		if am.authenticate(c, tokenString) {
+			c.Next()
 		}
+	}
+}
 
-		// Set user in context
-		c.Set("user", &user)
-		c.Next()
+// authenticate sets the active user a token belongs to in the context, or
+// responds 401 and reports false
+func (am *AuthMiddleware) authenticate(c *gin.Context, tokenString string) bool {
+	claims, err := utils.ValidateToken(tokenString)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		c.Abort()
+		return false
+	}
+
+	// Get user from database
+	var user models.User
+	err = am.userCollection.FindOne(context.Background(), bson.M{"_id": claims.UserID}).Decode(&user)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		c.Abort()
+		return false
 	}
+
+	if !user.IsActive {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User account is deactivated"})
+		c.Abort()
+		return false
+	}
+
+	// Set user in context
+	c.Set("user", &user)
+	return true
 }
 
 // RequireRole middleware checks if user has required role
@@ -143,5 +176,3 @@ func (am *AuthMiddleware) OptionalAuth() gin.HandlerFunc {
 		c.Next()
 	}
 }
-
- 
\ No newline at end of file
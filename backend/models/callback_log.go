@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Callback outcomes recorded in the audit log
+const (
+	CallbackReceived         = "received"
+	CallbackProcessed        = "processed"
+	CallbackDuplicate        = "duplicate"
+	CallbackInvalidSignature = "invalid_signature"
+	CallbackReplayed         = "replayed"
+	CallbackRejected         = "rejected"
+	CallbackError            = "error"
+)
+
+// CallbackLog is the audit record of one raw payment provider callback,
+// kept whatever happened to it
+type CallbackLog struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Provider    string             `bson:"provider" json:"provider"`
+	Headers     map[string]string  `bson:"headers" json:"headers"`
+	Body        string             `bson:"body" json:"body"`
+	RemoteIP    string             `bson:"remote_ip" json:"remote_ip"`
+	Nonce       string             `bson:"nonce,omitempty" json:"nonce,omitempty"`
+	Outcome     string             `bson:"outcome" json:"outcome"`
+	Detail      string             `bson:"detail,omitempty" json:"detail,omitempty"`
+	PaymentID   primitive.ObjectID `bson:"payment_id,omitempty" json:"payment_id,omitempty"`
+	ReceivedAt  time.Time          `bson:"received_at" json:"received_at"`
+	ProcessedAt *time.Time         `bson:"processed_at,omitempty" json:"processed_at,omitempty"`
+}
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidAmount is returned for decimal amounts that are malformed or more
+// precise than their currency allows
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// Currency describes how amounts in a currency are counted. Amounts are
+// stored as integers in the currency's minor unit (pesewas, kobo, cents), so
+// 12.50 GHS is stored as 1250. Currencies without a minor unit in practical
+// use, such as the CFA franc, have an exponent of 0.
+type Currency struct {
+	Code     string `json:"code"`
+	Name     string `json:"name"`
+	Exponent int    `json:"exponent"` // digits after the decimal point
+}
+
+// currencies are the ISO 4217 currencies events can be priced in
+var currencies = map[string]Currency{
+	"GHS": {Code: "GHS", Name: "Ghanaian cedi", Exponent: 2},
+	"NGN": {Code: "NGN", Name: "Nigerian naira", Exponent: 2},
+	"KES": {Code: "KES", Name: "Kenyan shilling", Exponent: 2},
+	"UGX": {Code: "UGX", Name: "Ugandan shilling", Exponent: 0},
+	"RWF": {Code: "RWF", Name: "Rwandan franc", Exponent: 0},
+	"XOF": {Code: "XOF", Name: "West African CFA franc", Exponent: 0},
+	"XAF": {Code: "XAF", Name: "Central African CFA franc", Exponent: 0},
+	"ZAR": {Code: "ZAR", Name: "South African rand", Exponent: 2},
+	"ZMW": {Code: "ZMW", Name: "Zambian kwacha", Exponent: 2},
+	"EUR": {Code: "EUR", Name: "Euro", Exponent: 2},
+	"USD": {Code: "USD", Name: "US dollar", Exponent: 2},
+	"GBP": {Code: "GBP", Name: "Pound sterling", Exponent: 2},
+}
+
+// LookupCurrency returns a supported currency by its ISO 4217 code
+func LookupCurrency(code string) (Currency, bool) {
+	currency, ok := currencies[strings.ToUpper(code)]
+	return currency, ok
+}
+
+// currencyOrDefault returns the currency for code, treating unknown codes as
+// having two decimal places so amounts still render sensibly
+func currencyOrDefault(code string) Currency {
+	if currency, ok := LookupCurrency(code); ok {
+		return currency
+	}
+	return Currency{Code: strings.ToUpper(code), Exponent: 2}
+}
+
+// Decimal writes a minor-unit amount as a plain decimal, e.g. 1250 as "12.50"
+func (c Currency) Decimal(minor int64) string {
+	sign := ""
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+	digits := strconv.FormatInt(minor, 10)
+	if c.Exponent == 0 {
+		return sign + digits
+	}
+	if len(digits) <= c.Exponent {
+		digits = strings.Repeat("0", c.Exponent-len(digits)+1) + digits
+	}
+	split := len(digits) - c.Exponent
+	return sign + digits[:split] + "." + digits[split:]
+}
+
+// Format writes a minor-unit amount for people, e.g. 125000 as "GHS 1,250.00".
+// The code is used rather than a symbol because symbols like ₵ and ₦ do not
+// survive the GSM character set used by SMS and USSD.
+func (c Currency) Format(minor int64) string {
+	decimal := c.Decimal(minor)
+	sign := ""
+	if strings.HasPrefix(decimal, "-") {
+		sign, decimal = "-", decimal[1:]
+	}
+	whole, fraction := decimal, ""
+	if i := strings.IndexByte(decimal, '.'); i >= 0 {
+		whole, fraction = decimal[:i], decimal[i:]
+	}
+	for i := len(whole) - 3; i > 0; i -= 3 {
+		whole = whole[:i] + "," + whole[i:]
+	}
+	return c.Code + " " + sign + whole + fraction
+}
+
+// Parse reads a decimal amount such as a provider's "12.50" into minor units
+// without going through floating point
+func (c Currency) Parse(amount string) (int64, error) {
+	amount = strings.TrimSpace(amount)
+	whole, fraction := amount, ""
+	if i := strings.IndexByte(amount, '.'); i >= 0 {
+		whole, fraction = amount[:i], amount[i+1:]
+	}
+	fraction = strings.TrimRight(fraction, "0")
+	if whole == "" || len(fraction) > c.Exponent {
+		return 0, ErrInvalidAmount
+	}
+	fraction += strings.Repeat("0", c.Exponent-len(fraction))
+
+	minor, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidAmount
+	}
+	return minor, nil
+}
+
+// FromMajor converts a legacy floating-point amount to minor units, rounding
+// to the nearest minor unit
+func (c Currency) FromMajor(major float64) int64 {
+	return int64(math.Round(major * math.Pow10(c.Exponent)))
+}
+
+// FormatAmount writes a minor-unit amount in the given currency for people
+func FormatAmount(minor int64, code string) string {
+	return currencyOrDefault(code).Format(minor)
+}
+
+// DecimalAmount writes a minor-unit amount in the given currency as a plain decimal
+func DecimalAmount(minor int64, code string) string {
+	return currencyOrDefault(code).Decimal(minor)
+}
+
+// ParseAmount reads a decimal amount in the given currency into minor units
+func ParseAmount(amount, code string) (int64, error) {
+	return currencyOrDefault(code).Parse(amount)
+}
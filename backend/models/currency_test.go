@@ -0,0 +1,56 @@
+package models
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCurrencyFormatting(t *testing.T) {
+	cases := []struct {
+		code    string
+		minor   int64
+		decimal string
+		format  string
+	}{
+		{"GHS", 1250, "12.50", "GHS 12.50"},
+		{"GHS", 5, "0.05", "GHS 0.05"},
+		{"GHS", 123456789, "1234567.89", "GHS 1,234,567.89"},
+		{"NGN", -250, "-2.50", "NGN -2.50"},
+		{"XOF", 1500, "1500", "XOF 1,500"},
+		{"UGX", 0, "0", "UGX 0"},
+	}
+	for _, tc := range cases {
+		if got := DecimalAmount(tc.minor, tc.code); got != tc.decimal {
+			t.Errorf("DecimalAmount(%d, %s) = %q, want %q", tc.minor, tc.code, got, tc.decimal)
+		}
+		if got := FormatAmount(tc.minor, tc.code); got != tc.format {
+			t.Errorf("FormatAmount(%d, %s) = %q, want %q", tc.minor, tc.code, got, tc.format)
+		}
+		if parsed, err := ParseAmount(tc.decimal, tc.code); err != nil || parsed != tc.minor {
+			t.Errorf("ParseAmount(%q, %s) = %d, %v; want %d", tc.decimal, tc.code, parsed, err, tc.minor)
+		}
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	valid := map[string]int64{"10": 1000, "10.5": 1050, "10.50": 1050, "10.500": 1050, "0.01": 1}
+	for amount, expected := range valid {
+		if got, err := ParseAmount(amount, "GHS"); err != nil || got != expected {
+			t.Errorf("ParseAmount(%q) = %d, %v; want %d", amount, got, err, expected)
+		}
+	}
+
+	for _, amount := range []string{"", ".5", "10.505", "1,000", "ten", "10.5x"} {
+		if _, err := ParseAmount(amount, "GHS"); !errors.Is(err, ErrInvalidAmount) {
+			t.Errorf("ParseAmount(%q): expected ErrInvalidAmount, got %v", amount, err)
+		}
+	}
+	if _, err := ParseAmount("1500.50", "XOF"); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("expected fractions of a currency without minor units to be rejected, got %v", err)
+	}
+
+	cedi, _ := LookupCurrency("ghs")
+	if minor := cedi.FromMajor(19.99); minor != 1999 {
+		t.Errorf("FromMajor(19.99) = %d, want 1999", minor)
+	}
+}
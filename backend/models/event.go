@@ -1,67 +1,128 @@
 package models
 
 import (
+	"math"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Event struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Title       string            `bson:"title" json:"title" validate:"required,min=3,max=100"`
-	Description string            `bson:"description" json:"description" validate:"required,min=10"`
-	Date        time.Time         `bson:"date" json:"date" validate:"required"`
-	Location    string            `bson:"location" json:"location" validate:"required"`
-	Price       float64           `bson:"price" json:"price" validate:"required,min=0"`
-	MaxTickets  int               `bson:"max_tickets" json:"max_tickets" validate:"required,min=1"`
-	SoldTickets int               `bson:"sold_tickets" json:"sold_tickets"`
-	Status      string            `bson:"status" json:"status" validate:"required,oneof=active upcoming ongoing completed cancelled"`
-	Category    string            `bson:"category" json:"category" validate:"required"`
-	ImageURL    string            `bson:"image_url" json:"image_url"`
-	OrganizerID primitive.ObjectID `bson:"organizer_id" json:"organizer_id" validate:"required"`
-	CreatedAt   time.Time         `bson:"created_at" json:"created_at"`
-	UpdatedAt   time.Time         `bson:"updated_at" json:"updated_at"`
+	ID               primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	Title            string               `bson:"title" json:"title" validate:"required,min=3,max=100"`
+	Description      string               `bson:"description" json:"description" validate:"required,min=10"`
+	Date             time.Time            `bson:"date" json:"date" validate:"required"`
+	Location         string               `bson:"location" json:"location" validate:"required"`
+	Price            int64                `bson:"price" json:"price" validate:"required,min=0"` // in minor units of Currency
+	Currency         string               `bson:"currency" json:"currency"`
+	MaxTickets       int                  `bson:"max_tickets" json:"max_tickets" validate:"required,min=1"`
+	SoldTickets      int                  `bson:"sold_tickets" json:"sold_tickets"`
+	Status           string               `bson:"status" json:"status" validate:"required,oneof=active upcoming ongoing completed cancelled"`
+	Category         string               `bson:"category" json:"category" validate:"required"`
+	ImageURL         string               `bson:"image_url" json:"image_url"`
+	OrganizerID      primitive.ObjectID   `bson:"organizer_id" json:"organizer_id" validate:"required"`
+	TicketTypes      []TicketType         `bson:"ticket_types,omitempty" json:"ticket_types,omitempty"`
+	VenueID          primitive.ObjectID   `bson:"venue_id,omitempty" json:"venue_id,omitempty"`
+	PaymentProviders []string             `bson:"payment_providers,omitempty" json:"payment_providers,omitempty"` // accepted providers, first preferred; empty accepts any
+	Queue            *EventQueue          `bson:"queue,omitempty" json:"queue,omitempty"`
+	Pricing          *EventPricing        `bson:"pricing,omitempty" json:"pricing,omitempty"`
+	DoorStaff        []primitive.ObjectID `bson:"door_staff,omitempty" json:"-"` // users the organizer lets check tickets at the door
+	CreatedAt        time.Time            `bson:"created_at" json:"created_at"`
+	UpdatedAt        time.Time            `bson:"updated_at" json:"updated_at"`
 }
 
 type EventResponse struct {
-	ID          primitive.ObjectID `json:"id"`
-	Title       string            `json:"title"`
-	Description string            `json:"description"`
-	Date        time.Time         `json:"date"`
-	Location    string            `json:"location"`
-	Price       float64           `json:"price"`
-	MaxTickets  int               `json:"max_tickets"`
-	SoldTickets int               `json:"sold_tickets"`
-	Status      string            `json:"status"`
-	Category    string            `json:"category"`
-	ImageURL    string            `json:"image_url"`
-	OrganizerID primitive.ObjectID `json:"organizer_id"`
-	Organizer   UserResponse      `json:"organizer,omitempty"`
-	CreatedAt   time.Time         `json:"created_at"`
-	UpdatedAt   time.Time         `json:"updated_at"`
+	ID               primitive.ObjectID `json:"id"`
+	Title            string             `json:"title"`
+	Description      string             `json:"description"`
+	Date             time.Time          `json:"date"`
+	Location         string             `json:"location"`
+	Price            int64              `json:"price"`
+	Currency         string             `json:"currency"`
+	MaxTickets       int                `json:"max_tickets"`
+	SoldTickets      int                `json:"sold_tickets"`
+	Status           string             `json:"status"`
+	Category         string             `json:"category"`
+	ImageURL         string             `json:"image_url"`
+	OrganizerID      primitive.ObjectID `json:"organizer_id"`
+	Organizer        UserResponse       `json:"organizer,omitempty"`
+	TicketTypes      []TicketType       `json:"ticket_types,omitempty"`
+	VenueID          primitive.ObjectID `json:"venue_id,omitempty"`
+	PaymentProviders []string           `json:"payment_providers,omitempty"`
+	Queue            *EventQueue        `json:"queue,omitempty"`
+	Pricing          *EventPricing      `json:"pricing,omitempty"`
+	CurrentPrice     int64              `json:"current_price"` // what the headline price sells for now
+	CreatedAt        time.Time          `json:"created_at"`
+	UpdatedAt        time.Time          `json:"updated_at"`
 }
 
 type CreateEventRequest struct {
-	Title       string            `json:"title" validate:"required,min=3,max=100"`
-	Description string            `json:"description" validate:"required,min=10"`
-	Date        time.Time         `json:"date" validate:"required"`
-	Location    string            `json:"location" validate:"required"`
-	Price       float64           `json:"price" validate:"required,min=0"`
-	MaxTickets  int               `json:"max_tickets" validate:"required,min=1"`
-	Category    string            `json:"category" validate:"required"`
-	ImageURL    string            `json:"image_url"`
+	Title            string              `json:"title" validate:"required,min=3,max=100"`
+	Description      string              `json:"description" validate:"required,min=10"`
+	Date             time.Time           `json:"date" validate:"required"`
+	Location         string              `json:"location" validate:"required"`
+	Price            int64               `json:"price" validate:"required,min=0"` // in minor units, e.g. pesewas
+	Currency         string              `json:"currency"`                        // ISO 4217 code; defaults to DEFAULT_CURRENCY
+	MaxTickets       int                 `json:"max_tickets" validate:"required,min=1"`
+	Category         string              `json:"category" validate:"required"`
+	ImageURL         string              `json:"image_url"`
+	TicketTypes      []TicketTypeRequest `json:"ticket_types"`
+	VenueID          primitive.ObjectID  `json:"venue_id"`
+	PaymentProviders []string            `json:"payment_providers"`
 }
 
 type UpdateEventRequest struct {
-	Title       string    `json:"title" validate:"omitempty,min=3,max=100"`
-	Description string    `json:"description" validate:"omitempty,min=10"`
-	Date        time.Time `json:"date" validate:"omitempty"`
-	Location    string    `json:"location" validate:"omitempty"`
-	Price       float64   `json:"price" validate:"omitempty,min=0"`
-	MaxTickets  int       `json:"max_tickets" validate:"omitempty,min=1"`
-	Category    string    `json:"category" validate:"omitempty"`
-	ImageURL    string    `json:"image_url"`
-	Status      string    `json:"status" validate:"omitempty,oneof=active upcoming ongoing completed cancelled"`
+	Title            string              `json:"title" validate:"omitempty,min=3,max=100"`
+	Description      string              `json:"description" validate:"omitempty,min=10"`
+	Date             time.Time           `json:"date" validate:"omitempty"`
+	Location         string              `json:"location" validate:"omitempty"`
+	Price            int64               `json:"price" validate:"omitempty,min=0"`
+	Currency         string              `json:"currency"`
+	MaxTickets       int                 `json:"max_tickets" validate:"omitempty,min=1"`
+	Category         string              `json:"category" validate:"omitempty"`
+	ImageURL         string              `json:"image_url"`
+	Status           string              `json:"status" validate:"omitempty,oneof=active upcoming ongoing completed cancelled"`
+	TicketTypes      []TicketTypeRequest `json:"ticket_types"`
+	PaymentProviders []string            `json:"payment_providers"` // an empty list accepts any provider
+}
+
+// EventQueue puts an event's sale behind a virtual queue: buyers join it
+// and are let through to buy a few at a time, at the rate the organizer sets
+type EventQueue struct {
+	Enabled         bool      `bson:"enabled" json:"enabled"`
+	OpensAt         time.Time `bson:"opens_at" json:"opens_at"`                 // when buyers can start joining
+	AdmissionRate   int       `bson:"admission_rate" json:"admission_rate"`     // buyers let through per minute
+	AdmissionWindow int       `bson:"admission_window" json:"admission_window"` // minutes a buyer has to buy once let through
+}
+
+type UpdateQueueRequest struct {
+	Enabled         bool      `json:"enabled"`
+	OpensAt         time.Time `json:"opens_at"`
+	AdmissionRate   int       `json:"admission_rate" validate:"omitempty,min=1"`
+	AdmissionWindow int       `json:"admission_window" validate:"omitempty,min=1"` // defaults to 10 minutes
+}
+
+// EventPricing lets an event's prices follow demand. Every price the
+// organizer set is scaled by Multiplier, which the pricing job moves with
+// demand, time to the event and competing events, between the bounds.
+type EventPricing struct {
+	Enabled        bool      `bson:"enabled" json:"enabled"`
+	FloorPercent   int       `bson:"floor_percent" json:"floor_percent"`     // the lowest price, as a percentage of the organizer's
+	CeilingPercent int       `bson:"ceiling_percent" json:"ceiling_percent"` // the highest
+	Multiplier     float64   `bson:"multiplier" json:"multiplier"`
+	UpdatedAt      time.Time `bson:"updated_at" json:"updated_at"` // when Multiplier was last calculated
+}
+
+// UpdateDoorStaffRequest names, by the email of their accounts, everyone who
+// checks tickets at an event's door besides its organizer
+type UpdateDoorStaffRequest struct {
+	Emails []string `json:"emails"`
+}
+
+type UpdatePricingRequest struct {
+	Enabled        bool `json:"enabled"`
+	FloorPercent   int  `json:"floor_percent"`
+	CeilingPercent int  `json:"ceiling_percent"`
 }
 
 type EventFilter struct {
@@ -93,23 +154,113 @@ func (e *Event) CanPurchaseTickets(quantity int) bool {
 	return e.IsAvailable() && e.GetAvailableTickets() >= quantity
 }
 
+// HasTicketTypes checks if the event sells tickets through separate tiers
+func (e *Event) HasTicketTypes() bool {
+	return len(e.TicketTypes) > 0
+}
+
+// HasDynamicPricing checks if the event's prices follow demand
+func (e *Event) HasDynamicPricing() bool {
+	return e.Pricing != nil && e.Pricing.Enabled
+}
+
+// LivePrice returns what a ticket the organizer priced at price sells for now
+func (e *Event) LivePrice(price int64) int64 {
+	if !e.HasDynamicPricing() || e.Pricing.Multiplier <= 0 {
+		return price
+	}
+	return int64(math.Round(float64(price) * e.Pricing.Multiplier))
+}
+
+// HasQueue checks if buyers must come through the event's virtual queue to buy
+func (e *Event) HasQueue() bool {
+	return e.Queue != nil && e.Queue.Enabled
+}
+
+// CanCheckTickets checks if a user may check tickets at the event's door:
+// its organizer, an admin, or one of its door staff
+func (e *Event) CanCheckTickets(user *User) bool {
+	if e.OrganizerID == user.ID || user.IsAdmin() {
+		return true
+	}
+	for _, id := range e.DoorStaff {
+		if id == user.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// HasReservedSeating checks if buyers are assigned seats from a venue seat map
+func (e *Event) HasReservedSeating() bool {
+	return !e.VenueID.IsZero()
+}
+
+// AcceptsProvider checks if buyers may pay for the event through a payment provider
+func (e *Event) AcceptsProvider(name string) bool {
+	if len(e.PaymentProviders) == 0 {
+		return true
+	}
+	for _, provider := range e.PaymentProviders {
+		if provider == name {
+			return true
+		}
+	}
+	return false
+}
+
+// FindTicketType returns the event's ticket type with the given ID, or nil
+func (e *Event) FindTicketType(id primitive.ObjectID) *TicketType {
+	for i := range e.TicketTypes {
+		if e.TicketTypes[i].ID == id {
+			return &e.TicketTypes[i]
+		}
+	}
+	return nil
+}
+
+// SyncTicketTypeTotals derives the event-wide capacity and headline price from its tiers
+func (e *Event) SyncTicketTypeTotals() {
+	if !e.HasTicketTypes() {
+		return
+	}
+
+	capacity := 0
+	lowestPrice := e.TicketTypes[0].Price
+	for _, tt := range e.TicketTypes {
+		capacity += tt.Capacity
+		if tt.Price < lowestPrice {
+			lowestPrice = tt.Price
+		}
+	}
+	e.MaxTickets = capacity
+	e.Price = lowestPrice
+}
+
 // ToResponse converts Event to EventResponse
 func (e *Event) ToResponse() EventResponse {
 	return EventResponse{
-		ID:          e.ID,
-		Title:       e.Title,
-		Description: e.Description,
-		Date:        e.Date,
-		Location:    e.Location,
-		Price:       e.Price,
-		MaxTickets:  e.MaxTickets,
-		SoldTickets: e.SoldTickets,
-		Status:      e.Status,
-		Category:    e.Category,
-		ImageURL:    e.ImageURL,
-		OrganizerID: e.OrganizerID,
-		CreatedAt:   e.CreatedAt,
-		UpdatedAt:   e.UpdatedAt,
+		ID:               e.ID,
+		Title:            e.Title,
+		Description:      e.Description,
+		Date:             e.Date,
+		Location:         e.Location,
+		Price:            e.Price,
+		Currency:         e.Currency,
+		MaxTickets:       e.MaxTickets,
+		SoldTickets:      e.SoldTickets,
+		Status:           e.Status,
+		Category:         e.Category,
+		ImageURL:         e.ImageURL,
+		OrganizerID:      e.OrganizerID,
+		TicketTypes:      e.TicketTypes,
+		VenueID:          e.VenueID,
+		PaymentProviders: e.PaymentProviders,
+		Queue:            e.Queue,
+		Pricing:          e.Pricing,
+		CurrentPrice:     e.LivePrice(e.Price),
+		CreatedAt:        e.CreatedAt,
+		UpdatedAt:        e.UpdatedAt,
 	}
 }
 
@@ -118,4 +269,4 @@ func (e *Event) ToResponseWithOrganizer(organizer UserResponse) EventResponse {
 	response := e.ToResponse()
 	response.Organizer = organizer
 	return response
-} 
\ No newline at end of file
+}
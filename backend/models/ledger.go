@@ -0,0 +1,87 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Ledger accounts. Each entry concerns one organizer, so the organizer
+// payable account is kept per organizer through the entry's OrganizerID.
+const (
+	AccountProviderClearing   = "provider_clearing"   // money collected by payment providers on our behalf
+	AccountProviderFees       = "provider_fees"       // what providers keep for taking payments
+	AccountPlatformCommission = "platform_commission" // our commission on sales
+	AccountOrganizerPayable   = "organizer_payable"   // what we owe organizers
+	AccountPayoutsInTransit   = "payouts_in_transit"  // batched payouts not yet settled
+)
+
+// Ledger entry types
+const (
+	LedgerSale            = "sale"
+	LedgerRefund          = "refund"
+	LedgerPayout          = "payout"
+	LedgerPayoutSettled   = "payout_settled"
+	LedgerPayoutCancelled = "payout_cancelled"
+)
+
+// LedgerEntry is one double-entry journal entry: the debits of its lines
+// equal their credits. Entries are never changed once posted; a correction is
+// another entry. Key identifies what the entry records, e.g. "sale:<payment
+// id>", so posting the same thing twice has no effect.
+type LedgerEntry struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Key            string             `bson:"key" json:"key"`
+	Type           string             `bson:"type" json:"type" validate:"required,oneof=sale refund payout payout_settled payout_cancelled"`
+	OrganizerID    primitive.ObjectID `bson:"organizer_id" json:"organizer_id"`
+	EventID        primitive.ObjectID `bson:"event_id,omitempty" json:"event_id,omitempty"`
+	PaymentID      primitive.ObjectID `bson:"payment_id,omitempty" json:"payment_id,omitempty"`
+	RefundID       primitive.ObjectID `bson:"refund_id,omitempty" json:"refund_id,omitempty"`
+	PayoutID       primitive.ObjectID `bson:"payout_id,omitempty" json:"payout_id,omitempty"`
+	Provider       string             `bson:"provider,omitempty" json:"provider,omitempty"`
+	Currency       string             `bson:"currency" json:"currency"`
+	Gross          int64              `bson:"gross" json:"gross"`                 // paid by the buyer; negative when refunded
+	Commission     int64              `bson:"commission" json:"commission"`       // kept by the platform; negative when returned
+	ProviderFee    int64              `bson:"provider_fee" json:"provider_fee"`   // kept by the provider
+	OrganizerNet   int64              `bson:"organizer_net" json:"organizer_net"` // change to the organizer's balance
+	CommissionRate float64            `bson:"commission_rate,omitempty" json:"commission_rate,omitempty"`
+	Lines          []LedgerLine       `bson:"lines" json:"lines"`
+	Description    string             `bson:"description" json:"description"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// LedgerLine debits or credits one account, in minor units of the entry's currency
+type LedgerLine struct {
+	Account string `bson:"account" json:"account"`
+	Debit   int64  `bson:"debit" json:"debit"`
+	Credit  int64  `bson:"credit" json:"credit"`
+}
+
+// IsBalanced reports whether the entry's debits equal its credits
+func (e *LedgerEntry) IsBalanced() bool {
+	var debits, credits int64
+	for _, line := range e.Lines {
+		debits += line.Debit
+		credits += line.Credit
+	}
+	return debits == credits
+}
+
+// OrganizerBalance sums an organizer's ledger in one currency
+type OrganizerBalance struct {
+	Currency       string `json:"currency"`
+	GrossSales     int64  `json:"gross_sales"`
+	Refunds        int64  `json:"refunds"`
+	Commission     int64  `json:"commission"`
+	PendingPayouts int64  `json:"pending_payouts"`
+	PaidOut        int64  `json:"paid_out"`
+	Available      int64  `json:"available"` // owed and not yet in a payout
+}
+
+// AccountBalance is the total of one account in one currency
+type AccountBalance struct {
+	Account  string `bson:"account" json:"account"`
+	Currency string `bson:"currency" json:"currency"`
+	Debit    int64  `bson:"debit" json:"debit"`
+	Credit   int64  `bson:"credit" json:"credit"`
+}
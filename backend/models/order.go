@@ -0,0 +1,107 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Order is one purchase: it carries the payment and totals, while each
+// admission it bought is a separate Ticket with its own code
+type Order struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrderNumber    string             `bson:"order_number" json:"order_number"`
+	UserID         primitive.ObjectID `bson:"user_id" json:"user_id"`
+	EventID        primitive.ObjectID `bson:"event_id" json:"event_id"`
+	TicketTypeID   primitive.ObjectID `bson:"ticket_type_id,omitempty" json:"ticket_type_id,omitempty"`
+	TicketTypeName string             `bson:"ticket_type_name,omitempty" json:"ticket_type_name,omitempty"`
+	Quantity       int                `bson:"quantity" json:"quantity"`
+	UnitPrice      int64              `bson:"unit_price" json:"unit_price"`
+	TotalAmount    int64              `bson:"total_amount" json:"total_amount"`
+	Currency       string             `bson:"currency" json:"currency"`
+	Status         string             `bson:"status" json:"status" validate:"required,oneof=pending paid expired cancelled refunded partially_refunded"`
+	AdmissionID    string             `bson:"admission_id,omitempty" json:"-"` // the queue admission the order was placed with
+	ExpiresAt      *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	PaidAt         *time.Time         `bson:"paid_at,omitempty" json:"paid_at,omitempty"` // unset until the order is first paid
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+type OrderResponse struct {
+	ID             primitive.ObjectID `json:"id"`
+	OrderNumber    string             `json:"order_number"`
+	UserID         primitive.ObjectID `json:"user_id"`
+	EventID        primitive.ObjectID `json:"event_id"`
+	TicketTypeID   primitive.ObjectID `json:"ticket_type_id,omitempty"`
+	TicketTypeName string             `json:"ticket_type_name"`
+	Quantity       int                `json:"quantity"`
+	UnitPrice      int64              `json:"unit_price"`
+	TotalAmount    int64              `json:"total_amount"`
+	Currency       string             `json:"currency"`
+	Status         string             `json:"status"`
+	ExpiresAt      *time.Time         `json:"expires_at,omitempty"`
+	Tickets        []TicketResponse   `json:"tickets,omitempty"`
+	CreatedAt      time.Time          `json:"created_at"`
+	UpdatedAt      time.Time          `json:"updated_at"`
+}
+
+// StatusFromTickets derives an order's status from the statuses of its tickets
+func StatusFromTickets(statuses []string) string {
+	counts := make(map[string]int)
+	for _, status := range statuses {
+		counts[status]++
+	}
+
+	switch {
+	case counts["pending"] > 0:
+		return "pending"
+	case counts["paid"]+counts["used"] > 0 && counts["refunded"] > 0:
+		return "partially_refunded"
+	case counts["paid"]+counts["used"] > 0:
+		return "paid"
+	case counts["refunded"] > 0:
+		return "refunded"
+	case counts["expired"] == len(statuses):
+		return "expired"
+	default:
+		return "cancelled"
+	}
+}
+
+// ToResponse converts Order to OrderResponse
+func (o *Order) ToResponse() OrderResponse {
+	ticketTypeName := o.TicketTypeName
+	if ticketTypeName == "" {
+		ticketTypeName = GeneralAdmission
+	}
+	return OrderResponse{
+		ID:             o.ID,
+		OrderNumber:    o.OrderNumber,
+		UserID:         o.UserID,
+		EventID:        o.EventID,
+		TicketTypeID:   o.TicketTypeID,
+		TicketTypeName: ticketTypeName,
+		Quantity:       o.Quantity,
+		UnitPrice:      o.UnitPrice,
+		TotalAmount:    o.TotalAmount,
+		Currency:       o.Currency,
+		Status:         o.Status,
+		ExpiresAt:      o.ExpiresAt,
+		CreatedAt:      o.CreatedAt,
+		UpdatedAt:      o.UpdatedAt,
+	}
+}
+
+// ToResponseWithTickets converts Order to OrderResponse including its tickets
+func (o *Order) ToResponseWithTickets(tickets []Ticket) OrderResponse {
+	response := o.ToResponse()
+	for _, ticket := range tickets {
+		response.Tickets = append(response.Tickets, ticket.ToResponse())
+	}
+	return response
+}
+
+// GenerateOrderNumber generates a unique order number
+func GenerateOrderNumber() string {
+	return "ORD-" + time.Now().Format("20060102") + "-" + primitive.NewObjectID().Hex()[16:]
+}
@@ -7,54 +7,72 @@ import (
 )
 
 type Payment struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	UserID      primitive.ObjectID `bson:"user_id" json:"user_id" validate:"required"`
-	EventID     primitive.ObjectID `bson:"event_id" json:"event_id" validate:"required"`
-	TicketID    primitive.ObjectID `bson:"ticket_id" json:"ticket_id" validate:"required"`
-	Amount      float64           `bson:"amount" json:"amount" validate:"required,min=0"`
-	Status      string            `bson:"status" json:"status" validate:"required,oneof=pending success failed cancelled"`
-	PaymentType string            `bson:"payment_type" json:"payment_type" validate:"required,oneof=momo ussd"`
-	MoMoRef     string            `bson:"momo_ref" json:"momo_ref"`
-	PhoneNumber string            `bson:"phone_number" json:"phone_number" validate:"required"`
-	Description string            `bson:"description" json:"description"`
-	CreatedAt   time.Time         `bson:"created_at" json:"created_at"`
-	UpdatedAt   time.Time         `bson:"updated_at" json:"updated_at"`
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID         primitive.ObjectID `bson:"user_id" json:"user_id" validate:"required"`
+	EventID        primitive.ObjectID `bson:"event_id" json:"event_id" validate:"required"`
+	OrderID        primitive.ObjectID `bson:"order_id" json:"order_id" validate:"required"`
+	Amount         int64              `bson:"amount" json:"amount" validate:"required,min=0"` // in minor units of Currency
+	RefundedAmount int64              `bson:"refunded_amount" json:"refunded_amount"`         // committed to refunds that have not failed
+	Currency       string             `bson:"currency" json:"currency"`
+	Status         string             `bson:"status" json:"status" validate:"required,oneof=pending success failed cancelled"`
+	PaymentType    string             `bson:"payment_type" json:"payment_type" validate:"required"` // ussd, or the provider for web purchases
+	Provider       string             `bson:"provider,omitempty" json:"provider"`
+	MoMoRef        string             `bson:"momo_ref" json:"momo_ref"` // the provider's reference, whichever provider it is
+	PhoneNumber    string             `bson:"phone_number" json:"phone_number" validate:"required"`
+	Description    string             `bson:"description" json:"description"`
+	NotifiedAt     *time.Time         `bson:"notified_at,omitempty" json:"notified_at,omitempty"`     // when the confirmation SMS was sent
+	ReconciledAt   *time.Time         `bson:"reconciled_at,omitempty" json:"reconciled_at,omitempty"` // when its final status was checked against the provider
+	RefundDue      bool               `bson:"refund_due,omitempty" json:"refund_due,omitempty"`       // charged, but its tickets could not be reinstated
+	RefundID       primitive.ObjectID `bson:"refund_id,omitempty" json:"refund_id,omitempty"`         // the refund returning a payment that was due one
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
 }
 
 type PaymentResponse struct {
-	ID          primitive.ObjectID `json:"id"`
-	UserID      primitive.ObjectID `json:"user_id"`
-	EventID     primitive.ObjectID `json:"event_id"`
-	TicketID    primitive.ObjectID `json:"ticket_id"`
-	Amount      float64           `json:"amount"`
-	Status      string            `json:"status"`
-	PaymentType string            `json:"payment_type"`
-	MoMoRef     string            `json:"momo_ref"`
-	PhoneNumber string            `json:"phone_number"`
-	Description string            `json:"description"`
-	User        UserResponse      `json:"user,omitempty"`
-	Event       EventResponse     `json:"event,omitempty"`
-	Ticket      TicketResponse    `json:"ticket,omitempty"`
-	CreatedAt   time.Time         `json:"created_at"`
-	UpdatedAt   time.Time         `json:"updated_at"`
+	ID             primitive.ObjectID `json:"id"`
+	UserID         primitive.ObjectID `json:"user_id"`
+	EventID        primitive.ObjectID `json:"event_id"`
+	OrderID        primitive.ObjectID `json:"order_id"`
+	Amount         int64              `json:"amount"`
+	RefundedAmount int64              `json:"refunded_amount"`
+	Currency       string             `json:"currency"`
+	Status         string             `json:"status"`
+	PaymentType    string             `json:"payment_type"`
+	Provider       string             `json:"provider"`
+	MoMoRef        string             `json:"momo_ref"`
+	PhoneNumber    string             `json:"phone_number"`
+	Description    string             `json:"description"`
+	User           UserResponse       `json:"user,omitempty"`
+	Event          EventResponse      `json:"event,omitempty"`
+	Order          OrderResponse      `json:"order,omitempty"`
+	CreatedAt      time.Time          `json:"created_at"`
+	UpdatedAt      time.Time          `json:"updated_at"`
 }
 
 type InitiatePaymentRequest struct {
-	EventID     primitive.ObjectID `json:"event_id" validate:"required"`
-	Quantity    int               `json:"quantity" validate:"required,min=1"`
-	PhoneNumber string            `json:"phone_number" validate:"required"`
-	PaymentType string            `json:"payment_type" validate:"required,oneof=momo ussd"`
+	EventID      primitive.ObjectID `json:"event_id" validate:"required"`
+	TicketTypeID primitive.ObjectID `json:"ticket_type_id"`
+	Quantity     int                `json:"quantity" validate:"required,min=1"`
+	Seats        []string           `json:"seats"` // seat IDs, required for reserved-seating events
+	PhoneNumber  string             `json:"phone_number" validate:"required"`
+	PaymentType  string             `json:"payment_type"`
+	Provider     string             `json:"provider"`    // defaults to the event's first provider
+	QueueToken   string             `json:"queue_token"` // admission token, for events sold through a queue
 }
 
 type MoMoCallbackRequest struct {
-	Status      string `json:"status"`
+	Status        string `json:"status"`
 	TransactionID string `json:"transaction_id"`
-	Amount      string `json:"amount"`
-	PhoneNumber string `json:"phone_number"`
-	Description string `json:"description"`
-	Reference   string `json:"reference"`
+	Amount        string `json:"amount"`
+	PhoneNumber   string `json:"phone_number"`
+	Description   string `json:"description"`
+	Reference     string `json:"reference"`
 }
 
+// LegacyPaymentProvider is the provider of payments recorded before payments
+// named their provider
+const LegacyPaymentProvider = "momo"
+
 type PaymentFilter struct {
 	UserID      string `json:"user_id"`
 	EventID     string `json:"event_id"`
@@ -86,6 +104,14 @@ func (p *Payment) IsCancelled() bool {
 	return p.Status == "cancelled"
 }
 
+// ProviderName returns the provider that took the payment
+func (p *Payment) ProviderName() string {
+	if p.Provider == "" {
+		return LegacyPaymentProvider
+	}
+	return p.Provider
+}
+
 // MarkAsSuccessful marks the payment as successful
 func (p *Payment) MarkAsSuccessful(momoRef string) {
 	p.Status = "success"
@@ -108,26 +134,29 @@ func (p *Payment) MarkAsCancelled() {
 // ToResponse converts Payment to PaymentResponse
 func (p *Payment) ToResponse() PaymentResponse {
 	return PaymentResponse{
-		ID:          p.ID,
-		UserID:      p.UserID,
-		EventID:     p.EventID,
-		TicketID:    p.TicketID,
-		Amount:      p.Amount,
-		Status:      p.Status,
-		PaymentType: p.PaymentType,
-		MoMoRef:     p.MoMoRef,
-		PhoneNumber: p.PhoneNumber,
-		Description: p.Description,
-		CreatedAt:   p.CreatedAt,
-		UpdatedAt:   p.UpdatedAt,
+		ID:             p.ID,
+		UserID:         p.UserID,
+		EventID:        p.EventID,
+		OrderID:        p.OrderID,
+		Amount:         p.Amount,
+		RefundedAmount: p.RefundedAmount,
+		Currency:       p.Currency,
+		Status:         p.Status,
+		PaymentType:    p.PaymentType,
+		Provider:       p.ProviderName(),
+		MoMoRef:        p.MoMoRef,
+		PhoneNumber:    p.PhoneNumber,
+		Description:    p.Description,
+		CreatedAt:      p.CreatedAt,
+		UpdatedAt:      p.UpdatedAt,
 	}
 }
 
 // ToResponseWithDetails converts Payment to PaymentResponse with related details
-func (p *Payment) ToResponseWithDetails(user UserResponse, event EventResponse, ticket TicketResponse) PaymentResponse {
+func (p *Payment) ToResponseWithDetails(user UserResponse, event EventResponse, order OrderResponse) PaymentResponse {
 	response := p.ToResponse()
 	response.User = user
 	response.Event = event
-	response.Ticket = ticket
+	response.Order = order
 	return response
-} 
\ No newline at end of file
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Payout is money owed to an organizer that an admin has batched for payment.
+// It is pending until the admin marks it settled with the reference of the
+// transfer made outside the system, or cancels it, which returns the amount
+// to the organizer's balance.
+type Payout struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	BatchID     primitive.ObjectID `bson:"batch_id" json:"batch_id"`
+	OrganizerID primitive.ObjectID `bson:"organizer_id" json:"organizer_id"`
+	Amount      int64              `bson:"amount" json:"amount"`
+	Currency    string             `bson:"currency" json:"currency"`
+	Status      string             `bson:"status" json:"status" validate:"required,oneof=pending settled cancelled"`
+	Reference   string             `bson:"reference,omitempty" json:"reference,omitempty"`
+	Note        string             `bson:"note,omitempty" json:"note,omitempty"`
+	CreatedBy   primitive.ObjectID `bson:"created_by" json:"created_by"`
+	SettledAt   *time.Time         `bson:"settled_at,omitempty" json:"settled_at,omitempty"`
+	CancelledAt *time.Time         `bson:"cancelled_at,omitempty" json:"cancelled_at,omitempty"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+type CreatePayoutBatchRequest struct {
+	Currency      string   `json:"currency"`       // only pay balances in this currency
+	OrganizerIDs  []string `json:"organizer_ids"`  // only pay these organizers
+	MinimumAmount int64    `json:"minimum_amount"` // skip balances below this, in minor units
+}
+
+type SettlePayoutRequest struct {
+	Reference string `json:"reference" binding:"required"`
+	Note      string `json:"note"`
+}
+
+type CancelPayoutRequest struct {
+	Note string `json:"note"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PriceChange records a change of an event's dynamic price and the factors
+// behind it
+type PriceChange struct {
+	ID                 primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	EventID            primitive.ObjectID `bson:"event_id" json:"event_id"`
+	PreviousMultiplier float64            `bson:"previous_multiplier" json:"previous_multiplier"`
+	Multiplier         float64            `bson:"multiplier" json:"multiplier"`
+	BasePrice          int64              `bson:"base_price" json:"base_price"` // the organizer's headline price, in minor units of Currency
+	Price              int64              `bson:"price" json:"price"`           // what it sells for from this change
+	Currency           string             `bson:"currency" json:"currency"`
+	DemandMultiplier   float64            `bson:"demand_multiplier" json:"demand_multiplier"`
+	TimeMultiplier     float64            `bson:"time_multiplier" json:"time_multiplier"`
+	CompetitionFactor  float64            `bson:"competition_factor" json:"competition_factor"`
+	RecentSales        int                `bson:"recent_sales" json:"recent_sales"`
+	CompetingEvents    int                `bson:"competing_events" json:"competing_events"`
+	CreatedAt          time.Time          `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Queue counts an event's virtual queue: buyers are numbered as they join,
+// and every buyer numbered up to Admitted has been let through
+type Queue struct {
+	EventID     primitive.ObjectID `bson:"_id" json:"event_id"`
+	Joined      int64              `bson:"joined" json:"joined"`
+	Admitted    int64              `bson:"admitted" json:"admitted"`
+	NextBatchAt time.Time          `bson:"next_batch_at" json:"next_batch_at"` // when the next buyers are let through
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// QueueEntry is a buyer's place in an event's queue
+type QueueEntry struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	EventID    primitive.ObjectID `bson:"event_id" json:"event_id"`
+	UserID     primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Position   int64              `bson:"position" json:"position"`
+	Status     string             `bson:"status" json:"status"` // waiting or admitted
+	JoinedAt   time.Time          `bson:"joined_at" json:"joined_at"`
+	AdmittedAt *time.Time         `bson:"admitted_at,omitempty" json:"admitted_at,omitempty"`
+	ExpiresAt  *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"` // when the admission lapses
+}
+
+// IsAdmitted checks if the buyer has been let through and may still buy
+func (qe *QueueEntry) IsAdmitted(now time.Time) bool {
+	return qe.Status == "admitted" && qe.ExpiresAt != nil && now.Before(*qe.ExpiresAt)
+}
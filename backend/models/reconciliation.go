@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Reconciliation actions taken on a mismatched payment
+const (
+	ReconcileMarkedSuccess = "marked_success"
+	ReconcileMarkedFailed  = "marked_failed"
+	ReconcileNeedsRefund   = "needs_refund"
+	ReconcileNeedsReview   = "needs_review"
+	ReconcileConfirmed     = "confirmed_order"
+	ReconcileRefundQueued  = "refund_queued"
+)
+
+// ReconciliationReport is the result of one pass comparing our payments with
+// what the payment provider recorded
+type ReconciliationReport struct {
+	ID           primitive.ObjectID       `bson:"_id,omitempty" json:"id"`
+	StartedAt    time.Time                `bson:"started_at" json:"started_at"`
+	FinishedAt   time.Time                `bson:"finished_at" json:"finished_at"`
+	Checked      int                      `bson:"checked" json:"checked"`
+	Resolved     int                      `bson:"resolved" json:"resolved"`
+	StillPending int                      `bson:"still_pending" json:"still_pending"`
+	Errors       int                      `bson:"errors" json:"errors"`
+	Mismatches   []ReconciliationMismatch `bson:"mismatches" json:"mismatches"`
+}
+
+// ReconciliationMismatch is a payment whose status disagreed with the provider's
+type ReconciliationMismatch struct {
+	PaymentID      primitive.ObjectID `bson:"payment_id" json:"payment_id"`
+	OrderID        primitive.ObjectID `bson:"order_id" json:"order_id"`
+	UserID         primitive.ObjectID `bson:"user_id" json:"user_id"`
+	PhoneNumber    string             `bson:"phone_number" json:"phone_number"`
+	Provider       string             `bson:"provider" json:"provider"`
+	MoMoRef        string             `bson:"momo_ref" json:"momo_ref"`
+	Amount         int64              `bson:"amount" json:"amount"`
+	Currency       string             `bson:"currency" json:"currency"`
+	ProviderAmount string             `bson:"provider_amount,omitempty" json:"provider_amount,omitempty"`
+	OurStatus      string             `bson:"our_status" json:"our_status"`
+	ProviderStatus string             `bson:"provider_status" json:"provider_status"`
+	Action         string             `bson:"action" json:"action"`
+	Detail         string             `bson:"detail,omitempty" json:"detail,omitempty"`
+}
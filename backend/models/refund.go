@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Refund is money returned to a buyer through the payment provider. It moves
+// from requested (recorded, not yet accepted by the provider) to processing
+// (accepted, awaiting the outcome) and ends completed or failed. Its tickets
+// are only marked refunded once the provider confirms the transfer.
+type Refund struct {
+	ID                    primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	IdempotencyKey        string               `bson:"idempotency_key" json:"idempotency_key"`
+	PaymentID             primitive.ObjectID   `bson:"payment_id" json:"payment_id"`
+	OrderID               primitive.ObjectID   `bson:"order_id" json:"order_id"`
+	TicketIDs             []primitive.ObjectID `bson:"ticket_ids" json:"ticket_ids"`
+	UserID                primitive.ObjectID   `bson:"user_id" json:"user_id"`
+	RequestedBy           primitive.ObjectID   `bson:"requested_by" json:"requested_by"`
+	Amount                int64                `bson:"amount" json:"amount"`
+	Currency              string               `bson:"currency" json:"currency"`
+	PhoneNumber           string               `bson:"phone_number" json:"phone_number"`
+	Reason                string               `bson:"reason" json:"reason"`
+	Status                string               `bson:"status" json:"status" validate:"required,oneof=requested processing completed failed"`
+	Provider              string               `bson:"provider,omitempty" json:"provider"`
+	ProviderRef           string               `bson:"provider_ref" json:"provider_ref"`
+	ProviderTransactionID string               `bson:"provider_transaction_id,omitempty" json:"provider_transaction_id,omitempty"`
+	FailureReason         string               `bson:"failure_reason,omitempty" json:"failure_reason,omitempty"`
+	Attempts              int                  `bson:"attempts" json:"attempts"`
+	CompletedAt           *time.Time           `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+	CreatedAt             time.Time            `bson:"created_at" json:"created_at"`
+	UpdatedAt             time.Time            `bson:"updated_at" json:"updated_at"`
+}
+
+type RefundTicketRequest struct {
+	Amount int64  `json:"amount"` // in minor units; defaults to the ticket price, less refunds part of it
+	Reason string `json:"reason"`
+}
+
+// ProviderName returns the provider paying the refund
+func (r *Refund) ProviderName() string {
+	if r.Provider == "" {
+		return LegacyPaymentProvider
+	}
+	return r.Provider
+}
+
+// IsSettled reports whether the refund has reached a final status
+func (r *Refund) IsSettled() bool {
+	return r.Status == "completed" || r.Status == "failed"
+}
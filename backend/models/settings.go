@@ -0,0 +1,98 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SettingsID is the _id of the one document in the settings collection
+const SettingsID = "system"
+
+// Settings are the system settings admins can change while the system runs
+type Settings struct {
+	CommissionRate     float64 `bson:"commission_rate" json:"commission_rate"`
+	EnableUSSD         bool    `bson:"enable_ussd" json:"enable_ussd"`
+	EnableQR           bool    `bson:"enable_qr" json:"enable_qr"`
+	EnableSMS          bool    `bson:"enable_sms" json:"enable_sms"`
+	EnableEmail        bool    `bson:"enable_email" json:"enable_email"`
+	TicketHoldSeconds  int     `bson:"ticket_hold_seconds" json:"ticket_hold_seconds"`
+	USSDSessionTimeout int     `bson:"ussd_session_timeout" json:"ussd_session_timeout"` // seconds
+}
+
+// TicketHoldDuration is how long a pending order holds its tickets
+func (s Settings) TicketHoldDuration() time.Duration {
+	return time.Duration(s.TicketHoldSeconds) * time.Second
+}
+
+// USSDSessionDuration is how long an idle USSD session is kept
+func (s Settings) USSDSessionDuration() time.Duration {
+	return time.Duration(s.USSDSessionTimeout) * time.Second
+}
+
+// SystemSettings is the stored settings document. Version goes up by one
+// with every change.
+type SystemSettings struct {
+	Settings `bson:",inline"`
+
+	ID        string             `bson:"_id" json:"-"`
+	Version   int64              `bson:"version" json:"version"`
+	UpdatedBy primitive.ObjectID `bson:"updated_by,omitempty" json:"updated_by,omitempty"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// SettingsChange records one version of the settings: the values it set,
+// what changed from the version before, and who changed it
+type SettingsChange struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Version   int64              `bson:"version" json:"version"`
+	Settings  Settings           `bson:"settings" json:"settings"`
+	Changes   []SettingChange    `bson:"changes" json:"changes"`
+	UpdatedBy primitive.ObjectID `bson:"updated_by,omitempty" json:"updated_by,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// SettingChange is one setting's old and new value
+type SettingChange struct {
+	Setting string      `bson:"setting" json:"setting"`
+	From    interface{} `bson:"from" json:"from"`
+	To      interface{} `bson:"to" json:"to"`
+}
+
+// UpdateSettingsRequest changes the settings it names and leaves the rest
+type UpdateSettingsRequest struct {
+	Version            *int64   `json:"version"` // when given, the update fails if the settings have changed since
+	CommissionRate     *float64 `json:"commission_rate" binding:"omitempty,min=0,max=1"`
+	EnableUSSD         *bool    `json:"enable_ussd"`
+	EnableQR           *bool    `json:"enable_qr"`
+	EnableSMS          *bool    `json:"enable_sms"`
+	EnableEmail        *bool    `json:"enable_email"`
+	TicketHoldSeconds  *int     `json:"ticket_hold_seconds" binding:"omitempty,min=60,max=86400"`
+	USSDSessionTimeout *int     `json:"ussd_session_timeout" binding:"omitempty,min=30,max=3600"`
+}
+
+// Apply returns settings with the request's changes made
+func (r *UpdateSettingsRequest) Apply(settings Settings) Settings {
+	if r.CommissionRate != nil {
+		settings.CommissionRate = *r.CommissionRate
+	}
+	if r.EnableUSSD != nil {
+		settings.EnableUSSD = *r.EnableUSSD
+	}
+	if r.EnableQR != nil {
+		settings.EnableQR = *r.EnableQR
+	}
+	if r.EnableSMS != nil {
+		settings.EnableSMS = *r.EnableSMS
+	}
+	if r.EnableEmail != nil {
+		settings.EnableEmail = *r.EnableEmail
+	}
+	if r.TicketHoldSeconds != nil {
+		settings.TicketHoldSeconds = *r.TicketHoldSeconds
+	}
+	if r.USSDSessionTimeout != nil {
+		settings.USSDSessionTimeout = *r.USSDSessionTimeout
+	}
+	return settings
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SMS message statuses. Messages wait as queued until a worker picks them
+// up, and go back to queued with a later attempt time when sending fails.
+const (
+	SMSQueued    = "queued"
+	SMSSending   = "sending"
+	SMSSent      = "sent"      // accepted by the provider
+	SMSDelivered = "delivered" // confirmed by a delivery receipt
+	SMSFailed    = "failed"
+)
+
+// SMSMessage is an outgoing SMS in the outbox. Every message is written here
+// first and sent by the SMS workers.
+type SMSMessage struct {
+	ID                primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	To                string             `bson:"to" json:"to"`
+	From              string             `bson:"from" json:"from"`
+	Body              string             `bson:"body" json:"body"`
+	Provider          string             `bson:"provider" json:"provider"`
+	Status            string             `bson:"status" json:"status"`
+	Attempts          int                `bson:"attempts" json:"attempts"`
+	NextAttemptAt     time.Time          `bson:"next_attempt_at" json:"next_attempt_at"`
+	LeaseExpiresAt    *time.Time         `bson:"lease_expires_at,omitempty" json:"-"` // when a worker's claim on a sending message lapses
+	ProviderMessageID string             `bson:"provider_message_id,omitempty" json:"provider_message_id,omitempty"`
+	LastError         string             `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	SentAt            *time.Time         `bson:"sent_at,omitempty" json:"sent_at,omitempty"`
+	DeliveredAt       *time.Time         `bson:"delivered_at,omitempty" json:"delivered_at,omitempty"`
+	FailedAt          *time.Time         `bson:"failed_at,omitempty" json:"failed_at,omitempty"`
+	CreatedAt         time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt         time.Time          `bson:"updated_at" json:"updated_at"`
+}
@@ -7,48 +7,64 @@ import (
 )
 
 type Ticket struct {
-	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	EventID    primitive.ObjectID `bson:"event_id" json:"event_id" validate:"required"`
-	UserID     primitive.ObjectID `bson:"user_id" json:"user_id" validate:"required"`
-	TicketCode string            `bson:"ticket_code" json:"ticket_code" validate:"required"`
-	QRCode     string            `bson:"qr_code" json:"qr_code"`
-	Status     string            `bson:"status" json:"status" validate:"required,oneof=pending paid used cancelled refunded"`
-	Price      float64           `bson:"price" json:"price" validate:"required,min=0"`
-	Quantity   int               `bson:"quantity" json:"quantity" validate:"required,min=1"`
-	UsedAt     *time.Time        `bson:"used_at,omitempty" json:"used_at,omitempty"`
-	CreatedAt  time.Time         `bson:"created_at" json:"created_at"`
-	UpdatedAt  time.Time         `bson:"updated_at" json:"updated_at"`
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	EventID        primitive.ObjectID `bson:"event_id" json:"event_id" validate:"required"`
+	UserID         primitive.ObjectID `bson:"user_id" json:"user_id" validate:"required"`
+	OrderID        primitive.ObjectID `bson:"order_id,omitempty" json:"order_id,omitempty"`
+	TicketTypeID   primitive.ObjectID `bson:"ticket_type_id,omitempty" json:"ticket_type_id,omitempty"`
+	TicketTypeName string             `bson:"ticket_type_name,omitempty" json:"ticket_type_name,omitempty"`
+	Seats          []TicketSeat       `bson:"seats,omitempty" json:"seats,omitempty"`
+	TicketCode     string             `bson:"ticket_code" json:"ticket_code" validate:"required"`
+	QRCode         string             `bson:"qr_code" json:"qr_code"`
+	Status         string             `bson:"status" json:"status" validate:"required,oneof=pending paid used cancelled refunded expired"`
+	Price          int64              `bson:"price" json:"price" validate:"required,min=0"`               // in minor units of the order's currency
+	Quantity       int                `bson:"quantity" json:"quantity" validate:"required,min=1"`         // admissions; 1 for every ticket created per admission
+	RefundedAmount int64              `bson:"refunded_amount,omitempty" json:"refunded_amount,omitempty"` // committed to refunds that have not failed
+	UsedAt         *time.Time         `bson:"used_at,omitempty" json:"used_at,omitempty"`
+	ExpiresAt      *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	ReleaseDue     bool               `bson:"release_due,omitempty" json:"-"` // closed but its inventory not yet returned
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
 }
 
 type TicketResponse struct {
-	ID         primitive.ObjectID `json:"id"`
-	EventID    primitive.ObjectID `json:"event_id"`
-	UserID     primitive.ObjectID `json:"user_id"`
-	TicketCode string            `json:"ticket_code"`
-	QRCode     string            `json:"qr_code"`
-	Status     string            `json:"status"`
-	Price      float64           `json:"price"`
-	Quantity   int               `json:"quantity"`
-	UsedAt     *time.Time        `json:"used_at,omitempty"`
-	Event      EventResponse     `json:"event,omitempty"`
-	User       UserResponse      `json:"user,omitempty"`
-	CreatedAt  time.Time         `json:"created_at"`
-	UpdatedAt  time.Time         `json:"updated_at"`
+	ID             primitive.ObjectID `json:"id"`
+	EventID        primitive.ObjectID `json:"event_id"`
+	UserID         primitive.ObjectID `json:"user_id"`
+	OrderID        primitive.ObjectID `json:"order_id,omitempty"`
+	TicketTypeID   primitive.ObjectID `json:"ticket_type_id,omitempty"`
+	TicketTypeName string             `json:"ticket_type_name"`
+	Seats          []TicketSeat       `json:"seats,omitempty"`
+	TicketCode     string             `json:"ticket_code"`
+	QRCode         string             `json:"qr_code"`
+	Status         string             `json:"status"`
+	Price          int64              `json:"price"`
+	Quantity       int                `json:"quantity"`
+	UsedAt         *time.Time         `json:"used_at,omitempty"`
+	ExpiresAt      *time.Time         `json:"expires_at,omitempty"`
+	Event          EventResponse      `json:"event,omitempty"`
+	User           UserResponse       `json:"user,omitempty"`
+	CreatedAt      time.Time          `json:"created_at"`
+	UpdatedAt      time.Time          `json:"updated_at"`
 }
 
 type CreateTicketRequest struct {
-	EventID  primitive.ObjectID `json:"event_id" validate:"required"`
-	Quantity int               `json:"quantity" validate:"required,min=1"`
+	EventID      primitive.ObjectID `json:"event_id" validate:"required"`
+	TicketTypeID primitive.ObjectID `json:"ticket_type_id"`
+	Quantity     int                `json:"quantity" validate:"required,min=1"`
+	Seats        []string           `json:"seats"`       // seat IDs; best available seats are assigned when empty
+	QueueToken   string             `json:"queue_token"` // admission token, for events sold through a queue
 }
 
 type VerifyTicketRequest struct {
 	TicketCode string `json:"ticket_code" validate:"required"`
 	EventID    string `json:"event_id" validate:"required"`
+	Entrance   string `json:"entrance"` // where the ticket was scanned; "Main" when not given
 }
 
 type VerifyTicketResponse struct {
-	Valid   bool   `json:"valid"`
-	Message string `json:"message"`
+	Valid   bool            `json:"valid"`
+	Message string          `json:"message"`
 	Ticket  *TicketResponse `json:"ticket,omitempty"`
 }
 
@@ -60,6 +76,30 @@ type TicketFilter struct {
 	Limit   int    `json:"limit" validate:"min=1,max=100"`
 }
 
+// Ways a ticket resend can be asked for
+const (
+	ResendChannelUSSD  = "ussd"
+	ResendChannelSMS   = "sms"
+	ResendChannelAdmin = "admin"
+)
+
+// TicketResend records ticket codes sent again to a phone, to limit how
+// often a phone can ask and to show support what was sent
+type TicketResend struct {
+	ID          primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	Phone       string               `bson:"phone" json:"phone"`
+	Channel     string               `bson:"channel" json:"channel"`
+	TicketIDs   []primitive.ObjectID `bson:"ticket_ids,omitempty" json:"ticket_ids,omitempty"`
+	RequestedBy primitive.ObjectID   `bson:"requested_by,omitempty" json:"requested_by,omitempty"` // the agent, for admin resends
+	CreatedAt   time.Time            `bson:"created_at" json:"created_at"`
+}
+
+// ResendTicketRequest lets support send a ticket to a number other than
+// the buyer's
+type ResendTicketRequest struct {
+	Phone string `json:"phone"`
+}
+
 // IsValid checks if the ticket is valid for use
 func (t *Ticket) IsValid() bool {
 	return t.Status == "paid" && t.UsedAt == nil
@@ -75,6 +115,28 @@ func (t *Ticket) CanBeUsed() bool {
 	return t.IsValid() && !t.IsUsed()
 }
 
+// GetTicketTypeName returns the ticket's tier name, falling back to general admission
+func (t *Ticket) GetTicketTypeName() string {
+	if t.TicketTypeName == "" {
+		return GeneralAdmission
+	}
+	return t.TicketTypeName
+}
+
+// SeatLabels returns the labels of a ticket's seats
+func (t *Ticket) SeatLabels() []string {
+	labels := make([]string, 0, len(t.Seats))
+	for _, seat := range t.Seats {
+		labels = append(labels, seat.Label)
+	}
+	return labels
+}
+
+// IsHoldExpired checks if a pending ticket has outlived its inventory hold
+func (t *Ticket) IsHoldExpired() bool {
+	return t.Status == "pending" && t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
 // MarkAsUsed marks the ticket as used
 func (t *Ticket) MarkAsUsed() {
 	now := time.Now()
@@ -86,17 +148,22 @@ func (t *Ticket) MarkAsUsed() {
 // ToResponse converts Ticket to TicketResponse
 func (t *Ticket) ToResponse() TicketResponse {
 	return TicketResponse{
-		ID:         t.ID,
-		EventID:    t.EventID,
-		UserID:     t.UserID,
-		TicketCode: t.TicketCode,
-		QRCode:     t.QRCode,
-		Status:     t.Status,
-		Price:      t.Price,
-		Quantity:   t.Quantity,
-		UsedAt:     t.UsedAt,
-		CreatedAt:  t.CreatedAt,
-		UpdatedAt:  t.UpdatedAt,
+		ID:             t.ID,
+		EventID:        t.EventID,
+		UserID:         t.UserID,
+		OrderID:        t.OrderID,
+		TicketTypeID:   t.TicketTypeID,
+		TicketTypeName: t.GetTicketTypeName(),
+		Seats:          t.Seats,
+		TicketCode:     t.TicketCode,
+		QRCode:         t.QRCode,
+		Status:         t.Status,
+		Price:          t.Price,
+		Quantity:       t.Quantity,
+		UsedAt:         t.UsedAt,
+		ExpiresAt:      t.ExpiresAt,
+		CreatedAt:      t.CreatedAt,
+		UpdatedAt:      t.UpdatedAt,
 	}
 }
 
@@ -113,4 +180,4 @@ func GenerateTicketCode() string {
 	// In a real implementation, you might want to use a more sophisticated algorithm
 	// For now, we'll use a simple timestamp-based approach
 	return "TIX-" + time.Now().Format("20060102150405") + "-" + primitive.NewObjectID().Hex()[:8]
-} 
\ No newline at end of file
+}
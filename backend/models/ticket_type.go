@@ -0,0 +1,99 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GeneralAdmission is the tier name reported for tickets of events without ticket types
+const GeneralAdmission = "General Admission"
+
+// TicketType is a priced tier of an event (e.g. VIP, Regular, Early Bird) with its own inventory
+type TicketType struct {
+	ID          primitive.ObjectID `bson:"_id" json:"id"`
+	Name        string             `bson:"name" json:"name" validate:"required,min=2,max=50"`
+	Description string             `bson:"description" json:"description"`
+	Price       int64              `bson:"price" json:"price" validate:"min=0"` // in minor units of the event's currency
+	Capacity    int                `bson:"capacity" json:"capacity" validate:"required,min=1"`
+	Sold        int                `bson:"sold" json:"sold"`
+	SaleStart   *time.Time         `bson:"sale_start,omitempty" json:"sale_start,omitempty"`
+	SaleEnd     *time.Time         `bson:"sale_end,omitempty" json:"sale_end,omitempty"`
+	MaxPerOrder int                `bson:"max_per_order" json:"max_per_order"` // 0 means no per-order limit
+}
+
+type TicketTypeRequest struct {
+	ID          primitive.ObjectID `json:"id"`
+	Name        string             `json:"name" validate:"required,min=2,max=50"`
+	Description string             `json:"description"`
+	Price       int64              `json:"price" validate:"min=0"`
+	Capacity    int                `json:"capacity" validate:"required,min=1"`
+	SaleStart   *time.Time         `json:"sale_start"`
+	SaleEnd     *time.Time         `json:"sale_end"`
+	MaxPerOrder int                `json:"max_per_order" validate:"min=0"`
+}
+
+// IsOnSale checks if the ticket type's sale window includes the given time
+func (tt *TicketType) IsOnSale(now time.Time) bool {
+	if tt.SaleStart != nil && now.Before(*tt.SaleStart) {
+		return false
+	}
+	if tt.SaleEnd != nil && now.After(*tt.SaleEnd) {
+		return false
+	}
+	return true
+}
+
+// GetAvailableTickets returns the number of tickets left in this tier
+func (tt *TicketType) GetAvailableTickets() int {
+	available := tt.Capacity - tt.Sold
+	if available < 0 {
+		return 0
+	}
+	return available
+}
+
+// AllowsQuantity checks if a single order may contain quantity tickets of this tier
+func (tt *TicketType) AllowsQuantity(quantity int) bool {
+	return tt.MaxPerOrder <= 0 || quantity <= tt.MaxPerOrder
+}
+
+// ToTicketType converts the request into a ticket type, keeping the sold count of an existing tier
+func (r *TicketTypeRequest) ToTicketType(sold int) TicketType {
+	id := r.ID
+	if id.IsZero() {
+		id = primitive.NewObjectID()
+	}
+	return TicketType{
+		ID:          id,
+		Name:        r.Name,
+		Description: r.Description,
+		Price:       r.Price,
+		Capacity:    r.Capacity,
+		Sold:        sold,
+		SaleStart:   r.SaleStart,
+		SaleEnd:     r.SaleEnd,
+		MaxPerOrder: r.MaxPerOrder,
+	}
+}
+
+// Validate checks the request for values the binding layer does not enforce
+func (r *TicketTypeRequest) Validate() error {
+	if len(r.Name) < 2 || len(r.Name) > 50 {
+		return errors.New("ticket type name must be between 2 and 50 characters")
+	}
+	if r.Price < 0 {
+		return errors.New("ticket type price cannot be negative")
+	}
+	if r.Capacity < 1 {
+		return errors.New("ticket type capacity must be at least 1")
+	}
+	if r.MaxPerOrder < 0 {
+		return errors.New("ticket type per-order limit cannot be negative")
+	}
+	if r.SaleStart != nil && r.SaleEnd != nil && r.SaleEnd.Before(*r.SaleStart) {
+		return errors.New("ticket type sale end must be after sale start")
+	}
+	return nil
+}
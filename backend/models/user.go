@@ -8,25 +8,32 @@ import (
 )
 
 type User struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Name      string            `bson:"name" json:"name" validate:"required,min=2,max=50"`
-	Email     string            `bson:"email" json:"email" validate:"required,email"`
-	Phone     string            `bson:"phone" json:"phone" validate:"required"`
-	Password  string            `bson:"password" json:"-" validate:"required,min=6"`
-	Role      string            `bson:"role" json:"role" validate:"required,oneof=user organizer admin"`
-	IsActive  bool              `bson:"is_active" json:"is_active"`
-	CreatedAt time.Time         `bson:"created_at" json:"created_at"`
-	UpdatedAt time.Time         `bson:"updated_at" json:"updated_at"`
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name     string             `bson:"name" json:"name" validate:"required,min=2,max=50"`
+	Email    string             `bson:"email" json:"email" validate:"required,email"`
+	Phone    string             `bson:"phone" json:"phone" validate:"required"`
+	Password string             `bson:"password" json:"-" validate:"required,min=6"`
+	Role     string             `bson:"role" json:"role" validate:"required,oneof=user organizer admin"`
+	IsActive bool               `bson:"is_active" json:"is_active"`
+	Language string             `bson:"language,omitempty" json:"language,omitempty"` // for USSD and SMS; empty means the default
+	// USSDOnly marks an account created for a USSD caller. It has a phone but
+	// no email or password until it is claimed on the web.
+	USSDOnly        bool       `bson:"ussd_only,omitempty" json:"ussd_only,omitempty"`
+	PhoneVerifiedAt *time.Time `bson:"phone_verified_at,omitempty" json:"phone_verified_at,omitempty"`
+	CreatedAt       time.Time  `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time  `bson:"updated_at" json:"updated_at"`
 }
 
 type UserResponse struct {
-	ID        primitive.ObjectID `json:"id"`
-	Name      string            `json:"name"`
-	Email     string            `json:"email"`
-	Phone     string            `json:"phone"`
-	Role      string            `json:"role"`
-	IsActive  bool              `json:"is_active"`
-	CreatedAt time.Time         `json:"created_at"`
+	ID              primitive.ObjectID `json:"id"`
+	Name            string             `json:"name"`
+	Email           string             `json:"email"`
+	Phone           string             `json:"phone"`
+	Role            string             `json:"role"`
+	IsActive        bool               `json:"is_active"`
+	Language        string             `json:"language,omitempty"`
+	PhoneVerifiedAt *time.Time         `json:"phone_verified_at,omitempty"`
+	CreatedAt       time.Time          `json:"created_at"`
 }
 
 type LoginRequest struct {
@@ -40,11 +47,45 @@ type RegisterRequest struct {
 	Phone    string `json:"phone" validate:"required"`
 	Password string `json:"password" validate:"required,min=6"`
 	Role     string `json:"role" validate:"required,oneof=user organizer"`
+	Language string `json:"language"` // one of the supported language codes
 }
 
 type UpdateUserRequest struct {
-	Name  string `json:"name" validate:"omitempty,min=2,max=50"`
-	Phone string `json:"phone" validate:"omitempty"`
+	Name     string `json:"name" validate:"omitempty,min=2,max=50"`
+	Phone    string `json:"phone" validate:"omitempty"`
+	Language string `json:"language"` // one of the supported language codes
+}
+
+// PhoneCodeRequest asks for a verification code to be sent to a phone
+type PhoneCodeRequest struct {
+	Phone string `json:"phone" validate:"required"`
+}
+
+// VerifyPhoneRequest adds a phone to the current user's account with the
+// code sent to it
+type VerifyPhoneRequest struct {
+	Phone string `json:"phone" validate:"required"`
+	Code  string `json:"code" validate:"required"`
+}
+
+// ClaimAccountRequest turns a USSD account into a web account with the code
+// sent to its phone
+type ClaimAccountRequest struct {
+	Phone    string `json:"phone" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+	Name     string `json:"name" validate:"required,min=2,max=50"`
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required,min=6"`
+}
+
+// PhoneVerification is a code sent by SMS to prove a phone belongs to
+// whoever asks for it. There is one per phone; asking again replaces it.
+type PhoneVerification struct {
+	Phone     string    `bson:"_id"`
+	CodeHash  string    `bson:"code_hash"`
+	Attempts  int       `bson:"attempts"`
+	SentAt    time.Time `bson:"sent_at"`
+	ExpiresAt time.Time `bson:"expires_at"`
 }
 
 // HashPassword hashes the user's password
@@ -66,13 +107,15 @@ func (u *User) CheckPassword(password string) bool {
 // ToResponse converts User to UserResponse (without password)
 func (u *User) ToResponse() UserResponse {
 	return UserResponse{
-		ID:        u.ID,
-		Name:      u.Name,
-		Email:     u.Email,
-		Phone:     u.Phone,
-		Role:      u.Role,
-		IsActive:  u.IsActive,
-		CreatedAt: u.CreatedAt,
+		ID:              u.ID,
+		Name:            u.Name,
+		Email:           u.Email,
+		Phone:           u.Phone,
+		Role:            u.Role,
+		IsActive:        u.IsActive,
+		Language:        u.Language,
+		PhoneVerifiedAt: u.PhoneVerifiedAt,
+		CreatedAt:       u.CreatedAt,
 	}
 }
 
@@ -103,4 +146,4 @@ func (u *User) CanAccess(requiredRole string) bool {
 	default:
 		return false
 	}
-} 
\ No newline at end of file
+}
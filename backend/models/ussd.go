@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// USSDSession is a caller's progress through the USSD menus. It is kept
+// until it has been idle for the USSD session timeout.
+type USSDSession struct {
+	ID          string            `bson:"_id" json:"id"`                    // the gateway's session id
+	PhoneNumber string            `bson:"phone_number" json:"phone_number"` // the caller; requests from other numbers are refused
+	ServiceCode string            `bson:"service_code" json:"service_code"`
+	Language    string            `bson:"language" json:"language"`
+	Menu        string            `bson:"menu" json:"menu"` // the screen the caller is on
+	Page        int               `bson:"page" json:"page"`
+	History     []USSDPosition    `bson:"history" json:"history"`   // screens "0 Back" returns to, latest last
+	Options     []string          `bson:"options" json:"options"`   // values of the numbered options on screen
+	More        bool              `bson:"more" json:"more"`         // whether "00 More" has another page
+	Data        map[string]string `bson:"data" json:"data"`         // choices made so far, such as the event
+	Text        string            `bson:"text" json:"text"`         // everything entered so far, separated by "*"
+	Sequence    int               `bson:"sequence" json:"sequence"` // the gateway's number for the last request
+	Response    string            `bson:"response" json:"response"`
+	Ended       bool              `bson:"ended" json:"ended"`
+	ExpiresAt   time.Time         `bson:"expires_at" json:"expires_at"`
+	CreatedAt   time.Time         `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time         `bson:"updated_at" json:"updated_at"`
+}
+
+// USSDPosition is a screen and page of the USSD menus
+type USSDPosition struct {
+	Menu string `bson:"menu" json:"menu"`
+	Page int    `bson:"page" json:"page"`
+}
+
+// Get returns a choice recorded in the session
+func (s *USSDSession) Get(key string) string {
+	return s.Data[key]
+}
+
+// Set records a choice in the session
+func (s *USSDSession) Set(key, value string) {
+	if s.Data == nil {
+		s.Data = make(map[string]string)
+	}
+	s.Data[key] = value
+}
@@ -0,0 +1,176 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Seat lock statuses
+const (
+	SeatHeld = "held"
+	SeatSold = "sold"
+)
+
+// Venue is a seat map that reserved-seating events are sold against
+type Venue struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name        string             `bson:"name" json:"name" validate:"required,min=2,max=100"`
+	Address     string             `bson:"address" json:"address"`
+	Sections    []Section          `bson:"sections" json:"sections"`
+	OrganizerID primitive.ObjectID `bson:"organizer_id" json:"organizer_id"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+type Section struct {
+	Name string    `bson:"name" json:"name" validate:"required"`
+	Rows []SeatRow `bson:"rows" json:"rows"`
+}
+
+type SeatRow struct {
+	Label string `bson:"label" json:"label" validate:"required"`
+	Seats []Seat `bson:"seats" json:"seats"`
+}
+
+// Seat is one sellable place in a row, with its accessibility flags
+type Seat struct {
+	ID             string `bson:"id" json:"id"`
+	Number         string `bson:"number" json:"number" validate:"required"`
+	Wheelchair     bool   `bson:"wheelchair" json:"wheelchair"`
+	Companion      bool   `bson:"companion" json:"companion"`
+	RestrictedView bool   `bson:"restricted_view" json:"restricted_view"`
+	Blocked        bool   `bson:"blocked" json:"blocked"` // never sold, e.g. kept for production
+}
+
+type CreateVenueRequest struct {
+	Name     string    `json:"name" validate:"required,min=2,max=100"`
+	Address  string    `json:"address"`
+	Sections []Section `json:"sections" validate:"required"`
+}
+
+// SeatLock claims one seat of an event for a ticket. Held locks lapse at
+// ExpiresAt; sold locks are permanent until the ticket is cancelled.
+type SeatLock struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	EventID   primitive.ObjectID `bson:"event_id" json:"event_id"`
+	SeatID    string             `bson:"seat_id" json:"seat_id"`
+	TicketID  primitive.ObjectID `bson:"ticket_id" json:"ticket_id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Status    string             `bson:"status" json:"status"`
+	ExpiresAt *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// TicketSeat is a seat assigned to a ticket
+type TicketSeat struct {
+	SeatID string `bson:"seat_id" json:"seat_id"`
+	Label  string `bson:"label" json:"label"`
+}
+
+// SeatAvailability is one seat of an event's seat map with its current state
+type SeatAvailability struct {
+	Seat
+	Label  string `json:"label"`
+	Status string `json:"status"` // available, held, sold or blocked
+}
+
+type RowAvailability struct {
+	Label string             `json:"label"`
+	Seats []SeatAvailability `json:"seats"`
+}
+
+type SectionAvailability struct {
+	Name      string            `json:"name"`
+	Available int               `json:"available"`
+	Rows      []RowAvailability `json:"rows"`
+}
+
+// SeatID builds the identifier buyers use to select a seat
+func SeatID(section, row, number string) string {
+	return fmt.Sprintf("%s-%s-%s", section, row, number)
+}
+
+// SeatLabel builds the human-readable seat description printed on tickets
+func SeatLabel(section, row, number string) string {
+	return fmt.Sprintf("Section %s, Row %s, Seat %s", section, row, number)
+}
+
+// Normalize assigns seat IDs and checks the seat map for gaps and duplicates
+func (v *Venue) Normalize() error {
+	if len(v.Sections) == 0 {
+		return errors.New("venue must have at least one section")
+	}
+
+	seen := make(map[string]bool)
+	for i := range v.Sections {
+		section := &v.Sections[i]
+		section.Name = strings.TrimSpace(section.Name)
+		if section.Name == "" {
+			return errors.New("every section needs a name")
+		}
+		if len(section.Rows) == 0 {
+			return fmt.Errorf("section %s has no rows", section.Name)
+		}
+
+		for j := range section.Rows {
+			row := &section.Rows[j]
+			row.Label = strings.TrimSpace(row.Label)
+			if row.Label == "" {
+				return fmt.Errorf("every row in section %s needs a label", section.Name)
+			}
+			if len(row.Seats) == 0 {
+				return fmt.Errorf("row %s in section %s has no seats", row.Label, section.Name)
+			}
+
+			for k := range row.Seats {
+				seat := &row.Seats[k]
+				seat.Number = strings.TrimSpace(seat.Number)
+				if seat.Number == "" {
+					return fmt.Errorf("every seat in row %s of section %s needs a number", row.Label, section.Name)
+				}
+				seat.ID = SeatID(section.Name, row.Label, seat.Number)
+				if seen[seat.ID] {
+					return fmt.Errorf("seat %s appears more than once", seat.ID)
+				}
+				seen[seat.ID] = true
+			}
+		}
+	}
+	return nil
+}
+
+// SellableSeats returns the number of seats that are not blocked
+func (v *Venue) SellableSeats() int {
+	count := 0
+	for _, section := range v.Sections {
+		for _, row := range section.Rows {
+			for _, seat := range row.Seats {
+				if !seat.Blocked {
+					count++
+				}
+			}
+		}
+	}
+	return count
+}
+
+// FindSeat returns the seat with the given ID and its label
+func (v *Venue) FindSeat(seatID string) (*Seat, string, bool) {
+	for i := range v.Sections {
+		section := &v.Sections[i]
+		for j := range section.Rows {
+			row := &section.Rows[j]
+			for k := range row.Seats {
+				if row.Seats[k].ID == seatID {
+					return &row.Seats[k], SeatLabel(section.Name, row.Label, row.Seats[k].Number), true
+				}
+			}
+		}
+	}
+	return nil, "", false
+}
@@ -3,19 +3,28 @@ package routes
 import (
 	"eventticketing/controllers"
 	"eventticketing/middleware"
+	"eventticketing/services"
 
 	"github.com/gin-gonic/gin"
 )
 
 // SetupRoutes configures all the routes for the application
-func SetupRoutes(router *gin.Engine, authMiddleware *middleware.AuthMiddleware) {
+func SetupRoutes(router *gin.Engine, authMiddleware *middleware.AuthMiddleware, webSocketService *services.WebSocketService) {
 	// Initialize controllers
 	authController := controllers.NewAuthController()
 	eventController := controllers.NewEventController()
-	ticketController := controllers.NewTicketController()
-	paymentController := controllers.NewPaymentController()
+	ticketController := controllers.NewTicketController(webSocketService)
+	paymentController := controllers.NewPaymentController(webSocketService)
 	adminController := controllers.NewAdminController()
 	ussdController := controllers.NewUSSDController()
+	smsController := controllers.NewSMSController()
+	venueController := controllers.NewVenueController()
+	orderController := controllers.NewOrderController()
+	refundController := controllers.NewRefundController()
+	reconciliationController := controllers.NewReconciliationController()
+	payoutController := controllers.NewPayoutController()
+	webSocketController := controllers.NewWebSocketController(webSocketService)
+	queueController := controllers.NewQueueController(webSocketService)
 
 	// API routes group
 	api := router.Group("/api")
@@ -23,14 +32,24 @@ func SetupRoutes(router *gin.Engine, authMiddleware *middleware.AuthMiddleware)
 		// Public routes (no authentication required)
 		api.GET("/events", eventController.GetAllEvents)
 		api.GET("/events/:id", eventController.GetEventByID)
+		api.GET("/events/:id/ticket-types", eventController.GetTicketTypes)
+		api.GET("/events/:id/seats", venueController.GetEventSeats)
+		api.GET("/venues/:id", venueController.GetVenueByID)
 		api.POST("/register", authController.Register)
 		api.POST("/login", authController.Login)
+		api.GET("/languages", authController.GetLanguages)
+		api.POST("/claim/code", authController.SendClaimCode)
+		api.POST("/claim", authController.ClaimAccount)
 
 		// USSD routes
 		api.POST("/ussd/entry", ussdController.HandleUSSDEntry)
+		api.POST("/sms/inbound", smsController.HandleInboundSMS)
+		api.POST("/sms/receipts/:provider", smsController.HandleDeliveryReceipt)
 
-		// Payment callback (webhook)
+		// Payment callbacks (webhooks); the first route is MoMo's original one
 		api.POST("/payment/callback", paymentController.HandleMoMoCallback)
+		api.POST("/payments/callback/:provider", paymentController.HandleProviderCallback)
+		api.GET("/payments/providers", paymentController.GetProviders)
 
 		// Protected routes (authentication required)
 		protected := api.Group("")
@@ -39,7 +58,14 @@ func SetupRoutes(router *gin.Engine, authMiddleware *middleware.AuthMiddleware)
 			// User routes
 			protected.GET("/me", authController.GetCurrentUser)
 			protected.PUT("/me", authController.UpdateProfile)
+			protected.POST("/me/phone/code", authController.SendPhoneCode)
+			protected.POST("/me/phone/verify", authController.VerifyPhone)
 			protected.GET("/user/tickets", ticketController.GetUserTickets)
+			protected.GET("/user/orders", orderController.GetUserOrders)
+
+			// Virtual queue routes
+			protected.POST("/events/:id/queue", queueController.JoinQueue)
+			protected.GET("/events/:id/queue", queueController.GetQueuePosition)
 
 			// Event routes (organizer/admin only)
 			events := protected.Group("/events")
@@ -48,7 +74,25 @@ func SetupRoutes(router *gin.Engine, authMiddleware *middleware.AuthMiddleware)
 				events.POST("", eventController.CreateEvent)
 				events.PUT("/:id", eventController.UpdateEvent)
 				events.DELETE("/:id", eventController.DeleteEvent)
+				events.POST("/:id/ticket-types", eventController.AddTicketType)
+				events.PUT("/:id/ticket-types/:typeId", eventController.UpdateTicketType)
+				events.DELETE("/:id/ticket-types/:typeId", eventController.DeleteTicketType)
+				events.PUT("/:id/queue", eventController.UpdateQueue)
+				events.PUT("/:id/pricing", eventController.UpdatePricing)
+				events.GET("/:id/door-staff", eventController.GetDoorStaff)
+				events.PUT("/:id/door-staff", eventController.UpdateDoorStaff)
+				events.GET("/:id/pricing/history", eventController.GetPriceHistory)
 				events.GET("/organizer/events", eventController.GetOrganizerEvents)
+				events.GET("/organizer/balance", payoutController.GetOrganizerBalance)
+				events.GET("/organizer/statement", payoutController.GetOrganizerStatement)
+			}
+
+			// Venue routes (organizer/admin only)
+			venues := protected.Group("/venues")
+			venues.Use(authMiddleware.RequireOrganizer())
+			{
+				venues.POST("", venueController.CreateVenue)
+				venues.GET("", venueController.GetVenues)
 			}
 
 			// Ticket routes
@@ -56,17 +100,32 @@ func SetupRoutes(router *gin.Engine, authMiddleware *middleware.AuthMiddleware)
 			{
 				tickets.POST("", ticketController.CreateTicket)
 				tickets.GET("/:id", ticketController.GetTicketByID)
+				tickets.GET("/:id/pdf", ticketController.DownloadTicketPDF)
+				tickets.GET("/:id/pkpass", ticketController.DownloadApplePass)
+				tickets.GET("/:id/wallet/google", ticketController.GetGoogleWalletLink)
 				tickets.POST("/verify", ticketController.VerifyTicket)
 				tickets.PUT("/:id/cancel", ticketController.CancelTicket)
 				tickets.PUT("/:id/refund", ticketController.RefundTicket)
 				tickets.GET("/event/:eventId", ticketController.GetEventTickets)
+				tickets.GET("/event/:eventId/door", ticketController.GetDoorStats)
 			}
 
+			// Order routes
+			orders := protected.Group("/orders")
+			{
+				orders.GET("/:id", orderController.GetOrderByID)
+				orders.PUT("/:id/cancel", orderController.CancelOrder)
+			}
+
+			// Refund routes
+			protected.GET("/refunds/:id", refundController.GetRefundByID)
+
 			// Payment routes
 			payments := protected.Group("/payments")
 			{
 				payments.POST("/initiate", paymentController.InitiatePayment)
 				payments.GET("", paymentController.GetPayments)
+				payments.POST("/:id/confirm-cash", paymentController.ConfirmCashPayment)
 			}
 
 			// Admin routes (admin only)
@@ -78,14 +137,36 @@ func SetupRoutes(router *gin.Engine, authMiddleware *middleware.AuthMiddleware)
 				admin.PUT("/users/:id", adminController.UpdateUser)
 				admin.GET("/events", adminController.GetAllEvents)
 				admin.GET("/tickets", adminController.GetAllTickets)
+				admin.POST("/tickets/:id/resend", adminController.ResendTicket)
 				admin.GET("/payments", adminController.GetAllPayments)
+				admin.GET("/refunds", refundController.GetAllRefunds)
+				admin.GET("/callbacks", adminController.GetCallbackLogs)
+				admin.GET("/reconciliation/reports", reconciliationController.GetReports)
+				admin.GET("/reconciliation/reports/:id", reconciliationController.GetReportByID)
+				admin.POST("/reconciliation/run", reconciliationController.RunReconciliation)
+				admin.POST("/refunds/:id/retry", refundController.RetryRefund)
+				admin.GET("/sms", smsController.GetMessages)
+				admin.GET("/sms/:id", smsController.GetMessage)
+				admin.POST("/sms/:id/retry", smsController.RetryMessage)
+				admin.GET("/payouts", payoutController.GetPayouts)
+				admin.GET("/payouts/export", payoutController.ExportPayouts)
+				admin.POST("/payouts/batch", payoutController.CreatePayoutBatch)
+				admin.PUT("/payouts/:id/settle", payoutController.SettlePayout)
+				admin.PUT("/payouts/:id/cancel", payoutController.CancelPayout)
+				admin.GET("/organizers/:id/balance", payoutController.GetBalanceByOrganizer)
+				admin.GET("/organizers/:id/statement", payoutController.GetStatementByOrganizer)
+				admin.GET("/ledger/trial-balance", payoutController.GetTrialBalance)
 				admin.GET("/settings", adminController.GetSettings)
 				admin.PUT("/settings", adminController.UpdateSettings)
+				admin.GET("/settings/history", adminController.GetSettingsHistory)
 				admin.GET("/analytics", adminController.GetAnalytics)
 			}
 		}
 	}
 
+	// Live notifications and event updates; the handshake carries the JWT
+	router.GET("/ws", authMiddleware.WebSocketAuth(), webSocketController.HandleWebSocket)
+
 	// Serve static files (for uploaded images)
 	router.Static("/uploads", "./uploads")
-} 
\ No newline at end of file
+}
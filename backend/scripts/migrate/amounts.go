@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// amountMigration lists where a collection keeps money
+type amountMigration struct {
+	collection string
+	fields     []string          // top-level amounts
+	arrays     map[string]string // array of documents -> amount field in each
+	currency   bool              // whether documents record their currency
+}
+
+var amountMigrations = []amountMigration{
+	{collection: "events", fields: []string{"price"}, arrays: map[string]string{"ticket_types": "price"}, currency: true},
+	{collection: "tickets", fields: []string{"price"}},
+	{collection: "orders", fields: []string{"unit_price", "total_amount"}, currency: true},
+	{collection: "payments", fields: []string{"amount", "refunded_amount"}, currency: true},
+	{collection: "refunds", fields: []string{"amount"}, currency: true},
+	{collection: "reconciliation_reports", arrays: map[string]string{"mismatches": "amount"}},
+}
+
+// migrateAmounts converts amounts stored as floating-point major units into
+// integer minor units of currency, and records currency on documents that
+// have none. Only values still stored as doubles are converted, so a re-run
+// leaves converted documents alone.
+func migrateAmounts(ctx context.Context, currency models.Currency, dryRun bool) error {
+	factor := math.Pow10(currency.Exponent)
+
+	for _, migration := range amountMigrations {
+		collection := utils.GetCollection(migration.collection)
+
+		var legacy []bson.M
+		set := bson.M{}
+		for _, field := range migration.fields {
+			legacy = append(legacy, bson.M{field: bson.M{"$type": "double"}})
+			set[field] = toMinorUnits("$"+field, factor)
+		}
+		for array, field := range migration.arrays {
+			legacy = append(legacy, bson.M{array + "." + field: bson.M{"$type": "double"}})
+			set[array] = bson.M{"$cond": bson.A{
+				bson.M{"$isArray": "$" + array},
+				bson.M{"$map": bson.M{
+					"input": "$" + array,
+					"as":    "item",
+					"in": bson.M{"$mergeObjects": bson.A{
+						"$$item",
+						bson.M{field: toMinorUnits("$$item."+field, factor)},
+					}},
+				}},
+				"$$REMOVE",
+			}}
+		}
+		if migration.currency {
+			legacy = append(legacy, bson.M{"currency": bson.M{"$exists": false}})
+			set["currency"] = bson.M{"$ifNull": bson.A{"$currency", currency.Code}}
+		}
+		filter := bson.M{"$or": legacy}
+
+		if dryRun {
+			count, err := collection.CountDocuments(ctx, filter)
+			if err != nil {
+				return fmt.Errorf("failed to count %s: %w", migration.collection, err)
+			}
+			log.Printf("Would convert amounts of %d %s to %s minor units", count, migration.collection, currency.Code)
+			continue
+		}
+
+		result, err := collection.UpdateMany(ctx, filter, bson.A{bson.M{"$set": set}})
+		if err != nil {
+			return fmt.Errorf("failed to convert %s: %w", migration.collection, err)
+		}
+		log.Printf("Converted amounts of %d %s to %s minor units", result.ModifiedCount, migration.collection, currency.Code)
+	}
+	return nil
+}
+
+// toMinorUnits converts a double to a rounded long in minor units and leaves
+// any other value as it is
+func toMinorUnits(value string, factor float64) bson.M {
+	return bson.M{"$cond": bson.A{
+		bson.M{"$eq": bson.A{bson.M{"$type": value}, "double"}},
+		bson.M{"$toLong": bson.M{"$round": bson.A{bson.M{"$multiply": bson.A{value, factor}}, 0}}},
+		value,
+	}}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"eventticketing/models"
+	"eventticketing/services"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// backfillLedger posts successful payments and completed refunds made before
+// the ledger existed. Posting is idempotent, so entries already in the ledger
+// are left alone. It runs after amounts and orders are migrated, since
+// entries need amounts in minor units.
+func backfillLedger(ctx context.Context) error {
+	ledger := services.NewLedgerService()
+
+	payments := utils.GetCollection("payments")
+	cursor, err := payments.Find(ctx, bson.M{"status": "success"})
+	if err != nil {
+		return fmt.Errorf("failed to fetch payments: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	sales := 0
+	for cursor.Next(ctx) {
+		var payment models.Payment
+		if err := cursor.Decode(&payment); err != nil {
+			return fmt.Errorf("failed to decode payment: %w", err)
+		}
+		sales++
+		if err := ledger.RecordSale(ctx, &payment); err != nil {
+			return fmt.Errorf("failed to post payment %s: %w", payment.ID.Hex(), err)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to iterate payments: %w", err)
+	}
+
+	refunds := utils.GetCollection("refunds")
+	cursor, err = refunds.Find(ctx, bson.M{"status": "completed"})
+	if err != nil {
+		return fmt.Errorf("failed to fetch refunds: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	refunded := 0
+	for cursor.Next(ctx) {
+		var refund models.Refund
+		if err := cursor.Decode(&refund); err != nil {
+			return fmt.Errorf("failed to decode refund: %w", err)
+		}
+		refunded++
+		if err := ledger.RecordRefund(ctx, &refund); err != nil {
+			return fmt.Errorf("failed to post refund %s: %w", refund.ID.Hex(), err)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to iterate refunds: %w", err)
+	}
+
+	log.Printf("Posted %d payments and %d refunds to the ledger", sales, refunded)
+	return nil
+}
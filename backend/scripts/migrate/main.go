@@ -0,0 +1,256 @@
+// Command migrate brings data written by earlier versions up to date:
+//
+//   - amounts stored as floating-point major units are converted to integer
+//     minor units of DEFAULT_CURRENCY, which is recorded as their currency
+//   - purchases made before orders existed are moved onto the order/ticket
+//     model: every legacy ticket becomes an order with one ticket per
+//     admission, and its payments are linked to the order
+//   - successful payments and completed refunds are posted to the ledger
+//   - orders with a successful payment are given the paid_at they lacked
+//
+// The migration is safe to re-run. Only amounts still stored as doubles are
+// converted. Each order reuses the ID of the legacy ticket it came from, and a
+// ticket is only marked migrated (given an order_id) once its order, sibling
+// tickets and payments are in place.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"eventticketing/config"
+	"eventticketing/models"
+	"eventticketing/services"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report what would be migrated without writing")
+	flag.Parse()
+
+	// Load configuration
+	config.LoadConfig()
+
+	// Connect to database
+	utils.ConnectDB()
+	defer utils.DisconnectDB()
+
+	// Create indexes
+	utils.CreateIndexes()
+
+	ctx := context.Background()
+
+	currency, ok := models.LookupCurrency(config.AppConfig.Payment.DefaultCurrency)
+	if !ok {
+		log.Fatalf("Unsupported DEFAULT_CURRENCY %q", config.AppConfig.Payment.DefaultCurrency)
+	}
+	if err := migrateAmounts(ctx, currency, *dryRun); err != nil {
+		log.Fatal("Failed to convert amounts:", err)
+	}
+
+	tickets := utils.GetCollection("tickets")
+
+	cursor, err := tickets.Find(ctx, bson.M{"order_id": bson.M{"$exists": false}})
+	if err != nil {
+		log.Fatal("Failed to fetch legacy tickets:", err)
+	}
+	defer cursor.Close(ctx)
+
+	m := newMigrator(*dryRun, currency.Code)
+	for cursor.Next(ctx) {
+		var ticket models.Ticket
+		if *dryRun {
+			// Prices may still be doubles that cannot be decoded until converted
+			var summary struct {
+				TicketCode string `bson:"ticket_code"`
+				Quantity   int    `bson:"quantity"`
+			}
+			if err := cursor.Decode(&summary); err != nil {
+				log.Fatal("Failed to decode ticket:", err)
+			}
+			ticket.TicketCode, ticket.Quantity = summary.TicketCode, summary.Quantity
+		} else if err := cursor.Decode(&ticket); err != nil {
+			log.Fatal("Failed to decode ticket:", err)
+		}
+		if err := m.migrateTicket(ctx, &ticket); err != nil {
+			log.Fatalf("Failed to migrate ticket %s: %v", ticket.ID.Hex(), err)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		log.Fatal("Failed to iterate legacy tickets:", err)
+	}
+
+	log.Printf("Migrated %d tickets into orders (%d admission tickets created)", m.orders, m.created)
+
+	if *dryRun {
+		log.Println("Dry run: payments and refunds would be posted to the ledger")
+	} else if err := backfillLedger(ctx); err != nil {
+		log.Fatal("Failed to backfill the ledger:", err)
+	}
+
+	if *dryRun {
+		log.Println("Dry run: paid orders would be given paid_at")
+	} else if err := backfillPaidAt(ctx); err != nil {
+		log.Fatal("Failed to backfill paid_at:", err)
+	}
+
+	if *dryRun {
+		log.Println("Dry run: no changes were written")
+	}
+}
+
+type migrator struct {
+	dryRun             bool
+	currency           string
+	orderCollection    *mongo.Collection
+	ticketCollection   *mongo.Collection
+	paymentCollection  *mongo.Collection
+	seatLockCollection *mongo.Collection
+	qrService          *services.QRService
+	orders             int
+	created            int
+}
+
+func newMigrator(dryRun bool, currency string) *migrator {
+	return &migrator{
+		dryRun:             dryRun,
+		currency:           currency,
+		orderCollection:    utils.GetCollection("orders"),
+		ticketCollection:   utils.GetCollection("tickets"),
+		paymentCollection:  utils.GetCollection("payments"),
+		seatLockCollection: utils.GetCollection("seat_locks"),
+		qrService:          services.NewQRService(),
+	}
+}
+
+// migrateTicket turns one legacy ticket into an order and splits it into one
+// ticket per admission. The legacy ticket keeps its code as the first admission.
+func (m *migrator) migrateTicket(ctx context.Context, legacy *models.Ticket) error {
+	quantity := legacy.Quantity
+	if quantity < 1 {
+		quantity = 1
+	}
+	// Legacy tickets stored the total for all admissions; the first admission
+	// keeps any minor units that do not divide evenly
+	unitPrice := legacy.Price / int64(quantity)
+	firstPrice := legacy.Price - unitPrice*int64(quantity-1)
+
+	m.orders++
+	m.created += quantity - 1
+	if m.dryRun {
+		log.Printf("Would migrate ticket %s (%d admissions)", legacy.TicketCode, quantity)
+		return nil
+	}
+
+	order := models.Order{
+		ID:             legacy.ID,
+		OrderNumber:    models.GenerateOrderNumber(),
+		UserID:         legacy.UserID,
+		EventID:        legacy.EventID,
+		TicketTypeID:   legacy.TicketTypeID,
+		TicketTypeName: legacy.TicketTypeName,
+		Quantity:       quantity,
+		UnitPrice:      unitPrice,
+		TotalAmount:    legacy.Price,
+		Currency:       m.currency,
+		Status:         models.StatusFromTickets([]string{legacy.Status}),
+		CreatedAt:      legacy.CreatedAt,
+		UpdatedAt:      time.Now(),
+	}
+	if order.Status == "pending" {
+		order.ExpiresAt = legacy.ExpiresAt
+	}
+	_, err := m.orderCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": order.ID},
+		bson.M{"$setOnInsert": order},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return err
+	}
+
+	// Siblings created by an interrupted earlier run are kept
+	existing, err := m.ticketCollection.CountDocuments(ctx, bson.M{"order_id": order.ID})
+	if err != nil {
+		return err
+	}
+	for i := int(existing) + 1; i < quantity; i++ {
+		sibling := models.Ticket{
+			ID:             primitive.NewObjectID(),
+			EventID:        legacy.EventID,
+			UserID:         legacy.UserID,
+			OrderID:        order.ID,
+			TicketTypeID:   legacy.TicketTypeID,
+			TicketTypeName: legacy.TicketTypeName,
+			TicketCode:     models.GenerateTicketCode(),
+			Status:         legacy.Status,
+			Price:          unitPrice,
+			Quantity:       1,
+			UsedAt:         legacy.UsedAt,
+			ExpiresAt:      legacy.ExpiresAt,
+			CreatedAt:      legacy.CreatedAt,
+			UpdatedAt:      time.Now(),
+		}
+		if i < len(legacy.Seats) {
+			sibling.Seats = []models.TicketSeat{legacy.Seats[i]}
+		}
+
+		qrCode, err := m.qrService.GenerateQRCode(sibling.TicketCode)
+		if err != nil {
+			return err
+		}
+		sibling.QRCode = qrCode
+
+		if _, err := m.ticketCollection.InsertOne(ctx, sibling); err != nil {
+			return err
+		}
+		if err := m.moveSeatLocks(ctx, legacy.EventID, sibling.ID, sibling.Seats); err != nil {
+			return err
+		}
+	}
+
+	_, err = m.paymentCollection.UpdateMany(
+		ctx,
+		bson.M{"ticket_id": legacy.ID, "order_id": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"order_id": order.ID}},
+	)
+	if err != nil {
+		return err
+	}
+
+	// Marking the legacy ticket last is what makes a re-run pick up where this one stopped
+	update := bson.M{
+		"order_id":   order.ID,
+		"price":      firstPrice,
+		"quantity":   1,
+		"updated_at": time.Now(),
+	}
+	if len(legacy.Seats) > 1 {
+		update["seats"] = legacy.Seats[:1]
+	}
+	_, err = m.ticketCollection.UpdateOne(ctx, bson.M{"_id": legacy.ID}, bson.M{"$set": update})
+	return err
+}
+
+// moveSeatLocks points the locks of seats handed to a new admission ticket at it
+func (m *migrator) moveSeatLocks(ctx context.Context, eventID, ticketID primitive.ObjectID, seats []models.TicketSeat) error {
+	for _, seat := range seats {
+		_, err := m.seatLockCollection.UpdateOne(
+			ctx,
+			bson.M{"event_id": eventID, "seat_id": seat.SeatID},
+			bson.M{"$set": bson.M{"ticket_id": ticketID, "updated_at": time.Now()}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
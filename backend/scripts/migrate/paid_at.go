@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// backfillPaidAt records when orders paid before paid_at existed were paid,
+// so a late payment success never reinstates an order its buyer cancelled
+// after paying. Only orders whose status shows they were paid are backfilled:
+// paid, partially refunded and refunded orders, and cancelled orders that
+// have a refund. A cancelled order that was never paid must stay without
+// paid_at, or a late success for it would never be applied. Orders that
+// already have it are left alone.
+func backfillPaidAt(ctx context.Context) error {
+	payments := utils.GetCollection("payments")
+	orders := utils.GetCollection("orders")
+	refunds := utils.GetCollection("refunds")
+
+	// Payments flagged for a refund never had their order confirmed
+	cursor, err := payments.Find(ctx, bson.M{"status": "success", "refund_due": bson.M{"$ne": true}})
+	if err != nil {
+		return fmt.Errorf("failed to fetch payments: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	backfilled := int64(0)
+	for cursor.Next(ctx) {
+		var payment models.Payment
+		if err := cursor.Decode(&payment); err != nil {
+			return fmt.Errorf("failed to decode payment: %w", err)
+		}
+
+		paidStatuses := []string{"paid", "partially_refunded", "refunded"}
+		refunded, err := refunds.CountDocuments(ctx, bson.M{"order_id": payment.OrderID})
+		if err != nil {
+			return fmt.Errorf("failed to count refunds of order %s: %w", payment.OrderID.Hex(), err)
+		}
+		if refunded > 0 {
+			paidStatuses = append(paidStatuses, "cancelled")
+		}
+
+		result, err := orders.UpdateOne(
+			ctx,
+			bson.M{"_id": payment.OrderID, "paid_at": bson.M{"$exists": false}, "status": bson.M{"$in": paidStatuses}},
+			bson.M{"$set": bson.M{"paid_at": payment.UpdatedAt}},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to update order %s: %w", payment.OrderID.Hex(), err)
+		}
+		backfilled += result.ModifiedCount
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to iterate payments: %w", err)
+	}
+
+	log.Printf("Recorded when %d orders were paid", backfilled)
+	return nil
+}
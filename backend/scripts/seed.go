@@ -157,7 +157,8 @@ func seedSampleEvents() {
 			Description: "A fantastic summer music festival featuring top artists from around the world. Join us for an unforgettable experience with great music, food, and entertainment.",
 			Date:        time.Now().AddDate(0, 2, 0), // 2 months from now
 			Location:    "Central Park, New York",
-			Price:       75.00,
+			Price:       7500,
+			Currency:    config.AppConfig.Payment.DefaultCurrency,
 			MaxTickets:  1000,
 			SoldTickets: 0,
 			Status:      "active",
@@ -172,7 +173,8 @@ func seedSampleEvents() {
 			Description: "The biggest tech conference of the year featuring keynote speakers, workshops, and networking opportunities. Learn about the latest trends in technology.",
 			Date:        time.Now().AddDate(0, 1, 15), // 1.5 months from now
 			Location:    "Convention Center, San Francisco",
-			Price:       150.00,
+			Price:       15000,
+			Currency:    config.AppConfig.Payment.DefaultCurrency,
 			MaxTickets:  500,
 			SoldTickets: 0,
 			Status:      "active",
@@ -187,7 +189,8 @@ func seedSampleEvents() {
 			Description: "Experience the finest cuisines and wines from renowned chefs and wineries. A culinary journey you won't want to miss.",
 			Date:        time.Now().AddDate(0, 3, 0), // 3 months from now
 			Location:    "Downtown Plaza, Los Angeles",
-			Price:       120.00,
+			Price:       12000,
+			Currency:    config.AppConfig.Payment.DefaultCurrency,
 			MaxTickets:  300,
 			SoldTickets: 0,
 			Status:      "active",
@@ -202,7 +205,8 @@ func seedSampleEvents() {
 			Description: "A night of laughter with top comedians from the comedy circuit. Perfect for a fun evening out with friends.",
 			Date:        time.Now().AddDate(0, 0, 7), // 1 week from now
 			Location:    "Comedy Club, Chicago",
-			Price:       45.00,
+			Price:       4500,
+			Currency:    config.AppConfig.Payment.DefaultCurrency,
 			MaxTickets:  200,
 			SoldTickets: 0,
 			Status:      "active",
@@ -217,7 +221,8 @@ func seedSampleEvents() {
 			Description: "Contemporary art exhibition featuring works from emerging and established artists. A cultural experience for art enthusiasts.",
 			Date:        time.Now().AddDate(0, 1, 0), // 1 month from now
 			Location:    "Modern Art Museum, Miami",
-			Price:       35.00,
+			Price:       3500,
+			Currency:    config.AppConfig.Payment.DefaultCurrency,
 			MaxTickets:  400,
 			SoldTickets: 0,
 			Status:      "active",
@@ -248,4 +253,4 @@ func seedSampleEvents() {
 		event.ID = result.InsertedID.(primitive.ObjectID)
 		log.Printf("Event %s created with ID: %s", event.Title, event.ID.Hex())
 	}
-} 
\ No newline at end of file
+}
@@ -0,0 +1,338 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"eventticketing/i18n"
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrNoUSSDAccount is returned when claiming a phone that has no USSD account
+	ErrNoUSSDAccount = errors.New("no USSD account for this phone")
+	// ErrPhoneTaken is returned when a phone belongs to another web account
+	ErrPhoneTaken = errors.New("phone number belongs to another account")
+	// ErrEmailTaken is returned when claiming an account with an email already in use
+	ErrEmailTaken = errors.New("email already in use")
+	// ErrPhoneCodeTooSoon is returned when a code is asked for again before the resend interval
+	ErrPhoneCodeTooSoon = errors.New("a code was sent recently")
+	// ErrInvalidPhoneCode is returned for wrong, expired or used up verification codes
+	ErrInvalidPhoneCode = errors.New("invalid or expired verification code")
+)
+
+// Phone verification codes
+const (
+	phoneCodeDigits      = 6
+	phoneCodeLifetime    = 10 * time.Minute
+	phoneCodeResendAfter = time.Minute
+	phoneCodeMaxAttempts = 5
+)
+
+// userCollections are the collections whose records belong to a user
+// through user_id, and move with them when accounts are merged
+var userCollections = []string{"tickets", "orders", "payments", "refunds", "seat_locks"}
+
+// AccountService manages accounts identified by phone: the lightweight
+// accounts USSD callers get automatically, claiming them on the web, and
+// merging them into web accounts that verify the same phone
+type AccountService struct {
+	userCollection         *mongo.Collection
+	verificationCollection *mongo.Collection
+	smsService             *SMSService
+}
+
+func NewAccountService() *AccountService {
+	return &AccountService{
+		userCollection:         utils.GetCollection("users"),
+		verificationCollection: utils.GetCollection("phone_verifications"),
+		smsService:             NewSMSService(),
+	}
+}
+
+// FindOrCreateByPhone returns the account with a phone, creating a USSD
+// account for it when there is none. The network vouches for the number a
+// USSD session comes from, so the phone counts as verified.
+func (as *AccountService) FindOrCreateByPhone(ctx context.Context, phone string) (*models.User, error) {
+	now := time.Now()
+	update := bson.M{"$setOnInsert": bson.M{
+		"name":              "",
+		"email":             "",
+		"password":          "",
+		"role":              "user",
+		"is_active":         true,
+		"ussd_only":         true,
+		"phone_verified_at": now,
+		"created_at":        now,
+		"updated_at":        now,
+	}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var user models.User
+	err := as.userCollection.FindOneAndUpdate(ctx, bson.M{"phone": phone}, update, opts).Decode(&user)
+	if mongo.IsDuplicateKeyError(err) {
+		// Another session created the account first
+		err = as.userCollection.FindOne(ctx, bson.M{"phone": phone}).Decode(&user)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find or create account: %w", err)
+	}
+	return &user, nil
+}
+
+// findByPhone returns the account with a phone, or nil when there is none
+func (as *AccountService) findByPhone(ctx context.Context, phone string) (*models.User, error) {
+	var user models.User
+	err := as.userCollection.FindOne(ctx, bson.M{"phone": phone}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch user: %w", err)
+	}
+	return &user, nil
+}
+
+// SendClaimCode texts a verification code to a phone that has a USSD
+// account, so whoever holds the phone can claim the account
+func (as *AccountService) SendClaimCode(ctx context.Context, phone string) error {
+	owner, err := as.findByPhone(ctx, phone)
+	if err != nil {
+		return err
+	}
+	if owner == nil || !owner.USSDOnly {
+		return ErrNoUSSDAccount
+	}
+	return as.sendPhoneCode(ctx, phone, owner.Language)
+}
+
+// SendVerificationCode texts a verification code to a phone the user wants
+// to add to their account
+func (as *AccountService) SendVerificationCode(ctx context.Context, user *models.User, phone string) error {
+	owner, err := as.findByPhone(ctx, phone)
+	if err != nil {
+		return err
+	}
+	if owner != nil && owner.ID != user.ID && !owner.USSDOnly {
+		return ErrPhoneTaken
+	}
+	return as.sendPhoneCode(ctx, phone, user.Language)
+}
+
+func (as *AccountService) sendPhoneCode(ctx context.Context, phone, language string) error {
+	code, err := as.newPhoneCode(ctx, phone)
+	if err != nil {
+		return err
+	}
+	message := i18n.T(language, "sms.verification_code", code, int(phoneCodeLifetime/time.Minute))
+	if err := as.smsService.SendSMS(phone, message); err != nil {
+		return fmt.Errorf("failed to send verification code: %w", err)
+	}
+	return nil
+}
+
+// newPhoneCode stores a new verification code for a phone, replacing any
+// earlier one, and returns it
+func (as *AccountService) newPhoneCode(ctx context.Context, phone string) (string, error) {
+	var previous models.PhoneVerification
+	err := as.verificationCollection.FindOne(ctx, bson.M{"_id": phone}).Decode(&previous)
+	if err == nil && time.Since(previous.SentAt) < phoneCodeResendAfter {
+		return "", ErrPhoneCodeTooSoon
+	}
+	if err != nil && err != mongo.ErrNoDocuments {
+		return "", fmt.Errorf("failed to fetch verification: %w", err)
+	}
+
+	code, err := randomDigits(phoneCodeDigits)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate code: %w", err)
+	}
+	now := time.Now()
+	verification := models.PhoneVerification{
+		Phone:     phone,
+		CodeHash:  hashPhoneCode(phone, code),
+		SentAt:    now,
+		ExpiresAt: now.Add(phoneCodeLifetime),
+	}
+	_, err = as.verificationCollection.ReplaceOne(ctx, bson.M{"_id": phone}, verification, options.Replace().SetUpsert(true))
+	if err != nil {
+		return "", fmt.Errorf("failed to store verification: %w", err)
+	}
+	return code, nil
+}
+
+// checkPhoneCode uses up the verification code for a phone. Each guess
+// counts against the code, so it cannot be found by trying them all.
+func (as *AccountService) checkPhoneCode(ctx context.Context, phone, code string) error {
+	var verification models.PhoneVerification
+	err := as.verificationCollection.FindOneAndUpdate(ctx,
+		bson.M{
+			"_id":        phone,
+			"expires_at": bson.M{"$gt": time.Now()},
+			"attempts":   bson.M{"$lt": phoneCodeMaxAttempts},
+		},
+		bson.M{"$inc": bson.M{"attempts": 1}},
+	).Decode(&verification)
+	if err == mongo.ErrNoDocuments {
+		return ErrInvalidPhoneCode
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch verification: %w", err)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(verification.CodeHash), []byte(hashPhoneCode(phone, code))) != 1 {
+		return ErrInvalidPhoneCode
+	}
+	if _, err := as.verificationCollection.DeleteOne(ctx, bson.M{"_id": phone}); err != nil {
+		return fmt.Errorf("failed to use verification: %w", err)
+	}
+	return nil
+}
+
+// ClaimAccount gives the USSD account on a phone the name, email and
+// password from req, making it a web account that keeps its tickets
+func (as *AccountService) ClaimAccount(ctx context.Context, req *models.ClaimAccountRequest) (*models.User, error) {
+	owner, err := as.findByPhone(ctx, req.Phone)
+	if err != nil {
+		return nil, err
+	}
+	if owner == nil || !owner.USSDOnly {
+		return nil, ErrNoUSSDAccount
+	}
+	if err := as.userCollection.FindOne(ctx, bson.M{"email": req.Email}).Err(); err == nil {
+		return nil, ErrEmailTaken
+	} else if err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("failed to check email: %w", err)
+	}
+	if err := as.checkPhoneCode(ctx, req.Phone, req.Code); err != nil {
+		return nil, err
+	}
+
+	claimed := models.User{Password: req.Password}
+	if err := claimed.HashPassword(); err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+	now := time.Now()
+	var user models.User
+	err = as.userCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": owner.ID, "ussd_only": true},
+		bson.M{
+			"$set": bson.M{
+				"name":              req.Name,
+				"email":             req.Email,
+				"password":          claimed.Password,
+				"phone_verified_at": now,
+				"updated_at":        now,
+			},
+			"$unset": bson.M{"ussd_only": ""},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrEmailTaken
+	}
+	if err == mongo.ErrNoDocuments {
+		// Claimed by a concurrent request
+		return nil, ErrNoUSSDAccount
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim account: %w", err)
+	}
+	return &user, nil
+}
+
+// VerifyPhone adds a phone to a user's account with the code sent to it.
+// When the phone has a USSD account, its tickets, orders and payments move
+// to the user and the USSD account is removed. It returns the updated user.
+func (as *AccountService) VerifyPhone(ctx context.Context, user *models.User, phone, code string) (*models.User, error) {
+	owner, err := as.findByPhone(ctx, phone)
+	if err != nil {
+		return nil, err
+	}
+	if owner != nil && owner.ID != user.ID && !owner.USSDOnly {
+		return nil, ErrPhoneTaken
+	}
+	if err := as.checkPhoneCode(ctx, phone, code); err != nil {
+		return nil, err
+	}
+
+	set := bson.M{"phone": phone, "phone_verified_at": time.Now(), "updated_at": time.Now()}
+	if owner != nil && owner.ID != user.ID {
+		if err := as.mergeAccount(ctx, owner, user); err != nil {
+			return nil, err
+		}
+		if user.Language == "" && owner.Language != "" {
+			set["language"] = owner.Language
+		}
+	}
+
+	var updated models.User
+	err = as.userCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrPhoneTaken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update phone: %w", err)
+	}
+	return &updated, nil
+}
+
+// mergeAccount moves everything a USSD account owns to another account and
+// removes it, freeing its phone. Each step can be repeated, so a merge cut
+// short finishes when the phone is verified again.
+func (as *AccountService) mergeAccount(ctx context.Context, from, into *models.User) error {
+	filter := bson.M{"user_id": from.ID}
+	update := bson.M{"$set": bson.M{"user_id": into.ID}}
+	for _, name := range userCollections {
+		result, err := utils.GetCollection(name).UpdateMany(ctx, filter, update)
+		if err != nil {
+			return fmt.Errorf("failed to move %s: %w", name, err)
+		}
+		if result.ModifiedCount > 0 {
+			log.Printf("Moved %d %s from USSD account %s to %s", result.ModifiedCount, name, from.ID.Hex(), into.ID.Hex())
+		}
+	}
+
+	_, err := as.userCollection.DeleteOne(ctx, bson.M{"_id": from.ID, "ussd_only": true})
+	if err != nil {
+		return fmt.Errorf("failed to remove USSD account: %w", err)
+	}
+	return nil
+}
+
+// hashPhoneCode hashes a code with its phone, so a stored hash only matches
+// the code for that phone
+func hashPhoneCode(phone, code string) string {
+	sum := sha256.Sum256([]byte(phone + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomDigits returns n random decimal digits
+func randomDigits(n int) (string, error) {
+	digits := make([]byte, n)
+	for i := range digits {
+		d, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		digits[i] = byte('0' + d.Int64())
+	}
+	return string(digits), nil
+}
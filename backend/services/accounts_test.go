@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestFindOrCreateByPhone(t *testing.T) {
+	setupInventoryTest(t)
+	ctx := context.Background()
+	as := NewAccountService()
+
+	first, err := as.FindOrCreateByPhone(ctx, "+233200000010")
+	if err != nil {
+		t.Fatalf("failed to create account: %v", err)
+	}
+	if !first.USSDOnly || !first.IsActive || first.Role != "user" || first.PhoneVerifiedAt == nil || first.Email != "" {
+		t.Errorf("expected an active, phone-verified USSD account, got %+v", first)
+	}
+
+	again, err := as.FindOrCreateByPhone(ctx, "+233200000010")
+	if err != nil {
+		t.Fatalf("failed to find account: %v", err)
+	}
+	if again.ID != first.ID {
+		t.Errorf("expected the same account, got %s and %s", first.ID.Hex(), again.ID.Hex())
+	}
+}
+
+func TestPhoneCodes(t *testing.T) {
+	setupInventoryTest(t)
+	ctx := context.Background()
+	as := NewAccountService()
+	phone := "+233200000011"
+
+	code, err := as.newPhoneCode(ctx, phone)
+	if err != nil || len(code) != phoneCodeDigits {
+		t.Fatalf("expected a %d digit code, got %q (%v)", phoneCodeDigits, code, err)
+	}
+	if _, err := as.newPhoneCode(ctx, phone); err != ErrPhoneCodeTooSoon {
+		t.Errorf("expected a second code straight away to be refused, got %v", err)
+	}
+
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	for i := 0; i < phoneCodeMaxAttempts; i++ {
+		if err := as.checkPhoneCode(ctx, phone, wrong); err != ErrInvalidPhoneCode {
+			t.Fatalf("guess %d: expected a wrong code to be refused, got %v", i+1, err)
+		}
+	}
+	if err := as.checkPhoneCode(ctx, phone, code); err != ErrInvalidPhoneCode {
+		t.Errorf("expected the right code to be refused after %d wrong guesses, got %v", phoneCodeMaxAttempts, err)
+	}
+
+	utils.GetCollection("phone_verifications").DeleteMany(ctx, bson.M{})
+	code, _ = as.newPhoneCode(ctx, phone)
+	if err := as.checkPhoneCode(ctx, phone, code); err != nil {
+		t.Fatalf("expected the code to be accepted, got %v", err)
+	}
+	if err := as.checkPhoneCode(ctx, phone, code); err != ErrInvalidPhoneCode {
+		t.Errorf("expected a code to work only once, got %v", err)
+	}
+}
+
+func TestClaimAccount(t *testing.T) {
+	setupInventoryTest(t)
+	ctx := context.Background()
+	as := NewAccountService()
+	phone := "+233200000012"
+
+	ussdUser, _ := as.FindOrCreateByPhone(ctx, phone)
+	code, _ := as.newPhoneCode(ctx, phone)
+	req := &models.ClaimAccountRequest{Phone: phone, Code: code, Name: "Ama Mensah", Email: "ama@example.com", Password: "secret123"}
+
+	user, err := as.ClaimAccount(ctx, req)
+	if err != nil {
+		t.Fatalf("failed to claim account: %v", err)
+	}
+	if user.ID != ussdUser.ID || user.USSDOnly || user.Email != req.Email || !user.CheckPassword(req.Password) {
+		t.Errorf("expected the USSD account to become a web account, got %+v", user)
+	}
+
+	if _, err := as.ClaimAccount(ctx, req); err != ErrNoUSSDAccount {
+		t.Errorf("expected a claimed account not to be claimed again, got %v", err)
+	}
+}
+
+func TestVerifyPhoneMergesUSSDAccount(t *testing.T) {
+	setupInventoryTest(t)
+	ctx := context.Background()
+	as := NewAccountService()
+	phone := "+233200000013"
+
+	ussdUser, _ := as.FindOrCreateByPhone(ctx, phone)
+	utils.GetCollection("users").UpdateOne(ctx, bson.M{"_id": ussdUser.ID}, bson.M{"$set": bson.M{"language": "tw"}})
+	for i := 0; i < 2; i++ {
+		utils.GetCollection("tickets").InsertOne(ctx, models.Ticket{UserID: ussdUser.ID, TicketCode: models.GenerateTicketCode(), Status: "paid"})
+	}
+	utils.GetCollection("orders").InsertOne(ctx, models.Order{UserID: ussdUser.ID})
+
+	webUser := models.User{Name: "Kofi", Email: "kofi@example.com", Phone: "+233200000099", Role: "user", IsActive: true, CreatedAt: time.Now()}
+	result, err := utils.GetCollection("users").InsertOne(ctx, webUser)
+	if err != nil {
+		t.Fatalf("failed to insert user: %v", err)
+	}
+	webUser.ID = result.InsertedID.(primitive.ObjectID)
+
+	code, _ := as.newPhoneCode(ctx, phone)
+	if _, err := as.VerifyPhone(ctx, &webUser, phone, "not-the-code"); err != ErrInvalidPhoneCode {
+		t.Fatalf("expected a wrong code to be refused, got %v", err)
+	}
+	updated, err := as.VerifyPhone(ctx, &webUser, phone, code)
+	if err != nil {
+		t.Fatalf("failed to verify phone: %v", err)
+	}
+	if updated.Phone != phone || updated.PhoneVerifiedAt == nil || updated.Language != "tw" {
+		t.Errorf("expected the verified phone and the USSD language on the account, got %+v", updated)
+	}
+
+	for name, expected := range map[string]int64{"tickets": 2, "orders": 1} {
+		moved, _ := utils.GetCollection(name).CountDocuments(ctx, bson.M{"user_id": webUser.ID})
+		if moved != expected {
+			t.Errorf("expected %d %s moved to the web account, got %d", expected, name, moved)
+		}
+	}
+	if n, _ := utils.GetCollection("users").CountDocuments(ctx, bson.M{"_id": ussdUser.ID}); n != 0 {
+		t.Error("expected the USSD account to be removed")
+	}
+
+	// A phone on another web account cannot be taken over
+	other := models.User{Name: "Esi", Email: "esi@example.com", Phone: "+233200000098", Role: "user", IsActive: true}
+	if err := as.SendVerificationCode(ctx, &other, phone); err != ErrPhoneTaken {
+		t.Errorf("expected a phone on a web account to be refused, got %v", err)
+	}
+}
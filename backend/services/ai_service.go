@@ -1,10 +1,7 @@
 package services
 
 import (
-	"context"
-	"encoding/json"
 	"fmt"
-	"log"
 	"math"
 	"time"
 
@@ -18,40 +15,44 @@ type AIService struct {
 
 type DynamicPricingData struct {
 	EventID           string    `json:"event_id"`
-	BasePrice         float64   `json:"base_price"`
-	CurrentPrice      float64   `json:"current_price"`
+	BasePrice         int64     `json:"base_price"`    // in minor units of Currency
+	CurrentPrice      int64     `json:"current_price"` // in minor units of Currency
+	Currency          string    `json:"currency"`
+	Multiplier        float64   `json:"multiplier"` // applied to every price of the event, within its bounds
 	DemandMultiplier  float64   `json:"demand_multiplier"`
 	TimeMultiplier    float64   `json:"time_multiplier"`
 	CompetitionFactor float64   `json:"competition_factor"`
+	RecentSales       int       `json:"recent_sales"`     // tickets taken in the last week
+	CompetingEvents   int       `json:"competing_events"` // events in the same category around the same date
 	LastUpdated       time.Time `json:"last_updated"`
 }
 
 type FraudRiskAssessment struct {
-	UserID        string   `json:"user_id"`
-	RiskScore     float64  `json:"risk_score"`     // 0-100
-	RiskLevel     string   `json:"risk_level"`     // low, medium, high, critical
-	RiskFactors   []string `json:"risk_factors"`
-	Recommendation string  `json:"recommendation"`
+	UserID         string   `json:"user_id"`
+	RiskScore      float64  `json:"risk_score"` // 0-100
+	RiskLevel      string   `json:"risk_level"` // low, medium, high, critical
+	RiskFactors    []string `json:"risk_factors"`
+	Recommendation string   `json:"recommendation"`
 }
 
 type PersonalizedRecommendation struct {
-	UserID      string                `json:"user_id"`
-	EventID     string                `json:"event_id"`
-	Score       float64               `json:"score"`        // 0-100
-	Reason      string                `json:"reason"`
-	Categories  []string              `json:"categories"`
-	Similarity  float64               `json:"similarity"`
-	Confidence  float64               `json:"confidence"`
+	UserID     string   `json:"user_id"`
+	EventID    string   `json:"event_id"`
+	Score      float64  `json:"score"` // 0-100
+	Reason     string   `json:"reason"`
+	Categories []string `json:"categories"`
+	Similarity float64  `json:"similarity"`
+	Confidence float64  `json:"confidence"`
 }
 
 type EventAnalytics struct {
-	EventID           string             `json:"event_id"`
-	PredictedSales    int                `json:"predicted_sales"`
-	OptimalPrice      float64            `json:"optimal_price"`
-	PeakDemandTime    time.Time          `json:"peak_demand_time"`
+	EventID              string             `json:"event_id"`
+	PredictedSales       int                `json:"predicted_sales"`
+	OptimalPrice         int64              `json:"optimal_price"` // in minor units of the event's currency
+	PeakDemandTime       time.Time          `json:"peak_demand_time"`
 	AttendeeDemographics map[string]float64 `json:"attendee_demographics"`
-	RevenueForecast   float64            `json:"revenue_forecast"`
-	ConfidenceLevel   float64            `json:"confidence_level"`
+	RevenueForecast      int64              `json:"revenue_forecast"`
+	ConfidenceLevel      float64            `json:"confidence_level"`
 }
 
 func NewAIService() *AIService {
@@ -59,25 +60,35 @@ func NewAIService() *AIService {
 }
 
 // CalculateDynamicPricing calculates optimal ticket pricing based on demand, time, and competition
-func (ai *AIService) CalculateDynamicPricing(event *models.Event, historicalData []models.Ticket) DynamicPricingData {
-	basePrice := event.Price
+func (ai *AIService) CalculateDynamicPricing(event *models.Event, historicalData []models.Ticket, competingEvents []models.Event) DynamicPricingData {
 	demandMultiplier := ai.calculateDemandMultiplier(event, historicalData)
 	timeMultiplier := ai.calculateTimeMultiplier(event.Date)
-	competitionFactor := ai.calculateCompetitionFactor(event)
+	competitionFactor := ai.calculateCompetitionFactor(event, competingEvents)
 
 	// Apply pricing algorithm
-	currentPrice := basePrice * demandMultiplier * timeMultiplier * competitionFactor
+	multiplier := demandMultiplier * timeMultiplier * competitionFactor
 
-	// Ensure price stays within reasonable bounds (50% to 200% of base price)
-	currentPrice = math.Max(basePrice*0.5, math.Min(basePrice*2.0, currentPrice))
+	// Keep the price within the organizer's bounds, or 50% to 200% of base price
+	floor, ceiling := 0.5, 2.0
+	if event.HasDynamicPricing() {
+		floor = float64(event.Pricing.FloorPercent) / 100
+		ceiling = float64(event.Pricing.CeilingPercent) / 100
+	}
+	multiplier = math.Max(floor, math.Min(ceiling, multiplier))
+	// Round to whole percentages so prices do not change by a pesewa at a time
+	multiplier = math.Round(multiplier*100) / 100
 
 	return DynamicPricingData{
 		EventID:           event.ID.Hex(),
-		BasePrice:         basePrice,
-		CurrentPrice:      currentPrice,
+		BasePrice:         event.Price,
+		CurrentPrice:      int64(math.Round(float64(event.Price) * multiplier)),
+		Currency:          event.Currency,
+		Multiplier:        multiplier,
 		DemandMultiplier:  demandMultiplier,
 		TimeMultiplier:    timeMultiplier,
 		CompetitionFactor: competitionFactor,
+		RecentSales:       ai.countRecentSales(event, historicalData),
+		CompetingEvents:   len(competingEvents),
 		LastUpdated:       time.Now(),
 	}
 }
@@ -147,10 +158,10 @@ func (ai *AIService) AssessFraudRisk(user *models.User, purchaseData map[string]
 	}
 
 	return FraudRiskAssessment{
-		UserID:        user.ID.Hex(),
-		RiskScore:     riskScore,
-		RiskLevel:     riskLevel,
-		RiskFactors:   riskFactors,
+		UserID:         user.ID.Hex(),
+		RiskScore:      riskScore,
+		RiskLevel:      riskLevel,
+		RiskFactors:    riskFactors,
 		Recommendation: recommendation,
 	}
 }
@@ -161,11 +172,11 @@ func (ai *AIService) GeneratePersonalizedRecommendations(user *models.User, user
 
 	// Analyze user preferences
 	userPreferences := ai.analyzeUserPreferences(userTickets)
-	
+
 	// Calculate recommendations for each available event
 	for _, event := range availableEvents {
 		score := ai.calculateRecommendationScore(user, event, userPreferences)
-		
+
 		if score > 30 { // Only recommend events with score > 30
 			recommendation := PersonalizedRecommendation{
 				UserID:     user.ID.Hex(),
@@ -187,27 +198,27 @@ func (ai *AIService) GeneratePersonalizedRecommendations(user *models.User, user
 func (ai *AIService) PredictEventPerformance(event *models.Event, historicalData []models.Event) EventAnalytics {
 	// Calculate predicted sales based on similar events
 	predictedSales := ai.predictSales(event, historicalData)
-	
+
 	// Calculate optimal pricing
 	optimalPrice := ai.calculateOptimalPrice(event, historicalData)
-	
+
 	// Predict peak demand time
 	peakDemandTime := ai.predictPeakDemand(event)
-	
+
 	// Forecast revenue
-	revenueForecast := float64(predictedSales) * optimalPrice
-	
+	revenueForecast := int64(predictedSales) * optimalPrice
+
 	// Calculate confidence level
 	confidenceLevel := ai.calculateConfidenceLevel(event, historicalData)
 
 	return EventAnalytics{
-		EventID:           event.ID.Hex(),
-		PredictedSales:    predictedSales,
-		OptimalPrice:      optimalPrice,
-		PeakDemandTime:    peakDemandTime,
+		EventID:              event.ID.Hex(),
+		PredictedSales:       predictedSales,
+		OptimalPrice:         optimalPrice,
+		PeakDemandTime:       peakDemandTime,
 		AttendeeDemographics: ai.predictDemographics(event),
-		RevenueForecast:   revenueForecast,
-		ConfidenceLevel:   confidenceLevel,
+		RevenueForecast:      revenueForecast,
+		ConfidenceLevel:      confidenceLevel,
 	}
 }
 
@@ -215,25 +226,21 @@ func (ai *AIService) PredictEventPerformance(event *models.Event, historicalData
 
 func (ai *AIService) calculateDemandMultiplier(event *models.Event, historicalData []models.Ticket) float64 {
 	// Calculate demand based on recent ticket sales
-	recentSales := 0
-	now := time.Now()
-	
-	for _, ticket := range historicalData {
-		if ticket.EventID == event.ID && ticket.CreatedAt.After(now.AddDate(0, 0, -7)) {
-			recentSales++
-		}
+	recentSales := ai.countRecentSales(event, historicalData)
+	if event.MaxTickets < 1 {
+		return 1.0
 	}
-	
+
 	// Simple demand calculation
 	demandRatio := float64(recentSales) / float64(event.MaxTickets)
-	
+
 	// Return multiplier between 0.8 and 1.5
 	return 0.8 + (demandRatio * 0.7)
 }
 
 func (ai *AIService) calculateTimeMultiplier(eventDate time.Time) float64 {
 	daysUntilEvent := time.Until(eventDate).Hours() / 24
-	
+
 	// Higher multiplier as event gets closer
 	if daysUntilEvent < 1 {
 		return 1.5 // 50% increase for same-day tickets
@@ -242,34 +249,55 @@ func (ai *AIService) calculateTimeMultiplier(eventDate time.Time) float64 {
 	} else if daysUntilEvent < 30 {
 		return 1.1 // 10% increase for last month
 	}
-	
+
 	return 1.0 // Base price for events far in the future
 }
 
-func (ai *AIService) calculateCompetitionFactor(event *models.Event) float64 {
-	// In a real implementation, you would analyze competing events
-	// For now, return a simple factor
-	return 1.0
+// countRecentSales counts the event's tickets taken in the last week
+func (ai *AIService) countRecentSales(event *models.Event, historicalData []models.Ticket) int {
+	recentSales := 0
+	weekAgo := time.Now().AddDate(0, 0, -7)
+	for _, ticket := range historicalData {
+		if ticket.EventID == event.ID && ticket.CreatedAt.After(weekAgo) {
+			recentSales++
+		}
+	}
+	return recentSales
+}
+
+// calculateCompetitionFactor lowers the price when other events compete for
+// the same buyers. Each competitor counts for the share of its tickets still
+// unsold, since a sold-out event takes no more buyers; every full
+// competitor takes 5% off, down to 80%.
+func (ai *AIService) calculateCompetitionFactor(event *models.Event, competingEvents []models.Event) float64 {
+	competition := 0.0
+	for _, competitor := range competingEvents {
+		if competitor.ID == event.ID || competitor.MaxTickets < 1 {
+			continue
+		}
+		competition += float64(competitor.GetAvailableTickets()) / float64(competitor.MaxTickets)
+	}
+	return math.Max(0.8, 1.0-0.05*competition)
 }
 
 func (ai *AIService) analyzeUserPreferences(tickets []models.Ticket) map[string]float64 {
 	preferences := make(map[string]float64)
-	
-	for _, ticket := range tickets {
+
+	for range tickets {
 		// Analyze event categories, prices, times, etc.
 		// This is a simplified version
 		preferences["total_tickets"]++
 	}
-	
+
 	return preferences
 }
 
 func (ai *AIService) calculateRecommendationScore(user *models.User, event models.Event, preferences map[string]float64) float64 {
 	score := 50.0 // Base score
-	
+
 	// Add points based on user preferences
 	// This is a simplified scoring algorithm
-	
+
 	return math.Min(100, score)
 }
 
@@ -296,24 +324,24 @@ func (ai *AIService) predictSales(event *models.Event, historicalData []models.E
 	// Simple prediction based on similar events
 	similarEvents := 0
 	totalSales := 0
-	
+
 	for _, histEvent := range historicalData {
 		if histEvent.Category == event.Category {
 			similarEvents++
 			totalSales += histEvent.SoldTickets
 		}
 	}
-	
+
 	if similarEvents == 0 {
 		return event.MaxTickets / 2 // Default prediction
 	}
-	
+
 	return totalSales / similarEvents
 }
 
-func (ai *AIService) calculateOptimalPrice(event *models.Event, historicalData []models.Event) float64 {
+func (ai *AIService) calculateOptimalPrice(event *models.Event, historicalData []models.Event) int64 {
 	// Calculate optimal price based on historical data
-	return event.Price * 1.1 // 10% increase as optimal
+	return int64(math.Round(float64(event.Price) * 1.1)) // 10% increase as optimal
 }
 
 func (ai *AIService) predictPeakDemand(event *models.Event) time.Time {
@@ -334,4 +362,4 @@ func (ai *AIService) predictDemographics(event *models.Event) map[string]float64
 func (ai *AIService) calculateConfidenceLevel(event *models.Event, historicalData []models.Event) float64 {
 	// Calculate confidence in predictions
 	return 0.75 // Placeholder
-} 
\ No newline at end of file
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"eventticketing/config"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Envelope is a live message on its way to clients: who it is for, and the
+// message already encoded as JSON, so it is encoded once however many
+// clients receive it
+type Envelope struct {
+	Instance string `bson:"instance"` // the instance that published it
+	UserID   string `bson:"user_id,omitempty"`
+	EventID  string `bson:"event_id,omitempty"`
+	// QueueEventID marks the progress of an event's queue, which each
+	// instance turns into a message for every user watching it
+	QueueEventID string `bson:"queue_event_id,omitempty"`
+	Payload      []byte `bson:"payload"`
+}
+
+// Broker carries live messages between API instances, so a message
+// published on one reaches the clients connected to all of them
+type Broker interface {
+	// Publish sends a message to every subscriber, on every instance
+	Publish(ctx context.Context, envelope *Envelope) error
+	// Subscribe hands deliver each published message until ctx is cancelled
+	// or the subscription fails. deliver must not block.
+	Subscribe(ctx context.Context, deliver func(*Envelope)) error
+}
+
+// NewBroker returns the broker with the given name
+func NewBroker(name string) (Broker, error) {
+	switch name {
+	case "mongo":
+		return NewMongoBroker(), nil
+	case "memory":
+		return NewMemoryBroker(), nil
+	}
+	return nil, fmt.Errorf("unknown live message broker %q", name)
+}
+
+// DefaultBroker returns the broker named in the configuration, or the
+// MongoDB broker when the name is not known
+func DefaultBroker() Broker {
+	broker, err := NewBroker(config.AppConfig.Live.Broker)
+	if err != nil {
+		log.Printf("%v; using the mongo broker", err)
+		return NewMongoBroker()
+	}
+	return broker
+}
+
+// MemoryBroker passes messages between subscribers in one process. It
+// suits a single instance, and tests that run several services side by side.
+type MemoryBroker struct {
+	mutex       sync.RWMutex
+	subscribers map[*func(*Envelope)]struct{}
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{subscribers: make(map[*func(*Envelope)]struct{})}
+}
+
+func (mb *MemoryBroker) Publish(ctx context.Context, envelope *Envelope) error {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+	for deliver := range mb.subscribers {
+		(*deliver)(envelope)
+	}
+	return nil
+}
+
+func (mb *MemoryBroker) Subscribe(ctx context.Context, deliver func(*Envelope)) error {
+	mb.mutex.Lock()
+	mb.subscribers[&deliver] = struct{}{}
+	mb.mutex.Unlock()
+
+	<-ctx.Done()
+
+	mb.mutex.Lock()
+	delete(mb.subscribers, &deliver)
+	mb.mutex.Unlock()
+	return ctx.Err()
+}
+
+// MongoBroker shares messages through the live_messages collection. Each
+// instance follows a change stream of the messages written to it, so
+// MongoDB must run as a replica set, as change streams need. Messages are
+// only for the instances subscribed when they are written, and are removed
+// after five minutes.
+type MongoBroker struct {
+	messageCollection *mongo.Collection
+}
+
+func NewMongoBroker() *MongoBroker {
+	return &MongoBroker{messageCollection: utils.GetCollection("live_messages")}
+}
+
+type liveMessage struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	Envelope  `bson:",inline"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+func (mb *MongoBroker) Publish(ctx context.Context, envelope *Envelope) error {
+	_, err := mb.messageCollection.InsertOne(ctx, liveMessage{
+		ID:        primitive.NewObjectID(),
+		Envelope:  *envelope,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish live message: %w", err)
+	}
+	return nil
+}
+
+func (mb *MongoBroker) Subscribe(ctx context.Context, deliver func(*Envelope)) error {
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{"operationType": "insert"}}}}
+	stream, err := mb.messageCollection.Watch(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.Background())
+
+	for stream.Next(ctx) {
+		var change struct {
+			FullDocument liveMessage `bson:"fullDocument"`
+		}
+		if err := stream.Decode(&change); err != nil {
+			log.Printf("Failed to decode live message: %v", err)
+			continue
+		}
+		deliver(&change.FullDocument.Envelope)
+	}
+	return stream.Err()
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMongoBroker(t *testing.T) {
+	setupInventoryTest(t)
+	broker := NewMongoBroker()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	received := make(chan *Envelope, 1)
+	failed := make(chan error, 1)
+	go func() {
+		failed <- broker.Subscribe(ctx, func(envelope *Envelope) {
+			select {
+			case received <- envelope:
+			default:
+			}
+		})
+	}()
+
+	// The change stream may take a moment to open, so publish until it delivers
+	sent := &Envelope{Instance: "first", UserID: "user", Payload: []byte(`{"type":"notification"}`)}
+	deadline := time.After(10 * time.Second)
+	for {
+		if err := broker.Publish(ctx, sent); err != nil {
+			t.Fatalf("failed to publish: %v", err)
+		}
+		select {
+		case err := <-failed:
+			t.Skipf("change streams unavailable, as on a standalone server: %v", err)
+		case envelope := <-received:
+			if envelope.Instance != "first" || envelope.UserID != "user" || string(envelope.Payload) != string(sent.Payload) {
+				t.Errorf("unexpected envelope %+v", envelope)
+			}
+			return
+		case <-deadline:
+			t.Fatal("expected the message to be delivered")
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
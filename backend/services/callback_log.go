@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrCallbackReplayed is returned when a callback's nonce has been seen before
+var ErrCallbackReplayed = errors.New("callback nonce already used")
+
+// redactedHeaders are not copied into the callback audit log
+var redactedHeaders = map[string]bool{
+	"Authorization": true,
+	"Cookie":        true,
+}
+
+// CallbackLogService keeps the audit log of provider callbacks and the nonces
+// that stop them being replayed
+type CallbackLogService struct {
+	logCollection   *mongo.Collection
+	nonceCollection *mongo.Collection
+}
+
+func NewCallbackLogService() *CallbackLogService {
+	return &CallbackLogService{
+		logCollection:   utils.GetCollection("callback_logs"),
+		nonceCollection: utils.GetCollection("callback_nonces"),
+	}
+}
+
+// Record stores a raw callback as soon as it arrives, before anything about
+// it is trusted, and returns the log entry's ID
+func (cs *CallbackLogService) Record(ctx context.Context, provider string, r *http.Request, body []byte, remoteIP string) (primitive.ObjectID, error) {
+	headers := make(map[string]string)
+	for name, values := range r.Header {
+		if redactedHeaders[name] {
+			continue
+		}
+		headers[name] = strings.Join(values, ", ")
+	}
+
+	entry := models.CallbackLog{
+		Provider:   provider,
+		Headers:    headers,
+		Body:       string(body),
+		RemoteIP:   remoteIP,
+		Outcome:    models.CallbackReceived,
+		ReceivedAt: time.Now(),
+	}
+	result, err := cs.logCollection.InsertOne(ctx, entry)
+	if err != nil {
+		return primitive.NilObjectID, fmt.Errorf("failed to record callback: %w", err)
+	}
+	return result.InsertedID.(primitive.ObjectID), nil
+}
+
+// SetOutcome records what was done with a logged callback
+func (cs *CallbackLogService) SetOutcome(ctx context.Context, logID primitive.ObjectID, outcome, detail string, paymentID primitive.ObjectID) error {
+	if logID.IsZero() {
+		return nil
+	}
+
+	update := bson.M{
+		"outcome":      outcome,
+		"detail":       detail,
+		"processed_at": time.Now(),
+	}
+	if !paymentID.IsZero() {
+		update["payment_id"] = paymentID
+	}
+	_, err := cs.logCollection.UpdateOne(ctx, bson.M{"_id": logID}, bson.M{"$set": update})
+	if err != nil {
+		return fmt.Errorf("failed to update callback log: %w", err)
+	}
+	return nil
+}
+
+// ClaimNonce records a callback's nonce, failing with ErrCallbackReplayed if
+// it was already used. Nonces only need remembering for as long as their
+// timestamp is accepted; a TTL index removes them after that.
+func (cs *CallbackLogService) ClaimNonce(ctx context.Context, provider, nonce string) error {
+	_, err := cs.nonceCollection.InsertOne(ctx, bson.M{
+		"_id":        provider + ":" + nonce,
+		"created_at": time.Now(),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrCallbackReplayed
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record callback nonce: %w", err)
+	}
+	return nil
+}
+
+// ReleaseNonce forgets a claimed nonce so the provider can deliver a callback
+// again after processing it failed
+func (cs *CallbackLogService) ReleaseNonce(ctx context.Context, provider, nonce string) error {
+	_, err := cs.nonceCollection.DeleteOne(ctx, bson.M{"_id": provider + ":" + nonce})
+	if err != nil {
+		return fmt.Errorf("failed to release callback nonce: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestClaimNonceRejectsReplays(t *testing.T) {
+	setupInventoryTest(t)
+	callbacks := NewCallbackLogService()
+	ctx := context.Background()
+
+	if err := callbacks.ClaimNonce(ctx, "momo", "nonce-1"); err != nil {
+		t.Fatalf("failed to claim nonce: %v", err)
+	}
+	if err := callbacks.ClaimNonce(ctx, "momo", "nonce-1"); !errors.Is(err, ErrCallbackReplayed) {
+		t.Errorf("expected ErrCallbackReplayed, got %v", err)
+	}
+	if err := callbacks.ClaimNonce(ctx, "other", "nonce-1"); err != nil {
+		t.Errorf("expected nonces to be scoped per provider, got %v", err)
+	}
+}
+
+func TestReleasedNonceCanBeClaimedAgain(t *testing.T) {
+	setupInventoryTest(t)
+	callbacks := NewCallbackLogService()
+	ctx := context.Background()
+
+	if err := callbacks.ClaimNonce(ctx, "momo", "nonce-1"); err != nil {
+		t.Fatalf("failed to claim nonce: %v", err)
+	}
+	if err := callbacks.ReleaseNonce(ctx, "momo", "nonce-1"); err != nil {
+		t.Fatalf("failed to release nonce: %v", err)
+	}
+	if err := callbacks.ClaimNonce(ctx, "momo", "nonce-1"); err != nil {
+		t.Errorf("expected a released nonce to be claimable, got %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Results of a scan at the door
+const (
+	ScanAdmitted  = "admitted"
+	ScanDuplicate = "duplicate" // the ticket was already used
+	ScanRejected  = "rejected"  // unknown, for another event, or not valid
+)
+
+const (
+	// doorStatsInterval is the most often an event's door stats are sent
+	doorStatsInterval = time.Second
+	// doorStatsMinutes is how many recent minutes door stats break down
+	doorStatsMinutes = 15
+	// doorRateMinutes is how many recent minutes scan rates are averaged over
+	doorRateMinutes = 5
+	// DefaultEntrance names the entrance of scans that do not give one
+	DefaultEntrance = "Main"
+	// maxEntranceLength keeps entrance names to something a dashboard can show
+	maxEntranceLength = 50
+)
+
+// TicketScan is one verification at the door
+type TicketScan struct {
+	EventID    string    `json:"event_id"`
+	TicketCode string    `json:"ticket_code"`
+	Entrance   string    `json:"entrance"`
+	Result     string    `json:"result"` // admitted, duplicate or rejected
+	Message    string    `json:"message"`
+	ScannedAt  time.Time `json:"scanned_at"`
+}
+
+// DoorStats are an event's figures at the door: how many are in against
+// how many bought tickets, how fast they are coming in and through which
+// entrances
+type DoorStats struct {
+	LiveEventData
+	Duplicates     int             `json:"duplicates"` // scans of tickets already used
+	Rejected       int             `json:"rejected"`
+	ScansPerMinute float64         `json:"scans_per_minute"` // over the last five minutes
+	Minutes        []DoorMinute    `json:"minutes"`          // the last 15 minutes, oldest first
+	Entrances      []EntranceStats `json:"entrances"`
+}
+
+// DoorMinute counts one minute's scans
+type DoorMinute struct {
+	Minute     time.Time `json:"minute"`
+	Admitted   int       `json:"admitted"`
+	Duplicates int       `json:"duplicates"`
+	Rejected   int       `json:"rejected"`
+}
+
+// EntranceStats count the scans at one entrance
+type EntranceStats struct {
+	Entrance       string  `json:"entrance"`
+	Admitted       int     `json:"admitted"`
+	Duplicates     int     `json:"duplicates"`
+	Rejected       int     `json:"rejected"`
+	ScansPerMinute float64 `json:"scans_per_minute"` // over the last five minutes
+}
+
+// doorCounter counts the scans at one entrance in one minute. Its counts
+// are named after the scan results.
+type doorCounter struct {
+	EventID    primitive.ObjectID `bson:"event_id"`
+	Entrance   string             `bson:"entrance"`
+	Minute     time.Time          `bson:"minute"`
+	Admitted   int                `bson:"admitted"`
+	Duplicates int                `bson:"duplicate"`
+	Rejected   int                `bson:"rejected"`
+}
+
+func (c *doorCounter) scans() int {
+	return c.Admitted + c.Duplicates + c.Rejected
+}
+
+// DoorService counts ticket scans per event, entrance and minute, and
+// streams each scan and the event's door stats to its followers. Counters
+// are kept in the database so every instance's scans are counted together.
+type DoorService struct {
+	counterCollection *mongo.Collection
+	eventCollection   *mongo.Collection
+	webSocketService  *WebSocketService
+	mutex             sync.Mutex
+	pending           map[primitive.ObjectID]bool // events with door stats about to be sent
+}
+
+func NewDoorService(webSocketService *WebSocketService) *DoorService {
+	return &DoorService{
+		counterCollection: utils.GetCollection("door_counters"),
+		eventCollection:   utils.GetCollection("events"),
+		webSocketService:  webSocketService,
+		pending:           make(map[primitive.ObjectID]bool),
+	}
+}
+
+// NormalizeEntrance tidies the entrance a scan was made at
+func NormalizeEntrance(entrance string) string {
+	entrance = strings.TrimSpace(entrance)
+	if entrance == "" {
+		return DefaultEntrance
+	}
+	if len([]rune(entrance)) > maxEntranceLength {
+		entrance = string([]rune(entrance)[:maxEntranceLength])
+	}
+	return entrance
+}
+
+// RecordScan counts a scan and streams it to the event's followers. Door
+// stats follow at most every doorStatsInterval, so a busy gate sends one
+// update for many scans.
+func (ds *DoorService) RecordScan(ctx context.Context, eventID primitive.ObjectID, scan *TicketScan) error {
+	scan.Entrance = NormalizeEntrance(scan.Entrance)
+	_, err := ds.counterCollection.UpdateOne(
+		ctx,
+		bson.M{"event_id": eventID, "entrance": scan.Entrance, "minute": scan.ScannedAt.Truncate(time.Minute)},
+		bson.M{"$inc": bson.M{scan.Result: 1}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to count scan: %w", err)
+	}
+
+	ds.webSocketService.BroadcastTicketScan(scan)
+	ds.schedule(eventID)
+	return nil
+}
+
+// schedule sends an event's door stats after doorStatsInterval, unless they
+// are already about to be sent
+func (ds *DoorService) schedule(eventID primitive.ObjectID) {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+	if ds.pending[eventID] {
+		return
+	}
+	ds.pending[eventID] = true
+
+	time.AfterFunc(doorStatsInterval, func() {
+		ds.mutex.Lock()
+		delete(ds.pending, eventID)
+		ds.mutex.Unlock()
+
+		if err := ds.publish(context.Background(), eventID); err != nil {
+			log.Printf("Failed to publish door stats for event %s: %v", eventID.Hex(), err)
+		}
+	})
+}
+
+func (ds *DoorService) publish(ctx context.Context, eventID primitive.ObjectID) error {
+	var event models.Event
+	if err := ds.eventCollection.FindOne(ctx, bson.M{"_id": eventID}).Decode(&event); err != nil {
+		return fmt.Errorf("failed to fetch event: %w", err)
+	}
+	stats, err := ds.Stats(ctx, &event, time.Now())
+	if err != nil {
+		return err
+	}
+	ds.webSocketService.BroadcastLiveEventData(stats.LiveEventData)
+	ds.webSocketService.BroadcastDoorStats(stats)
+	return nil
+}
+
+// Stats returns an event's door stats as of now
+func (ds *DoorService) Stats(ctx context.Context, event *models.Event, now time.Time) (*DoorStats, error) {
+	live, err := ds.webSocketService.EventStats(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+	stats := &DoorStats{LiveEventData: *live, Entrances: []EntranceStats{}}
+
+	// Every scan so far, by entrance
+	cursor, err := ds.counterCollection.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"event_id": event.ID}},
+		{"$group": bson.M{
+			"_id":       "$entrance",
+			"admitted":  bson.M{"$sum": "$admitted"},
+			"duplicate": bson.M{"$sum": "$duplicate"},
+			"rejected":  bson.M{"$sum": "$rejected"},
+		}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to total door scans: %w", err)
+	}
+	var totals []struct {
+		Entrance   string `bson:"_id"`
+		Admitted   int    `bson:"admitted"`
+		Duplicates int    `bson:"duplicate"`
+		Rejected   int    `bson:"rejected"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return nil, fmt.Errorf("failed to decode door scans: %w", err)
+	}
+
+	// Recent minutes, for the rates
+	windowStart := now.Truncate(time.Minute).Add(-(doorStatsMinutes - 1) * time.Minute)
+	rateStart := now.Truncate(time.Minute).Add(-(doorRateMinutes - 1) * time.Minute)
+	cursor, err = ds.counterCollection.Find(ctx, bson.M{"event_id": event.ID, "minute": bson.M{"$gte": windowStart}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch recent door scans: %w", err)
+	}
+	var recent []doorCounter
+	if err := cursor.All(ctx, &recent); err != nil {
+		return nil, fmt.Errorf("failed to decode recent door scans: %w", err)
+	}
+
+	stats.Minutes = make([]DoorMinute, doorStatsMinutes)
+	for i := range stats.Minutes {
+		stats.Minutes[i].Minute = windowStart.Add(time.Duration(i) * time.Minute)
+	}
+	recentScans := make(map[string]int)
+	for i := range recent {
+		counter := &recent[i]
+		index := int(counter.Minute.Sub(windowStart) / time.Minute)
+		if index < 0 || index >= doorStatsMinutes {
+			continue
+		}
+		stats.Minutes[index].Admitted += counter.Admitted
+		stats.Minutes[index].Duplicates += counter.Duplicates
+		stats.Minutes[index].Rejected += counter.Rejected
+		if !counter.Minute.Before(rateStart) {
+			recentScans[counter.Entrance] += counter.scans()
+		}
+	}
+
+	for _, total := range totals {
+		stats.Duplicates += total.Duplicates
+		stats.Rejected += total.Rejected
+		stats.ScansPerMinute += float64(recentScans[total.Entrance]) / doorRateMinutes
+		stats.Entrances = append(stats.Entrances, EntranceStats{
+			Entrance:       total.Entrance,
+			Admitted:       total.Admitted,
+			Duplicates:     total.Duplicates,
+			Rejected:       total.Rejected,
+			ScansPerMinute: float64(recentScans[total.Entrance]) / doorRateMinutes,
+		})
+	}
+	sort.Slice(stats.Entrances, func(i, j int) bool { return stats.Entrances[i].Entrance < stats.Entrances[j].Entrance })
+	return stats, nil
+}
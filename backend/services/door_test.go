@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"eventticketing/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestNormalizeEntrance(t *testing.T) {
+	cases := map[string]string{
+		"":                      DefaultEntrance,
+		"   ":                   DefaultEntrance,
+		" Gate B ":              "Gate B",
+		strings.Repeat("é", 60): strings.Repeat("é", maxEntranceLength),
+	}
+	for entrance, want := range cases {
+		if got := NormalizeEntrance(entrance); got != want {
+			t.Errorf("NormalizeEntrance(%q) = %q, want %q", entrance, got, want)
+		}
+	}
+}
+
+func TestDoorStats(t *testing.T) {
+	setupInventoryTest(t)
+	ctx := context.Background()
+	eventID := insertTestEvent(t, 100)
+	event := loadTestEvent(t, eventID)
+
+	ws := newWebSocketService(NewMemoryBroker(), 16)
+	ds := NewDoorService(ws)
+
+	now := time.Now()
+	scans := []struct {
+		entrance string
+		result   string
+		at       time.Time
+	}{
+		{"", ScanAdmitted, now},
+		{"Main", ScanAdmitted, now.Add(-time.Minute)},
+		{"Gate B", ScanAdmitted, now},
+		{"Gate B", ScanDuplicate, now},
+		{"Gate B", ScanRejected, now.Add(-10 * time.Minute)},
+		{"Gate B", ScanAdmitted, now.Add(-time.Hour)}, // before the window
+	}
+	for _, scan := range scans {
+		err := ds.RecordScan(ctx, eventID, &TicketScan{
+			EventID:    eventID.Hex(),
+			TicketCode: primitive.NewObjectID().Hex(),
+			Entrance:   scan.entrance,
+			Result:     scan.result,
+			ScannedAt:  scan.at,
+		})
+		if err != nil {
+			t.Fatalf("failed to record scan: %v", err)
+		}
+	}
+
+	stats, err := ds.Stats(ctx, &event, now)
+	if err != nil {
+		t.Fatalf("failed to fetch door stats: %v", err)
+	}
+	if stats.Duplicates != 1 || stats.Rejected != 1 {
+		t.Errorf("expected 1 duplicate and 1 rejected, got %d and %d", stats.Duplicates, stats.Rejected)
+	}
+	// Four scans in the last five minutes
+	if stats.ScansPerMinute != 4.0/doorRateMinutes {
+		t.Errorf("expected %v scans a minute, got %v", 4.0/doorRateMinutes, stats.ScansPerMinute)
+	}
+
+	if len(stats.Minutes) != doorStatsMinutes {
+		t.Fatalf("expected %d minutes, got %d", doorStatsMinutes, len(stats.Minutes))
+	}
+	last := stats.Minutes[doorStatsMinutes-1]
+	if !last.Minute.Equal(now.Truncate(time.Minute)) || last.Admitted != 2 || last.Duplicates != 1 {
+		t.Errorf("unexpected current minute %+v", last)
+	}
+	if stats.Minutes[doorStatsMinutes-11].Rejected != 1 {
+		t.Errorf("expected the rejection ten minutes ago, got %+v", stats.Minutes[doorStatsMinutes-11])
+	}
+
+	want := []EntranceStats{
+		{Entrance: "Gate B", Admitted: 2, Duplicates: 1, Rejected: 1, ScansPerMinute: 2.0 / doorRateMinutes},
+		{Entrance: "Main", Admitted: 2, ScansPerMinute: 2.0 / doorRateMinutes},
+	}
+	if len(stats.Entrances) != len(want) {
+		t.Fatalf("expected %d entrances, got %+v", len(want), stats.Entrances)
+	}
+	for i := range want {
+		if stats.Entrances[i] != want[i] {
+			t.Errorf("expected %+v, got %+v", want[i], stats.Entrances[i])
+		}
+	}
+}
+
+func TestDoorStatsStream(t *testing.T) {
+	setupInventoryTest(t)
+	ctx := context.Background()
+	eventID := insertTestEvent(t, 100)
+
+	ws := NewWebSocketService()
+	ds := NewDoorService(ws)
+	url := startWebSocketTest(t, ws)
+	admin := dialWebSocket(t, url, &models.User{ID: primitive.NewObjectID(), Role: "admin"})
+	admin.WriteJSON(map[string]string{"type": "subscribe_event", "event_id": eventID.Hex()})
+	if msg := readWebSocket(t, admin); msg["type"] != "subscribed" {
+		t.Fatalf("expected to follow the event, got %v", msg)
+	}
+
+	// Each scan is sent at once; the stats once for both
+	for i := 0; i < 2; i++ {
+		scan := &TicketScan{EventID: eventID.Hex(), TicketCode: "CODE", Result: ScanAdmitted, ScannedAt: time.Now()}
+		if err := ds.RecordScan(ctx, eventID, scan); err != nil {
+			t.Fatalf("failed to record scan: %v", err)
+		}
+	}
+	for _, want := range []string{"ticket_scan", "ticket_scan", "live_event_update", "door_stats"} {
+		if msg := readWebSocket(t, admin); msg["type"] != want {
+			t.Fatalf("expected %s, got %v", want, msg)
+		}
+	}
+	admin.SetReadDeadline(time.Now().Add(2 * doorStatsInterval))
+	if _, _, err := admin.ReadMessage(); err == nil {
+		t.Error("expected the stats to be sent once for both scans")
+	}
+}
@@ -0,0 +1,375 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"net/mail"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"eventticketing/config"
+	"eventticketing/i18n"
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrEmailDisabled is returned when email is switched off in the settings
+var ErrEmailDisabled = errors.New("email is disabled")
+
+// Email is a message with plain-text and HTML bodies. The HTML refers to
+// inline images by their content ID, as cid:<id>.
+type Email struct {
+	To          string
+	ToName      string
+	Subject     string
+	Text        string
+	HTML        string
+	Inline      []EmailAttachment
+	Attachments []EmailAttachment
+}
+
+// EmailAttachment is a file sent with an email
+type EmailAttachment struct {
+	Filename    string
+	ContentType string
+	ContentID   string // for inline images
+	Data        []byte
+}
+
+// EmailService sends customers their tickets and tells them about
+// cancellations, refunds and changes to their events. Emails are written
+// from the templates in templates/email, in English.
+type EmailService struct {
+	from             mail.Address
+	transport        *SMTPTransport
+	userCollection   *mongo.Collection
+	eventCollection  *mongo.Collection
+	ticketCollection *mongo.Collection
+	qrService        *QRService
+}
+
+func NewEmailService() *EmailService {
+	return &EmailService{
+		from:             mail.Address{Name: config.AppConfig.Email.FromName, Address: config.AppConfig.Email.From},
+		transport:        NewSMTPTransport(),
+		userCollection:   utils.GetCollection("users"),
+		eventCollection:  utils.GetCollection("events"),
+		ticketCollection: utils.GetCollection("tickets"),
+		qrService:        NewQRService(),
+	}
+}
+
+// Send composes an email and hands it to the SMTP server
+func (es *EmailService) Send(ctx context.Context, email *Email) error {
+	if !CurrentSettings().EnableEmail {
+		return ErrEmailDisabled
+	}
+
+	message, err := composeEmail(es.from, email)
+	if err != nil {
+		return fmt.Errorf("failed to compose email: %w", err)
+	}
+	return es.transport.Send(ctx, es.from.Address, []string{email.To}, message)
+}
+
+// SendOrderConfirmation emails the buyer of a paid order their tickets, with
+// each QR code in the message and all of them in an attached PDF
+func (es *EmailService) SendOrderConfirmation(ctx context.Context, payment *models.Payment, event *models.Event, tickets []models.Ticket) error {
+	user, ok, err := es.recipient(ctx, payment.UserID)
+	if !ok {
+		return err
+	}
+
+	data := es.newEmailData(user, event)
+	data.Total = models.FormatAmount(payment.Amount, payment.Currency)
+	inline := make([]EmailAttachment, 0, len(tickets))
+	for i := range tickets {
+		ticket := &tickets[i]
+		png, err := es.qrService.GenerateQRCodePNG(ticket.TicketCode)
+		if err != nil {
+			return err
+		}
+		contentID := "qr-" + ticket.TicketCode + "@" + emailDomain(es.from.Address)
+		inline = append(inline, EmailAttachment{
+			Filename:    ticket.TicketCode + ".png",
+			ContentType: "image/png",
+			ContentID:   contentID,
+			Data:        png,
+		})
+		data.Tickets = append(data.Tickets, emailTicket{
+			Code:  ticket.TicketCode,
+			Type:  ticket.TicketTypeName,
+			Seats: strings.Join(ticket.SeatLabels(), "; "),
+			Price: models.FormatAmount(ticket.Price, payment.Currency),
+			QR:    htmltemplate.URL("cid:" + contentID),
+		})
+	}
+
+	pdf, err := TicketPDF(event, tickets)
+	if err != nil {
+		return err
+	}
+
+	email, err := renderEmail("order_confirmed", user, data)
+	if err != nil {
+		return err
+	}
+	email.Inline = inline
+	email.Attachments = []EmailAttachment{{Filename: "tickets.pdf", ContentType: "application/pdf", Data: pdf}}
+	return es.Send(ctx, email)
+}
+
+// SendCancellationNotice tells a ticket's holder it was cancelled, and about
+// the refund requested for it, if any
+func (es *EmailService) SendCancellationNotice(ctx context.Context, ticket *models.Ticket, refund *models.Refund) error {
+	user, ok, err := es.recipient(ctx, ticket.UserID)
+	if !ok {
+		return err
+	}
+	event, err := es.event(ctx, ticket.EventID)
+	if err != nil {
+		return err
+	}
+
+	data := es.newEmailData(user, event)
+	data.Tickets = []emailTicket{{Code: ticket.TicketCode}}
+	if refund != nil && refund.Status != "failed" {
+		data.Refund = models.FormatAmount(refund.Amount, refund.Currency)
+	}
+
+	email, err := renderEmail("ticket_cancelled", user, data)
+	if err != nil {
+		return err
+	}
+	return es.Send(ctx, email)
+}
+
+// SendRefundNotice tells a buyer their refund has been paid
+func (es *EmailService) SendRefundNotice(ctx context.Context, refund *models.Refund) error {
+	user, ok, err := es.recipient(ctx, refund.UserID)
+	if !ok {
+		return err
+	}
+
+	var tickets []models.Ticket
+	cursor, err := es.ticketCollection.Find(ctx, bson.M{"_id": bson.M{"$in": refund.TicketIDs}})
+	if err != nil {
+		return fmt.Errorf("failed to fetch tickets: %w", err)
+	}
+	if err := cursor.All(ctx, &tickets); err != nil {
+		return fmt.Errorf("failed to decode tickets: %w", err)
+	}
+	if len(tickets) == 0 {
+		return nil
+	}
+	event, err := es.event(ctx, tickets[0].EventID)
+	if err != nil {
+		return err
+	}
+
+	data := es.newEmailData(user, event)
+	data.Refund = models.FormatAmount(refund.Amount, refund.Currency)
+	data.Reason = refund.Reason
+	for _, ticket := range tickets {
+		data.Tickets = append(data.Tickets, emailTicket{Code: ticket.TicketCode})
+	}
+
+	email, err := renderEmail("refund_completed", user, data)
+	if err != nil {
+		return err
+	}
+	return es.Send(ctx, email)
+}
+
+// SendEventChangeNotice emails everyone holding a paid ticket for an event
+// when its title, date or location changed or it was cancelled. It returns
+// how many emails were sent; one failing does not stop the rest.
+func (es *EmailService) SendEventChangeNotice(ctx context.Context, before, after *models.Event) (int, error) {
+	if !CurrentSettings().EnableEmail {
+		return 0, nil
+	}
+	changes := eventChanges(before, after)
+	cancelled := after.Status == "cancelled" && before.Status != "cancelled"
+	if len(changes) == 0 && !cancelled {
+		return 0, nil
+	}
+
+	userIDs, err := es.ticketCollection.Distinct(ctx, "user_id", bson.M{"event_id": after.ID, "status": "paid"})
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch ticket holders: %w", err)
+	}
+	if len(userIDs) == 0 {
+		return 0, nil
+	}
+	var users []models.User
+	cursor, err := es.userCollection.Find(ctx, bson.M{"_id": bson.M{"$in": userIDs}, "email": bson.M{"$gt": ""}})
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch ticket holders: %w", err)
+	}
+	if err := cursor.All(ctx, &users); err != nil {
+		return 0, fmt.Errorf("failed to decode ticket holders: %w", err)
+	}
+
+	sent := 0
+	var firstErr error
+	for i := range users {
+		data := es.newEmailData(&users[i], after)
+		data.Changes = changes
+		data.Cancelled = cancelled
+		email, err := renderEmail("event_changed", &users[i], data)
+		if err == nil {
+			err = es.Send(ctx, email)
+		}
+		if err != nil {
+			log.Printf("Failed to email %s about changes to event %s: %v", users[i].Email, after.ID.Hex(), err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		sent++
+	}
+	return sent, firstErr
+}
+
+// recipient fetches the user to email. It reports false, with no error, when
+// email is off or the user has no address, such as USSD-only accounts.
+func (es *EmailService) recipient(ctx context.Context, userID primitive.ObjectID) (*models.User, bool, error) {
+	if !CurrentSettings().EnableEmail {
+		return nil, false, nil
+	}
+	var user models.User
+	if err := es.userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		return nil, false, fmt.Errorf("failed to fetch user: %w", err)
+	}
+	if user.Email == "" {
+		return nil, false, nil
+	}
+	return &user, true, nil
+}
+
+func (es *EmailService) event(ctx context.Context, eventID primitive.ObjectID) (*models.Event, error) {
+	var event models.Event
+	if err := es.eventCollection.FindOne(ctx, bson.M{"_id": eventID}).Decode(&event); err != nil {
+		return nil, fmt.Errorf("failed to fetch event: %w", err)
+	}
+	return &event, nil
+}
+
+func (es *EmailService) newEmailData(user *models.User, event *models.Event) *emailData {
+	return &emailData{Brand: es.from.Name, Name: user.Name, Event: event}
+}
+
+// emailData is what the email templates are given
+type emailData struct {
+	Brand     string
+	Subject   string
+	Name      string
+	Event     *models.Event
+	Tickets   []emailTicket
+	Total     string
+	Refund    string // amount refunded, when there is a refund
+	Reason    string
+	Changes   []emailChange
+	Cancelled bool
+}
+
+type emailTicket struct {
+	Code  string
+	Type  string
+	Seats string
+	Price string
+	QR    htmltemplate.URL // source of the QR code image
+}
+
+type emailChange struct {
+	Field  string
+	Before string
+	After  string
+}
+
+// eventChanges lists the changes to an event its ticket holders need to know about
+func eventChanges(before, after *models.Event) []emailChange {
+	var changes []emailChange
+	if after.Title != before.Title {
+		changes = append(changes, emailChange{"Event", before.Title, after.Title})
+	}
+	if !after.Date.Equal(before.Date) {
+		changes = append(changes, emailChange{"Date", formatEmailDate(before.Date), formatEmailDate(after.Date)})
+	}
+	if after.Location != before.Location {
+		changes = append(changes, emailChange{"Location", before.Location, after.Location})
+	}
+	return changes
+}
+
+func formatEmailDate(t time.Time) string {
+	return i18n.Date(i18n.DefaultLanguage, t)
+}
+
+//go:embed templates/email
+var emailTemplateFiles embed.FS
+
+// emailTemplateNames are the emails there are templates for. Each has a
+// .txt template, which also defines the subject, and an .html template
+// that fills in layout.html.
+var emailTemplateNames = []string{"order_confirmed", "ticket_cancelled", "refund_completed", "event_changed"}
+
+type emailTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+var emailTemplates = loadEmailTemplates()
+
+func loadEmailTemplates() map[string]*emailTemplate {
+	funcs := map[string]interface{}{"date": formatEmailDate}
+	templates := make(map[string]*emailTemplate, len(emailTemplateNames))
+	for _, name := range emailTemplateNames {
+		templates[name] = &emailTemplate{
+			text: texttemplate.Must(texttemplate.New(name+".txt").Funcs(funcs).
+				ParseFS(emailTemplateFiles, "templates/email/"+name+".txt")),
+			html: htmltemplate.Must(htmltemplate.New(name+".html").Funcs(funcs).
+				ParseFS(emailTemplateFiles, "templates/email/layout.html", "templates/email/"+name+".html")),
+		}
+	}
+	return templates
+}
+
+// renderEmail writes the named email to a user
+func renderEmail(name string, user *models.User, data *emailData) (*Email, error) {
+	tmpl, ok := emailTemplates[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown email template %q", name)
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := tmpl.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, fmt.Errorf("failed to render %s subject: %w", name, err)
+	}
+	data.Subject = strings.TrimSpace(subject.String())
+	if err := tmpl.text.Execute(&text, data); err != nil {
+		return nil, fmt.Errorf("failed to render %s email: %w", name, err)
+	}
+	if err := tmpl.html.ExecuteTemplate(&html, "layout", data); err != nil {
+		return nil, fmt.Errorf("failed to render %s email: %w", name, err)
+	}
+
+	return &Email{
+		To:      user.Email,
+		ToName:  user.Name,
+		Subject: data.Subject,
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
@@ -0,0 +1,395 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"eventticketing/config"
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeSMTPServer is a local SMTP server that accepts every message and keeps it
+type fakeSMTPServer struct {
+	listener net.Listener
+
+	mu   sync.Mutex
+	mail []fakeSMTPMessage
+}
+
+type fakeSMTPMessage struct {
+	From string
+	To   []string
+	Data string
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := &fakeSMTPServer{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return server
+}
+
+// Port is the port the server listens on
+func (s *fakeSMTPServer) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 fake ESMTP")
+
+	var message fakeSMTPMessage
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			text.PrintfLine("250-fake\r\n250 8BITMIME")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			message = fakeSMTPMessage{From: smtpPath(line)}
+			text.PrintfLine("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			message.To = append(message.To, smtpPath(line))
+			text.PrintfLine("250 OK")
+		case command == "DATA":
+			text.PrintfLine("354 Go ahead")
+			data, err := io.ReadAll(text.DotReader())
+			if err != nil {
+				return
+			}
+			message.Data = string(data)
+			s.mu.Lock()
+			s.mail = append(s.mail, message)
+			s.mu.Unlock()
+			text.PrintfLine("250 Queued")
+		case command == "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("250 OK")
+		}
+	}
+}
+
+// smtpPath is the address in a MAIL FROM or RCPT TO command
+func smtpPath(line string) string {
+	start, end := strings.Index(line, "<"), strings.Index(line, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return line[start+1 : end]
+}
+
+func (s *fakeSMTPServer) messages() []fakeSMTPMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]fakeSMTPMessage(nil), s.mail...)
+}
+
+// useFakeSMTPServer points email at a fake SMTP server
+func useFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	t.Helper()
+	server := newFakeSMTPServer(t)
+	if config.AppConfig == nil {
+		config.AppConfig = &config.Config{}
+	}
+	config.AppConfig.Email = config.EmailConfig{
+		SMTPHost: "127.0.0.1",
+		SMTPPort: server.Port(),
+		From:     "tickets@example.com",
+		FromName: "EventTix",
+	}
+	return server
+}
+
+// emailPart is a leaf of a MIME message
+type emailPart struct {
+	ContentType string
+	Disposition string
+	ContentID   string
+	Body        string
+}
+
+// readEmail parses a message into its headers and leaf parts, decoded
+func readEmail(t *testing.T, data string) (mail.Header, []emailPart) {
+	t.Helper()
+	message, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to read email: %v", err)
+	}
+	var parts []emailPart
+	var walk func(contentType string, body io.Reader)
+	walk = func(contentType string, body io.Reader) {
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			t.Fatalf("bad content type %q: %v", contentType, err)
+		}
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to read %s part: %v", mediaType, err)
+			}
+			partType := part.Header.Get("Content-Type")
+			if strings.HasPrefix(partType, "multipart/") {
+				walk(partType, part)
+				continue
+			}
+			var content io.Reader = part
+			if part.Header.Get("Content-Transfer-Encoding") == "base64" {
+				content = base64.NewDecoder(base64.StdEncoding, part)
+			}
+			b, _ := io.ReadAll(content)
+			parts = append(parts, emailPart{
+				ContentType: partType,
+				Disposition: part.Header.Get("Content-Disposition"),
+				ContentID:   part.Header.Get("Content-ID"),
+				Body:        string(b),
+			})
+		}
+	}
+	walk(message.Header.Get("Content-Type"), message.Body)
+	return message.Header, parts
+}
+
+func TestComposeEmail(t *testing.T) {
+	email := &Email{
+		To:      "ama@example.com",
+		ToName:  "Ama Mensah",
+		Subject: "Your tickets for Fête de la Musique",
+		Text:    "Hi Ama,\n\nSee you there.\n",
+		HTML:    `<p>Hi Ama,</p><img src="cid:qr-ABC@example.com">`,
+		Inline:  []EmailAttachment{{Filename: "ABC.png", ContentType: "image/png", ContentID: "qr-ABC@example.com", Data: []byte("png")}},
+		Attachments: []EmailAttachment{{Filename: "tickets.pdf", ContentType: "application/pdf",
+			Data: []byte(strings.Repeat("%PDF-1.4 ", 40))}},
+	}
+	message, err := composeEmail(mail.Address{Name: "EventTix", Address: "tickets@example.com"}, email)
+	if err != nil {
+		t.Fatalf("failed to compose email: %v", err)
+	}
+
+	header, parts := readEmail(t, string(message))
+	subject, _ := new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+	if subject != email.Subject {
+		t.Errorf("expected subject %q, got %q", email.Subject, subject)
+	}
+	if to, _ := header.AddressList("To"); len(to) != 1 || to[0].Address != email.To || to[0].Name != email.ToName {
+		t.Errorf("unexpected recipient %v", to)
+	}
+	if !strings.HasSuffix(header.Get("Message-ID"), "@example.com>") {
+		t.Errorf("unexpected message ID %q", header.Get("Message-ID"))
+	}
+
+	if len(parts) != 4 {
+		t.Fatalf("expected text, HTML, image and PDF parts, got %+v", parts)
+	}
+	if !strings.HasPrefix(parts[0].ContentType, "text/plain") || parts[0].Body != strings.ReplaceAll(email.Text, "\n", "\r\n") {
+		t.Errorf("unexpected text part %+v", parts[0])
+	}
+	if !strings.HasPrefix(parts[1].ContentType, "text/html") || parts[1].Body != email.HTML {
+		t.Errorf("unexpected HTML part %+v", parts[1])
+	}
+	if parts[2].ContentID != "<qr-ABC@example.com>" || !strings.HasPrefix(parts[2].Disposition, "inline") || parts[2].Body != "png" {
+		t.Errorf("unexpected inline image %+v", parts[2])
+	}
+	if parts[3].ContentType != "application/pdf" || parts[3].Disposition != `attachment; filename=tickets.pdf` ||
+		parts[3].Body != string(email.Attachments[0].Data) {
+		t.Errorf("unexpected attachment %+v", parts[3])
+	}
+}
+
+func TestEmailTemplates(t *testing.T) {
+	user := &models.User{Name: "Ama", Email: "ama@example.com"}
+	event := &models.Event{Title: "Rock & <Roll>", Location: "Accra", Date: time.Date(2026, 12, 31, 20, 0, 0, 0, time.UTC)}
+	tickets := []emailTicket{{Code: "TKT123", Type: "VIP", Seats: "A1", Price: "GHS 100.00", QR: "cid:qr-TKT123@example.com"}}
+
+	cases := map[string]*emailData{
+		"order_confirmed":  {Event: event, Tickets: tickets, Total: "GHS 100.00"},
+		"ticket_cancelled": {Event: event, Tickets: tickets, Refund: "GHS 100.00"},
+		"refund_completed": {Event: event, Tickets: tickets, Refund: "GHS 100.00", Reason: "Ticket cancelled"},
+		"event_changed":    {Event: event, Changes: []emailChange{{"Location", "Kumasi", "Accra"}}},
+	}
+	subjects := map[string]string{
+		"order_confirmed":  "Your tickets for Rock & <Roll>",
+		"ticket_cancelled": "Ticket TKT123 cancelled",
+		"refund_completed": "Your refund of GHS 100.00 has been paid",
+		"event_changed":    "Changes to Rock & <Roll>",
+	}
+	for name, data := range cases {
+		data.Name = user.Name
+		email, err := renderEmail(name, user, data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if email.Subject != subjects[name] || email.To != user.Email {
+			t.Errorf("%s: unexpected subject %q", name, email.Subject)
+		}
+		if !strings.HasPrefix(email.Text, "Hi Ama,") || !strings.Contains(email.Text, "Rock & <Roll>") {
+			t.Errorf("%s: unexpected text %q", name, email.Text)
+		}
+		if !strings.Contains(email.HTML, "Rock &amp; &lt;Roll&gt;") || strings.Contains(email.HTML, "<Roll>") {
+			t.Errorf("%s: expected the title escaped in HTML", name)
+		}
+	}
+
+	email, _ := renderEmail("order_confirmed", user, cases["order_confirmed"])
+	if !strings.Contains(email.HTML, `src="cid:qr-TKT123@example.com"`) {
+		t.Error("expected the QR code to be shown inline")
+	}
+	if !strings.Contains(email.Text, "Dec 31, 2026 20:00") {
+		t.Errorf("expected the event date in the text, got %q", email.Text)
+	}
+
+	cancelled, _ := renderEmail("event_changed", user, &emailData{Name: "Ama", Event: event, Cancelled: true})
+	if cancelled.Subject != "Cancelled: Rock & <Roll>" {
+		t.Errorf("unexpected subject %q", cancelled.Subject)
+	}
+}
+
+func TestSMTPTransport(t *testing.T) {
+	server := useFakeSMTPServer(t)
+
+	err := NewSMTPTransport().Send(context.Background(), "tickets@example.com", []string{"ama@example.com"}, []byte("Subject: Hi\r\n\r\nHello\r\n"))
+	if err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+	received := server.messages()
+	if len(received) != 1 || received[0].From != "tickets@example.com" || received[0].To[0] != "ama@example.com" ||
+		!strings.Contains(received[0].Data, "Hello") {
+		t.Errorf("unexpected mail at the server: %+v", received)
+	}
+
+	config.AppConfig.Email.SMTPPort = 1
+	if err := NewSMTPTransport().Send(context.Background(), "tickets@example.com", []string{"ama@example.com"}, nil); err == nil {
+		t.Error("expected an error with no server to talk to")
+	}
+}
+
+func insertEmailUser(t *testing.T, email string) primitive.ObjectID {
+	t.Helper()
+	user := models.User{Name: "Ama Mensah", Email: email, Phone: "+2332" + primitive.NewObjectID().Hex()[16:], Role: "user", IsActive: true, CreatedAt: time.Now()}
+	result, err := utils.GetCollection("users").InsertOne(context.Background(), user)
+	if err != nil {
+		t.Fatalf("failed to insert user: %v", err)
+	}
+	return result.InsertedID.(primitive.ObjectID)
+}
+
+func TestOrderConfirmationEmail(t *testing.T) {
+	setupInventoryTest(t)
+	server := useFakeSMTPServer(t)
+	config.AppConfig.Features.EnableEmail = true
+	ctx := context.Background()
+	es := NewEmailService()
+
+	event := loadTestEvent(t, insertTestEvent(t, 10))
+	userID := insertEmailUser(t, "ama@example.com")
+	tickets := []models.Ticket{
+		{UserID: userID, EventID: event.ID, TicketCode: models.GenerateTicketCode(), Status: "paid", Price: 1000, Quantity: 1},
+		{UserID: userID, EventID: event.ID, TicketCode: models.GenerateTicketCode(), Status: "paid", Price: 1000, Quantity: 1},
+	}
+	payment := &models.Payment{UserID: userID, EventID: event.ID, Amount: 2000, Currency: "GHS"}
+
+	if err := es.SendOrderConfirmation(ctx, payment, &event, tickets); err != nil {
+		t.Fatalf("failed to send confirmation: %v", err)
+	}
+	received := server.messages()
+	if len(received) != 1 || received[0].To[0] != "ama@example.com" {
+		t.Fatalf("expected one email to the buyer, got %+v", received)
+	}
+	_, parts := readEmail(t, received[0].Data)
+	if len(parts) != 5 {
+		t.Fatalf("expected text, HTML, two QR codes and a PDF, got %d parts", len(parts))
+	}
+	if !strings.Contains(parts[0].Body, tickets[0].TicketCode) || !strings.Contains(parts[0].Body, "GHS 20.00") {
+		t.Errorf("expected the ticket codes and total in the text, got %q", parts[0].Body)
+	}
+	if parts[2].ContentType != "image/png" || !strings.Contains(parts[1].Body, "cid:"+strings.Trim(parts[2].ContentID, "<>")) {
+		t.Error("expected the HTML to show the inline QR codes")
+	}
+	if pdf := parts[4]; pdf.ContentType != "application/pdf" || !strings.HasPrefix(pdf.Body, "%PDF-") || !strings.Contains(pdf.Body, "/Count 2") {
+		t.Errorf("expected a two-page ticket PDF attached, got %s", pdf.ContentType)
+	}
+
+	// USSD-only buyers have no address
+	ussdBuyer, _ := NewAccountService().FindOrCreateByPhone(ctx, "+233200000031")
+	payment.UserID = ussdBuyer.ID
+	if err := es.SendOrderConfirmation(ctx, payment, &event, tickets); err != nil {
+		t.Errorf("expected buyers without an email address to be skipped, got %v", err)
+	}
+
+	config.AppConfig.Features.EnableEmail = false
+	liveSettings.Store(nil)
+	payment.UserID = userID
+	es.SendOrderConfirmation(ctx, payment, &event, tickets)
+	if len(server.messages()) != 1 {
+		t.Error("expected no email with email switched off")
+	}
+}
+
+func TestEventChangeNotice(t *testing.T) {
+	setupInventoryTest(t)
+	server := useFakeSMTPServer(t)
+	config.AppConfig.Features.EnableEmail = true
+	ctx := context.Background()
+	es := NewEmailService()
+
+	before := loadTestEvent(t, insertTestEvent(t, 10))
+	holder := insertEmailUser(t, "holder@example.com")
+	insertPaidTicket(t, holder, before.ID)
+	insertPaidTicket(t, holder, before.ID)
+	cancelledID := insertPaidTicket(t, insertEmailUser(t, "gone@example.com"), before.ID)
+	utils.GetCollection("tickets").UpdateByID(ctx, cancelledID, bson.M{"$set": bson.M{"status": "cancelled"}})
+	ussdBuyer, _ := NewAccountService().FindOrCreateByPhone(ctx, "+233200000032")
+	insertPaidTicket(t, ussdBuyer.ID, before.ID)
+
+	after := before
+	after.Description = "Now with more music"
+	if sent, err := es.SendEventChangeNotice(ctx, &before, &after); sent != 0 || err != nil {
+		t.Errorf("expected no email for a new description, got %d, %v", sent, err)
+	}
+
+	after.Location = "Accra Sports Stadium"
+	sent, err := es.SendEventChangeNotice(ctx, &before, &after)
+	if sent != 1 || err != nil {
+		t.Fatalf("expected one email, to the holder of paid tickets, got %d, %v", sent, err)
+	}
+	received := server.messages()
+	if len(received) != 1 || received[0].To[0] != "holder@example.com" || !strings.Contains(received[0].Data, "Accra Sports Stadium") {
+		t.Errorf("unexpected email %+v", received)
+	}
+}
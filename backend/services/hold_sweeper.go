@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// holdSweepInterval is how often expired holds are looked for
+const holdSweepInterval = 30 * time.Second
+
+// HoldSweeper expires pending orders whose hold has lapsed and returns their inventory
+type HoldSweeper struct {
+	orderCollection *mongo.Collection
+	orderService    *OrderService
+}
+
+func NewHoldSweeper() *HoldSweeper {
+	return &HoldSweeper{
+		orderCollection: utils.GetCollection("orders"),
+		orderService:    NewOrderService(),
+	}
+}
+
+// Start runs the sweeper until ctx is cancelled
+func (hs *HoldSweeper) Start(ctx context.Context) {
+	ticker := time.NewTicker(holdSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		if released, err := hs.Sweep(ctx); err != nil {
+			log.Printf("Hold sweep failed: %v", err)
+		} else if released > 0 {
+			log.Printf("Released %d expired ticket holds", released)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep expires every lapsed order once and returns how many were released.
+// Each order is claimed with a conditional update, so concurrent sweepers on
+// other instances never release the same hold twice. Tickets left held by
+// orders that were closed part way are released as well.
+func (hs *HoldSweeper) Sweep(ctx context.Context) (int, error) {
+	filter := bson.M{
+		"status":     "pending",
+		"expires_at": bson.M{"$lte": time.Now()},
+	}
+	opts := options.Find().SetLimit(500).SetSort(bson.M{"expires_at": 1})
+
+	cursor, err := hs.orderCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find expired holds: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var orders []models.Order
+	if err := cursor.All(ctx, &orders); err != nil {
+		return 0, fmt.Errorf("failed to decode expired holds: %w", err)
+	}
+
+	released := 0
+	for _, order := range orders {
+		expired, err := hs.orderService.ClosePending(ctx, order.ID, "expired")
+		if err != nil {
+			log.Printf("Failed to release expired order %s: %v", order.ID.Hex(), err)
+		}
+		if expired {
+			released++
+		}
+	}
+
+	// Finish releases that closing an order left undone
+	if n, err := hs.orderService.FinishReleases(ctx); err != nil {
+		log.Printf("Failed to finish releasing closed orders' tickets: %v", err)
+	} else if n > 0 {
+		log.Printf("Released %d tickets left held by closed orders", n)
+	}
+
+	return released, nil
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSweepReleasesOnlyExpiredHolds(t *testing.T) {
+	inventory := setupInventoryTest(t)
+	sweeper := NewHoldSweeper()
+	eventID := insertTestEvent(t, 10)
+	ctx := context.Background()
+
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+	holds := []struct {
+		quantity  int
+		expiresAt time.Time
+	}{
+		{2, past},
+		{3, future},
+	}
+
+	var orders []*models.Order
+	for _, hold := range holds {
+		reservation, err := inventory.Reserve(ctx, eventID, primitive.NilObjectID, hold.quantity)
+		if err != nil {
+			t.Fatalf("failed to reserve: %v", err)
+		}
+		order, _, err := reservation.PlaceOrder(ctx, primitive.NewObjectID(), nil)
+		if err != nil {
+			t.Fatalf("failed to place order: %v", err)
+		}
+		payment := models.Payment{EventID: eventID, OrderID: order.ID, Status: "pending"}
+		if err := reservation.InsertPayment(ctx, &payment); err != nil {
+			t.Fatalf("failed to insert payment: %v", err)
+		}
+		reservation.Commit()
+
+		utils.GetCollection("orders").UpdateOne(ctx, bson.M{"_id": order.ID}, bson.M{"$set": bson.M{"expires_at": hold.expiresAt}})
+		orders = append(orders, order)
+	}
+
+	released, err := sweeper.Sweep(ctx)
+	if err != nil {
+		t.Fatalf("sweep failed: %v", err)
+	}
+	if released != 1 {
+		t.Fatalf("expected 1 released hold, got %d", released)
+	}
+
+	// A second sweep must not release the same hold again
+	if released, _ := sweeper.Sweep(ctx); released != 0 {
+		t.Errorf("expected second sweep to release nothing, got %d", released)
+	}
+
+	if sold := loadTestEvent(t, eventID).SoldTickets; sold != 3 {
+		t.Errorf("expected 3 tickets still held, got %d", sold)
+	}
+
+	expired, _ := utils.GetCollection("tickets").CountDocuments(ctx, bson.M{"order_id": orders[0].ID, "status": "expired"})
+	if expired != 2 {
+		t.Errorf("expected both lapsed tickets to be expired, got %d", expired)
+	}
+	var payment models.Payment
+	utils.GetCollection("payments").FindOne(ctx, bson.M{"order_id": orders[0].ID}).Decode(&payment)
+	if payment.Status != "cancelled" {
+		t.Errorf("expected lapsed payment to be cancelled, got %s", payment.Status)
+	}
+}
+
+func TestSweepFinishesPartialReleases(t *testing.T) {
+	inventory := setupInventoryTest(t)
+	sweeper := NewHoldSweeper()
+	eventID := insertTestEvent(t, 10)
+	ctx := context.Background()
+
+	var orders []*models.Order
+	for i := 0; i < 2; i++ {
+		reservation, err := inventory.Reserve(ctx, eventID, primitive.NilObjectID, 2)
+		if err != nil {
+			t.Fatalf("failed to reserve: %v", err)
+		}
+		order, _, err := reservation.PlaceOrder(ctx, primitive.NewObjectID(), nil)
+		if err != nil {
+			t.Fatalf("failed to place order: %v", err)
+		}
+		reservation.Commit()
+		orders = append(orders, order)
+	}
+	tickets := utils.GetCollection("tickets")
+
+	// The first order was closed but its tickets were never touched
+	utils.GetCollection("orders").UpdateOne(ctx, bson.M{"_id": orders[0].ID}, bson.M{"$set": bson.M{"status": "cancelled"}})
+	tickets.UpdateMany(ctx, bson.M{"order_id": orders[0].ID}, bson.M{"$set": bson.M{"expires_at": time.Now().Add(-time.Minute)}})
+
+	// The second order's tickets were closed but their inventory never returned
+	utils.GetCollection("orders").UpdateOne(ctx, bson.M{"_id": orders[1].ID}, bson.M{"$set": bson.M{"status": "expired"}})
+	tickets.UpdateMany(ctx, bson.M{"order_id": orders[1].ID}, bson.M{"$set": bson.M{"status": "expired", "release_due": true}})
+
+	if _, err := sweeper.Sweep(ctx); err != nil {
+		t.Fatalf("sweep failed: %v", err)
+	}
+	if sold := loadTestEvent(t, eventID).SoldTickets; sold != 0 {
+		t.Errorf("expected every held ticket to be returned, %d still sold", sold)
+	}
+	if n, _ := tickets.CountDocuments(ctx, bson.M{"order_id": orders[0].ID, "status": "cancelled"}); n != 2 {
+		t.Errorf("expected the closed order's tickets to be cancelled, got %d", n)
+	}
+	if n, _ := tickets.CountDocuments(ctx, bson.M{"release_due": true}); n != 0 {
+		t.Errorf("expected no ticket left to release, got %d", n)
+	}
+
+	// Nothing is released twice
+	utils.GetCollection("events").UpdateOne(ctx, bson.M{"_id": eventID}, bson.M{"$set": bson.M{"sold_tickets": 4}})
+	if _, err := sweeper.Sweep(ctx); err != nil {
+		t.Fatalf("sweep failed: %v", err)
+	}
+	if sold := loadTestEvent(t, eventID).SoldTickets; sold != 4 {
+		t.Errorf("expected a second sweep to release nothing, got %d sold", sold)
+	}
+}
@@ -0,0 +1,501 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrEventNotFound is returned when the event being reserved does not exist
+	ErrEventNotFound = errors.New("event not found")
+	// ErrTicketsUnavailable is returned when the event cannot accommodate the requested quantity
+	ErrTicketsUnavailable = errors.New("not enough tickets available")
+	// ErrInvalidQuantity is returned when fewer than one ticket is requested
+	ErrInvalidQuantity = errors.New("quantity must be at least 1")
+	// ErrTicketTypeRequired is returned when an event sold by tier is bought without naming one
+	ErrTicketTypeRequired = errors.New("ticket type is required for this event")
+	// ErrTicketTypeNotFound is returned when the requested tier does not belong to the event
+	ErrTicketTypeNotFound = errors.New("ticket type not found")
+	// ErrTicketTypeNotOnSale is returned outside a tier's sale window
+	ErrTicketTypeNotOnSale = errors.New("ticket type is not on sale")
+	// ErrOrderLimitExceeded is returned when the quantity exceeds a tier's per-order limit
+	ErrOrderLimitExceeded = errors.New("quantity exceeds the per-order limit")
+)
+
+// IsUnavailable reports whether a reservation failed because the tickets can
+// no longer be had, as when they sold out, the event closed or the tier went
+// off sale, rather than for a reason that retrying could get past
+func IsUnavailable(err error) bool {
+	for _, unavailable := range []error{
+		ErrEventNotFound,
+		ErrTicketsUnavailable,
+		ErrInvalidQuantity,
+		ErrTicketTypeRequired,
+		ErrTicketTypeNotFound,
+		ErrTicketTypeNotOnSale,
+		ErrOrderLimitExceeded,
+		ErrSeatUnavailable,
+	} {
+		if errors.Is(err, unavailable) {
+			return true
+		}
+	}
+	return false
+}
+
+// InventoryService is the single path through which ticket inventory is claimed and released
+type InventoryService struct {
+	eventCollection   *mongo.Collection
+	orderCollection   *mongo.Collection
+	ticketCollection  *mongo.Collection
+	paymentCollection *mongo.Collection
+	seatingService    *SeatingService
+	qrService         *QRService
+}
+
+// Reservation holds claimed inventory together with the documents and seat locks
+// created against it. Until Commit is called, Rollback deletes those documents,
+// frees the seats and returns the inventory.
+type Reservation struct {
+	Event      *models.Event
+	TicketType *models.TicketType
+	Quantity   int
+	// AdmissionID is the queue admission the order is placed with, if any;
+	// an admission places one order
+	AdmissionID string
+
+	inventory  *InventoryService
+	orderIDs   []primitive.ObjectID
+	ticketIDs  []primitive.ObjectID
+	paymentIDs []primitive.ObjectID
+	seatedIDs  []primitive.ObjectID
+	done       bool
+}
+
+func NewInventoryService() *InventoryService {
+	return &InventoryService{
+		eventCollection:   utils.GetCollection("events"),
+		orderCollection:   utils.GetCollection("orders"),
+		ticketCollection:  utils.GetCollection("tickets"),
+		paymentCollection: utils.GetCollection("payments"),
+		seatingService:    NewSeatingService(),
+		qrService:         NewQRService(),
+	}
+}
+
+// Reserve claims quantity tickets for an event, from the given ticket type when
+// the event is sold by tier. The availability check and the sold counters'
+// increment happen in one conditional update, so concurrent buyers can never
+// push an event or tier past its capacity.
+func (is *InventoryService) Reserve(ctx context.Context, eventID, ticketTypeID primitive.ObjectID, quantity int) (*Reservation, error) {
+	if quantity < 1 {
+		return nil, ErrInvalidQuantity
+	}
+
+	eventCapacity := bson.M{
+		"$lte": bson.A{bson.M{"$add": bson.A{"$sold_tickets", quantity}}, "$max_tickets"},
+	}
+	filter := bson.M{"_id": eventID, "status": "active"}
+	inc := bson.M{"sold_tickets": quantity}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	if ticketTypeID.IsZero() {
+		// Events sold by tier must be bought through one of them
+		filter["ticket_types.0"] = bson.M{"$exists": false}
+		filter["$expr"] = eventCapacity
+	} else {
+		// Sale windows and order limits are organizer settings rather than
+		// contended state, so they are checked up front
+		if err := is.checkTicketType(ctx, eventID, ticketTypeID, quantity); err != nil {
+			return nil, err
+		}
+
+		tierCapacity := bson.M{"$gt": bson.A{
+			bson.M{"$size": bson.M{"$filter": bson.M{
+				"input": "$ticket_types",
+				"as":    "tt",
+				"cond": bson.M{"$and": bson.A{
+					bson.M{"$eq": bson.A{"$$tt._id", ticketTypeID}},
+					bson.M{"$lte": bson.A{bson.M{"$add": bson.A{"$$tt.sold", quantity}}, "$$tt.capacity"}},
+				}},
+			}}},
+			0,
+		}}
+		filter["$expr"] = bson.M{"$and": bson.A{eventCapacity, tierCapacity}}
+		inc["ticket_types.$[tt].sold"] = quantity
+		opts.SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"tt._id": ticketTypeID}}})
+	}
+
+	var event models.Event
+	err := is.eventCollection.FindOneAndUpdate(ctx, filter, bson.M{"$inc": inc}, opts).Decode(&event)
+	if err == mongo.ErrNoDocuments {
+		return nil, is.explainReserveFailure(ctx, eventID, ticketTypeID, quantity)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve tickets: %w", err)
+	}
+
+	return &Reservation{
+		Event:      &event,
+		TicketType: event.FindTicketType(ticketTypeID),
+		Quantity:   quantity,
+		inventory:  is,
+	}, nil
+}
+
+// checkTicketType validates that a tier exists, is on sale and allows the quantity
+func (is *InventoryService) checkTicketType(ctx context.Context, eventID, ticketTypeID primitive.ObjectID, quantity int) error {
+	var event models.Event
+	err := is.eventCollection.FindOne(ctx, bson.M{"_id": eventID}).Decode(&event)
+	if err == mongo.ErrNoDocuments {
+		return ErrEventNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check event: %w", err)
+	}
+	return validateTicketType(&event, ticketTypeID, quantity)
+}
+
+// explainReserveFailure works out why a conditional reservation matched no event
+func (is *InventoryService) explainReserveFailure(ctx context.Context, eventID, ticketTypeID primitive.ObjectID, quantity int) error {
+	if err := is.checkTicketType(ctx, eventID, ticketTypeID, quantity); err != nil {
+		return err
+	}
+	// The event exists and the tier is valid, so it is sold out or not on sale
+	return ErrTicketsUnavailable
+}
+
+func validateTicketType(event *models.Event, ticketTypeID primitive.ObjectID, quantity int) error {
+	if ticketTypeID.IsZero() {
+		if event.HasTicketTypes() {
+			return ErrTicketTypeRequired
+		}
+		return nil
+	}
+
+	ticketType := event.FindTicketType(ticketTypeID)
+	if ticketType == nil {
+		return ErrTicketTypeNotFound
+	}
+	if !ticketType.IsOnSale(time.Now()) {
+		return ErrTicketTypeNotOnSale
+	}
+	if !ticketType.AllowsQuantity(quantity) {
+		return ErrOrderLimitExceeded
+	}
+	return nil
+}
+
+// HoldExpiry returns when a pending ticket created now should give its inventory back
+func (is *InventoryService) HoldExpiry() *time.Time {
+	expiresAt := time.Now().Add(CurrentSettings().TicketHoldDuration())
+	return &expiresAt
+}
+
+// Release returns quantity tickets to an event's available inventory, and to
+// the ticket type's when one is given
+func (is *InventoryService) Release(ctx context.Context, eventID, ticketTypeID primitive.ObjectID, quantity int) error {
+	if quantity < 1 {
+		return ErrInvalidQuantity
+	}
+
+	// Guard against double releases driving sold counts below zero
+	filter := bson.M{"_id": eventID, "sold_tickets": bson.M{"$gte": quantity}}
+	inc := bson.M{"sold_tickets": -quantity}
+	opts := options.Update()
+	if !ticketTypeID.IsZero() {
+		filter["ticket_types"] = bson.M{"$elemMatch": bson.M{"_id": ticketTypeID, "sold": bson.M{"$gte": quantity}}}
+		inc["ticket_types.$[tt].sold"] = -quantity
+		opts.SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"tt._id": ticketTypeID}}})
+	}
+
+	_, err := is.eventCollection.UpdateOne(ctx, filter, bson.M{"$inc": inc}, opts)
+	if err != nil {
+		return fmt.Errorf("failed to release tickets: %w", err)
+	}
+	return nil
+}
+
+// ReleaseTicket returns a ticket's quantity to its event and ticket type, and frees its seats
+func (is *InventoryService) ReleaseTicket(ctx context.Context, ticket *models.Ticket) error {
+	var errs []error
+	if len(ticket.Seats) > 0 {
+		if err := is.seatingService.ReleaseSeats(ctx, ticket.ID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := is.Release(ctx, ticket.EventID, ticket.TicketTypeID, ticket.Quantity); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// ConfirmSeats turns paid tickets' seat holds into permanent assignments,
+// taking seats back if a hold lapsed and nobody else has claimed them
+func (is *InventoryService) ConfirmSeats(ctx context.Context, tickets []models.Ticket) error {
+	for _, ticket := range tickets {
+		if len(ticket.Seats) == 0 {
+			continue
+		}
+		err := is.seatingService.ClaimSeats(ctx, ticket.EventID, ticket.ID, ticket.UserID, ticket.Seats, models.SeatSold, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnitPrice returns the price of one ticket under this reservation, at the
+// event's dynamic price when it has one
+func (r *Reservation) UnitPrice() int64 {
+	if r.TicketType != nil {
+		return r.Event.LivePrice(r.TicketType.Price)
+	}
+	return r.Event.LivePrice(r.Event.Price)
+}
+
+// NewOrder builds a pending order for the reserved tier and quantity
+func (r *Reservation) NewOrder(userID primitive.ObjectID) models.Order {
+	order := models.Order{
+		OrderNumber: models.GenerateOrderNumber(),
+		UserID:      userID,
+		EventID:     r.Event.ID,
+		Quantity:    r.Quantity,
+		UnitPrice:   r.UnitPrice(),
+		TotalAmount: r.UnitPrice() * int64(r.Quantity),
+		Currency:    r.Event.Currency,
+		Status:      "pending",
+		AdmissionID: r.AdmissionID,
+		ExpiresAt:   r.inventory.HoldExpiry(),
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if r.TicketType != nil {
+		order.TicketTypeID = r.TicketType.ID
+		order.TicketTypeName = r.TicketType.Name
+	}
+	return order
+}
+
+// NewTickets builds one pending ticket per admission of an order, each with its own code and QR
+func (r *Reservation) NewTickets(order *models.Order) ([]models.Ticket, error) {
+	tickets := make([]models.Ticket, 0, order.Quantity)
+	for i := 0; i < order.Quantity; i++ {
+		ticket := models.Ticket{
+			ID:             primitive.NewObjectID(),
+			EventID:        order.EventID,
+			UserID:         order.UserID,
+			OrderID:        order.ID,
+			TicketTypeID:   order.TicketTypeID,
+			TicketTypeName: order.TicketTypeName,
+			TicketCode:     models.GenerateTicketCode(),
+			Status:         "pending",
+			ExpiresAt:      order.ExpiresAt,
+			Price:          order.UnitPrice,
+			Quantity:       1,
+			CreatedAt:      time.Now(),
+			UpdatedAt:      time.Now(),
+		}
+
+		if CurrentSettings().EnableQR {
+			qrCode, err := r.inventory.qrService.GenerateQRCode(ticket.TicketCode)
+			if err != nil {
+				return nil, err
+			}
+			ticket.QRCode = qrCode
+		}
+
+		tickets = append(tickets, ticket)
+	}
+	return tickets, nil
+}
+
+// AssignSeats holds one seat per ticket of a reserved-seating event until the
+// tickets' hold expires. Without a selection the best available seats are
+// picked. General admission events accept no selection and are left alone.
+func (r *Reservation) AssignSeats(ctx context.Context, tickets []models.Ticket, seatIDs []string) error {
+	if !r.Event.HasReservedSeating() {
+		if len(seatIDs) > 0 {
+			return ErrSeatingNotAvailable
+		}
+		return nil
+	}
+
+	seating := r.inventory.seatingService
+	venue, err := seating.LoadVenue(ctx, r.Event.VenueID)
+	if err != nil {
+		return err
+	}
+
+	if len(seatIDs) > 0 {
+		seats, err := seating.ResolveSeats(venue, seatIDs, len(tickets))
+		if err != nil {
+			return err
+		}
+		return r.claimTicketSeats(ctx, tickets, seats)
+	}
+
+	// Automatically picked seats can be taken between reading and locking them
+	for attempt := 0; attempt < bestAvailableAttempts; attempt++ {
+		seats, err := seating.BestAvailable(ctx, r.Event, venue, len(tickets))
+		if err != nil {
+			return err
+		}
+		err = r.claimTicketSeats(ctx, tickets, seats)
+		if !errors.Is(err, ErrSeatUnavailable) {
+			return err
+		}
+	}
+	return ErrSeatUnavailable
+}
+
+// PlaceOrder creates the order and its tickets for the reservation, holding
+// seats for reserved-seating events
+func (r *Reservation) PlaceOrder(ctx context.Context, userID primitive.ObjectID, seatIDs []string) (*models.Order, []models.Ticket, error) {
+	order := r.NewOrder(userID)
+	if err := r.InsertOrder(ctx, &order); err != nil {
+		return nil, nil, err
+	}
+
+	tickets, err := r.NewTickets(&order)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := r.AssignSeats(ctx, tickets, seatIDs); err != nil {
+		return nil, nil, err
+	}
+	if err := r.InsertTickets(ctx, tickets); err != nil {
+		return nil, nil, err
+	}
+	return &order, tickets, nil
+}
+
+// claimTicketSeats holds seats[i] for tickets[i], releasing every seat it took if one fails
+func (r *Reservation) claimTicketSeats(ctx context.Context, tickets []models.Ticket, seats []models.TicketSeat) error {
+	seating := r.inventory.seatingService
+	for i := range tickets {
+		ticket := &tickets[i]
+		if ticket.ID.IsZero() {
+			ticket.ID = primitive.NewObjectID()
+		}
+
+		assigned := []models.TicketSeat{seats[i]}
+		if err := seating.ClaimSeats(ctx, r.Event.ID, ticket.ID, ticket.UserID, assigned, models.SeatHeld, ticket.ExpiresAt); err != nil {
+			for j := 0; j < i; j++ {
+				seating.ReleaseSeats(ctx, tickets[j].ID)
+				tickets[j].Seats = nil
+			}
+			return err
+		}
+		ticket.Seats = assigned
+	}
+
+	for _, ticket := range tickets {
+		r.seatedIDs = append(r.seatedIDs, ticket.ID)
+	}
+	return nil
+}
+
+// InsertOrder stores an order as part of the reservation. An order with an
+// admission already used by another fails with ErrAdmissionUsed.
+func (r *Reservation) InsertOrder(ctx context.Context, order *models.Order) error {
+	result, err := r.inventory.orderCollection.InsertOne(ctx, order)
+	if mongo.IsDuplicateKeyError(err) && order.AdmissionID != "" {
+		return ErrAdmissionUsed
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create order: %w", err)
+	}
+	order.ID = result.InsertedID.(primitive.ObjectID)
+	r.orderIDs = append(r.orderIDs, order.ID)
+	return nil
+}
+
+// InsertTickets stores an order's tickets as part of the reservation
+func (r *Reservation) InsertTickets(ctx context.Context, tickets []models.Ticket) error {
+	documents := make([]interface{}, 0, len(tickets))
+	for _, ticket := range tickets {
+		documents = append(documents, ticket)
+	}
+	result, err := r.inventory.ticketCollection.InsertMany(ctx, documents)
+	if err != nil {
+		// Some tickets may have been written before the failure
+		for _, ticket := range tickets {
+			r.ticketIDs = append(r.ticketIDs, ticket.ID)
+		}
+		return fmt.Errorf("failed to create tickets: %w", err)
+	}
+	for _, id := range result.InsertedIDs {
+		r.ticketIDs = append(r.ticketIDs, id.(primitive.ObjectID))
+	}
+	return nil
+}
+
+// InsertPayment stores a payment as part of the reservation
+func (r *Reservation) InsertPayment(ctx context.Context, payment *models.Payment) error {
+	result, err := r.inventory.paymentCollection.InsertOne(ctx, payment)
+	if err != nil {
+		return fmt.Errorf("failed to create payment: %w", err)
+	}
+	payment.ID = result.InsertedID.(primitive.ObjectID)
+	r.paymentIDs = append(r.paymentIDs, payment.ID)
+	return nil
+}
+
+// Commit keeps the reservation; later calls to Rollback become no-ops
+func (r *Reservation) Commit() {
+	r.done = true
+}
+
+// Rollback deletes the documents created under the reservation and releases
+// the claimed inventory. It is safe to defer and does nothing after Commit.
+func (r *Reservation) Rollback(ctx context.Context) error {
+	if r == nil || r.done {
+		return nil
+	}
+	r.done = true
+
+	var errs []error
+	if len(r.paymentIDs) > 0 {
+		_, err := r.inventory.paymentCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": r.paymentIDs}})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete payments: %w", err))
+		}
+	}
+	if len(r.ticketIDs) > 0 {
+		_, err := r.inventory.ticketCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": r.ticketIDs}})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete tickets: %w", err))
+		}
+	}
+	if len(r.orderIDs) > 0 {
+		_, err := r.inventory.orderCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": r.orderIDs}})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete orders: %w", err))
+		}
+	}
+	for _, ticketID := range r.seatedIDs {
+		if err := r.inventory.seatingService.ReleaseSeats(ctx, ticketID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	var ticketTypeID primitive.ObjectID
+	if r.TicketType != nil {
+		ticketTypeID = r.TicketType.ID
+	}
+	if err := r.inventory.Release(ctx, r.Event.ID, ticketTypeID, r.Quantity); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,385 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"eventticketing/config"
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// setupInventoryTest connects to the MongoDB named by MONGODB_TEST_URI and
+// points the service at a throwaway database that is dropped afterwards.
+func setupInventoryTest(t *testing.T) *InventoryService {
+	t.Helper()
+
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URI not set; skipping MongoDB-backed inventory tests")
+	}
+
+	config.AppConfig = &config.Config{
+		Database: config.DatabaseConfig{
+			URI: uri,
+			DB:  "eventticketing_test_" + primitive.NewObjectID().Hex(),
+		},
+	}
+	utils.ConnectDB()
+	liveSettings.Store(nil)
+	t.Cleanup(func() {
+		utils.DB.Drop(context.Background())
+		utils.DisconnectDB()
+	})
+
+	return NewInventoryService()
+}
+
+func insertTestEvent(t *testing.T, maxTickets int) primitive.ObjectID {
+	t.Helper()
+
+	event := models.Event{
+		Title:      "Concurrency Test Event",
+		Date:       time.Now().AddDate(0, 1, 0),
+		Price:      1000,
+		Currency:   "GHS",
+		MaxTickets: maxTickets,
+		Status:     "active",
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+	result, err := utils.GetCollection("events").InsertOne(context.Background(), event)
+	if err != nil {
+		t.Fatalf("failed to insert event: %v", err)
+	}
+	return result.InsertedID.(primitive.ObjectID)
+}
+
+func loadTestEvent(t *testing.T, id primitive.ObjectID) models.Event {
+	t.Helper()
+
+	var event models.Event
+	if err := utils.GetCollection("events").FindOne(context.Background(), bson.M{"_id": id}).Decode(&event); err != nil {
+		t.Fatalf("failed to load event: %v", err)
+	}
+	return event
+}
+
+func TestReserveNeverOversells(t *testing.T) {
+	inventory := setupInventoryTest(t)
+	const maxTickets, buyers = 10, 300
+	eventID := insertTestEvent(t, maxTickets)
+
+	var succeeded, soldOut int64
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < buyers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			ctx := context.Background()
+			reservation, err := inventory.Reserve(ctx, eventID, primitive.NilObjectID, 1)
+			if errors.Is(err, ErrTicketsUnavailable) {
+				atomic.AddInt64(&soldOut, 1)
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected reserve error: %v", err)
+				return
+			}
+			defer reservation.Rollback(ctx)
+
+			if _, _, err := reservation.PlaceOrder(ctx, primitive.NewObjectID(), nil); err != nil {
+				t.Errorf("failed to place order: %v", err)
+				return
+			}
+			reservation.Commit()
+			atomic.AddInt64(&succeeded, 1)
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if succeeded != maxTickets {
+		t.Errorf("expected %d successful purchases, got %d", maxTickets, succeeded)
+	}
+	if succeeded+soldOut != buyers {
+		t.Errorf("expected every buyer to succeed or be told sold out, got %d + %d", succeeded, soldOut)
+	}
+
+	event := loadTestEvent(t, eventID)
+	if event.SoldTickets != maxTickets || event.GetAvailableTickets() != 0 {
+		t.Errorf("expected event to be exactly sold out, got sold=%d max=%d", event.SoldTickets, event.MaxTickets)
+	}
+
+	tickets, err := utils.GetCollection("tickets").CountDocuments(context.Background(), bson.M{"event_id": eventID})
+	if err != nil {
+		t.Fatalf("failed to count tickets: %v", err)
+	}
+	if tickets != maxTickets {
+		t.Errorf("expected %d tickets, got %d", maxTickets, tickets)
+	}
+}
+
+func TestReserveWithFailuresNeverGoesNegative(t *testing.T) {
+	inventory := setupInventoryTest(t)
+	const maxTickets, buyers = 7, 200
+	eventID := insertTestEvent(t, maxTickets)
+
+	// Buyers ask for mixed quantities and a third of them fail after reserving,
+	// which exercises Rollback racing against fresh reservations
+	var committed int64
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < buyers; i++ {
+		quantity := rand.Intn(3) + 1
+		fail := i%3 == 0
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			ctx := context.Background()
+			reservation, err := inventory.Reserve(ctx, eventID, primitive.NilObjectID, quantity)
+			if err != nil {
+				if !errors.Is(err, ErrTicketsUnavailable) {
+					t.Errorf("unexpected reserve error: %v", err)
+				}
+				return
+			}
+			defer reservation.Rollback(ctx)
+
+			order, _, err := reservation.PlaceOrder(ctx, primitive.NewObjectID(), nil)
+			if err != nil {
+				t.Errorf("failed to place order: %v", err)
+				return
+			}
+			payment := models.Payment{EventID: eventID, OrderID: order.ID, Status: "pending"}
+			if err := reservation.InsertPayment(ctx, &payment); err != nil {
+				t.Errorf("failed to insert payment: %v", err)
+				return
+			}
+			if fail {
+				return
+			}
+			reservation.Commit()
+			atomic.AddInt64(&committed, int64(quantity))
+
+			var current models.Event
+			if err := utils.GetCollection("events").FindOne(ctx, bson.M{"_id": eventID}).Decode(&current); err != nil {
+				t.Errorf("failed to load event: %v", err)
+			} else if current.SoldTickets > current.MaxTickets {
+				t.Errorf("available tickets went negative: sold=%d max=%d", current.SoldTickets, current.MaxTickets)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	event := loadTestEvent(t, eventID)
+	if event.SoldTickets > event.MaxTickets || event.SoldTickets < 0 {
+		t.Fatalf("inventory out of bounds: sold=%d max=%d", event.SoldTickets, event.MaxTickets)
+	}
+	if int64(event.SoldTickets) != committed {
+		t.Errorf("sold_tickets %d does not match committed quantity %d", event.SoldTickets, committed)
+	}
+
+	// Only committed purchases may leave documents behind
+	pipeline := []bson.M{
+		{"$match": bson.M{"event_id": eventID}},
+		{"$group": bson.M{"_id": nil, "total": bson.M{"$sum": "$quantity"}}},
+	}
+	cursor, err := utils.GetCollection("tickets").Aggregate(context.Background(), pipeline)
+	if err != nil {
+		t.Fatalf("failed to sum tickets: %v", err)
+	}
+	var result []struct {
+		Total int64 `bson:"total"`
+	}
+	if err := cursor.All(context.Background(), &result); err != nil {
+		t.Fatalf("failed to decode ticket sum: %v", err)
+	}
+	var ticketTotal int64
+	if len(result) > 0 {
+		ticketTotal = result[0].Total
+	}
+	if ticketTotal != committed {
+		t.Errorf("expected %d ticketed admissions, got %d", committed, ticketTotal)
+	}
+}
+
+func TestReservationRollbackRemovesDocuments(t *testing.T) {
+	inventory := setupInventoryTest(t)
+	eventID := insertTestEvent(t, 5)
+	ctx := context.Background()
+
+	reservation, err := inventory.Reserve(ctx, eventID, primitive.NilObjectID, 3)
+	if err != nil {
+		t.Fatalf("failed to reserve: %v", err)
+	}
+	order, _, err := reservation.PlaceOrder(ctx, primitive.NewObjectID(), nil)
+	if err != nil {
+		t.Fatalf("failed to place order: %v", err)
+	}
+	payment := models.Payment{EventID: eventID, OrderID: order.ID, Status: "pending"}
+	if err := reservation.InsertPayment(ctx, &payment); err != nil {
+		t.Fatalf("failed to insert payment: %v", err)
+	}
+
+	if err := reservation.Rollback(ctx); err != nil {
+		t.Fatalf("rollback failed: %v", err)
+	}
+	// A second rollback must not release the inventory again
+	if err := reservation.Rollback(ctx); err != nil {
+		t.Fatalf("second rollback failed: %v", err)
+	}
+
+	if sold := loadTestEvent(t, eventID).SoldTickets; sold != 0 {
+		t.Errorf("expected sold_tickets to return to 0, got %d", sold)
+	}
+	if n, _ := utils.GetCollection("orders").CountDocuments(ctx, bson.M{"_id": order.ID}); n != 0 {
+		t.Error("expected order to be deleted on rollback")
+	}
+	if n, _ := utils.GetCollection("tickets").CountDocuments(ctx, bson.M{"order_id": order.ID}); n != 0 {
+		t.Error("expected tickets to be deleted on rollback")
+	}
+	if n, _ := utils.GetCollection("payments").CountDocuments(ctx, bson.M{"_id": payment.ID}); n != 0 {
+		t.Error("expected payment to be deleted on rollback")
+	}
+}
+
+func TestReserveRejectsUnknownAndInactiveEvents(t *testing.T) {
+	inventory := setupInventoryTest(t)
+	ctx := context.Background()
+
+	if _, err := inventory.Reserve(ctx, primitive.NewObjectID(), primitive.NilObjectID, 1); !errors.Is(err, ErrEventNotFound) {
+		t.Errorf("expected ErrEventNotFound, got %v", err)
+	}
+
+	eventID := insertTestEvent(t, 5)
+	utils.GetCollection("events").UpdateOne(ctx, bson.M{"_id": eventID}, bson.M{"$set": bson.M{"status": "cancelled"}})
+	if _, err := inventory.Reserve(ctx, eventID, primitive.NilObjectID, 1); !errors.Is(err, ErrTicketsUnavailable) {
+		t.Errorf("expected ErrTicketsUnavailable for inactive event, got %v", err)
+	}
+	if _, err := inventory.Reserve(ctx, eventID, primitive.NilObjectID, 0); !errors.Is(err, ErrInvalidQuantity) {
+		t.Errorf("expected ErrInvalidQuantity, got %v", err)
+	}
+}
+
+func insertTieredTestEvent(t *testing.T, ticketTypes ...models.TicketType) primitive.ObjectID {
+	t.Helper()
+
+	event := models.Event{
+		Title:       "Tiered Test Event",
+		Date:        time.Now().AddDate(0, 1, 0),
+		Status:      "active",
+		TicketTypes: ticketTypes,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	event.SyncTicketTypeTotals()
+	result, err := utils.GetCollection("events").InsertOne(context.Background(), event)
+	if err != nil {
+		t.Fatalf("failed to insert event: %v", err)
+	}
+	return result.InsertedID.(primitive.ObjectID)
+}
+
+func TestReserveTicketTypeNeverOversells(t *testing.T) {
+	inventory := setupInventoryTest(t)
+	vip := models.TicketType{ID: primitive.NewObjectID(), Name: "VIP", Price: 100, Capacity: 3}
+	regular := models.TicketType{ID: primitive.NewObjectID(), Name: "Regular", Price: 20, Capacity: 20}
+	eventID := insertTieredTestEvent(t, vip, regular)
+
+	var succeeded int64
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			reservation, err := inventory.Reserve(context.Background(), eventID, vip.ID, 1)
+			if errors.Is(err, ErrTicketsUnavailable) {
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected reserve error: %v", err)
+				return
+			}
+			if reservation.UnitPrice() != vip.Price {
+				t.Errorf("expected VIP price %v, got %v", vip.Price, reservation.UnitPrice())
+			}
+			reservation.Commit()
+			atomic.AddInt64(&succeeded, 1)
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if succeeded != int64(vip.Capacity) {
+		t.Errorf("expected %d VIP purchases, got %d", vip.Capacity, succeeded)
+	}
+
+	event := loadTestEvent(t, eventID)
+	if sold := event.FindTicketType(vip.ID).Sold; sold != vip.Capacity {
+		t.Errorf("expected VIP sold count %d, got %d", vip.Capacity, sold)
+	}
+	if sold := event.FindTicketType(regular.ID).Sold; sold != 0 {
+		t.Errorf("expected regular tier untouched, got sold=%d", sold)
+	}
+	if event.SoldTickets != vip.Capacity {
+		t.Errorf("expected event sold_tickets %d, got %d", vip.Capacity, event.SoldTickets)
+	}
+
+	// Regular tickets are still available even though VIP is sold out
+	if _, err := inventory.Reserve(context.Background(), eventID, regular.ID, 2); err != nil {
+		t.Errorf("expected regular tier to be available, got %v", err)
+	}
+}
+
+func TestReserveEnforcesTicketTypeRules(t *testing.T) {
+	inventory := setupInventoryTest(t)
+	ctx := context.Background()
+	saleEnded := time.Now().Add(-time.Hour)
+	limited := models.TicketType{ID: primitive.NewObjectID(), Name: "Limited", Price: 10, Capacity: 10, MaxPerOrder: 2}
+	closed := models.TicketType{ID: primitive.NewObjectID(), Name: "Early Bird", Price: 5, Capacity: 10, SaleEnd: &saleEnded}
+	eventID := insertTieredTestEvent(t, limited, closed)
+
+	if _, err := inventory.Reserve(ctx, eventID, primitive.NilObjectID, 1); !errors.Is(err, ErrTicketTypeRequired) {
+		t.Errorf("expected ErrTicketTypeRequired, got %v", err)
+	}
+	if _, err := inventory.Reserve(ctx, eventID, primitive.NewObjectID(), 1); !errors.Is(err, ErrTicketTypeNotFound) {
+		t.Errorf("expected ErrTicketTypeNotFound, got %v", err)
+	}
+	if _, err := inventory.Reserve(ctx, eventID, closed.ID, 1); !errors.Is(err, ErrTicketTypeNotOnSale) {
+		t.Errorf("expected ErrTicketTypeNotOnSale, got %v", err)
+	}
+	if _, err := inventory.Reserve(ctx, eventID, limited.ID, 3); !errors.Is(err, ErrOrderLimitExceeded) {
+		t.Errorf("expected ErrOrderLimitExceeded, got %v", err)
+	}
+
+	reservation, err := inventory.Reserve(ctx, eventID, limited.ID, 2)
+	if err != nil {
+		t.Fatalf("failed to reserve: %v", err)
+	}
+	if err := reservation.Rollback(ctx); err != nil {
+		t.Fatalf("rollback failed: %v", err)
+	}
+	event := loadTestEvent(t, eventID)
+	if sold := event.FindTicketType(limited.ID).Sold; sold != 0 {
+		t.Errorf("expected tier sold count to return to 0, got %d", sold)
+	}
+}
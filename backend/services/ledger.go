@@ -0,0 +1,561 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"eventticketing/config"
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrUnbalancedEntry is returned when a ledger entry's debits and credits differ
+	ErrUnbalancedEntry = errors.New("ledger entry does not balance")
+	// ErrPayoutNotFound is returned when a payout does not exist
+	ErrPayoutNotFound = errors.New("payout not found")
+	// ErrPayoutNotPending is returned when settling or cancelling a payout that is no longer pending
+	ErrPayoutNotPending = errors.New("payout is not pending")
+)
+
+// LedgerService keeps the double-entry ledger of what buyers paid, what the
+// platform and payment providers kept and what is owed to organizers, and
+// pays organizers out of it.
+//
+// A sale credits the organizer with the gross amount less the platform
+// commission. Provider fees are the platform's cost, paid out of its
+// commission. A refund takes back the refunded amount from the organizer and
+// returns the same share of commission; the provider's fee is not returned.
+type LedgerService struct {
+	entryCollection   *mongo.Collection
+	payoutCollection  *mongo.Collection
+	eventCollection   *mongo.Collection
+	paymentCollection *mongo.Collection
+}
+
+func NewLedgerService() *LedgerService {
+	return &LedgerService{
+		entryCollection:   utils.GetCollection("ledger_entries"),
+		payoutCollection:  utils.GetCollection("payouts"),
+		eventCollection:   utils.GetCollection("events"),
+		paymentCollection: utils.GetCollection("payments"),
+	}
+}
+
+// SaleSplit divides a payment of gross minor units into the platform's
+// commission, the provider's fee and the organizer's net, rounding each rate
+// to the nearest minor unit
+func SaleSplit(gross int64, commissionRate, feeRate float64) (commission, fee, net int64) {
+	commission = applyRate(gross, commissionRate)
+	fee = applyRate(gross, feeRate)
+	return commission, fee, gross - commission
+}
+
+// RefundCommission is the commission returned when amount of a sale of gross
+// is refunded: the same share of the commission as of the sale
+func RefundCommission(amount, gross, commission int64) int64 {
+	if gross <= 0 {
+		return 0
+	}
+	return applyRate(commission, float64(amount)/float64(gross))
+}
+
+// applyRate takes rate of amount, clamped to between nothing and all of it
+func applyRate(amount int64, rate float64) int64 {
+	share := int64(math.Round(float64(amount) * rate))
+	if share < 0 {
+		return 0
+	}
+	if share > amount {
+		return amount
+	}
+	return share
+}
+
+// RecordSale posts a successful payment to the ledger. Posting the same
+// payment again does nothing.
+func (ls *LedgerService) RecordSale(ctx context.Context, payment *models.Payment) error {
+	var event models.Event
+	if err := ls.eventCollection.FindOne(ctx, bson.M{"_id": payment.EventID}).Decode(&event); err != nil {
+		return fmt.Errorf("failed to fetch event: %w", err)
+	}
+
+	rate := CurrentSettings().CommissionRate
+	commission, fee, net := SaleSplit(payment.Amount, rate, config.AppConfig.Payment.FeeRates[payment.ProviderName()])
+
+	return ls.post(ctx, &models.LedgerEntry{
+		Key:            "sale:" + payment.ID.Hex(),
+		Type:           models.LedgerSale,
+		OrganizerID:    event.OrganizerID,
+		EventID:        event.ID,
+		PaymentID:      payment.ID,
+		Provider:       payment.ProviderName(),
+		Currency:       payment.Currency,
+		Gross:          payment.Amount,
+		Commission:     commission,
+		ProviderFee:    fee,
+		OrganizerNet:   net,
+		CommissionRate: rate,
+		Lines: []models.LedgerLine{
+			{Account: models.AccountProviderClearing, Debit: payment.Amount - fee},
+			{Account: models.AccountProviderFees, Debit: fee},
+			{Account: models.AccountPlatformCommission, Credit: commission},
+			{Account: models.AccountOrganizerPayable, Credit: net},
+		},
+		Description: "Tickets for " + event.Title,
+	})
+}
+
+// RecordRefund posts a completed refund to the ledger, taking it back from
+// the organizer less the commission it returns. Posting the same refund again
+// does nothing.
+func (ls *LedgerService) RecordRefund(ctx context.Context, refund *models.Refund) error {
+	sale, err := ls.saleEntry(ctx, refund.PaymentID)
+	if err != nil {
+		return err
+	}
+	commission := RefundCommission(refund.Amount, sale.Gross, sale.Commission)
+
+	return ls.post(ctx, &models.LedgerEntry{
+		Key:          "refund:" + refund.ID.Hex(),
+		Type:         models.LedgerRefund,
+		OrganizerID:  sale.OrganizerID,
+		EventID:      sale.EventID,
+		PaymentID:    refund.PaymentID,
+		RefundID:     refund.ID,
+		Provider:     refund.ProviderName(),
+		Currency:     refund.Currency,
+		Gross:        -refund.Amount,
+		Commission:   -commission,
+		OrganizerNet: -(refund.Amount - commission),
+		Lines: []models.LedgerLine{
+			{Account: models.AccountOrganizerPayable, Debit: refund.Amount - commission},
+			{Account: models.AccountPlatformCommission, Debit: commission},
+			{Account: models.AccountProviderClearing, Credit: refund.Amount},
+		},
+		Description: "Refund: " + refund.Reason,
+	})
+}
+
+// saleEntry returns the sale a payment posted, posting it first if it is missing
+func (ls *LedgerService) saleEntry(ctx context.Context, paymentID primitive.ObjectID) (*models.LedgerEntry, error) {
+	var entry models.LedgerEntry
+	err := ls.entryCollection.FindOne(ctx, bson.M{"key": "sale:" + paymentID.Hex()}).Decode(&entry)
+	if err == nil {
+		return &entry, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("failed to fetch sale: %w", err)
+	}
+
+	var payment models.Payment
+	if err := ls.paymentCollection.FindOne(ctx, bson.M{"_id": paymentID}).Decode(&payment); err != nil {
+		return nil, fmt.Errorf("failed to fetch payment: %w", err)
+	}
+	if err := ls.RecordSale(ctx, &payment); err != nil {
+		return nil, err
+	}
+	if err := ls.entryCollection.FindOne(ctx, bson.M{"key": "sale:" + paymentID.Hex()}).Decode(&entry); err != nil {
+		return nil, fmt.Errorf("failed to fetch sale: %w", err)
+	}
+	return &entry, nil
+}
+
+// post records an entry unless one with the same key already exists
+func (ls *LedgerService) post(ctx context.Context, entry *models.LedgerEntry) error {
+	if !entry.IsBalanced() {
+		return fmt.Errorf("%w: %s", ErrUnbalancedEntry, entry.Key)
+	}
+	lines := entry.Lines[:0]
+	for _, line := range entry.Lines {
+		if line.Debit != 0 || line.Credit != 0 {
+			lines = append(lines, line)
+		}
+	}
+	entry.Lines = lines
+	entry.CreatedAt = time.Now()
+
+	result, err := ls.entryCollection.InsertOne(ctx, entry)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to post ledger entry: %w", err)
+	}
+	entry.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// Balances sums an organizer's ledger in each currency they have sold in
+func (ls *LedgerService) Balances(ctx context.Context, organizerID primitive.ObjectID) ([]models.OrganizerBalance, error) {
+	balances := map[string]*models.OrganizerBalance{}
+	balance := func(currency string) *models.OrganizerBalance {
+		if balances[currency] == nil {
+			balances[currency] = &models.OrganizerBalance{Currency: currency}
+		}
+		return balances[currency]
+	}
+
+	cursor, err := ls.entryCollection.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"organizer_id": organizerID}},
+		{"$group": bson.M{
+			"_id":           bson.M{"currency": "$currency", "type": "$type"},
+			"gross":         bson.M{"$sum": "$gross"},
+			"commission":    bson.M{"$sum": "$commission"},
+			"organizer_net": bson.M{"$sum": "$organizer_net"},
+		}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum ledger: %w", err)
+	}
+	var totals []struct {
+		ID struct {
+			Currency string `bson:"currency"`
+			Type     string `bson:"type"`
+		} `bson:"_id"`
+		Gross        int64 `bson:"gross"`
+		Commission   int64 `bson:"commission"`
+		OrganizerNet int64 `bson:"organizer_net"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return nil, fmt.Errorf("failed to decode ledger totals: %w", err)
+	}
+	for _, total := range totals {
+		b := balance(total.ID.Currency)
+		switch total.ID.Type {
+		case models.LedgerSale:
+			b.GrossSales += total.Gross
+		case models.LedgerRefund:
+			b.Refunds -= total.Gross
+		}
+		b.Commission += total.Commission
+		b.Available += total.OrganizerNet
+	}
+
+	cursor, err = ls.payoutCollection.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"organizer_id": organizerID, "status": bson.M{"$in": []string{"pending", "settled"}}}},
+		{"$group": bson.M{
+			"_id":    bson.M{"currency": "$currency", "status": "$status"},
+			"amount": bson.M{"$sum": "$amount"},
+		}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum payouts: %w", err)
+	}
+	var payouts []struct {
+		ID struct {
+			Currency string `bson:"currency"`
+			Status   string `bson:"status"`
+		} `bson:"_id"`
+		Amount int64 `bson:"amount"`
+	}
+	if err := cursor.All(ctx, &payouts); err != nil {
+		return nil, fmt.Errorf("failed to decode payout totals: %w", err)
+	}
+	for _, payout := range payouts {
+		if payout.ID.Status == "settled" {
+			balance(payout.ID.Currency).PaidOut += payout.Amount
+		} else {
+			balance(payout.ID.Currency).PendingPayouts += payout.Amount
+		}
+	}
+
+	result := make([]models.OrganizerBalance, 0, len(balances))
+	for _, b := range balances {
+		result = append(result, *b)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Currency < result[j].Currency })
+	return result, nil
+}
+
+// Statement returns an organizer's ledger entries in currency between from
+// and to, oldest first, with their balance before from. Zero times leave
+// that end open.
+func (ls *LedgerService) Statement(ctx context.Context, organizerID primitive.ObjectID, currency string, from, to time.Time) (int64, []models.LedgerEntry, error) {
+	filter := bson.M{"organizer_id": organizerID, "currency": currency}
+
+	var opening int64
+	if !from.IsZero() {
+		var err error
+		before := bson.M{"organizer_id": organizerID, "currency": currency, "created_at": bson.M{"$lt": from}}
+		if opening, err = ls.sumNet(ctx, before); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	period := bson.M{}
+	if !from.IsZero() {
+		period["$gte"] = from
+	}
+	if !to.IsZero() {
+		period["$lt"] = to
+	}
+	if len(period) > 0 {
+		filter["created_at"] = period
+	}
+
+	cursor, err := ls.entryCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to fetch ledger entries: %w", err)
+	}
+	var entries []models.LedgerEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return 0, nil, fmt.Errorf("failed to decode ledger entries: %w", err)
+	}
+	return opening, entries, nil
+}
+
+// TrialBalance totals every account's debits and credits in each currency.
+// Across all accounts in a currency the debits equal the credits.
+func (ls *LedgerService) TrialBalance(ctx context.Context) ([]models.AccountBalance, error) {
+	cursor, err := ls.entryCollection.Aggregate(ctx, []bson.M{
+		{"$unwind": "$lines"},
+		{"$group": bson.M{
+			"_id":    bson.M{"account": "$lines.account", "currency": "$currency"},
+			"debit":  bson.M{"$sum": "$lines.debit"},
+			"credit": bson.M{"$sum": "$lines.credit"},
+		}},
+		{"$project": bson.M{"_id": 0, "account": "$_id.account", "currency": "$_id.currency", "debit": 1, "credit": 1}},
+		{"$sort": bson.D{{Key: "currency", Value: 1}, {Key: "account", Value: 1}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum ledger: %w", err)
+	}
+	var balances []models.AccountBalance
+	if err := cursor.All(ctx, &balances); err != nil {
+		return nil, fmt.Errorf("failed to decode ledger totals: %w", err)
+	}
+	return balances, nil
+}
+
+// CreatePayouts batches the positive balances of organizers into pending
+// payouts, one per organizer and currency. Organizers who already have a
+// pending payout in a currency are skipped until it is settled or cancelled.
+func (ls *LedgerService) CreatePayouts(ctx context.Context, req models.CreatePayoutBatchRequest, createdBy primitive.ObjectID) ([]models.Payout, error) {
+	match := bson.M{}
+	if req.Currency != "" {
+		match["currency"] = req.Currency
+	}
+	if len(req.OrganizerIDs) > 0 {
+		organizerIDs := make([]primitive.ObjectID, 0, len(req.OrganizerIDs))
+		for _, id := range req.OrganizerIDs {
+			organizerID, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				return nil, fmt.Errorf("invalid organizer ID %q", id)
+			}
+			organizerIDs = append(organizerIDs, organizerID)
+		}
+		match["organizer_id"] = bson.M{"$in": organizerIDs}
+	}
+
+	cursor, err := ls.entryCollection.Aggregate(ctx, []bson.M{
+		{"$match": match},
+		{"$group": bson.M{
+			"_id":       bson.M{"organizer_id": "$organizer_id", "currency": "$currency"},
+			"available": bson.M{"$sum": "$organizer_net"},
+		}},
+		{"$match": bson.M{"available": bson.M{"$gt": 0, "$gte": req.MinimumAmount}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum balances: %w", err)
+	}
+	var balances []struct {
+		ID struct {
+			OrganizerID primitive.ObjectID `bson:"organizer_id"`
+			Currency    string             `bson:"currency"`
+		} `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &balances); err != nil {
+		return nil, fmt.Errorf("failed to decode balances: %w", err)
+	}
+
+	batchID := primitive.NewObjectID()
+	payouts := []models.Payout{}
+	for _, balance := range balances {
+		payout, err := ls.createPayout(ctx, batchID, balance.ID.OrganizerID, balance.ID.Currency, req.MinimumAmount, createdBy)
+		if err != nil {
+			return payouts, err
+		}
+		if payout != nil {
+			payouts = append(payouts, *payout)
+		}
+	}
+	return payouts, nil
+}
+
+// createPayout pays out an organizer's balance in currency, returning nil if
+// there is nothing to pay or a payout is already pending
+func (ls *LedgerService) createPayout(ctx context.Context, batchID, organizerID primitive.ObjectID, currency string, minimum int64, createdBy primitive.ObjectID) (*models.Payout, error) {
+	now := time.Now()
+	payout := models.Payout{
+		ID:          primitive.NewObjectID(),
+		BatchID:     batchID,
+		OrganizerID: organizerID,
+		Currency:    currency,
+		Status:      "pending",
+		CreatedBy:   createdBy,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if _, err := ls.payoutCollection.InsertOne(ctx, payout); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to create payout: %w", err)
+	}
+
+	// Only one payout per organizer and currency can be pending, so no other
+	// batch can pay out the balance read now
+	available, err := ls.sumNet(ctx, bson.M{"organizer_id": organizerID, "currency": currency})
+	if err == nil && (available <= 0 || available < minimum) {
+		_, err = ls.payoutCollection.DeleteOne(ctx, bson.M{"_id": payout.ID, "status": "pending"})
+		return nil, err
+	}
+	if err == nil {
+		payout.Amount = available
+		_, err = ls.payoutCollection.UpdateOne(ctx, bson.M{"_id": payout.ID}, bson.M{"$set": bson.M{"amount": available}})
+	}
+	if err == nil {
+		err = ls.post(ctx, &models.LedgerEntry{
+			Key:          "payout:" + payout.ID.Hex(),
+			Type:         models.LedgerPayout,
+			OrganizerID:  organizerID,
+			PayoutID:     payout.ID,
+			Currency:     currency,
+			OrganizerNet: -available,
+			Lines: []models.LedgerLine{
+				{Account: models.AccountOrganizerPayable, Debit: available},
+				{Account: models.AccountPayoutsInTransit, Credit: available},
+			},
+			Description: "Payout",
+		})
+	}
+	if err != nil {
+		ls.payoutCollection.DeleteOne(ctx, bson.M{"_id": payout.ID, "status": "pending"})
+		return nil, err
+	}
+	return &payout, nil
+}
+
+// GetPayout fetches a payout
+func (ls *LedgerService) GetPayout(ctx context.Context, payoutID primitive.ObjectID) (*models.Payout, error) {
+	var payout models.Payout
+	err := ls.payoutCollection.FindOne(ctx, bson.M{"_id": payoutID}).Decode(&payout)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrPayoutNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch payout: %w", err)
+	}
+	return &payout, nil
+}
+
+// SettlePayout records that a pending payout was paid to the organizer,
+// with the reference of the transfer. Settling it again does nothing.
+func (ls *LedgerService) SettlePayout(ctx context.Context, payoutID primitive.ObjectID, reference, note string) (*models.Payout, error) {
+	payout, err := ls.closePayout(ctx, payoutID, "settled", bson.M{
+		"reference":  reference,
+		"note":       note,
+		"settled_at": time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return payout, ls.post(ctx, &models.LedgerEntry{
+		Key:         "payout_settled:" + payout.ID.Hex(),
+		Type:        models.LedgerPayoutSettled,
+		OrganizerID: payout.OrganizerID,
+		PayoutID:    payout.ID,
+		Currency:    payout.Currency,
+		Lines: []models.LedgerLine{
+			{Account: models.AccountPayoutsInTransit, Debit: payout.Amount},
+			{Account: models.AccountProviderClearing, Credit: payout.Amount},
+		},
+		Description: "Payout settled: " + payout.Reference,
+	})
+}
+
+// CancelPayout returns a pending payout's amount to the organizer's balance.
+// Cancelling it again does nothing.
+func (ls *LedgerService) CancelPayout(ctx context.Context, payoutID primitive.ObjectID, note string) (*models.Payout, error) {
+	payout, err := ls.closePayout(ctx, payoutID, "cancelled", bson.M{
+		"note":         note,
+		"cancelled_at": time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return payout, ls.post(ctx, &models.LedgerEntry{
+		Key:          "payout_cancelled:" + payout.ID.Hex(),
+		Type:         models.LedgerPayoutCancelled,
+		OrganizerID:  payout.OrganizerID,
+		PayoutID:     payout.ID,
+		Currency:     payout.Currency,
+		OrganizerNet: payout.Amount,
+		Lines: []models.LedgerLine{
+			{Account: models.AccountPayoutsInTransit, Debit: payout.Amount},
+			{Account: models.AccountOrganizerPayable, Credit: payout.Amount},
+		},
+		Description: "Payout cancelled",
+	})
+}
+
+// closePayout moves a pending payout to status. A payout already in status is
+// returned as it is, so its ledger entry can be posted again if that failed.
+func (ls *LedgerService) closePayout(ctx context.Context, payoutID primitive.ObjectID, status string, set bson.M) (*models.Payout, error) {
+	set["status"] = status
+	set["updated_at"] = time.Now()
+
+	var payout models.Payout
+	err := ls.payoutCollection.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": payoutID, "status": "pending", "amount": bson.M{"$gt": 0}},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&payout)
+	if err == nil {
+		return &payout, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("failed to update payout: %w", err)
+	}
+
+	existing, err := ls.GetPayout(ctx, payoutID)
+	if err != nil {
+		return nil, err
+	}
+	if existing.Status != status {
+		return nil, ErrPayoutNotPending
+	}
+	return existing, nil
+}
+
+// sumNet totals the organizer net of the entries matching filter
+func (ls *LedgerService) sumNet(ctx context.Context, filter bson.M) (int64, error) {
+	cursor, err := ls.entryCollection.Aggregate(ctx, []bson.M{
+		{"$match": filter},
+		{"$group": bson.M{"_id": nil, "net": bson.M{"$sum": "$organizer_net"}}},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to sum ledger: %w", err)
+	}
+	var totals []struct {
+		Net int64 `bson:"net"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return 0, fmt.Errorf("failed to decode ledger totals: %w", err)
+	}
+	if len(totals) == 0 {
+		return 0, nil
+	}
+	return totals[0].Net, nil
+}
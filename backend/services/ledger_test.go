@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"eventticketing/config"
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSaleSplit(t *testing.T) {
+	cases := []struct {
+		gross                 int64
+		commissionRate, fee   float64
+		commission, fees, net int64
+	}{
+		{10000, 0.05, 0.01, 500, 100, 9500},
+		{1999, 0.05, 0, 100, 0, 1899}, // 99.95 rounds to 100
+		{1000, 0, 0.015, 0, 15, 1000},
+		{1000, 1.5, 0, 1000, 0, 0}, // never more than the sale
+	}
+	for _, tc := range cases {
+		commission, fee, net := SaleSplit(tc.gross, tc.commissionRate, tc.fee)
+		if commission != tc.commission || fee != tc.fees || net != tc.net {
+			t.Errorf("split of %d at %v/%v: expected %d/%d/%d, got %d/%d/%d",
+				tc.gross, tc.commissionRate, tc.fee, tc.commission, tc.fees, tc.net, commission, fee, net)
+		}
+	}
+
+	if got := RefundCommission(2500, 10000, 500); got != 125 {
+		t.Errorf("expected a quarter refund to return 125 of commission, got %d", got)
+	}
+	if got := RefundCommission(10000, 10000, 500); got != 500 {
+		t.Errorf("expected a full refund to return all commission, got %d", got)
+	}
+}
+
+func TestLedgerPayouts(t *testing.T) {
+	setupInventoryTest(t)
+	utils.CreateIndexes()
+	config.AppConfig.Features.CommissionRate = 0.05
+	config.AppConfig.Payment.FeeRates = map[string]float64{"momo": 0.01}
+	ledger := NewLedgerService()
+	ctx := context.Background()
+
+	organizerID := primitive.NewObjectID()
+	eventID := insertTestEvent(t, 10)
+	utils.GetCollection("events").UpdateOne(ctx, bson.M{"_id": eventID}, bson.M{"$set": bson.M{"organizer_id": organizerID}})
+
+	payment := models.Payment{
+		ID:       primitive.NewObjectID(),
+		EventID:  eventID,
+		Amount:   10000,
+		Currency: "GHS",
+		Status:   "success",
+		Provider: "momo",
+	}
+	if _, err := utils.GetCollection("payments").InsertOne(ctx, payment); err != nil {
+		t.Fatalf("failed to insert payment: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := ledger.RecordSale(ctx, &payment); err != nil {
+			t.Fatalf("failed to record sale: %v", err)
+		}
+	}
+
+	refund := models.Refund{ID: primitive.NewObjectID(), PaymentID: payment.ID, Amount: 2000, Currency: "GHS", Provider: "momo"}
+	if err := ledger.RecordRefund(ctx, &refund); err != nil {
+		t.Fatalf("failed to record refund: %v", err)
+	}
+
+	balances, err := ledger.Balances(ctx, organizerID)
+	if err != nil || len(balances) != 1 {
+		t.Fatalf("expected one balance, got %+v (%v)", balances, err)
+	}
+	// 10000 less 500 commission, then 2000 refunded less 100 commission returned
+	expected := models.OrganizerBalance{Currency: "GHS", GrossSales: 10000, Refunds: 2000, Commission: 400, Available: 7600}
+	if balances[0] != expected {
+		t.Errorf("expected %+v, got %+v", expected, balances[0])
+	}
+
+	payouts, err := ledger.CreatePayouts(ctx, models.CreatePayoutBatchRequest{}, primitive.NewObjectID())
+	if err != nil || len(payouts) != 1 || payouts[0].Amount != 7600 {
+		t.Fatalf("expected one payout of 7600, got %+v (%v)", payouts, err)
+	}
+	if again, err := ledger.CreatePayouts(ctx, models.CreatePayoutBatchRequest{}, primitive.NewObjectID()); err != nil || len(again) != 0 {
+		t.Errorf("expected no second payout while one is pending, got %+v (%v)", again, err)
+	}
+
+	if _, err := ledger.SettlePayout(ctx, payouts[0].ID, "BANK-1", ""); err != nil {
+		t.Fatalf("failed to settle payout: %v", err)
+	}
+	if _, err := ledger.CancelPayout(ctx, payouts[0].ID, ""); !errors.Is(err, ErrPayoutNotPending) {
+		t.Errorf("expected a settled payout not to be cancellable, got %v", err)
+	}
+
+	balances, _ = ledger.Balances(ctx, organizerID)
+	if balances[0].Available != 0 || balances[0].PaidOut != 7600 || balances[0].PendingPayouts != 0 {
+		t.Errorf("unexpected balance after payout: %+v", balances[0])
+	}
+
+	opening, entries, err := ledger.Statement(ctx, organizerID, "GHS", time.Time{}, time.Time{})
+	if err != nil || opening != 0 || len(entries) != 4 {
+		t.Errorf("expected a statement of sale, refund, payout and settlement, got %d entries (%v)", len(entries), err)
+	}
+
+	accounts, err := ledger.TrialBalance(ctx)
+	if err != nil {
+		t.Fatalf("failed to fetch trial balance: %v", err)
+	}
+	var debits, credits int64
+	for _, account := range accounts {
+		debits += account.Debit
+		credits += account.Credit
+		if account.Account == models.AccountOrganizerPayable && account.Debit != account.Credit {
+			t.Errorf("expected nothing left owed to the organizer, got %+v", account)
+		}
+	}
+	if debits != credits {
+		t.Errorf("ledger does not balance: %d debits, %d credits", debits, credits)
+	}
+}
@@ -3,18 +3,38 @@ package services
 import (
 	"bytes"
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"eventticketing/config"
 	"eventticketing/models"
 )
 
+// CallbackTolerance is how far a callback's timestamp may be from our clock
+const CallbackTolerance = 5 * time.Minute
+
+var (
+	// ErrMoMoTransferNotFound is returned when MoMo has no transfer with a reference
+	ErrMoMoTransferNotFound = fmt.Errorf("momo transfer not found: %w", ErrProviderNotFound)
+	// ErrMoMoPaymentNotFound is returned when MoMo has no payment request with a reference
+	ErrMoMoPaymentNotFound = fmt.Errorf("momo payment not found: %w", ErrProviderNotFound)
+	// ErrMoMoRejected is returned when MoMo refuses a request outright
+	ErrMoMoRejected = fmt.Errorf("momo rejected the request: %w", ErrProviderRejected)
+	// ErrInvalidSignature is returned for callbacks that are unsigned or signed with the wrong key
+	ErrInvalidSignature = errors.New("invalid callback signature")
+	// ErrStaleCallback is returned for callbacks whose timestamp is outside CallbackTolerance
+	ErrStaleCallback = errors.New("callback timestamp outside the accepted window")
+)
+
 type MoMoService struct {
 	apiKey    string
 	apiSecret string
@@ -22,12 +42,12 @@ type MoMoService struct {
 }
 
 type MoMoRequest struct {
-	Amount      string `json:"amount"`
-	Currency    string `json:"currency"`
-	ExternalID  string `json:"externalId"`
-	Payer       Payer  `json:"payer"`
+	Amount       string `json:"amount"`
+	Currency     string `json:"currency"`
+	ExternalID   string `json:"externalId"`
+	Payer        Payer  `json:"payer"`
 	PayerMessage string `json:"payerMessage"`
-	PayeeNote   string `json:"payeeNote"`
+	PayeeNote    string `json:"payeeNote"`
 }
 
 type Payer struct {
@@ -35,12 +55,35 @@ type Payer struct {
 	PartyID     string `json:"partyId"`
 }
 
+// MoMoTransferRequest is a disbursement from our account to a payee
+type MoMoTransferRequest struct {
+	Amount       string `json:"amount"`
+	Currency     string `json:"currency"`
+	ExternalID   string `json:"externalId"`
+	Payee        Payer  `json:"payee"`
+	PayerMessage string `json:"payerMessage"`
+	PayeeNote    string `json:"payeeNote"`
+}
+
+// MoMoTransferStatus is MoMo's view of a disbursement
+type MoMoTransferStatus struct {
+	Amount                 string `json:"amount"`
+	Currency               string `json:"currency"`
+	FinancialTransactionID string `json:"financialTransactionId"`
+	ExternalID             string `json:"externalId"`
+	Status                 string `json:"status"` // PENDING, SUCCESSFUL or FAILED
+	Reason                 string `json:"reason"`
+}
+
 type MoMoResponse struct {
-	Status     string `json:"status"`
-	Message    string `json:"message"`
-	Reference  string `json:"reference"`
-	RequestID  string `json:"requestId"`
-	CollectionID string `json:"collectionId"`
+	Status                 string `json:"status"`
+	Message                string `json:"message"`
+	Reference              string `json:"reference"`
+	RequestID              string `json:"requestId"`
+	CollectionID           string `json:"collectionId"`
+	Amount                 string `json:"amount"`
+	FinancialTransactionID string `json:"financialTransactionId"`
+	Reason                 string `json:"reason"`
 }
 
 func NewMoMoService() *MoMoService {
@@ -48,6 +91,9 @@ func NewMoMoService() *MoMoService {
 	if config.AppConfig.MoMo.Environment == "production" {
 		baseURL = "https://proxy.momoapi.mtn.com"
 	}
+	if config.AppConfig.MoMo.BaseURL != "" {
+		baseURL = config.AppConfig.MoMo.BaseURL
+	}
 
 	return &MoMoService{
 		apiKey:    config.AppConfig.MoMo.APIKey,
@@ -56,16 +102,20 @@ func NewMoMoService() *MoMoService {
 	}
 }
 
-// InitiatePayment initiates a MoMo payment
+// InitiatePayment sends a request-to-pay for a payment. The payment's MoMoRef
+// is sent as the X-Reference-Id and must already be stored, so the payment can
+// be found whatever becomes of the request. MoMo accepts the request with an
+// empty 202; the outcome arrives by callback or from GetPaymentStatus.
 func (ms *MoMoService) InitiatePayment(payment *models.Payment, event *models.Event) (*MoMoResponse, error) {
-	// Generate external ID
-	externalID := fmt.Sprintf("TIX_%s_%d", payment.ID.Hex(), time.Now().Unix())
+	if payment.MoMoRef == "" {
+		return nil, errors.New("payment has no MoMo reference")
+	}
 
 	// Create MoMo request
 	momoReq := MoMoRequest{
-		Amount:      strconv.FormatFloat(payment.Amount, 'f', 2, 64),
-		Currency:    "EUR", // Change to your currency
-		ExternalID:  externalID,
+		Amount:     models.DecimalAmount(payment.Amount, payment.Currency),
+		Currency:   payment.Currency,
+		ExternalID: payment.ID.Hex(),
 		Payer: Payer{
 			PartyIDType: "MSISDN",
 			PartyID:     payment.PhoneNumber,
@@ -90,7 +140,7 @@ func (ms *MoMoService) InitiatePayment(payment *models.Payment, event *models.Ev
 	// Add headers
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ms.apiKey))
-	req.Header.Set("X-Reference-Id", externalID)
+	req.Header.Set("X-Reference-Id", payment.MoMoRef)
 	req.Header.Set("X-Target-Environment", config.AppConfig.MoMo.Environment)
 
 	// Add signature
@@ -105,16 +155,22 @@ func (ms *MoMoService) InitiatePayment(payment *models.Payment, event *models.Ev
 	}
 	defer resp.Body.Close()
 
-	// Parse response
-	var momoResp MoMoResponse
-	if err := json.NewDecoder(resp.Body).Decode(&momoResp); err != nil {
-		return nil, fmt.Errorf("failed to decode MoMo response: %w", err)
+	switch {
+	case resp.StatusCode == http.StatusAccepted || resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusConflict:
+		// A request with this reference already exists
+	case resp.StatusCode >= 500:
+		return nil, fmt.Errorf("MoMo request to pay returned status %d", resp.StatusCode)
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%w: status %d: %s", ErrMoMoRejected, resp.StatusCode, bytes.TrimSpace(body))
 	}
 
-	// Update payment with MoMo reference
-	payment.MoMoRef = externalID
-
-	return &momoResp, nil
+	return &MoMoResponse{
+		Status:    "PENDING",
+		Reference: payment.MoMoRef,
+		Amount:    momoReq.Amount,
+	}, nil
 }
 
 // generateSignature generates HMAC signature for MoMo API
@@ -124,10 +180,46 @@ func (ms *MoMoService) generateSignature(data []byte) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
+// signCallback signs a callback's timestamp, nonce and body, joined by ".",
+// so none of them can be changed without invalidating the signature
+func (ms *MoMoService) signCallback(timestamp, nonce string, body []byte) string {
+	data := make([]byte, 0, len(timestamp)+len(nonce)+len(body)+2)
+	data = append(data, timestamp...)
+	data = append(data, '.')
+	data = append(data, nonce...)
+	data = append(data, '.')
+	data = append(data, body...)
+	return ms.generateSignature(data)
+}
+
+// VerifyCallback checks a callback's X-Signature and that its X-Timestamp is
+// recent. Without an API secret nothing can be verified, so every callback is
+// rejected.
+func (ms *MoMoService) VerifyCallback(body []byte, timestamp, nonce, signature string) error {
+	if ms.apiSecret == "" || timestamp == "" || nonce == "" || signature == "" {
+		return ErrInvalidSignature
+	}
+
+	expected := ms.signCallback(timestamp, nonce, body)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) {
+		return ErrInvalidSignature
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrStaleCallback
+	}
+	age := time.Since(time.Unix(unix, 0))
+	if age > CallbackTolerance || age < -CallbackTolerance {
+		return ErrStaleCallback
+	}
+	return nil
+}
+
 // GetPaymentStatus checks the status of a MoMo payment
 func (ms *MoMoService) GetPaymentStatus(referenceID string) (*MoMoResponse, error) {
 	url := fmt.Sprintf("%s/collection/v1_0/requesttopay/%s", ms.baseURL, referenceID)
-	
+
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
@@ -145,6 +237,13 @@ func (ms *MoMoService) GetPaymentStatus(referenceID string) (*MoMoResponse, erro
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrMoMoPaymentNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("MoMo payment status returned status %d", resp.StatusCode)
+	}
+
 	// Parse response
 	var momoResp MoMoResponse
 	if err := json.NewDecoder(resp.Body).Decode(&momoResp); err != nil {
@@ -152,4 +251,103 @@ func (ms *MoMoService) GetPaymentStatus(referenceID string) (*MoMoResponse, erro
 	}
 
 	return &momoResp, nil
-} 
\ No newline at end of file
+}
+
+// Transfer asks MoMo to disburse a refund to the buyer's wallet. The refund's
+// ProviderRef is sent as the X-Reference-Id, so repeating a transfer MoMo has
+// already accepted is harmless and is treated as success.
+func (ms *MoMoService) Transfer(refund *models.Refund) error {
+	transferReq := MoMoTransferRequest{
+		Amount:     models.DecimalAmount(refund.Amount, refund.Currency),
+		Currency:   refund.Currency,
+		ExternalID: refund.ID.Hex(),
+		Payee: Payer{
+			PartyIDType: "MSISDN",
+			PartyID:     refund.PhoneNumber,
+		},
+		PayerMessage: "Ticket refund",
+		PayeeNote:    refund.Reason,
+	}
+
+	jsonData, err := json.Marshal(transferReq)
+	if err != nil {
+		return fmt.Errorf("failed to marshal MoMo transfer: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/disbursement/v1_0/transfer", ms.baseURL)
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ms.apiKey))
+	req.Header.Set("X-Reference-Id", refund.ProviderRef)
+	req.Header.Set("X-Target-Environment", config.AppConfig.MoMo.Environment)
+	req.Header.Set("X-Signature", ms.generateSignature(jsonData))
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make MoMo request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusAccepted || resp.StatusCode == http.StatusOK:
+		return nil
+	case resp.StatusCode == http.StatusConflict:
+		// A transfer with this reference already exists
+		return nil
+	case resp.StatusCode >= 500:
+		return fmt.Errorf("MoMo transfer returned status %d", resp.StatusCode)
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%w: status %d: %s", ErrMoMoRejected, resp.StatusCode, bytes.TrimSpace(body))
+	}
+}
+
+// GetTransferStatus checks the status of a MoMo disbursement
+func (ms *MoMoService) GetTransferStatus(referenceID string) (*MoMoTransferStatus, error) {
+	url := fmt.Sprintf("%s/disbursement/v1_0/transfer/%s", ms.baseURL, referenceID)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ms.apiKey))
+	req.Header.Set("X-Target-Environment", config.AppConfig.MoMo.Environment)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make MoMo request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrMoMoTransferNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("MoMo transfer status returned status %d", resp.StatusCode)
+	}
+
+	var status MoMoTransferStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("failed to decode MoMo response: %w", err)
+	}
+
+	return &status, nil
+}
+
+// NewReferenceID generates a UUID v4, the format MoMo requires for X-Reference-Id
+func NewReferenceID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to generate reference ID: %v", err))
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,256 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"eventticketing/config"
+	"eventticketing/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeMoMo is a local stand-in for the MoMo collection and disbursement APIs
+type fakeMoMo struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	payments  map[string]*MoMoResponse       // request-to-pay results by reference
+	transfers map[string]*MoMoTransferStatus // by X-Reference-Id
+	outcome   string                         // status new transfers get
+	failNext  int                            // transfer requests to answer with 500
+	reject    bool                           // answer transfer requests with 400
+	posts     int
+}
+
+func newFakeMoMo(t *testing.T) *fakeMoMo {
+	t.Helper()
+	if config.AppConfig == nil {
+		config.AppConfig = &config.Config{}
+	}
+
+	fake := &fakeMoMo{
+		payments:  make(map[string]*MoMoResponse),
+		transfers: make(map[string]*MoMoTransferStatus),
+		outcome:   "SUCCESSFUL",
+	}
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.handle))
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+func (f *fakeMoMo) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	const prefix = "/disbursement/v1_0/transfer"
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == prefix:
+		f.posts++
+		if f.failNext > 0 {
+			f.failNext--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if f.reject {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"code":"PAYEE_NOT_FOUND"}`))
+			return
+		}
+
+		ref := r.Header.Get("X-Reference-Id")
+		if _, exists := f.transfers[ref]; exists {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		var req MoMoTransferRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || ref == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.transfers[ref] = &MoMoTransferStatus{
+			Amount:                 req.Amount,
+			Currency:               req.Currency,
+			ExternalID:             req.ExternalID,
+			FinancialTransactionID: "FT-" + ref[:8],
+			Status:                 f.outcome,
+		}
+		w.WriteHeader(http.StatusAccepted)
+
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, prefix+"/"):
+		transfer, exists := f.transfers[strings.TrimPrefix(r.URL.Path, prefix+"/")]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(transfer)
+
+	case r.Method == http.MethodPost && r.URL.Path == "/collection/v1_0/requesttopay":
+		if f.reject {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"code":"PAYER_NOT_FOUND"}`))
+			return
+		}
+		ref := r.Header.Get("X-Reference-Id")
+		if _, exists := f.payments[ref]; exists {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		var req MoMoRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || ref == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.payments[ref] = &MoMoResponse{Status: "PENDING", Amount: req.Amount}
+		// MoMo accepts a request to pay with an empty body
+		w.WriteHeader(http.StatusAccepted)
+
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/collection/v1_0/requesttopay/"):
+		payment, exists := f.payments[strings.TrimPrefix(r.URL.Path, "/collection/v1_0/requesttopay/")]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(payment)
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// setPayment records what MoMo knows about a request to pay
+func (f *fakeMoMo) setPayment(reference, status, amount string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.payments[reference] = &MoMoResponse{Status: status, Amount: amount, FinancialTransactionID: "FT-" + reference}
+}
+
+// settle sets the outcome of every transfer MoMo is still working on
+func (f *fakeMoMo) settle(status string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, transfer := range f.transfers {
+		if transfer.Status == "PENDING" {
+			transfer.Status = status
+			if status == "FAILED" {
+				transfer.Reason = "PAYEE_NOT_ALLOWED_TO_RECEIVE"
+			}
+		}
+	}
+}
+
+func (f *fakeMoMo) service() *MoMoService {
+	return &MoMoService{baseURL: f.URL}
+}
+
+func TestMoMoTransferIsIdempotent(t *testing.T) {
+	fake := newFakeMoMo(t)
+	momo := fake.service()
+
+	refund := &models.Refund{ID: primitive.NewObjectID(), Amount: 1250, Currency: "EUR", PhoneNumber: "233240000000", ProviderRef: NewReferenceID()}
+	for i := 0; i < 2; i++ {
+		if err := momo.Transfer(refund); err != nil {
+			t.Fatalf("transfer %d failed: %v", i, err)
+		}
+	}
+	if len(fake.transfers) != 1 {
+		t.Fatalf("expected one transfer at MoMo, got %d", len(fake.transfers))
+	}
+
+	status, err := momo.GetTransferStatus(refund.ProviderRef)
+	if err != nil {
+		t.Fatalf("failed to get transfer status: %v", err)
+	}
+	if status.Status != "SUCCESSFUL" || status.Amount != "12.50" || status.ExternalID != refund.ID.Hex() {
+		t.Errorf("unexpected transfer status: %+v", status)
+	}
+}
+
+func TestMoMoTransferErrors(t *testing.T) {
+	fake := newFakeMoMo(t)
+	momo := fake.service()
+	refund := &models.Refund{ID: primitive.NewObjectID(), Amount: 500, Currency: "EUR", ProviderRef: NewReferenceID()}
+
+	fake.failNext = 1
+	if err := momo.Transfer(refund); err == nil || errors.Is(err, ErrMoMoRejected) {
+		t.Errorf("expected a retryable error for a 500, got %v", err)
+	}
+
+	fake.reject = true
+	if err := momo.Transfer(refund); !errors.Is(err, ErrMoMoRejected) {
+		t.Errorf("expected ErrMoMoRejected, got %v", err)
+	}
+
+	if _, err := momo.GetTransferStatus(NewReferenceID()); !errors.Is(err, ErrMoMoTransferNotFound) {
+		t.Errorf("expected ErrMoMoTransferNotFound, got %v", err)
+	}
+}
+
+func TestMoMoInitiatePaymentAcceptsEmptyReply(t *testing.T) {
+	fake := newFakeMoMo(t)
+	momo := fake.service()
+	event := &models.Event{Title: "Gala"}
+
+	payment := &models.Payment{ID: primitive.NewObjectID(), Amount: 2000, Currency: "GHS", PhoneNumber: "233240000000", MoMoRef: NewReferenceID()}
+	response, err := momo.InitiatePayment(payment, event)
+	if err != nil {
+		t.Fatalf("expected an accepted request to pay, got %v", err)
+	}
+	if response.Reference != payment.MoMoRef || fake.payments[payment.MoMoRef] == nil {
+		t.Errorf("expected the request to be sent under the payment's reference, got %+v", response)
+	}
+	if _, err := momo.InitiatePayment(payment, event); err != nil {
+		t.Errorf("expected a repeated request to be accepted, got %v", err)
+	}
+
+	fake.reject = true
+	rejected := &models.Payment{ID: primitive.NewObjectID(), Amount: 2000, Currency: "GHS", MoMoRef: NewReferenceID()}
+	if _, err := momo.InitiatePayment(rejected, event); !errors.Is(err, ErrMoMoRejected) {
+		t.Errorf("expected ErrMoMoRejected, got %v", err)
+	}
+	if _, err := momo.InitiatePayment(&models.Payment{ID: primitive.NewObjectID()}, event); err == nil {
+		t.Error("expected a payment without a reference to be refused")
+	}
+}
+
+func TestVerifyCallback(t *testing.T) {
+	momo := &MoMoService{apiSecret: "callback-secret"}
+	body := []byte(`{"status":"success","reference":"TIX_1","amount":"20.00"}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := momo.signCallback(now, "nonce-1", body)
+
+	if err := momo.VerifyCallback(body, now, "nonce-1", signature); err != nil {
+		t.Errorf("expected a valid callback, got %v", err)
+	}
+	if err := momo.VerifyCallback(body, now, "nonce-1", strings.ToUpper(signature)); err != nil {
+		t.Errorf("expected signature comparison to ignore hex case, got %v", err)
+	}
+
+	tampered := []byte(`{"status":"success","reference":"TIX_2","amount":"20.00"}`)
+	if err := momo.VerifyCallback(tampered, now, "nonce-1", signature); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected tampered body to be rejected, got %v", err)
+	}
+	if err := momo.VerifyCallback(body, now, "nonce-2", signature); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected changed nonce to be rejected, got %v", err)
+	}
+	if err := momo.VerifyCallback(body, now, "", ""); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected unsigned callback to be rejected, got %v", err)
+	}
+
+	old := strconv.FormatInt(time.Now().Add(-CallbackTolerance-time.Minute).Unix(), 10)
+	if err := momo.VerifyCallback(body, old, "nonce-1", momo.signCallback(old, "nonce-1", body)); !errors.Is(err, ErrStaleCallback) {
+		t.Errorf("expected stale callback to be rejected, got %v", err)
+	}
+
+	unkeyed := &MoMoService{}
+	if err := unkeyed.VerifyCallback(body, now, "nonce-1", unkeyed.signCallback(now, "nonce-1", body)); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected callbacks to be rejected without an API secret, got %v", err)
+	}
+}
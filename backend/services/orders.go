@@ -0,0 +1,353 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrOrderNotFound is returned when an order does not exist
+var ErrOrderNotFound = errors.New("order not found")
+
+// OrderService moves orders and their tickets through their lifecycle together
+type OrderService struct {
+	orderCollection   *mongo.Collection
+	ticketCollection  *mongo.Collection
+	paymentCollection *mongo.Collection
+	inventoryService  *InventoryService
+}
+
+func NewOrderService() *OrderService {
+	return &OrderService{
+		orderCollection:   utils.GetCollection("orders"),
+		ticketCollection:  utils.GetCollection("tickets"),
+		paymentCollection: utils.GetCollection("payments"),
+		inventoryService:  NewInventoryService(),
+	}
+}
+
+// GetOrder fetches an order
+func (ors *OrderService) GetOrder(ctx context.Context, orderID primitive.ObjectID) (*models.Order, error) {
+	var order models.Order
+	err := ors.orderCollection.FindOne(ctx, bson.M{"_id": orderID}).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch order: %w", err)
+	}
+	return &order, nil
+}
+
+// GetTickets returns the tickets of an order
+func (ors *OrderService) GetTickets(ctx context.Context, orderID primitive.ObjectID) ([]models.Ticket, error) {
+	cursor, err := ors.ticketCollection.Find(ctx, bson.M{"order_id": orderID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tickets: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var tickets []models.Ticket
+	if err := cursor.All(ctx, &tickets); err != nil {
+		return nil, fmt.Errorf("failed to decode tickets: %w", err)
+	}
+	return tickets, nil
+}
+
+// ClosePending moves a pending order and its pending tickets to status
+// (expired or cancelled), returns their inventory and cancels the pending
+// payment. It reports false if the order was no longer pending. The order is
+// closed first so that a payment confirming it at the same time cannot also
+// claim its tickets; if releasing them then fails part way, the hold sweeper
+// finishes the release.
+func (ors *OrderService) ClosePending(ctx context.Context, orderID primitive.ObjectID, status string) (bool, error) {
+	result, err := ors.orderCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": orderID, "status": "pending"},
+		bson.M{"$set": bson.M{
+			"status":     status,
+			"updated_at": time.Now(),
+		}},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to update order: %w", err)
+	}
+	if result.ModifiedCount == 0 {
+		// Paid, cancelled or expired elsewhere in the meantime
+		return false, nil
+	}
+	_, err = ors.releaseTickets(ctx, orderID, status)
+	return true, err
+}
+
+// releaseTickets moves a closed order's pending tickets to status, returns
+// their inventory and cancels its pending payment, reporting how many
+// tickets it released. Tickets are marked release_due as they close and
+// unmarked once their inventory is back, so none is lost if the release
+// stops part way.
+func (ors *OrderService) releaseTickets(ctx context.Context, orderID primitive.ObjectID, status string) (int, error) {
+	released := 0
+	var errs []error
+	_, err := ors.ticketCollection.UpdateMany(
+		ctx,
+		bson.M{"order_id": orderID, "status": "pending"},
+		bson.M{"$set": bson.M{
+			"status":      status,
+			"release_due": true,
+			"updated_at":  time.Now(),
+		}},
+	)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to update tickets: %w", err))
+	} else {
+		released, err = ors.releaseDue(ctx, bson.M{"order_id": orderID})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	_, err = ors.paymentCollection.UpdateMany(
+		ctx,
+		bson.M{"order_id": orderID, "status": "pending"},
+		bson.M{"$set": bson.M{
+			"status":     "cancelled",
+			"updated_at": time.Now(),
+		}},
+	)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to cancel payment: %w", err))
+	}
+
+	return released, errors.Join(errs...)
+}
+
+// releaseDue returns the inventory of closed tickets matching filter that
+// are still marked release_due, reporting how many it released. Each ticket
+// is claimed by clearing its mark, so no two callers release it twice, and
+// marked again if its release fails.
+func (ors *OrderService) releaseDue(ctx context.Context, filter bson.M) (int, error) {
+	closed := bson.M{"$in": []string{"expired", "cancelled"}}
+	filter["release_due"] = true
+	filter["status"] = closed
+	cursor, err := ors.ticketCollection.Find(ctx, filter, options.Find().SetLimit(500))
+	if err != nil {
+		return 0, fmt.Errorf("failed to find tickets to release: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var tickets []models.Ticket
+	if err := cursor.All(ctx, &tickets); err != nil {
+		return 0, fmt.Errorf("failed to decode tickets to release: %w", err)
+	}
+
+	released := 0
+	var errs []error
+	for _, ticket := range tickets {
+		result, err := ors.ticketCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": ticket.ID, "release_due": true, "status": closed},
+			bson.M{"$unset": bson.M{"release_due": ""}},
+		)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to update ticket %s: %w", ticket.ID.Hex(), err))
+			continue
+		}
+		if result.ModifiedCount == 0 {
+			continue
+		}
+		if err := ors.inventoryService.ReleaseTicket(ctx, &ticket); err != nil {
+			errs = append(errs, err)
+			_, err := ors.ticketCollection.UpdateOne(ctx, bson.M{"_id": ticket.ID}, bson.M{"$set": bson.M{"release_due": true}})
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to mark ticket %s for release: %w", ticket.ID.Hex(), err))
+			}
+			continue
+		}
+		released++
+	}
+	return released, errors.Join(errs...)
+}
+
+// FinishReleases returns the inventory that closing orders left held: the
+// pending tickets of orders closed before their tickets were, and closed
+// tickets whose release failed. It reports how many tickets it released.
+func (ors *OrderService) FinishReleases(ctx context.Context) (int, error) {
+	// Pending tickets outlive their order's hold only when closing it stopped part way
+	orderIDs, err := ors.ticketCollection.Distinct(ctx, "order_id", bson.M{
+		"status":     "pending",
+		"expires_at": bson.M{"$lte": time.Now()},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to find lapsed tickets: %w", err)
+	}
+
+	released := 0
+	var errs []error
+	for _, id := range orderIDs {
+		orderID, ok := id.(primitive.ObjectID)
+		if !ok {
+			continue
+		}
+		order, err := ors.GetOrder(ctx, orderID)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if order.Status != "expired" && order.Status != "cancelled" {
+			// Pending orders are the sweeper's to close
+			continue
+		}
+		n, err := ors.releaseTickets(ctx, orderID, order.Status)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		released += n
+	}
+
+	n, err := ors.releaseDue(ctx, bson.M{})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return released + n, errors.Join(errs...)
+}
+
+// Confirm marks a paid order and its tickets as paid and their seats as sold.
+// An order that was never paid but expired or was cancelled before the
+// payment arrived is reinstated if its inventory and seats can be claimed
+// again. It returns nil only once the order has been paid.
+func (ors *OrderService) Confirm(ctx context.Context, orderID primitive.ObjectID) error {
+	order, err := ors.GetOrder(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	tickets, err := ors.GetTickets(ctx, orderID)
+	if err != nil {
+		return err
+	}
+
+	if order.Status == "pending" {
+		// Seats are confirmed first so a paid ticket always owns its seats
+		if err := ors.inventoryService.ConfirmSeats(ctx, tickets); err != nil {
+			return err
+		}
+
+		confirmed, err := ors.transition(ctx, orderID, "pending")
+		if err != nil || confirmed {
+			return err
+		}
+
+		// The hold expired in the meantime
+		order, err = ors.GetOrder(ctx, orderID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if order.PaidAt != nil || (order.Status != "expired" && order.Status != "cancelled") {
+		// Already paid by an earlier callback
+		return nil
+	}
+
+	reservation, err := ors.inventoryService.Reserve(ctx, order.EventID, order.TicketTypeID, order.Quantity)
+	if err != nil {
+		return err
+	}
+	defer reservation.Rollback(ctx)
+
+	if err := ors.inventoryService.ConfirmSeats(ctx, tickets); err != nil {
+		return err
+	}
+
+	confirmed, err := ors.transition(ctx, orderID, order.Status)
+	if err != nil {
+		return err
+	}
+	if confirmed {
+		reservation.Commit()
+		return nil
+	}
+
+	// Reinstated by a concurrent confirmation, or closed again
+	order, err = ors.GetOrder(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	if order.PaidAt == nil {
+		return fmt.Errorf("order %s changed to %s while being reinstated", orderID.Hex(), order.Status)
+	}
+	return nil
+}
+
+// transition marks an order and its tickets in status "from" as paid, reporting
+// whether the order was still in that status
+func (ors *OrderService) transition(ctx context.Context, orderID primitive.ObjectID, from string) (bool, error) {
+	result, err := ors.orderCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": orderID, "status": from},
+		bson.M{"$set": bson.M{
+			"status":     "paid",
+			"paid_at":    time.Now(),
+			"updated_at": time.Now(),
+		}},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to update order: %w", err)
+	}
+	if result.ModifiedCount == 0 {
+		return false, nil
+	}
+
+	_, err = ors.ticketCollection.UpdateMany(
+		ctx,
+		bson.M{"order_id": orderID, "status": from},
+		bson.M{
+			"$set": bson.M{
+				"status":     "paid",
+				"updated_at": time.Now(),
+			},
+			"$unset": bson.M{"expires_at": ""},
+		},
+	)
+	if err != nil {
+		return true, fmt.Errorf("failed to update tickets: %w", err)
+	}
+	return true, nil
+}
+
+// SyncStatus recomputes a settled order's status after one of its tickets changed
+func (ors *OrderService) SyncStatus(ctx context.Context, orderID primitive.ObjectID) error {
+	if orderID.IsZero() {
+		return nil
+	}
+
+	tickets, err := ors.GetTickets(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	statuses := make([]string, 0, len(tickets))
+	for _, ticket := range tickets {
+		statuses = append(statuses, ticket.Status)
+	}
+
+	_, err = ors.orderCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": orderID, "status": bson.M{"$ne": "pending"}},
+		bson.M{"$set": bson.M{
+			"status":     models.StatusFromTickets(statuses),
+			"updated_at": time.Now(),
+		}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update order: %w", err)
+	}
+	return nil
+}
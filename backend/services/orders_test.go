@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestOrderIssuesOneTicketPerAdmission(t *testing.T) {
+	inventory := setupInventoryTest(t)
+	orders := NewOrderService()
+	eventID := insertTestEvent(t, 10)
+	ctx := context.Background()
+
+	reservation, err := inventory.Reserve(ctx, eventID, primitive.NilObjectID, 4)
+	if err != nil {
+		t.Fatalf("failed to reserve: %v", err)
+	}
+	order, tickets, err := reservation.PlaceOrder(ctx, primitive.NewObjectID(), nil)
+	if err != nil {
+		t.Fatalf("failed to place order: %v", err)
+	}
+	reservation.Commit()
+
+	if order.TotalAmount != 4000 || order.UnitPrice != 1000 {
+		t.Errorf("unexpected order totals: unit %v, total %v", order.UnitPrice, order.TotalAmount)
+	}
+	codes := make(map[string]bool)
+	for _, ticket := range tickets {
+		if ticket.Quantity != 1 || ticket.Price != 1000 || ticket.OrderID != order.ID {
+			t.Errorf("unexpected ticket: %+v", ticket)
+		}
+		codes[ticket.TicketCode] = true
+	}
+	if len(tickets) != 4 || len(codes) != 4 {
+		t.Fatalf("expected 4 tickets with distinct codes, got %d tickets and %d codes", len(tickets), len(codes))
+	}
+
+	if err := orders.Confirm(ctx, order.ID); err != nil {
+		t.Fatalf("failed to confirm order: %v", err)
+	}
+	if n, _ := utils.GetCollection("tickets").CountDocuments(ctx, bson.M{"order_id": order.ID, "status": "paid"}); n != 4 {
+		t.Errorf("expected all 4 tickets paid, got %d", n)
+	}
+
+	// Refunding one admission leaves the rest of the order valid
+	_, err = utils.GetCollection("tickets").UpdateOne(ctx, bson.M{"_id": tickets[0].ID}, bson.M{"$set": bson.M{"status": "refunded"}})
+	if err != nil {
+		t.Fatalf("failed to refund ticket: %v", err)
+	}
+	if err := orders.SyncStatus(ctx, order.ID); err != nil {
+		t.Fatalf("failed to sync order: %v", err)
+	}
+	updated, err := orders.GetOrder(ctx, order.ID)
+	if err != nil {
+		t.Fatalf("failed to load order: %v", err)
+	}
+	if updated.Status != "partially_refunded" {
+		t.Errorf("expected partially_refunded order, got %s", updated.Status)
+	}
+}
+
+func TestStatusFromTickets(t *testing.T) {
+	cases := []struct {
+		statuses []string
+		want     string
+	}{
+		{[]string{"pending", "pending"}, "pending"},
+		{[]string{"paid", "used"}, "paid"},
+		{[]string{"paid", "refunded"}, "partially_refunded"},
+		{[]string{"refunded", "cancelled"}, "refunded"},
+		{[]string{"expired", "expired"}, "expired"},
+		{[]string{"cancelled", "expired"}, "cancelled"},
+	}
+	for _, tc := range cases {
+		if got := models.StatusFromTickets(tc.statuses); got != tc.want {
+			t.Errorf("StatusFromTickets(%v) = %s, want %s", tc.statuses, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"eventticketing/config"
+	"eventticketing/models"
+)
+
+// Provider statuses. Each provider maps its own statuses onto these.
+const (
+	ProviderPending = "pending"
+	ProviderSuccess = "success"
+	ProviderFailed  = "failed"
+)
+
+var (
+	// ErrUnknownProvider is returned for a provider that is not registered or not enabled
+	ErrUnknownProvider = errors.New("unknown payment provider")
+	// ErrProviderNotAllowed is returned when an event does not accept the chosen provider
+	ErrProviderNotAllowed = errors.New("payment provider not accepted for this event")
+	// ErrProviderNotFound is returned when a provider has no record of a reference
+	ErrProviderNotFound = errors.New("provider has no record of the reference")
+	// ErrProviderRejected is returned when a provider refuses a request outright
+	ErrProviderRejected = errors.New("provider rejected the request")
+	// ErrNotSupported is returned for operations a provider does not offer
+	ErrNotSupported = errors.New("not supported by this payment provider")
+	// ErrMalformedCallback is returned for authentic callbacks whose body cannot be read
+	ErrMalformedCallback = errors.New("malformed callback")
+)
+
+// PaymentProvider is a way of taking payment for orders and giving money back.
+// Providers keep their reference for a payment in Payment.MoMoRef and for a
+// refund in Refund.ProviderRef.
+type PaymentProvider interface {
+	// Name is the key payments, events and the provider's callback route use
+	Name() string
+	// NewReference returns the reference to store on a payment before Initiate
+	// is called, or "" for providers that keep none
+	NewReference() string
+	// Initiate asks the buyer to pay under the reference stored on the payment
+	Initiate(ctx context.Context, payment *models.Payment, event *models.Event) (*ProviderInitiation, error)
+	// PaymentStatus asks the provider what became of a payment
+	PaymentStatus(ctx context.Context, payment *models.Payment) (*ProviderStatus, error)
+	// Refund submits a refund. Submitting the same refund again must be harmless.
+	Refund(ctx context.Context, refund *models.Refund) error
+	// RefundStatus asks the provider what became of a submitted refund
+	RefundStatus(ctx context.Context, refund *models.Refund) (*ProviderStatus, error)
+	// VerifyCallback authenticates a callback and reads the outcome it reports
+	VerifyCallback(r *http.Request, body []byte) (*ProviderCallback, error)
+}
+
+// ProviderInitiation is what a buyer needs to complete a payment
+type ProviderInitiation struct {
+	Provider     string      `json:"provider"`
+	Reference    string      `json:"reference,omitempty"`
+	Instructions string      `json:"instructions"`
+	Response     interface{} `json:"response,omitempty"` // the provider's own reply
+}
+
+// ProviderStatus is a provider's view of a payment or refund
+type ProviderStatus struct {
+	Status        string // ProviderPending, ProviderSuccess or ProviderFailed
+	RawStatus     string // as the provider reported it
+	Amount        string
+	TransactionID string
+	Reason        string
+}
+
+// ProviderCallback is the outcome a verified callback reports
+type ProviderCallback struct {
+	Reference     string
+	Status        string // ProviderPending, ProviderSuccess or ProviderFailed
+	Amount        string
+	TransactionID string
+	Nonce         string // claimed once per provider when set
+}
+
+// PaymentProviders is the set of enabled providers
+type PaymentProviders struct {
+	providers       map[string]PaymentProvider
+	defaultProvider string
+}
+
+// NewPaymentProviders registers the given providers; the first is the default
+func NewPaymentProviders(providers ...PaymentProvider) *PaymentProviders {
+	pp := &PaymentProviders{providers: make(map[string]PaymentProvider)}
+	for _, provider := range providers {
+		pp.providers[provider.Name()] = provider
+		if pp.defaultProvider == "" {
+			pp.defaultProvider = provider.Name()
+		}
+	}
+	return pp
+}
+
+// DefaultPaymentProviders returns the providers enabled in the configuration
+func DefaultPaymentProviders() *PaymentProviders {
+	available := map[string]func() PaymentProvider{
+		"momo": func() PaymentProvider { return NewMoMoProvider(NewMoMoService()) },
+		"cash": func() PaymentProvider { return NewCashProvider() },
+	}
+
+	var providers []PaymentProvider
+	for _, name := range config.AppConfig.Payment.Providers {
+		if create, ok := available[name]; ok {
+			providers = append(providers, create())
+		}
+	}
+	pp := NewPaymentProviders(providers...)
+	if _, ok := pp.providers[config.AppConfig.Payment.DefaultProvider]; ok {
+		pp.defaultProvider = config.AppConfig.Payment.DefaultProvider
+	}
+	return pp
+}
+
+// Get returns an enabled provider by name
+func (pp *PaymentProviders) Get(name string) (PaymentProvider, error) {
+	provider, ok := pp.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownProvider, name)
+	}
+	return provider, nil
+}
+
+// Names lists the enabled providers
+func (pp *PaymentProviders) Names() []string {
+	names := make([]string, 0, len(pp.providers))
+	for name := range pp.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Select picks the provider for a purchase: the one requested, else the
+// event's first choice, else the default. Events that list providers accept
+// only those.
+func (pp *PaymentProviders) Select(event *models.Event, requested string) (PaymentProvider, error) {
+	name := requested
+	if name == "" && len(event.PaymentProviders) > 0 {
+		name = event.PaymentProviders[0]
+	}
+	if name == "" {
+		name = pp.defaultProvider
+	}
+	if !event.AcceptsProvider(name) {
+		return nil, ErrProviderNotAllowed
+	}
+	return pp.Get(name)
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"eventticketing/models"
+)
+
+func TestSelectProvider(t *testing.T) {
+	providers := NewPaymentProviders(NewMoMoProvider(&MoMoService{}), NewCashProvider())
+	anyProvider := &models.Event{}
+	cashOnly := &models.Event{PaymentProviders: []string{"cash"}}
+
+	cases := []struct {
+		event     *models.Event
+		requested string
+		expected  string
+		err       error
+	}{
+		{anyProvider, "", "momo", nil},
+		{anyProvider, "cash", "cash", nil},
+		{anyProvider, "airtel", "", ErrUnknownProvider},
+		{cashOnly, "", "cash", nil},
+		{cashOnly, "momo", "", ErrProviderNotAllowed},
+	}
+	for _, tc := range cases {
+		provider, err := providers.Select(tc.event, tc.requested)
+		if tc.err != nil {
+			if !errors.Is(err, tc.err) {
+				t.Errorf("select %q for %v: expected %v, got %v", tc.requested, tc.event.PaymentProviders, tc.err, err)
+			}
+			continue
+		}
+		if err != nil || provider.Name() != tc.expected {
+			t.Errorf("select %q for %v: expected %s, got %v (%v)", tc.requested, tc.event.PaymentProviders, tc.expected, provider, err)
+		}
+	}
+}
+
+func TestMoMoProviderCallback(t *testing.T) {
+	momo := &MoMoService{apiSecret: "callback-secret"}
+	provider := NewMoMoProvider(momo)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	verify := func(body, signature string) (*ProviderCallback, error) {
+		r := httptest.NewRequest("POST", "/api/payments/callback/momo", strings.NewReader(body))
+		r.Header.Set("X-Timestamp", now)
+		r.Header.Set("X-Nonce", "nonce-1")
+		if signature == "" {
+			signature = momo.signCallback(now, "nonce-1", []byte(body))
+		}
+		r.Header.Set("X-Signature", signature)
+		return provider.VerifyCallback(r, []byte(body))
+	}
+
+	statuses := map[string]string{"success": ProviderSuccess, "pending": ProviderPending, "failed": ProviderFailed, "EXPIRED": ProviderFailed}
+	for reported, expected := range statuses {
+		callback, err := verify(`{"status":"`+reported+`","reference":"TIX_1","amount":"20.00"}`, "")
+		if err != nil {
+			t.Fatalf("expected a valid callback, got %v", err)
+		}
+		if callback.Status != expected || callback.Reference != "TIX_1" || callback.Amount != "20.00" || callback.Nonce != "nonce-1" {
+			t.Errorf("status %s: unexpected callback %+v", reported, callback)
+		}
+	}
+
+	if _, err := verify(`{"status":"success","reference":"TIX_1"}`, "deadbeef"); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected ErrInvalidSignature, got %v", err)
+	}
+	if _, err := verify(`not json`, ""); !errors.Is(err, ErrMalformedCallback) {
+		t.Errorf("expected ErrMalformedCallback, got %v", err)
+	}
+}
+
+func TestMoMoErrorsAreProviderErrors(t *testing.T) {
+	fake := newFakeMoMo(t)
+	provider := NewMoMoProvider(fake.service())
+	ctx := context.Background()
+
+	if _, err := provider.PaymentStatus(ctx, &models.Payment{MoMoRef: NewReferenceID()}); !errors.Is(err, ErrProviderNotFound) {
+		t.Errorf("expected ErrProviderNotFound for an unknown payment, got %v", err)
+	}
+	if _, err := provider.RefundStatus(ctx, &models.Refund{ProviderRef: NewReferenceID()}); !errors.Is(err, ErrProviderNotFound) {
+		t.Errorf("expected ErrProviderNotFound for an unknown transfer, got %v", err)
+	}
+
+	fake.reject = true
+	if err := provider.Refund(ctx, &models.Refund{Amount: 5, ProviderRef: NewReferenceID()}); !errors.Is(err, ErrProviderRejected) {
+		t.Errorf("expected ErrProviderRejected, got %v", err)
+	}
+
+	fake.setPayment("paid", "SUCCESSFUL", "10")
+	status, err := provider.PaymentStatus(ctx, &models.Payment{MoMoRef: "paid"})
+	if err != nil || status.Status != ProviderSuccess || status.RawStatus != "SUCCESSFUL" {
+		t.Errorf("unexpected payment status %+v (%v)", status, err)
+	}
+}
+
+func TestCashProviderHasNoRemoteSide(t *testing.T) {
+	cash := NewCashProvider()
+	ctx := context.Background()
+
+	payment := &models.Payment{Amount: 40}
+	initiation, err := cash.Initiate(ctx, payment, &models.Event{Title: "Gala"})
+	if err != nil || initiation.Instructions == "" || payment.MoMoRef != "" {
+		t.Errorf("expected box office instructions and no reference, got %+v (%v)", initiation, err)
+	}
+	if err := cash.Refund(ctx, &models.Refund{}); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("expected cash refunds to be unsupported, got %v", err)
+	}
+	if _, err := cash.VerifyCallback(httptest.NewRequest("POST", "/", nil), nil); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("expected cash callbacks to be unsupported, got %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"eventticketing/i18n"
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// PaymentService applies payment outcomes reported by the provider, whether
+// they arrive by callback or are found by reconciliation
+type PaymentService struct {
+	paymentCollection *mongo.Collection
+	eventCollection   *mongo.Collection
+	orderService      *OrderService
+	ledgerService     *LedgerService
+	smsService        *SMSService
+	emailService      *EmailService
+}
+
+func NewPaymentService() *PaymentService {
+	return &PaymentService{
+		paymentCollection: utils.GetCollection("payments"),
+		eventCollection:   utils.GetCollection("events"),
+		orderService:      NewOrderService(),
+		ledgerService:     NewLedgerService(),
+		smsService:        NewSMSService(),
+		emailService:      NewEmailService(),
+	}
+}
+
+// MarkSucceeded records that the provider took the money, confirms the order,
+// posts the sale to the ledger and sends the confirmation SMS and email once. The
+// provider's success is final, so it also overrides a payment we had
+// cancelled or failed, reinstating its order. When the tickets can no longer
+// be had, because they sold out, the event closed or the sale ended in the
+// meantime, the payment is flagged for a refund and the sale is not
+// recorded. It reports whether the payment was already successful.
+// Confirming is idempotent, so a repeat finishes a confirmation an earlier
+// attempt could not.
+func (ps *PaymentService) MarkSucceeded(ctx context.Context, payment *models.Payment) (bool, error) {
+	result, err := ps.paymentCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": payment.ID, "status": bson.M{"$in": []string{"pending", "cancelled", "failed"}}},
+		bson.M{"$set": bson.M{
+			"status":     "success",
+			"updated_at": time.Now(),
+		}},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to update payment: %w", err)
+	}
+	duplicate := result.ModifiedCount == 0
+	payment.Status = "success"
+
+	if err := ps.orderService.Confirm(ctx, payment.OrderID); err != nil {
+		if IsUnavailable(err) {
+			_, flagErr := ps.paymentCollection.UpdateOne(
+				ctx,
+				bson.M{"_id": payment.ID},
+				bson.M{"$set": bson.M{"refund_due": true, "updated_at": time.Now()}},
+			)
+			if flagErr != nil {
+				log.Printf("Failed to flag payment %s for a refund: %v", payment.ID.Hex(), flagErr)
+			}
+			payment.RefundDue = true
+		}
+		return duplicate, err
+	}
+	if err := ps.ledgerService.RecordSale(ctx, payment); err != nil {
+		return duplicate, err
+	}
+
+	// The confirmation goes out once, however often success is reported
+	notified, err := ps.paymentCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": payment.ID, "notified_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"notified_at": time.Now()}},
+	)
+	if err == nil && notified.ModifiedCount > 0 {
+		go ps.SendConfirmation(payment)
+	}
+
+	return duplicate, nil
+}
+
+// MarkFailed records that a pending payment failed and releases its order's
+// hold. A failure never undoes a success. It reports whether the payment was
+// still pending.
+func (ps *PaymentService) MarkFailed(ctx context.Context, payment *models.Payment) (bool, error) {
+	result, err := ps.paymentCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": payment.ID, "status": "pending"},
+		bson.M{"$set": bson.M{
+			"status":     "failed",
+			"updated_at": time.Now(),
+		}},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to update payment: %w", err)
+	}
+	if result.ModifiedCount == 0 {
+		return false, nil
+	}
+	payment.Status = "failed"
+
+	if _, err := ps.orderService.ClosePending(ctx, payment.OrderID, "cancelled"); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// SendConfirmation sends the SMS listing a paid order's ticket codes and
+// emails the tickets to buyers with an email address
+func (ps *PaymentService) SendConfirmation(payment *models.Payment) {
+	// Get the order's tickets
+	tickets, err := ps.orderService.GetTickets(context.Background(), payment.OrderID)
+	if err != nil || len(tickets) == 0 {
+		return
+	}
+
+	// Get event details
+	var event models.Event
+	err = ps.eventCollection.FindOne(context.Background(), bson.M{"_id": payment.EventID}).Decode(&event)
+	if err != nil {
+		return
+	}
+
+	// Send SMS
+	codes := make([]string, 0, len(tickets))
+	for i := range tickets {
+		codes = append(codes, ticketCodeWithSeats(&tickets[i]))
+	}
+	language := ps.smsService.UserLanguage(context.Background(), payment.UserID)
+	message := i18n.T(language, "sms.order_confirmed",
+		event.Title, strings.Join(codes, ", "), models.FormatAmount(payment.Amount, payment.Currency), i18n.Date(language, event.Date))
+
+	if err := ps.smsService.SendSMS(payment.PhoneNumber, message); err != nil {
+		log.Printf("Failed to queue confirmation of order %s: %v", payment.OrderID.Hex(), err)
+	}
+	if err := ps.emailService.SendOrderConfirmation(context.Background(), payment, &event, tickets); err != nil {
+		log.Printf("Failed to email confirmation of order %s: %v", payment.OrderID.Hex(), err)
+	}
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func loadTestPayment(t *testing.T, id primitive.ObjectID) models.Payment {
+	t.Helper()
+
+	var payment models.Payment
+	if err := utils.GetCollection("payments").FindOne(context.Background(), bson.M{"_id": id}).Decode(&payment); err != nil {
+		t.Fatalf("failed to load payment: %v", err)
+	}
+	return payment
+}
+
+func TestSuccessAfterFailureReinstatesOrder(t *testing.T) {
+	inventory := setupInventoryTest(t)
+	payments := NewPaymentService()
+	eventID := insertTestEvent(t, 10)
+	ctx := context.Background()
+
+	payment := insertReconcileOrder(t, inventory, eventID, "pending", time.Minute)
+	if failed, err := payments.MarkFailed(ctx, &payment); err != nil || !failed {
+		t.Fatalf("failed to mark payment failed: %v", err)
+	}
+	if status := orderStatus(t, payment.OrderID); status != "cancelled" {
+		t.Fatalf("expected the failed payment's order to be cancelled, got %s", status)
+	}
+
+	// The provider took the money after all
+	duplicate, err := payments.MarkSucceeded(ctx, &payment)
+	if err != nil || duplicate {
+		t.Fatalf("expected the late success to be applied, got duplicate %v, %v", duplicate, err)
+	}
+	if status := orderStatus(t, payment.OrderID); status != "paid" {
+		t.Errorf("expected the order to be reinstated, got %s", status)
+	}
+	if n, _ := utils.GetCollection("tickets").CountDocuments(ctx, bson.M{"order_id": payment.OrderID, "status": "paid"}); n != 1 {
+		t.Errorf("expected the ticket to be paid, got %d", n)
+	}
+	if sold := loadTestEvent(t, eventID).SoldTickets; sold != 1 {
+		t.Errorf("expected the ticket to be sold again, got %d", sold)
+	}
+	if n, _ := utils.GetCollection("ledger_entries").CountDocuments(ctx, bson.M{"payment_id": payment.ID}); n != 1 {
+		t.Errorf("expected the sale to be recorded once, got %d", n)
+	}
+	if stored := loadTestPayment(t, payment.ID); stored.NotifiedAt == nil || stored.RefundDue {
+		t.Errorf("expected the buyer to be notified, got %+v", stored)
+	}
+
+	// Repeats change nothing
+	if duplicate, err := payments.MarkSucceeded(ctx, &payment); err != nil || !duplicate {
+		t.Errorf("expected a duplicate success, got %v, %v", duplicate, err)
+	}
+	if sold := loadTestEvent(t, eventID).SoldTickets; sold != 1 {
+		t.Errorf("expected the ticket sold once, got %d", sold)
+	}
+}
+
+func TestSuccessAfterFailureWithTicketsGone(t *testing.T) {
+	inventory := setupInventoryTest(t)
+	payments := NewPaymentService()
+	eventID := insertTestEvent(t, 1)
+	ctx := context.Background()
+
+	payment := insertReconcileOrder(t, inventory, eventID, "pending", time.Minute)
+	if _, err := payments.MarkFailed(ctx, &payment); err != nil {
+		t.Fatalf("failed to mark payment failed: %v", err)
+	}
+	// Someone else buys the last ticket
+	insertReconcileOrder(t, inventory, eventID, "pending", time.Minute)
+
+	_, err := payments.MarkSucceeded(ctx, &payment)
+	if !errors.Is(err, ErrTicketsUnavailable) {
+		t.Fatalf("expected the tickets to be unavailable, got %v", err)
+	}
+	if status := orderStatus(t, payment.OrderID); status != "cancelled" {
+		t.Errorf("expected the order to stay cancelled, got %s", status)
+	}
+	stored := loadTestPayment(t, payment.ID)
+	if !stored.RefundDue || stored.NotifiedAt != nil {
+		t.Errorf("expected a refund due and no confirmation, got %+v", stored)
+	}
+	if n, _ := utils.GetCollection("ledger_entries").CountDocuments(ctx, bson.M{"payment_id": payment.ID}); n != 0 {
+		t.Errorf("expected no sale recorded, got %d entries", n)
+	}
+}
+
+func TestSuccessAfterSaleClosed(t *testing.T) {
+	inventory := setupInventoryTest(t)
+	payments := NewPaymentService()
+	earlyBird := models.TicketType{ID: primitive.NewObjectID(), Name: "Early Bird", Price: 500, Capacity: 10}
+	eventID := insertTieredTestEvent(t, earlyBird)
+	ctx := context.Background()
+
+	reservation, err := inventory.Reserve(ctx, eventID, earlyBird.ID, 1)
+	if err != nil {
+		t.Fatalf("failed to reserve: %v", err)
+	}
+	order, _, err := reservation.PlaceOrder(ctx, primitive.NewObjectID(), nil)
+	if err != nil {
+		t.Fatalf("failed to place order: %v", err)
+	}
+	reservation.Commit()
+
+	payment := models.Payment{
+		ID:          primitive.NewObjectID(),
+		UserID:      order.UserID,
+		EventID:     eventID,
+		OrderID:     order.ID,
+		Amount:      order.TotalAmount,
+		Currency:    order.Currency,
+		Status:      "pending",
+		PaymentType: "momo",
+		MoMoRef:     NewReferenceID(),
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if _, err := utils.GetCollection("payments").InsertOne(ctx, payment); err != nil {
+		t.Fatalf("failed to insert payment: %v", err)
+	}
+	if _, err := payments.MarkFailed(ctx, &payment); err != nil {
+		t.Fatalf("failed to mark payment failed: %v", err)
+	}
+
+	// The early bird sale ends before the provider reports the payment
+	_, err = utils.GetCollection("events").UpdateOne(ctx,
+		bson.M{"_id": eventID},
+		bson.M{"$set": bson.M{"ticket_types.0.sale_end": time.Now().Add(-time.Minute)}})
+	if err != nil {
+		t.Fatalf("failed to close the sale: %v", err)
+	}
+
+	_, err = payments.MarkSucceeded(ctx, &payment)
+	if !errors.Is(err, ErrTicketTypeNotOnSale) {
+		t.Fatalf("expected the ticket type to be off sale, got %v", err)
+	}
+	if status := orderStatus(t, payment.OrderID); status != "cancelled" {
+		t.Errorf("expected the order to stay cancelled, got %s", status)
+	}
+	if stored := loadTestPayment(t, payment.ID); !stored.RefundDue || stored.NotifiedAt != nil {
+		t.Errorf("expected a refund due and no confirmation, got %+v", stored)
+	}
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// pricingInterval is how often dynamic prices are recalculated
+	pricingInterval = 15 * time.Minute
+	// competitionWindow is how close to an event's date another event in its
+	// category competes for the same buyers
+	competitionWindow = 3 * 24 * time.Hour
+)
+
+// PricingService keeps the prices of events that opted into dynamic pricing
+// in step with demand, and records every change with the factors behind it.
+// Buyers are charged the price in force when their tickets are reserved.
+type PricingService struct {
+	eventCollection   *mongo.Collection
+	ticketCollection  *mongo.Collection
+	historyCollection *mongo.Collection
+	aiService         *AIService
+}
+
+func NewPricingService() *PricingService {
+	return &PricingService{
+		eventCollection:   utils.GetCollection("events"),
+		ticketCollection:  utils.GetCollection("tickets"),
+		historyCollection: utils.GetCollection("price_history"),
+		aiService:         NewAIService(),
+	}
+}
+
+// Start recalculates prices until ctx is cancelled
+func (ps *PricingService) Start(ctx context.Context) {
+	ticker := time.NewTicker(pricingInterval)
+	defer ticker.Stop()
+
+	for {
+		if changed, err := ps.Run(ctx); err != nil {
+			log.Printf("Dynamic pricing failed: %v", err)
+		} else if changed > 0 {
+			log.Printf("Changed the price of %d events", changed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Run recalculates the price of every event on sale with dynamic pricing,
+// and returns how many changed
+func (ps *PricingService) Run(ctx context.Context) (int, error) {
+	cursor, err := ps.eventCollection.Find(ctx, bson.M{"pricing.enabled": true, "status": "active"})
+	if err != nil {
+		return 0, fmt.Errorf("failed to find dynamically priced events: %w", err)
+	}
+	var events []models.Event
+	if err := cursor.All(ctx, &events); err != nil {
+		return 0, fmt.Errorf("failed to decode dynamically priced events: %w", err)
+	}
+
+	changed := 0
+	for i := range events {
+		change, err := ps.Recalculate(ctx, &events[i])
+		if err != nil {
+			log.Printf("Failed to recalculate the price of event %s: %v", events[i].ID.Hex(), err)
+		}
+		if change != nil {
+			changed++
+		}
+	}
+	return changed, nil
+}
+
+// Recalculate works out an event's price now, and returns the change
+// recorded, or nil when the price stays the same. The new price is stored
+// only if the event's pricing is unchanged since it was read, so runs
+// overlapping on other instances record each change once.
+func (ps *PricingService) Recalculate(ctx context.Context, event *models.Event) (*models.PriceChange, error) {
+	if !event.HasDynamicPricing() {
+		return nil, nil
+	}
+	now := time.Now().Truncate(time.Millisecond)
+
+	// Tickets taken in the last week, including those still held
+	opts := options.Find().SetProjection(bson.M{"event_id": 1, "created_at": 1})
+	cursor, err := ps.ticketCollection.Find(ctx, bson.M{
+		"event_id":   event.ID,
+		"status":     bson.M{"$in": []string{"pending", "paid", "used"}},
+		"created_at": bson.M{"$gte": now.AddDate(0, 0, -7)},
+	}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch recent sales: %w", err)
+	}
+	var tickets []models.Ticket
+	if err := cursor.All(ctx, &tickets); err != nil {
+		return nil, fmt.Errorf("failed to decode recent sales: %w", err)
+	}
+
+	cursor, err = ps.eventCollection.Find(ctx, bson.M{
+		"_id":      bson.M{"$ne": event.ID},
+		"category": event.Category,
+		"status":   "active",
+		"date":     bson.M{"$gte": event.Date.Add(-competitionWindow), "$lte": event.Date.Add(competitionWindow)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch competing events: %w", err)
+	}
+	var competitors []models.Event
+	if err := cursor.All(ctx, &competitors); err != nil {
+		return nil, fmt.Errorf("failed to decode competing events: %w", err)
+	}
+
+	pricing := ps.aiService.CalculateDynamicPricing(event, tickets, competitors)
+	previous := event.Pricing.Multiplier
+	if previous <= 0 {
+		previous = 1
+	}
+
+	result, err := ps.eventCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": event.ID, "pricing.enabled": true, "pricing.updated_at": event.Pricing.UpdatedAt},
+		bson.M{"$set": bson.M{"pricing.multiplier": pricing.Multiplier, "pricing.updated_at": now}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update price: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, nil
+	}
+	event.Pricing.Multiplier = pricing.Multiplier
+	event.Pricing.UpdatedAt = now
+	if pricing.Multiplier == previous {
+		return nil, nil
+	}
+
+	change := &models.PriceChange{
+		EventID:            event.ID,
+		PreviousMultiplier: previous,
+		Multiplier:         pricing.Multiplier,
+		BasePrice:          pricing.BasePrice,
+		Price:              pricing.CurrentPrice,
+		Currency:           pricing.Currency,
+		DemandMultiplier:   pricing.DemandMultiplier,
+		TimeMultiplier:     pricing.TimeMultiplier,
+		CompetitionFactor:  pricing.CompetitionFactor,
+		RecentSales:        pricing.RecentSales,
+		CompetingEvents:    pricing.CompetingEvents,
+		CreatedAt:          now,
+	}
+	res, err := ps.historyCollection.InsertOne(ctx, change)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record price change: %w", err)
+	}
+	change.ID, _ = res.InsertedID.(primitive.ObjectID)
+	return change, nil
+}
+
+// History returns a page of an event's price changes, newest first, and how
+// many there are
+func (ps *PricingService) History(ctx context.Context, eventID primitive.ObjectID, page, limit int) ([]models.PriceChange, int64, error) {
+	filter := bson.M{"event_id": eventID}
+	opts := options.Find().
+		SetSort(bson.M{"created_at": -1}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+
+	cursor, err := ps.historyCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch price history: %w", err)
+	}
+	changes := []models.PriceChange{}
+	if err := cursor.All(ctx, &changes); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode price history: %w", err)
+	}
+
+	total, err := ps.historyCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count price history: %w", err)
+	}
+	return changes, total, nil
+}
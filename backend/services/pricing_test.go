@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// recentTickets returns n tickets for an event taken in the last hour
+func recentTickets(eventID primitive.ObjectID, n int) []models.Ticket {
+	tickets := make([]models.Ticket, n)
+	for i := range tickets {
+		tickets[i] = models.Ticket{EventID: eventID, Status: "paid", CreatedAt: time.Now().Add(-time.Hour)}
+	}
+	return tickets
+}
+
+func TestCalculateDynamicPricing(t *testing.T) {
+	ai := NewAIService()
+	event := &models.Event{
+		ID:         primitive.NewObjectID(),
+		Date:       time.Now().AddDate(0, 0, 60),
+		Price:      1000,
+		Currency:   "GHS",
+		MaxTickets: 100,
+	}
+
+	// Half the tickets sold this week puts the price up 15%
+	sales := recentTickets(event.ID, 50)
+	sales = append(sales, models.Ticket{EventID: event.ID, CreatedAt: time.Now().AddDate(0, 0, -10)})
+	pricing := ai.CalculateDynamicPricing(event, sales, nil)
+	if pricing.Multiplier != 1.15 || pricing.CurrentPrice != 1150 || pricing.RecentSales != 50 {
+		t.Errorf("unexpected pricing for strong demand: %+v", pricing)
+	}
+
+	// The organizer's bounds hold it back
+	event.Pricing = &models.EventPricing{Enabled: true, FloorPercent: 90, CeilingPercent: 110}
+	if pricing := ai.CalculateDynamicPricing(event, sales, nil); pricing.Multiplier != 1.1 || pricing.CurrentPrice != 1100 {
+		t.Errorf("expected the price held at the ceiling, got %+v", pricing)
+	}
+	if pricing := ai.CalculateDynamicPricing(event, nil, nil); pricing.Multiplier != 0.9 || pricing.CurrentPrice != 900 {
+		t.Errorf("expected the price held at the floor, got %+v", pricing)
+	}
+}
+
+func TestCompetitionFactor(t *testing.T) {
+	ai := NewAIService()
+	event := &models.Event{ID: primitive.NewObjectID(), MaxTickets: 100}
+
+	competitors := []models.Event{
+		*event, // the event itself does not compete
+		{ID: primitive.NewObjectID(), MaxTickets: 100, SoldTickets: 50},
+		{ID: primitive.NewObjectID(), MaxTickets: 100, SoldTickets: 100}, // sold out
+		{ID: primitive.NewObjectID(), MaxTickets: 200},
+	}
+	if got := ai.calculateCompetitionFactor(event, competitors); got != 0.925 {
+		t.Errorf("expected a factor of 0.925, got %v", got)
+	}
+
+	crowded := make([]models.Event, 10)
+	for i := range crowded {
+		crowded[i] = models.Event{ID: primitive.NewObjectID(), MaxTickets: 100}
+	}
+	if got := ai.calculateCompetitionFactor(event, crowded); got != 0.8 {
+		t.Errorf("expected competition to take no more than 20%% off, got %v", got)
+	}
+}
+
+func TestLivePrice(t *testing.T) {
+	event := &models.Event{Price: 1000}
+	if got := event.LivePrice(1000); got != 1000 {
+		t.Errorf("expected the organizer's price without dynamic pricing, got %d", got)
+	}
+
+	event.Pricing = &models.EventPricing{Enabled: true, FloorPercent: 80, CeilingPercent: 150, Multiplier: 1.25}
+	reservation := &Reservation{Event: event}
+	if got := reservation.UnitPrice(); got != 1250 {
+		t.Errorf("expected general admission at 1250, got %d", got)
+	}
+	reservation.TicketType = &models.TicketType{Price: 2999}
+	if got := reservation.UnitPrice(); got != 3749 {
+		t.Errorf("expected the tier at 3749, got %d", got)
+	}
+
+	event.Pricing.Enabled = false
+	if got := reservation.UnitPrice(); got != 2999 {
+		t.Errorf("expected the tier at its own price once dynamic pricing is off, got %d", got)
+	}
+}
+
+func TestRecalculate(t *testing.T) {
+	setupInventoryTest(t)
+	ctx := context.Background()
+	ps := NewPricingService()
+
+	eventID := insertTestEvent(t, 100)
+	pricing := &models.EventPricing{Enabled: true, FloorPercent: 50, CeilingPercent: 150, Multiplier: 1}
+	_, err := utils.GetCollection("events").UpdateOne(ctx, bson.M{"_id": eventID},
+		bson.M{"$set": bson.M{"pricing": pricing, "date": time.Now().AddDate(0, 0, 60)}})
+	if err != nil {
+		t.Fatalf("failed to set up pricing: %v", err)
+	}
+	for _, ticket := range recentTickets(eventID, 40) {
+		ticket.TicketCode = primitive.NewObjectID().Hex()
+		if _, err := utils.GetCollection("tickets").InsertOne(ctx, ticket); err != nil {
+			t.Fatalf("failed to insert ticket: %v", err)
+		}
+	}
+
+	event := loadTestEvent(t, eventID)
+	stale := loadTestEvent(t, eventID)
+	change, err := ps.Recalculate(ctx, &event)
+	if err != nil {
+		t.Fatalf("failed to recalculate: %v", err)
+	}
+	if change == nil || change.PreviousMultiplier != 1 || change.Multiplier != 1.08 || change.Price != 1080 || change.RecentSales != 40 {
+		t.Fatalf("unexpected price change: %+v", change)
+	}
+
+	// Nothing more to record until demand moves, nor from a run that read
+	// the event before this one changed it
+	if change, err := ps.Recalculate(ctx, &event); err != nil || change != nil {
+		t.Errorf("expected no change, got %+v, %v", change, err)
+	}
+	if change, err := ps.Recalculate(ctx, &stale); err != nil || change != nil {
+		t.Errorf("expected the stale run to record nothing, got %+v, %v", change, err)
+	}
+
+	history, total, err := ps.History(ctx, eventID, 1, 10)
+	if err != nil || total != 1 || len(history) != 1 || history[0].Price != 1080 {
+		t.Errorf("expected one recorded change, got %d: %+v, %v", total, history, err)
+	}
+
+	// Buyers are charged the new price
+	event = loadTestEvent(t, eventID)
+	if got := (&Reservation{Event: &event}).UnitPrice(); got != 1080 {
+		t.Errorf("expected tickets to sell at 1080, got %d", got)
+	}
+}
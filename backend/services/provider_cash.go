@@ -0,0 +1,59 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"eventticketing/models"
+)
+
+// CashProviderName is the provider for cash taken at the box office
+const CashProviderName = "cash"
+
+// cashProvider takes cash at the box office. Nothing is sent anywhere: the
+// payment stays pending, holding its tickets, until box office staff confirm
+// they were paid. Cash refunds are handed over at the desk, not by the system.
+type cashProvider struct{}
+
+// NewCashProvider returns the box office cash provider
+func NewCashProvider() PaymentProvider {
+	return &cashProvider{}
+}
+
+func (cp *cashProvider) Name() string {
+	return CashProviderName
+}
+
+// NewReference returns nothing; cash payments are found by their ID
+func (cp *cashProvider) NewReference() string {
+	return ""
+}
+
+// Initiate tells the buyer how to pay
+func (cp *cashProvider) Initiate(ctx context.Context, payment *models.Payment, event *models.Event) (*ProviderInitiation, error) {
+	return &ProviderInitiation{
+		Provider:     cp.Name(),
+		Instructions: fmt.Sprintf("Pay %s in cash at the box office for %s, quoting payment %s, before your hold expires", models.FormatAmount(payment.Amount, payment.Currency), event.Title, payment.ID.Hex()),
+	}, nil
+}
+
+// PaymentStatus is not supported; staff confirm cash payments themselves
+func (cp *cashProvider) PaymentStatus(ctx context.Context, payment *models.Payment) (*ProviderStatus, error) {
+	return nil, ErrNotSupported
+}
+
+// Refund is not supported; cash is returned at the box office
+func (cp *cashProvider) Refund(ctx context.Context, refund *models.Refund) error {
+	return ErrNotSupported
+}
+
+// RefundStatus is not supported
+func (cp *cashProvider) RefundStatus(ctx context.Context, refund *models.Refund) (*ProviderStatus, error) {
+	return nil, ErrNotSupported
+}
+
+// VerifyCallback is not supported; there is nobody to call back
+func (cp *cashProvider) VerifyCallback(r *http.Request, body []byte) (*ProviderCallback, error) {
+	return nil, ErrNotSupported
+}
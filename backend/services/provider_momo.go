@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"eventticketing/models"
+)
+
+// momoProvider takes payment by MTN Mobile Money request-to-pay and refunds
+// by disbursement transfer
+type momoProvider struct {
+	momo *MoMoService
+}
+
+// NewMoMoProvider wraps a MoMo client as a payment provider
+func NewMoMoProvider(momo *MoMoService) PaymentProvider {
+	return &momoProvider{momo: momo}
+}
+
+func (mp *momoProvider) Name() string {
+	return "momo"
+}
+
+// NewReference returns a UUID, which MoMo requires as the X-Reference-Id
+func (mp *momoProvider) NewReference() string {
+	return NewReferenceID()
+}
+
+// Initiate sends a request-to-pay to the buyer's phone
+func (mp *momoProvider) Initiate(ctx context.Context, payment *models.Payment, event *models.Event) (*ProviderInitiation, error) {
+	response, err := mp.momo.InitiatePayment(payment, event)
+	if err != nil {
+		return nil, err
+	}
+	return &ProviderInitiation{
+		Provider:     mp.Name(),
+		Reference:    payment.MoMoRef,
+		Instructions: "Approve the payment prompt on your phone",
+		Response:     response,
+	}, nil
+}
+
+// PaymentStatus checks a request-to-pay
+func (mp *momoProvider) PaymentStatus(ctx context.Context, payment *models.Payment) (*ProviderStatus, error) {
+	response, err := mp.momo.GetPaymentStatus(payment.MoMoRef)
+	if err != nil {
+		return nil, err
+	}
+	return &ProviderStatus{
+		Status:        momoStatus(response.Status),
+		RawStatus:     response.Status,
+		Amount:        response.Amount,
+		TransactionID: response.FinancialTransactionID,
+		Reason:        response.Reason,
+	}, nil
+}
+
+// Refund submits a transfer to the phone that paid
+func (mp *momoProvider) Refund(ctx context.Context, refund *models.Refund) error {
+	return mp.momo.Transfer(refund)
+}
+
+// RefundStatus checks a transfer
+func (mp *momoProvider) RefundStatus(ctx context.Context, refund *models.Refund) (*ProviderStatus, error) {
+	transfer, err := mp.momo.GetTransferStatus(refund.ProviderRef)
+	if err != nil {
+		return nil, err
+	}
+	return &ProviderStatus{
+		Status:        momoStatus(transfer.Status),
+		RawStatus:     transfer.Status,
+		Amount:        transfer.Amount,
+		TransactionID: transfer.FinancialTransactionID,
+		Reason:        transfer.Reason,
+	}, nil
+}
+
+// VerifyCallback checks the X-Timestamp, X-Nonce and X-Signature headers
+// before reading the body
+func (mp *momoProvider) VerifyCallback(r *http.Request, body []byte) (*ProviderCallback, error) {
+	nonce := r.Header.Get("X-Nonce")
+	if err := mp.momo.VerifyCallback(body, r.Header.Get("X-Timestamp"), nonce, r.Header.Get("X-Signature")); err != nil {
+		return nil, err
+	}
+
+	var callback models.MoMoCallbackRequest
+	if err := json.Unmarshal(body, &callback); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedCallback, err)
+	}
+	if callback.Reference == "" {
+		return nil, fmt.Errorf("%w: missing reference", ErrMalformedCallback)
+	}
+
+	status := momoStatus(callback.Status)
+	if status == ProviderPending && !strings.EqualFold(callback.Status, "pending") {
+		// Anything MoMo reports that is neither success nor pending ends the payment
+		status = ProviderFailed
+	}
+	return &ProviderCallback{
+		Reference:     callback.Reference,
+		Status:        status,
+		Amount:        callback.Amount,
+		TransactionID: callback.TransactionID,
+		Nonce:         nonce,
+	}, nil
+}
+
+// momoStatus maps a MoMo request-to-pay or transfer status to ours
+func momoStatus(status string) string {
+	switch strings.ToUpper(status) {
+	case "SUCCESSFUL", "SUCCESS":
+		return ProviderSuccess
+	case "FAILED", "REJECTED", "TIMEOUT":
+		return ProviderFailed
+	default:
+		return ProviderPending
+	}
+}
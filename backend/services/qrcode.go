@@ -16,9 +16,9 @@ func NewQRService() *QRService {
 // GenerateQRCode generates a QR code for the given ticket code
 func (qs *QRService) GenerateQRCode(ticketCode string) (string, error) {
 	// Generate QR code as PNG
-	png, err := qrcode.Encode(ticketCode, qrcode.Medium, 256)
+	png, err := qs.GenerateQRCodePNG(ticketCode)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate QR code: %w", err)
+		return "", err
 	}
 
 	// Convert to base64
@@ -26,6 +26,15 @@ func (qs *QRService) GenerateQRCode(ticketCode string) (string, error) {
 	return fmt.Sprintf("data:image/png;base64,%s", base64String), nil
 }
 
+// GenerateQRCodePNG generates a QR code for the given ticket code as a PNG image
+func (qs *QRService) GenerateQRCodePNG(ticketCode string) ([]byte, error) {
+	png, err := qrcode.Encode(ticketCode, qrcode.Medium, 256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate QR code: %w", err)
+	}
+	return png, nil
+}
+
 // GenerateQRCodeFile generates a QR code and saves it to a file
 func (qs *QRService) GenerateQRCodeFile(ticketCode, filePath string) error {
 	err := qrcode.WriteFile(ticketCode, qrcode.Medium, 256, filePath)
@@ -33,4 +42,4 @@ func (qs *QRService) GenerateQRCodeFile(ticketCode, filePath string) error {
 		return fmt.Errorf("failed to generate QR code file: %w", err)
 	}
 	return nil
-} 
\ No newline at end of file
+}
@@ -0,0 +1,478 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"eventticketing/config"
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrQueueNotEnabled is returned when joining the queue of an event sold without one
+	ErrQueueNotEnabled = errors.New("event has no queue")
+	// ErrQueueNotOpen is returned when joining a queue before it opens
+	ErrQueueNotOpen = errors.New("queue is not open yet")
+	// ErrNotInQueue is returned for buyers who have not joined an event's queue
+	ErrNotInQueue = errors.New("not in the queue")
+	// ErrAdmissionRequired is returned when buying tickets for a queued event without an admission token
+	ErrAdmissionRequired = errors.New("admission token required")
+	// ErrAdmissionInvalid is returned for admission tokens that are forged,
+	// expired, or for another buyer or event
+	ErrAdmissionInvalid = errors.New("invalid admission token")
+	// ErrAdmissionUsed is returned when an order has already been placed with an admission
+	ErrAdmissionUsed = errors.New("admission already used")
+)
+
+const (
+	// queueInterval is how often queues are checked for buyers to let through
+	queueInterval = time.Second
+	// queueMinBatchInterval is the least time between two batches of a queue;
+	// higher admission rates let more buyers through in each batch
+	queueMinBatchInterval = 10 * time.Second
+	// DefaultAdmissionWindow is how long buyers have to buy once let
+	// through, when the organizer does not say
+	DefaultAdmissionWindow = 10 * time.Minute
+	// admissionAudience marks admission tokens, so they are never mistaken
+	// for login tokens signed with the same secret
+	admissionAudience = "queue-admission"
+)
+
+// QueueProgress is how far an event's queue has moved. It is sent to every
+// instance after each batch, and each turns it into the position of every
+// buyer watching the queue there.
+type QueueProgress struct {
+	EventID       string        `json:"event_id"`
+	Joined        int64         `json:"joined"`
+	AdmittedFrom  int64         `json:"admitted_from"` // buyers after this one were let through in the latest batch
+	Admitted      int64         `json:"admitted"`      // and buyers up to this one
+	BatchSize     int64         `json:"batch_size"`
+	BatchInterval time.Duration `json:"batch_interval"`
+	NextBatchAt   time.Time     `json:"next_batch_at"`
+	ExpiresAt     time.Time     `json:"expires_at"` // when the latest batch's admissions lapse
+}
+
+// QueuePosition is where a buyer stands in an event's queue
+type QueuePosition struct {
+	EventID   string     `json:"event_id"`
+	Position  int64      `json:"position"`
+	Status    string     `json:"status"`      // waiting, admitted, or expired when an admission lapsed unused
+	Ahead     int64      `json:"ahead"`       // buyers waiting in front
+	ETA       int64      `json:"eta_seconds"` // roughly how long until the buyer is let through
+	Token     string     `json:"token,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // when the admission lapses
+}
+
+// waiting returns the place of a buyer not yet let through
+func (qp *QueueProgress) waiting(position int64, now time.Time) *QueuePosition {
+	remaining := position - qp.Admitted
+	if remaining < 1 {
+		remaining = 1
+	}
+	batches := (remaining + qp.BatchSize - 1) / qp.BatchSize
+	eta := qp.NextBatchAt.Sub(now) + time.Duration(batches-1)*qp.BatchInterval
+	if eta < 0 {
+		eta = 0
+	}
+	return &QueuePosition{
+		EventID:  qp.EventID,
+		Position: position,
+		Status:   "waiting",
+		Ahead:    remaining - 1,
+		ETA:      int64(math.Ceil(eta.Seconds())),
+	}
+}
+
+// admitted returns the place of a buyer let through, with the token they buy with
+func admitted(eventID, userID string, position int64, expiresAt time.Time) (*QueuePosition, error) {
+	token, err := signAdmission(eventID, userID, admissionID(eventID, position), expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &QueuePosition{
+		EventID:   eventID,
+		Position:  position,
+		Status:    "admitted",
+		Token:     token,
+		ExpiresAt: &expiresAt,
+	}, nil
+}
+
+// queueBatch returns how many buyers to let through at a time, and how
+// often, to admit rate buyers a minute
+func queueBatch(rate int) (int64, time.Duration) {
+	if rate < 1 {
+		rate = 1
+	}
+	size := int64(math.Ceil(float64(rate) * queueMinBatchInterval.Minutes()))
+	return size, time.Duration(float64(size) / float64(rate) * float64(time.Minute))
+}
+
+// admissionWindow returns how long buyers have to buy once let through
+func admissionWindow(queue *models.EventQueue) time.Duration {
+	if queue.AdmissionWindow > 0 {
+		return time.Duration(queue.AdmissionWindow) * time.Minute
+	}
+	return DefaultAdmissionWindow
+}
+
+// admissionID identifies the admission given for a place in an event's
+// queue. Places are never reused, so however often a buyer's token is
+// issued again, it buys one order.
+func admissionID(eventID string, position int64) string {
+	return fmt.Sprintf("%s:%d", eventID, position)
+}
+
+type admissionClaims struct {
+	EventID string `json:"event_id"`
+	jwt.RegisteredClaims
+}
+
+// signAdmission issues the token that lets a buyer through to buy one order
+// of tickets for an event until expiresAt
+func signAdmission(eventID, userID, admissionID string, expiresAt time.Time) (string, error) {
+	claims := admissionClaims{
+		EventID: eventID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    "eventticketing",
+			Subject:   userID,
+			Audience:  jwt.ClaimStrings{admissionAudience},
+			ID:        admissionID,
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(config.AppConfig.JWT.Secret))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign admission token: %w", err)
+	}
+	return token, nil
+}
+
+// verifyAdmission checks an admission token was issued to the user for the
+// event and has not expired, and returns the admission it carries
+func verifyAdmission(token, eventID, userID string) (string, error) {
+	var claims admissionClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(config.AppConfig.JWT.Secret), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithAudience(admissionAudience),
+		jwt.WithSubject(userID),
+	)
+	if err != nil || claims.EventID != eventID || claims.ID == "" {
+		return "", ErrAdmissionInvalid
+	}
+	return claims.ID, nil
+}
+
+// QueueService runs the virtual queues buyers wait in for high-demand
+// sales. Buyers are numbered as they join, and let through a batch at a
+// time at the rate the organizer set; each is given a signed admission
+// token to buy with, valid for the event's admission window. Batches are
+// claimed with a conditional update, so however many instances run the
+// queue, it never moves faster than the organizer's rate.
+type QueueService struct {
+	eventCollection  *mongo.Collection
+	queueCollection  *mongo.Collection
+	entryCollection  *mongo.Collection
+	webSocketService *WebSocketService
+}
+
+func NewQueueService(webSocketService *WebSocketService) *QueueService {
+	return &QueueService{
+		eventCollection:  utils.GetCollection("events"),
+		queueCollection:  utils.GetCollection("queues"),
+		entryCollection:  utils.GetCollection("queue_entries"),
+		webSocketService: webSocketService,
+	}
+}
+
+// queuedEvent loads an event sold through a queue
+func (qs *QueueService) queuedEvent(ctx context.Context, eventID primitive.ObjectID) (*models.Event, error) {
+	var event models.Event
+	err := qs.eventCollection.FindOne(ctx, bson.M{"_id": eventID}).Decode(&event)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrEventNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch event: %w", err)
+	}
+	if !event.HasQueue() {
+		return nil, ErrQueueNotEnabled
+	}
+	return &event, nil
+}
+
+// Join puts a buyer at the back of an event's queue, or returns their place
+// when they are already in it. Buyers whose admission lapsed without buying
+// join again at the back.
+func (qs *QueueService) Join(ctx context.Context, eventID, userID primitive.ObjectID) (*QueuePosition, error) {
+	event, err := qs.queuedEvent(ctx, eventID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if now.Before(event.Queue.OpensAt) {
+		return nil, ErrQueueNotOpen
+	}
+
+	var existing models.QueueEntry
+	err = qs.entryCollection.FindOne(ctx, bson.M{"event_id": eventID, "user_id": userID}).Decode(&existing)
+	if err == nil && (existing.Status == "waiting" || existing.IsAdmitted(now)) {
+		return qs.position(ctx, event, &existing, now)
+	}
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("failed to fetch queue entry: %w", err)
+	}
+
+	// Take the next number
+	var queue models.Queue
+	err = qs.queueCollection.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": eventID},
+		bson.M{
+			"$inc":         bson.M{"joined": 1},
+			"$set":         bson.M{"updated_at": now},
+			"$setOnInsert": bson.M{"admitted": 0, "next_batch_at": time.Time{}},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&queue)
+	if err != nil {
+		return nil, fmt.Errorf("failed to join queue: %w", err)
+	}
+
+	entry := models.QueueEntry{
+		EventID:  eventID,
+		UserID:   userID,
+		Position: queue.Joined,
+		Status:   "waiting",
+		JoinedAt: now,
+	}
+	if existing.ID.IsZero() {
+		entry.ID = primitive.NewObjectID()
+		_, err = qs.entryCollection.InsertOne(ctx, entry)
+	} else {
+		// The lapsed entry is replaced only if no other request got there first
+		entry.ID = existing.ID
+		var result *mongo.UpdateResult
+		result, err = qs.entryCollection.ReplaceOne(ctx, bson.M{"_id": existing.ID, "position": existing.Position}, entry)
+		if err == nil && result.MatchedCount == 0 {
+			err = mongo.ErrNoDocuments
+		}
+	}
+	if mongo.IsDuplicateKeyError(err) || err == mongo.ErrNoDocuments {
+		// The buyer joined twice at once; the other request's number stands
+		return qs.Status(ctx, eventID, userID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save queue entry: %w", err)
+	}
+
+	return qs.position(ctx, event, &entry, now)
+}
+
+// Status returns a buyer's place in an event's queue
+func (qs *QueueService) Status(ctx context.Context, eventID, userID primitive.ObjectID) (*QueuePosition, error) {
+	event, err := qs.queuedEvent(ctx, eventID)
+	if err != nil {
+		return nil, err
+	}
+	var entry models.QueueEntry
+	err = qs.entryCollection.FindOne(ctx, bson.M{"event_id": eventID, "user_id": userID}).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotInQueue
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch queue entry: %w", err)
+	}
+	return qs.position(ctx, event, &entry, time.Now())
+}
+
+func (qs *QueueService) position(ctx context.Context, event *models.Event, entry *models.QueueEntry, now time.Time) (*QueuePosition, error) {
+	eventID, userID := event.ID.Hex(), entry.UserID.Hex()
+	switch {
+	case entry.IsAdmitted(now):
+		return admitted(eventID, userID, entry.Position, *entry.ExpiresAt)
+	case entry.Status == "admitted":
+		return &QueuePosition{EventID: eventID, Position: entry.Position, Status: "expired", ExpiresAt: entry.ExpiresAt}, nil
+	}
+
+	progress, err := qs.progress(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Position > progress.Admitted {
+		return progress.waiting(entry.Position, now), nil
+	}
+
+	// The buyer's batch has gone through, but their entry was not marked
+	// admitted with it, as when the batch was cut short
+	expiresAt := now.Add(admissionWindow(event.Queue))
+	_, err = qs.entryCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": entry.ID, "status": "waiting"},
+		bson.M{"$set": bson.M{"status": "admitted", "admitted_at": now, "expires_at": expiresAt}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to admit buyer: %w", err)
+	}
+	return admitted(eventID, userID, entry.Position, expiresAt)
+}
+
+// progress returns how far an event's queue has moved
+func (qs *QueueService) progress(ctx context.Context, event *models.Event) (*QueueProgress, error) {
+	var queue models.Queue
+	err := qs.queueCollection.FindOne(ctx, bson.M{"_id": event.ID}).Decode(&queue)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("failed to fetch queue: %w", err)
+	}
+	size, interval := queueBatch(event.Queue.AdmissionRate)
+	return &QueueProgress{
+		EventID:       event.ID.Hex(),
+		Joined:        queue.Joined,
+		AdmittedFrom:  queue.Admitted,
+		Admitted:      queue.Admitted,
+		BatchSize:     size,
+		BatchInterval: interval,
+		NextBatchAt:   queue.NextBatchAt,
+	}, nil
+}
+
+// VerifyAdmission checks that a buyer may buy tickets for an event: any
+// buyer for events sold without a queue, and buyers with a valid admission
+// token for events sold through one. It returns the admission, which the
+// order is placed with so that each admission buys one order, or "" for
+// events without a queue.
+func (qs *QueueService) VerifyAdmission(ctx context.Context, eventID, userID primitive.ObjectID, token string) (string, error) {
+	var event models.Event
+	opts := options.FindOne().SetProjection(bson.M{"queue": 1})
+	err := qs.eventCollection.FindOne(ctx, bson.M{"_id": eventID}, opts).Decode(&event)
+	if err == mongo.ErrNoDocuments {
+		return "", nil // reserving the tickets reports the missing event
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch event: %w", err)
+	}
+	if !event.HasQueue() {
+		return "", nil
+	}
+	if token == "" {
+		return "", ErrAdmissionRequired
+	}
+	return verifyAdmission(token, eventID.Hex(), userID.Hex())
+}
+
+// Start lets buyers through the queues until ctx is cancelled
+func (qs *QueueService) Start(ctx context.Context) {
+	ticker := time.NewTicker(queueInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := qs.Advance(ctx, time.Now()); err != nil {
+			log.Printf("Queue advance failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Advance lets the next batch through every queue that is due one, and
+// returns how many buyers were let through
+func (qs *QueueService) Advance(ctx context.Context, now time.Time) (int64, error) {
+	cursor, err := qs.queueCollection.Find(ctx, bson.M{
+		"next_batch_at": bson.M{"$lte": now},
+		"$expr":         bson.M{"$lt": bson.A{"$admitted", "$joined"}},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to find waiting queues: %w", err)
+	}
+	var queues []models.Queue
+	if err := cursor.All(ctx, &queues); err != nil {
+		return 0, fmt.Errorf("failed to decode waiting queues: %w", err)
+	}
+
+	var total int64
+	for i := range queues {
+		admittedCount, err := qs.admitBatch(ctx, &queues[i], now)
+		if err != nil {
+			log.Printf("Failed to let buyers through the queue for event %s: %v", queues[i].EventID.Hex(), err)
+		}
+		total += admittedCount
+	}
+	return total, nil
+}
+
+// admitBatch lets the next batch of buyers through a queue
+func (qs *QueueService) admitBatch(ctx context.Context, queue *models.Queue, now time.Time) (int64, error) {
+	var event models.Event
+	if err := qs.eventCollection.FindOne(ctx, bson.M{"_id": queue.EventID}).Decode(&event); err != nil {
+		return 0, fmt.Errorf("failed to fetch event: %w", err)
+	}
+	// Buyers need no admission once the organizer turns the queue off
+	if !event.HasQueue() {
+		return 0, nil
+	}
+	size, interval := queueBatch(event.Queue.AdmissionRate)
+
+	// Claim the batch; an instance that read the queue at the same time
+	// finds the batch time moved on and leaves it
+	var after models.Queue
+	err := qs.queueCollection.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": queue.EventID, "next_batch_at": queue.NextBatchAt},
+		[]bson.M{{"$set": bson.M{
+			"admitted":      bson.M{"$min": bson.A{bson.M{"$add": bson.A{"$admitted", size}}, "$joined"}},
+			"next_batch_at": now.Add(interval),
+			"updated_at":    now,
+		}}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&after)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim queue batch: %w", err)
+	}
+
+	expiresAt := now.Add(admissionWindow(event.Queue))
+	_, err = qs.entryCollection.UpdateMany(
+		ctx,
+		bson.M{
+			"event_id": queue.EventID,
+			"status":   "waiting",
+			"position": bson.M{"$gt": queue.Admitted, "$lte": after.Admitted},
+		},
+		bson.M{"$set": bson.M{"status": "admitted", "admitted_at": now, "expires_at": expiresAt}},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to admit buyers: %w", err)
+	}
+
+	qs.webSocketService.BroadcastQueueProgress(&QueueProgress{
+		EventID:       queue.EventID.Hex(),
+		Joined:        after.Joined,
+		AdmittedFrom:  queue.Admitted,
+		Admitted:      after.Admitted,
+		BatchSize:     size,
+		BatchInterval: interval,
+		NextBatchAt:   after.NextBatchAt,
+		ExpiresAt:     expiresAt,
+	})
+	return after.Admitted - queue.Admitted, nil
+}
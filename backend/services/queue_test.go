@@ -0,0 +1,320 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"eventticketing/config"
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestQueueBatch(t *testing.T) {
+	cases := []struct {
+		rate     int
+		size     int64
+		interval time.Duration
+	}{
+		{6, 1, 10 * time.Second},
+		{4, 1, 15 * time.Second},
+		{30, 5, 10 * time.Second},
+		{600, 100, 10 * time.Second},
+		{0, 1, time.Minute},
+	}
+	for _, tc := range cases {
+		size, interval := queueBatch(tc.rate)
+		if size != tc.size || interval != tc.interval {
+			t.Errorf("queueBatch(%d) = %d every %s, want %d every %s", tc.rate, size, interval, tc.size, tc.interval)
+		}
+	}
+}
+
+func TestQueueProgressWaiting(t *testing.T) {
+	now := time.Now()
+	progress := &QueueProgress{
+		EventID:       "event",
+		Admitted:      10,
+		BatchSize:     5,
+		BatchInterval: 10 * time.Second,
+		NextBatchAt:   now.Add(4 * time.Second),
+	}
+
+	cases := map[int64]struct{ ahead, eta int64 }{
+		11: {0, 4},  // in the next batch
+		15: {4, 4},  // last of the next batch
+		16: {5, 14}, // first of the one after
+		40: {29, 54},
+	}
+	for position, want := range cases {
+		got := progress.waiting(position, now)
+		if got.Status != "waiting" || got.Ahead != want.ahead || got.ETA != want.eta {
+			t.Errorf("position %d: got %+v, want %d ahead and %ds", position, got, want.ahead, want.eta)
+		}
+	}
+
+	// A batch that is overdue goes out on the next tick
+	progress.NextBatchAt = now.Add(-time.Minute)
+	if got := progress.waiting(12, now); got.ETA != 0 {
+		t.Errorf("expected no wait for an overdue batch, got %ds", got.ETA)
+	}
+}
+
+func TestAdmissionToken(t *testing.T) {
+	previous := config.AppConfig
+	config.AppConfig = &config.Config{JWT: config.JWTConfig{Secret: "test-secret", Expiry: time.Hour}}
+	defer func() { config.AppConfig = previous }()
+
+	eventID, userID := primitive.NewObjectID().Hex(), primitive.NewObjectID()
+	token, err := signAdmission(eventID, userID.Hex(), admissionID(eventID, 1), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("failed to sign admission: %v", err)
+	}
+	if admission, err := verifyAdmission(token, eventID, userID.Hex()); err != nil || admission != admissionID(eventID, 1) {
+		t.Errorf("expected the token to be accepted for its admission, got %q, %v", admission, err)
+	}
+	if _, err := verifyAdmission(token, primitive.NewObjectID().Hex(), userID.Hex()); !errors.Is(err, ErrAdmissionInvalid) {
+		t.Errorf("expected the token to be refused for another event, got %v", err)
+	}
+	if _, err := verifyAdmission(token, eventID, primitive.NewObjectID().Hex()); !errors.Is(err, ErrAdmissionInvalid) {
+		t.Errorf("expected the token to be refused for another buyer, got %v", err)
+	}
+
+	expired, _ := signAdmission(eventID, userID.Hex(), admissionID(eventID, 1), time.Now().Add(-time.Minute))
+	if _, err := verifyAdmission(expired, eventID, userID.Hex()); !errors.Is(err, ErrAdmissionInvalid) {
+		t.Errorf("expected an expired token to be refused, got %v", err)
+	}
+
+	// A login token is signed with the same secret, but is not an admission
+	login, _ := utils.GenerateToken(&models.User{ID: userID, Role: "user"})
+	if _, err := verifyAdmission(login, eventID, userID.Hex()); !errors.Is(err, ErrAdmissionInvalid) {
+		t.Errorf("expected a login token to be refused, got %v", err)
+	}
+}
+
+func TestWebSocketQueueProgress(t *testing.T) {
+	previous := config.AppConfig
+	config.AppConfig = &config.Config{JWT: config.JWTConfig{Secret: "test-secret"}}
+	defer func() { config.AppConfig = previous }()
+
+	ws := newWebSocketService(NewMemoryBroker(), 16)
+	url := startWebSocketTest(t, ws)
+	eventID := primitive.NewObjectID().Hex()
+	buyers := []*models.User{
+		{ID: primitive.NewObjectID(), Role: "user"},
+		{ID: primitive.NewObjectID(), Role: "user"},
+	}
+	first := dialWebSocket(t, url, buyers[0])
+	second := dialWebSocket(t, url, buyers[1])
+	waitForClients(t, ws, 2)
+
+	// As if each had sent watch_queue after joining: numbers 3 and 8
+	ws.mutex.Lock()
+	for client := range ws.clients {
+		client.info.QueueEventID = eventID
+		client.queuePosition = 3
+		if client.info.UserID == buyers[1].ID.Hex() {
+			client.queuePosition = 8
+		}
+	}
+	ws.mutex.Unlock()
+
+	now := time.Now()
+	ws.BroadcastQueueProgress(&QueueProgress{
+		EventID:       eventID,
+		Joined:        8,
+		AdmittedFrom:  0,
+		Admitted:      5,
+		BatchSize:     5,
+		BatchInterval: 10 * time.Second,
+		NextBatchAt:   now.Add(10 * time.Second),
+		ExpiresAt:     now.Add(10 * time.Minute),
+	})
+
+	msg := readWebSocket(t, first)
+	data, _ := msg["data"].(map[string]interface{})
+	if msg["type"] != "queue_admitted" || data["token"] == nil {
+		t.Fatalf("expected the first buyer to be let through, got %v", msg)
+	}
+	if _, err := verifyAdmission(data["token"].(string), eventID, buyers[0].ID.Hex()); err != nil {
+		t.Errorf("expected a valid admission token, got %v", err)
+	}
+
+	msg = readWebSocket(t, second)
+	data, _ = msg["data"].(map[string]interface{})
+	if msg["type"] != "queue_position" || data["ahead"] != float64(2) || data["position"] != float64(8) {
+		t.Errorf("expected the second buyer to hear their place, got %v", msg)
+	}
+
+	// The next batch is news only to those still waiting before it
+	ws.BroadcastQueueProgress(&QueueProgress{EventID: eventID, Joined: 8, AdmittedFrom: 5, Admitted: 8, BatchSize: 5, ExpiresAt: now.Add(10 * time.Minute)})
+	if msg := readWebSocket(t, second); msg["type"] != "queue_admitted" {
+		t.Errorf("expected the second buyer to be let through, got %v", msg)
+	}
+	first.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, _, err := first.ReadMessage(); err == nil {
+		t.Error("expected nothing more for the first buyer")
+	}
+}
+
+// setupQueueTest inserts an event sold through a queue letting rate buyers through a minute
+func setupQueueTest(t *testing.T, rate int) (*QueueService, primitive.ObjectID) {
+	t.Helper()
+	setupInventoryTest(t)
+	config.AppConfig.JWT.Secret = "test-secret"
+
+	eventID := insertTestEvent(t, 100)
+	queue := &models.EventQueue{Enabled: true, OpensAt: time.Now().Add(-time.Minute), AdmissionRate: rate, AdmissionWindow: 10}
+	_, err := utils.GetCollection("events").UpdateOne(context.Background(), bson.M{"_id": eventID}, bson.M{"$set": bson.M{"queue": queue}})
+	if err != nil {
+		t.Fatalf("failed to set up queue: %v", err)
+	}
+	return NewQueueService(newWebSocketService(NewMemoryBroker(), 16)), eventID
+}
+
+func TestQueue(t *testing.T) {
+	qs, eventID := setupQueueTest(t, 6) // one buyer every ten seconds
+	ctx := context.Background()
+
+	buyers := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()}
+	for i, buyer := range buyers {
+		position, err := qs.Join(ctx, eventID, buyer)
+		if err != nil {
+			t.Fatalf("failed to join: %v", err)
+		}
+		if position.Position != int64(i+1) || position.Status != "waiting" || position.Ahead != int64(i) {
+			t.Errorf("unexpected place for buyer %d: %+v", i, position)
+		}
+	}
+	// Joining again keeps the buyer's place
+	if position, _ := qs.Join(ctx, eventID, buyers[1]); position.Position != 2 {
+		t.Errorf("expected to keep place 2, got %+v", position)
+	}
+
+	// Without a token nobody can buy
+	if _, err := qs.VerifyAdmission(ctx, eventID, buyers[0], ""); !errors.Is(err, ErrAdmissionRequired) {
+		t.Errorf("expected a token to be required, got %v", err)
+	}
+
+	// One buyer per batch, and batches no closer than the rate allows
+	now := time.Now()
+	for _, step := range []struct {
+		at   time.Time
+		want int64
+	}{{now, 1}, {now.Add(5 * time.Second), 0}, {now.Add(10 * time.Second), 1}} {
+		admitted, err := qs.Advance(ctx, step.at)
+		if err != nil {
+			t.Fatalf("failed to advance: %v", err)
+		}
+		if admitted != step.want {
+			t.Errorf("expected %d let through, got %d", step.want, admitted)
+		}
+	}
+
+	first, err := qs.Status(ctx, eventID, buyers[0])
+	if err != nil || first.Status != "admitted" || first.Token == "" {
+		t.Fatalf("expected the first buyer to be let through, got %+v, %v", first, err)
+	}
+	if _, err := qs.VerifyAdmission(ctx, eventID, buyers[0], first.Token); err != nil {
+		t.Errorf("expected the first buyer's token to be accepted, got %v", err)
+	}
+	if _, err := qs.VerifyAdmission(ctx, eventID, buyers[2], first.Token); !errors.Is(err, ErrAdmissionInvalid) {
+		t.Errorf("expected another buyer's token to be refused, got %v", err)
+	}
+	if third, _ := qs.Status(ctx, eventID, buyers[2]); third.Status != "waiting" || third.Ahead != 0 {
+		t.Errorf("expected the third buyer to be next, got %+v", third)
+	}
+
+	// A buyer whose admission lapsed joins again at the back
+	_, err = utils.GetCollection("queue_entries").UpdateOne(ctx,
+		bson.M{"event_id": eventID, "user_id": buyers[0]},
+		bson.M{"$set": bson.M{"expires_at": time.Now().Add(-time.Second)}})
+	if err != nil {
+		t.Fatalf("failed to lapse admission: %v", err)
+	}
+	if lapsed, _ := qs.Status(ctx, eventID, buyers[0]); lapsed.Status != "expired" {
+		t.Errorf("expected the admission to have lapsed, got %+v", lapsed)
+	}
+	if rejoined, _ := qs.Join(ctx, eventID, buyers[0]); rejoined.Position != 4 || rejoined.Status != "waiting" {
+		t.Errorf("expected to rejoin at place 4, got %+v", rejoined)
+	}
+
+	if _, err := qs.Status(ctx, eventID, primitive.NewObjectID()); !errors.Is(err, ErrNotInQueue) {
+		t.Errorf("expected a stranger not to be in the queue, got %v", err)
+	}
+}
+
+func TestAdmissionPlacesOneOrder(t *testing.T) {
+	qs, eventID := setupQueueTest(t, 60)
+	utils.CreateIndexes()
+	inventory := NewInventoryService()
+	ctx := context.Background()
+
+	buyer := primitive.NewObjectID()
+	if _, err := qs.Join(ctx, eventID, buyer); err != nil {
+		t.Fatalf("failed to join: %v", err)
+	}
+	if _, err := qs.Advance(ctx, time.Now()); err != nil {
+		t.Fatalf("failed to advance: %v", err)
+	}
+
+	buy := func() error {
+		// The buyer's token is issued afresh each time they ask for it
+		position, err := qs.Status(ctx, eventID, buyer)
+		if err != nil || position.Status != "admitted" {
+			t.Fatalf("expected the buyer to be let through, got %+v, %v", position, err)
+		}
+		admission, err := qs.VerifyAdmission(ctx, eventID, buyer, position.Token)
+		if err != nil {
+			t.Fatalf("expected the token to be accepted, got %v", err)
+		}
+
+		reservation, err := inventory.Reserve(ctx, eventID, primitive.NilObjectID, 1)
+		if err != nil {
+			t.Fatalf("failed to reserve: %v", err)
+		}
+		defer reservation.Rollback(ctx)
+		reservation.AdmissionID = admission
+		if _, _, err := reservation.PlaceOrder(ctx, buyer, nil); err != nil {
+			return err
+		}
+		reservation.Commit()
+		return nil
+	}
+
+	if err := buy(); err != nil {
+		t.Fatalf("failed to place the first order: %v", err)
+	}
+	if err := buy(); !errors.Is(err, ErrAdmissionUsed) {
+		t.Errorf("expected ErrAdmissionUsed for a second order, got %v", err)
+	}
+	if n, _ := utils.GetCollection("orders").CountDocuments(ctx, bson.M{"event_id": eventID}); n != 1 {
+		t.Errorf("expected one order, got %d", n)
+	}
+	if event := loadTestEvent(t, eventID); event.SoldTickets != 1 {
+		t.Errorf("expected the refused order's ticket back, got %d sold", event.SoldTickets)
+	}
+}
+
+func TestQueueNotOpen(t *testing.T) {
+	qs, eventID := setupQueueTest(t, 60)
+	ctx := context.Background()
+	utils.GetCollection("events").UpdateOne(ctx, bson.M{"_id": eventID}, bson.M{"$set": bson.M{"queue.opens_at": time.Now().Add(time.Hour)}})
+
+	if _, err := qs.Join(ctx, eventID, primitive.NewObjectID()); !errors.Is(err, ErrQueueNotOpen) {
+		t.Errorf("expected the queue not to be open, got %v", err)
+	}
+
+	// Events without a queue need no token
+	other := insertTestEvent(t, 10)
+	if _, err := qs.VerifyAdmission(ctx, other, primitive.NewObjectID(), ""); err != nil {
+		t.Errorf("expected no token to be needed, got %v", err)
+	}
+	if _, err := qs.Join(ctx, other, primitive.NewObjectID()); !errors.Is(err, ErrQueueNotEnabled) {
+		t.Errorf("expected the event to have no queue, got %v", err)
+	}
+}
@@ -0,0 +1,321 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"eventticketing/config"
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Reconciler finds payments whose callback never arrived, asks the provider what
+// really happened and applies it. It also rechecks payments we cancelled or
+// failed, which is how customers who were charged but got no ticket are found,
+// retries confirming orders whose payment succeeded, and refunds payments
+// whose tickets could not be issued.
+type Reconciler struct {
+	paymentCollection *mongo.Collection
+	reportCollection  *mongo.Collection
+	providers         *PaymentProviders
+	paymentService    *PaymentService
+	refundService     *RefundService
+	interval          time.Duration
+	after             time.Duration
+	lookback          time.Duration
+}
+
+func NewReconciler() *Reconciler {
+	interval := config.AppConfig.Payment.ReconcileInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &Reconciler{
+		paymentCollection: utils.GetCollection("payments"),
+		reportCollection:  utils.GetCollection("reconciliation_reports"),
+		providers:         DefaultPaymentProviders(),
+		paymentService:    NewPaymentService(),
+		refundService:     NewRefundService(),
+		interval:          interval,
+		after:             config.AppConfig.Payment.ReconcileAfter,
+		lookback:          config.AppConfig.Payment.ReconcileLookback,
+	}
+}
+
+// Start runs reconciliation until ctx is cancelled
+func (r *Reconciler) Start(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		if report, err := r.Run(ctx); err != nil {
+			log.Printf("Payment reconciliation failed: %v", err)
+		} else if len(report.Mismatches) > 0 {
+			log.Printf("Payment reconciliation found %d mismatches (%d resolved)", len(report.Mismatches), report.Resolved)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Run checks every candidate payment once and stores a report if any were
+// checked. Payments are only changed through conditional updates, so runs
+// overlapping on other instances cannot apply an outcome twice.
+func (r *Reconciler) Run(ctx context.Context) (*models.ReconciliationReport, error) {
+	report := &models.ReconciliationReport{
+		StartedAt:  time.Now(),
+		Mismatches: []models.ReconciliationMismatch{},
+	}
+
+	now := time.Now()
+	filter := bson.M{
+		// Payments that never reached a provider have nothing to reconcile
+		"momo_ref":      bson.M{"$gt": ""},
+		"reconciled_at": bson.M{"$exists": false},
+		"$or": []bson.M{
+			{"status": "pending", "created_at": bson.M{"$lte": now.Add(-r.after)}},
+			{"status": bson.M{"$in": []string{"cancelled", "failed"}}, "created_at": bson.M{"$gte": now.Add(-r.lookback)}},
+		},
+	}
+	opts := options.Find().SetLimit(500).SetSort(bson.M{"created_at": 1})
+
+	cursor, err := r.paymentCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find payments to reconcile: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var payments []models.Payment
+	if err := cursor.All(ctx, &payments); err != nil {
+		return nil, fmt.Errorf("failed to decode payments to reconcile: %w", err)
+	}
+
+	for i := range payments {
+		report.Checked++
+		if err := r.reconcile(ctx, &payments[i], report); err != nil {
+			log.Printf("Failed to reconcile payment %s: %v", payments[i].ID.Hex(), err)
+			report.Errors++
+		}
+	}
+
+	if err := r.retryConfirmations(ctx, report); err != nil {
+		return nil, err
+	}
+	if err := r.queueRefunds(ctx, report); err != nil {
+		return nil, err
+	}
+
+	report.FinishedAt = time.Now()
+	if report.Checked > 0 {
+		result, err := r.reportCollection.InsertOne(ctx, report)
+		if err != nil {
+			return report, fmt.Errorf("failed to store reconciliation report: %w", err)
+		}
+		report.ID = result.InsertedID.(primitive.ObjectID)
+	}
+	return report, nil
+}
+
+// reconcile compares one payment with its provider, applies the provider's outcome and adds
+// any disagreement to the report
+func (r *Reconciler) reconcile(ctx context.Context, payment *models.Payment, report *models.ReconciliationReport) error {
+	ourStatus := payment.Status
+	mismatch := newMismatch(payment)
+
+	provider, err := r.providers.Get(payment.ProviderName())
+	if err != nil {
+		return err
+	}
+
+	status, err := provider.PaymentStatus(ctx, payment)
+	providerStatus := ""
+	switch {
+	case errors.Is(err, ErrProviderNotFound):
+		// The provider never recorded the request, so the customer was not charged
+		providerStatus = ProviderFailed
+		mismatch.ProviderStatus = "not_found"
+	case err != nil:
+		return err
+	default:
+		providerStatus = status.Status
+		mismatch.ProviderStatus = status.RawStatus
+		mismatch.ProviderAmount = status.Amount
+	}
+
+	if providerStatus == ProviderPending {
+		report.StillPending++
+		return nil
+	}
+
+	if providerStatus == ProviderSuccess && status != nil && status.Amount != "" {
+		amount, err := models.ParseAmount(status.Amount, payment.Currency)
+		if err != nil || amount != payment.Amount {
+			mismatch.Action = models.ReconcileNeedsReview
+			mismatch.Detail = "amount charged does not match the payment"
+			report.Mismatches = append(report.Mismatches, mismatch)
+			return r.markReconciled(ctx, payment)
+		}
+	}
+
+	switch {
+	case providerStatus == ProviderSuccess:
+		_, err := r.paymentService.MarkSucceeded(ctx, payment)
+		if IsUnavailable(err) {
+			mismatch.Action = models.ReconcileNeedsRefund
+			mismatch.Detail = "charged after the hold expired and the tickets could no longer be sold: " + err.Error()
+		} else if err != nil {
+			return err
+		} else {
+			mismatch.Action = models.ReconcileMarkedSuccess
+			report.Resolved++
+		}
+		report.Mismatches = append(report.Mismatches, mismatch)
+
+	case ourStatus == "pending":
+		failed, err := r.paymentService.MarkFailed(ctx, payment)
+		if err != nil {
+			return err
+		}
+		if failed {
+			mismatch.Action = models.ReconcileMarkedFailed
+			report.Resolved++
+			report.Mismatches = append(report.Mismatches, mismatch)
+		}
+	}
+
+	// Cancelled or failed on both sides needs nothing
+	return r.markReconciled(ctx, payment)
+}
+
+// retryConfirmations confirms again the orders of successful payments that
+// were never confirmed, such as when the database failed after the provider's
+// success was recorded
+func (r *Reconciler) retryConfirmations(ctx context.Context, report *models.ReconciliationReport) error {
+	now := time.Now()
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"status":     "success",
+			"refund_due": bson.M{"$ne": true},
+			// Leave the callback that recorded the success time to confirm it
+			"updated_at": bson.M{"$lte": now.Add(-r.after)},
+			"created_at": bson.M{"$gte": now.Add(-r.lookback)},
+		}}},
+		{{Key: "$lookup", Value: bson.M{"from": "orders", "localField": "order_id", "foreignField": "_id", "as": "order"}}},
+		{{Key: "$match", Value: bson.M{"order.0": bson.M{"$exists": true}, "order.paid_at": bson.M{"$exists": false}}}},
+		{{Key: "$project", Value: bson.M{"order": 0}}},
+		{{Key: "$limit", Value: 500}},
+	}
+
+	cursor, err := r.paymentCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return fmt.Errorf("failed to find unconfirmed payments: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var payments []models.Payment
+	if err := cursor.All(ctx, &payments); err != nil {
+		return fmt.Errorf("failed to decode unconfirmed payments: %w", err)
+	}
+
+	for i := range payments {
+		payment := &payments[i]
+		report.Checked++
+		mismatch := newMismatch(payment)
+		mismatch.Detail = "payment succeeded but its order was not confirmed"
+
+		_, err := r.paymentService.MarkSucceeded(ctx, payment)
+		switch {
+		case IsUnavailable(err):
+			mismatch.Action = models.ReconcileNeedsRefund
+			mismatch.Detail = "charged after the hold expired and the tickets could no longer be sold: " + err.Error()
+		case err != nil:
+			log.Printf("Failed to confirm order of payment %s: %v", payment.ID.Hex(), err)
+			report.Errors++
+			continue
+		default:
+			mismatch.Action = models.ReconcileConfirmed
+			report.Resolved++
+		}
+		report.Mismatches = append(report.Mismatches, mismatch)
+	}
+	return nil
+}
+
+// queueRefunds refunds payments that were charged for tickets that could not
+// be issued. The refund worker takes them from there.
+func (r *Reconciler) queueRefunds(ctx context.Context, report *models.ReconciliationReport) error {
+	filter := bson.M{
+		"refund_due": true,
+		"refund_id":  bson.M{"$exists": false},
+	}
+	opts := options.Find().SetLimit(500).SetSort(bson.M{"created_at": 1})
+
+	cursor, err := r.paymentCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return fmt.Errorf("failed to find payments due a refund: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var payments []models.Payment
+	if err := cursor.All(ctx, &payments); err != nil {
+		return fmt.Errorf("failed to decode payments due a refund: %w", err)
+	}
+
+	for i := range payments {
+		payment := &payments[i]
+		report.Checked++
+		refund, err := r.refundService.RefundPayment(ctx, payment, "Tickets were no longer available when the payment arrived", primitive.NilObjectID)
+		if err != nil {
+			log.Printf("Failed to refund payment %s: %v", payment.ID.Hex(), err)
+			report.Errors++
+			continue
+		}
+
+		mismatch := newMismatch(payment)
+		mismatch.Action = models.ReconcileRefundQueued
+		mismatch.Detail = "refund " + refund.ID.Hex() + " " + refund.Status
+		report.Mismatches = append(report.Mismatches, mismatch)
+		report.Resolved++
+	}
+	return nil
+}
+
+// newMismatch starts a report entry for a payment
+func newMismatch(payment *models.Payment) models.ReconciliationMismatch {
+	return models.ReconciliationMismatch{
+		PaymentID:   payment.ID,
+		OrderID:     payment.OrderID,
+		UserID:      payment.UserID,
+		PhoneNumber: payment.PhoneNumber,
+		Provider:    payment.ProviderName(),
+		MoMoRef:     payment.MoMoRef,
+		Amount:      payment.Amount,
+		Currency:    payment.Currency,
+		OurStatus:   payment.Status,
+	}
+}
+
+// markReconciled stops a payment being checked again
+func (r *Reconciler) markReconciled(ctx context.Context, payment *models.Payment) error {
+	_, err := r.paymentCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": payment.ID},
+		bson.M{"$set": bson.M{"reconciled_at": time.Now()}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update payment: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// insertReconcileOrder places a one-ticket order paid for by a MoMo payment
+// with the given status and age
+func insertReconcileOrder(t *testing.T, inventory *InventoryService, eventID primitive.ObjectID, status string, age time.Duration) models.Payment {
+	t.Helper()
+	ctx := context.Background()
+
+	reservation, err := inventory.Reserve(ctx, eventID, primitive.NilObjectID, 1)
+	if err != nil {
+		t.Fatalf("failed to reserve: %v", err)
+	}
+	order, _, err := reservation.PlaceOrder(ctx, primitive.NewObjectID(), nil)
+	if err != nil {
+		t.Fatalf("failed to place order: %v", err)
+	}
+	reservation.Commit()
+
+	payment := models.Payment{
+		ID:          primitive.NewObjectID(),
+		UserID:      order.UserID,
+		EventID:     eventID,
+		OrderID:     order.ID,
+		Amount:      order.TotalAmount,
+		Currency:    order.Currency,
+		Status:      "pending",
+		PaymentType: "momo",
+		MoMoRef:     NewReferenceID(),
+		PhoneNumber: "233240000000",
+		CreatedAt:   time.Now().Add(-age),
+		UpdatedAt:   time.Now().Add(-age),
+	}
+	if _, err := utils.GetCollection("payments").InsertOne(ctx, payment); err != nil {
+		t.Fatalf("failed to insert payment: %v", err)
+	}
+
+	// A hold that lapsed cancels the payment along with the order
+	if status == "cancelled" {
+		if _, err := NewOrderService().ClosePending(ctx, order.ID, "expired"); err != nil {
+			t.Fatalf("failed to expire order: %v", err)
+		}
+		payment.Status = "cancelled"
+	}
+	return payment
+}
+
+// newTestReconciler returns a reconciler asking a fake MoMo, with the default windows
+func newTestReconciler(fake *fakeMoMo) *Reconciler {
+	provider := NewPaymentProviders(NewMoMoProvider(fake.service()))
+	reconciler := NewReconciler()
+	reconciler.providers = provider
+	reconciler.refundService.providers = provider
+	reconciler.after = 10 * time.Minute
+	reconciler.lookback = 7 * 24 * time.Hour
+	return reconciler
+}
+
+func orderStatus(t *testing.T, orderID primitive.ObjectID) string {
+	t.Helper()
+	order, err := NewOrderService().GetOrder(context.Background(), orderID)
+	if err != nil {
+		t.Fatalf("failed to load order: %v", err)
+	}
+	return order.Status
+}
+
+func TestReconcilerAppliesProviderOutcomes(t *testing.T) {
+	inventory := setupInventoryTest(t)
+	eventID := insertTestEvent(t, 10)
+	fake := newFakeMoMo(t)
+	reconciler := newTestReconciler(fake)
+	ctx := context.Background()
+
+	stuck := 20 * time.Minute
+	paid := insertReconcileOrder(t, inventory, eventID, "pending", stuck)
+	fake.setPayment(paid.MoMoRef, "SUCCESSFUL", "10")
+	declined := insertReconcileOrder(t, inventory, eventID, "pending", stuck)
+	fake.setPayment(declined.MoMoRef, "FAILED", "10")
+	chargedAfterExpiry := insertReconcileOrder(t, inventory, eventID, "cancelled", stuck)
+	fake.setPayment(chargedAfterExpiry.MoMoRef, "SUCCESSFUL", "10")
+	recent := insertReconcileOrder(t, inventory, eventID, "pending", time.Minute)
+	fake.setPayment(recent.MoMoRef, "SUCCESSFUL", "10")
+	waiting := insertReconcileOrder(t, inventory, eventID, "pending", stuck)
+	fake.setPayment(waiting.MoMoRef, "PENDING", "10")
+	shortPaid := insertReconcileOrder(t, inventory, eventID, "pending", stuck)
+	fake.setPayment(shortPaid.MoMoRef, "SUCCESSFUL", "5")
+
+	report, err := reconciler.Run(ctx)
+	if err != nil {
+		t.Fatalf("reconciliation failed: %v", err)
+	}
+	if report.Checked != 5 || report.Resolved != 3 || report.StillPending != 1 || report.Errors != 0 {
+		t.Errorf("unexpected report counts: %+v", report)
+	}
+
+	actions := make(map[primitive.ObjectID]string)
+	for _, mismatch := range report.Mismatches {
+		actions[mismatch.PaymentID] = mismatch.Action
+	}
+	expected := map[primitive.ObjectID]string{
+		paid.ID:               models.ReconcileMarkedSuccess,
+		declined.ID:           models.ReconcileMarkedFailed,
+		chargedAfterExpiry.ID: models.ReconcileMarkedSuccess,
+		shortPaid.ID:          models.ReconcileNeedsReview,
+	}
+	if len(actions) != len(expected) {
+		t.Errorf("expected %d mismatches, got %+v", len(expected), report.Mismatches)
+	}
+	for id, action := range expected {
+		if actions[id] != action {
+			t.Errorf("payment %s: expected %s, got %q", id.Hex(), action, actions[id])
+		}
+	}
+
+	if status := orderStatus(t, paid.OrderID); status != "paid" {
+		t.Errorf("expected paid order, got %s", status)
+	}
+	if status := orderStatus(t, declined.OrderID); status != "cancelled" {
+		t.Errorf("expected declined order to be cancelled, got %s", status)
+	}
+	if status := orderStatus(t, chargedAfterExpiry.OrderID); status != "paid" {
+		t.Errorf("expected charged customer's order to be reinstated, got %s", status)
+	}
+	if status := orderStatus(t, shortPaid.OrderID); status != "pending" {
+		t.Errorf("expected amount mismatch to be left for review, got %s", status)
+	}
+	// paid, reinstated, recent, waiting and short-paid hold inventory; declined released it
+	if sold := loadTestEvent(t, eventID).SoldTickets; sold != 5 {
+		t.Errorf("expected 5 tickets sold, got %d", sold)
+	}
+
+	var stored models.ReconciliationReport
+	if err := utils.GetCollection("reconciliation_reports").FindOne(ctx, bson.M{"_id": report.ID}).Decode(&stored); err != nil {
+		t.Fatalf("report was not stored: %v", err)
+	}
+
+	// Only the payment MoMo is still working on is checked again
+	report, err = reconciler.Run(ctx)
+	if err != nil {
+		t.Fatalf("second reconciliation failed: %v", err)
+	}
+	if report.Checked != 1 || report.StillPending != 1 {
+		t.Errorf("expected only the pending payment to be rechecked, got %+v", report)
+	}
+}
+
+func TestReconcilerConfirmsAndRefundsSuccessfulPayments(t *testing.T) {
+	inventory := setupInventoryTest(t)
+	eventID := insertTestEvent(t, 10)
+	fake := newFakeMoMo(t)
+	reconciler := newTestReconciler(fake)
+	ctx := context.Background()
+	payments := utils.GetCollection("payments")
+
+	// The callback recorded the success but confirming the order failed
+	unconfirmed := insertReconcileOrder(t, inventory, eventID, "pending", 20*time.Minute)
+	payments.UpdateOne(ctx, bson.M{"_id": unconfirmed.ID}, bson.M{"$set": bson.M{"status": "success"}})
+
+	// Charged after the tickets had gone
+	soldOut := insertReconcileOrder(t, inventory, eventID, "cancelled", 20*time.Minute)
+	payments.UpdateOne(ctx, bson.M{"_id": soldOut.ID}, bson.M{"$set": bson.M{"status": "success", "refund_due": true, "reconciled_at": time.Now()}})
+
+	report, err := reconciler.Run(ctx)
+	if err != nil {
+		t.Fatalf("reconciliation failed: %v", err)
+	}
+	if report.Checked != 2 || report.Resolved != 2 || report.Errors != 0 {
+		t.Errorf("unexpected report counts: %+v", report)
+	}
+	if status := orderStatus(t, unconfirmed.OrderID); status != "paid" {
+		t.Errorf("expected the unconfirmed order to be paid, got %s", status)
+	}
+
+	stored := loadTestPayment(t, soldOut.ID)
+	if stored.RefundID.IsZero() {
+		t.Fatalf("expected a refund for the sold-out payment, got %+v", stored)
+	}
+	refund, err := reconciler.refundService.GetRefund(ctx, stored.RefundID)
+	if err != nil || refund.Amount != soldOut.Amount || len(fake.transfers) != 1 {
+		t.Errorf("expected the whole payment sent back, got %+v (%v)", refund, err)
+	}
+
+	// Neither is picked up again
+	report, err = reconciler.Run(ctx)
+	if err != nil || report.Checked != 0 {
+		t.Errorf("expected nothing left to reconcile, got %+v (%v)", report, err)
+	}
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// refundPollInterval is how often unsettled refunds are pushed forward
+const refundPollInterval = time.Minute
+
+// RefundWorker submits refunds the provider could not take at request time and polls
+// accepted ones until the provider reports their outcome
+type RefundWorker struct {
+	refundCollection *mongo.Collection
+	refundService    *RefundService
+}
+
+func NewRefundWorker() *RefundWorker {
+	return &RefundWorker{
+		refundCollection: utils.GetCollection("refunds"),
+		refundService:    NewRefundService(),
+	}
+}
+
+// Start runs the worker until ctx is cancelled
+func (rw *RefundWorker) Start(ctx context.Context) {
+	ticker := time.NewTicker(refundPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if settled, err := rw.Poll(ctx); err != nil {
+			log.Printf("Refund poll failed: %v", err)
+		} else if settled > 0 {
+			log.Printf("Settled %d refunds", settled)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll processes every unsettled refund once and returns how many settled.
+// Refund transitions are conditional updates and providers deduplicate transfers
+// by reference, so overlapping polls on other instances are harmless.
+func (rw *RefundWorker) Poll(ctx context.Context) (int, error) {
+	filter := bson.M{"status": bson.M{"$in": []string{"requested", "processing"}}}
+	opts := options.Find().SetLimit(100).SetSort(bson.M{"updated_at": 1})
+
+	cursor, err := rw.refundCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find unsettled refunds: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var refunds []models.Refund
+	if err := cursor.All(ctx, &refunds); err != nil {
+		return 0, fmt.Errorf("failed to decode unsettled refunds: %w", err)
+	}
+
+	settled := 0
+	for i := range refunds {
+		refund, err := rw.refundService.Process(ctx, &refunds[i])
+		if err != nil {
+			log.Printf("Failed to process refund %s: %v", refunds[i].ID.Hex(), err)
+			continue
+		}
+		if refund.IsSettled() {
+			settled++
+		}
+	}
+
+	return settled, nil
+}
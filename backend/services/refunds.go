@@ -0,0 +1,634 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	// ErrRefundNotFound is returned when a refund does not exist
+	ErrRefundNotFound = errors.New("refund not found")
+	// ErrPaymentNotRefundable is returned when a ticket has no successful payment to refund
+	ErrPaymentNotRefundable = errors.New("no successful payment to refund")
+	// ErrInvalidRefundAmount is returned for refunds of zero, negative or more than the ticket price
+	ErrInvalidRefundAmount = errors.New("invalid refund amount")
+	// ErrRefundExceedsPayment is returned when a refund would return more than was paid
+	ErrRefundExceedsPayment = errors.New("refund exceeds the amount left on the payment")
+	// ErrRefundExceedsTicket is returned when a ticket's refunds would add up to more than its price
+	ErrRefundExceedsTicket = errors.New("refund exceeds the amount left on the ticket")
+	// ErrRefundNotRetryable is returned when retrying a refund that has not failed
+	ErrRefundNotRetryable = errors.New("only failed refunds can be retried")
+	// ErrIdempotencyKeyRequired is returned for partial refunds requested without an idempotency key
+	ErrIdempotencyKeyRequired = errors.New("partial refunds need an idempotency key")
+)
+
+// RefundService returns money to buyers through the provider they paid with
+// and settles the refunded tickets
+type RefundService struct {
+	refundCollection  *mongo.Collection
+	ticketCollection  *mongo.Collection
+	paymentCollection *mongo.Collection
+	providers         *PaymentProviders
+	orderService      *OrderService
+	ledgerService     *LedgerService
+	emailService      *EmailService
+}
+
+func NewRefundService() *RefundService {
+	return &RefundService{
+		refundCollection:  utils.GetCollection("refunds"),
+		ticketCollection:  utils.GetCollection("tickets"),
+		paymentCollection: utils.GetCollection("payments"),
+		providers:         DefaultPaymentProviders(),
+		orderService:      NewOrderService(),
+		ledgerService:     NewLedgerService(),
+		emailService:      NewEmailService(),
+	}
+}
+
+// GetRefund fetches a refund
+func (rs *RefundService) GetRefund(ctx context.Context, refundID primitive.ObjectID) (*models.Refund, error) {
+	var refund models.Refund
+	err := rs.refundCollection.FindOne(ctx, bson.M{"_id": refundID}).Decode(&refund)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrRefundNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch refund: %w", err)
+	}
+	return &refund, nil
+}
+
+// RefundTicket refunds amount (what is left of the price when zero) of a
+// cancelled ticket to the phone that paid for it. Requests for the same ticket
+// with the same idempotency key return the same refund; repeating a request
+// whose refund failed retries it. Full refunds default to one key per ticket,
+// but a ticket can be partly refunded more than once, so partial refunds must
+// bring their own key. A ticket's refunds never add up to more than its price.
+func (rs *RefundService) RefundTicket(ctx context.Context, ticket *models.Ticket, amount int64, reason string, requestedBy primitive.ObjectID, idempotencyKey string) (*models.Refund, error) {
+	// Keys are scoped to the ticket, so a key reused on another ticket is a new request
+	scope := ticketRefundScope(ticket.ID)
+	if idempotencyKey == "" {
+		if amount != 0 && amount != ticket.Price {
+			return nil, ErrIdempotencyKeyRequired
+		}
+		idempotencyKey = scope
+	} else {
+		idempotencyKey = scope + ":" + idempotencyKey
+	}
+
+	existing, err := rs.findByKey(ctx, idempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		if existing.Status == "failed" {
+			return rs.Retry(ctx, existing.ID)
+		}
+		return existing, nil
+	}
+
+	if amount == 0 {
+		amount = ticket.Price - ticket.RefundedAmount
+		if amount <= 0 {
+			return nil, ErrRefundExceedsTicket
+		}
+	}
+	if amount < 0 || amount > ticket.Price {
+		return nil, ErrInvalidRefundAmount
+	}
+
+	if ticket.OrderID.IsZero() {
+		return nil, ErrPaymentNotRefundable
+	}
+	var payment models.Payment
+	err = rs.paymentCollection.FindOne(ctx, bson.M{"order_id": ticket.OrderID, "status": "success"}).Decode(&payment)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrPaymentNotRefundable
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch payment: %w", err)
+	}
+
+	if reason == "" {
+		reason = "Ticket refund"
+	}
+	refund := models.Refund{
+		IdempotencyKey: idempotencyKey,
+		PaymentID:      payment.ID,
+		OrderID:        ticket.OrderID,
+		TicketIDs:      []primitive.ObjectID{ticket.ID},
+		UserID:         ticket.UserID,
+		RequestedBy:    requestedBy,
+		Amount:         amount,
+		Currency:       payment.Currency,
+		PhoneNumber:    payment.PhoneNumber,
+		Reason:         reason,
+		Status:         "requested",
+		Provider:       payment.ProviderName(),
+		ProviderRef:    NewReferenceID(),
+		Attempts:       1,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+
+	return rs.create(ctx, &payment, ticket, refund)
+}
+
+// RefundPayment refunds what is left of a payment that was charged but whose
+// tickets could not be issued, and records the refund on the payment. It
+// returns the same refund however often it is called.
+func (rs *RefundService) RefundPayment(ctx context.Context, payment *models.Payment, reason string, requestedBy primitive.ObjectID) (*models.Refund, error) {
+	idempotencyKey := "payment:" + payment.ID.Hex()
+	refund, err := rs.findByKey(ctx, idempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if refund == nil {
+		if payment.Status != "success" {
+			return nil, ErrPaymentNotRefundable
+		}
+		amount := payment.Amount - payment.RefundedAmount
+		if amount <= 0 {
+			return nil, ErrRefundExceedsPayment
+		}
+		tickets, err := rs.orderService.GetTickets(ctx, payment.OrderID)
+		if err != nil {
+			return nil, err
+		}
+		ticketIDs := make([]primitive.ObjectID, 0, len(tickets))
+		for _, ticket := range tickets {
+			ticketIDs = append(ticketIDs, ticket.ID)
+		}
+
+		refund, err = rs.create(ctx, payment, nil, models.Refund{
+			IdempotencyKey: idempotencyKey,
+			PaymentID:      payment.ID,
+			OrderID:        payment.OrderID,
+			TicketIDs:      ticketIDs,
+			UserID:         payment.UserID,
+			RequestedBy:    requestedBy,
+			Amount:         amount,
+			Currency:       payment.Currency,
+			PhoneNumber:    payment.PhoneNumber,
+			Reason:         reason,
+			Status:         "requested",
+			Provider:       payment.ProviderName(),
+			ProviderRef:    NewReferenceID(),
+			Attempts:       1,
+			CreatedAt:      time.Now(),
+			UpdatedAt:      time.Now(),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = rs.paymentCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": payment.ID},
+		bson.M{"$set": bson.M{"refund_id": refund.ID, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return refund, fmt.Errorf("failed to update payment: %w", err)
+	}
+	payment.RefundID = refund.ID
+	return refund, nil
+}
+
+// create stores a new refund against a payment, and the ticket it refunds if
+// there is one, and submits it. A concurrent request with the same idempotency
+// key gets the refund that was stored first.
+func (rs *RefundService) create(ctx context.Context, payment *models.Payment, ticket *models.Ticket, refund models.Refund) (*models.Refund, error) {
+	// The amount is reserved before the refund exists, so the worker can never
+	// submit a refund that turns out to overdraw the ticket or the payment
+	if ticket != nil {
+		if err := rs.reserveTicketAmount(ctx, ticket.ID, ticket.Price, refund.Amount); err != nil {
+			return nil, err
+		}
+	}
+	release := func() {
+		if ticket != nil {
+			rs.releaseTicketAmount(ctx, ticket.ID, refund.Amount)
+		}
+	}
+	if err := rs.reserveAmount(ctx, payment, refund.Amount); err != nil {
+		release()
+		return nil, err
+	}
+
+	result, err := rs.refundCollection.InsertOne(ctx, refund)
+	if err != nil {
+		rs.releaseAmount(ctx, payment.ID, refund.Amount)
+		release()
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, fmt.Errorf("failed to create refund: %w", err)
+		}
+		// A concurrent request with the same key won the race
+		existing, err := rs.findByKey(ctx, refund.IdempotencyKey)
+		if err != nil {
+			return nil, err
+		}
+		if existing == nil {
+			return nil, ErrRefundNotFound
+		}
+		return existing, nil
+	}
+	refund.ID = result.InsertedID.(primitive.ObjectID)
+
+	return rs.Process(ctx, &refund)
+}
+
+// Retry resubmits a failed refund under a new provider reference
+func (rs *RefundService) Retry(ctx context.Context, refundID primitive.ObjectID) (*models.Refund, error) {
+	refund, err := rs.GetRefund(ctx, refundID)
+	if err != nil {
+		return nil, err
+	}
+	if refund.Status != "failed" {
+		return nil, ErrRefundNotRetryable
+	}
+
+	var payment models.Payment
+	if err := rs.paymentCollection.FindOne(ctx, bson.M{"_id": refund.PaymentID}).Decode(&payment); err != nil {
+		return nil, fmt.Errorf("failed to fetch payment: %w", err)
+	}
+	ticketID, perTicket := refundedTicket(refund)
+	if perTicket {
+		var ticket models.Ticket
+		if err := rs.ticketCollection.FindOne(ctx, bson.M{"_id": ticketID}).Decode(&ticket); err != nil {
+			return nil, fmt.Errorf("failed to fetch ticket: %w", err)
+		}
+		if err := rs.reserveTicketAmount(ctx, ticketID, ticket.Price, refund.Amount); err != nil {
+			return nil, err
+		}
+	}
+	if err := rs.reserveAmount(ctx, &payment, refund.Amount); err != nil {
+		if perTicket {
+			rs.releaseTicketAmount(ctx, ticketID, refund.Amount)
+		}
+		return nil, err
+	}
+
+	// Providers treat a failed transfer as final, so the retry needs a fresh reference
+	providerRef := NewReferenceID()
+	result, err := rs.refundCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": refundID, "status": "failed"},
+		bson.M{
+			"$set": bson.M{
+				"status":       "requested",
+				"provider_ref": providerRef,
+				"updated_at":   time.Now(),
+			},
+			"$inc":   bson.M{"attempts": 1},
+			"$unset": bson.M{"failure_reason": ""},
+		},
+	)
+	if err != nil || result.ModifiedCount == 0 {
+		rs.releaseAmount(ctx, refund.PaymentID, refund.Amount)
+		if perTicket {
+			rs.releaseTicketAmount(ctx, ticketID, refund.Amount)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to update refund: %w", err)
+		}
+		return nil, ErrRefundNotRetryable
+	}
+
+	refund.Status = "requested"
+	refund.ProviderRef = providerRef
+	refund.Attempts++
+	refund.FailureReason = ""
+	return rs.Process(ctx, refund)
+}
+
+// Process moves a refund forward as far as its provider allows right now: it
+// submits requested refunds and settles processing ones whose outcome is
+// known. A provider outage leaves the refund where it is for the worker to
+// pick up; a provider that cannot refund at all fails it.
+func (rs *RefundService) Process(ctx context.Context, refund *models.Refund) (*models.Refund, error) {
+	provider, err := rs.providers.Get(refund.ProviderName())
+	if err != nil {
+		log.Printf("Refund %s cannot be processed: %v", refund.ID.Hex(), err)
+		return refund, nil
+	}
+
+	if refund.Status == "requested" {
+		err := provider.Refund(ctx, refund)
+		if errors.Is(err, ErrProviderRejected) || errors.Is(err, ErrNotSupported) {
+			return refund, rs.fail(ctx, refund, err.Error())
+		}
+		if err != nil {
+			log.Printf("Refund %s not submitted yet: %v", refund.ID.Hex(), err)
+			return refund, nil
+		}
+		if err := rs.setStatus(ctx, refund, "requested", "processing"); err != nil {
+			return refund, err
+		}
+	}
+
+	if refund.Status != "processing" {
+		return refund, nil
+	}
+
+	status, err := provider.RefundStatus(ctx, refund)
+	if errors.Is(err, ErrProviderNotFound) {
+		// The provider lost or never recorded the transfer; submit it again under the same reference
+		return refund, rs.setStatus(ctx, refund, "processing", "requested")
+	}
+	if err != nil {
+		log.Printf("Refund %s status unavailable: %v", refund.ID.Hex(), err)
+		return refund, nil
+	}
+
+	switch status.Status {
+	case ProviderSuccess:
+		return refund, rs.complete(ctx, refund, status.TransactionID)
+	case ProviderFailed:
+		reason := status.Reason
+		if reason == "" {
+			reason = "Transfer failed"
+		}
+		return refund, rs.fail(ctx, refund, reason)
+	}
+	return refund, nil
+}
+
+// complete records the provider's confirmation and only then posts the refund
+// to the ledger, marks the tickets refunded and emails the buyer
+func (rs *RefundService) complete(ctx context.Context, refund *models.Refund, transactionID string) error {
+	now := time.Now()
+	result, err := rs.refundCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": refund.ID, "status": "processing"},
+		bson.M{"$set": bson.M{
+			"status":                  "completed",
+			"provider_transaction_id": transactionID,
+			"completed_at":            now,
+			"updated_at":              now,
+		}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update refund: %w", err)
+	}
+	if result.ModifiedCount == 0 {
+		return nil
+	}
+	refund.Status = "completed"
+	refund.ProviderTransactionID = transactionID
+	refund.CompletedAt = &now
+
+	if err := rs.ledgerService.RecordRefund(ctx, refund); err != nil {
+		return err
+	}
+
+	if err := rs.markRefunded(ctx, refund.TicketIDs); err != nil {
+		return err
+	}
+
+	completed := *refund
+	go func() {
+		if err := rs.emailService.SendRefundNotice(context.Background(), &completed); err != nil {
+			log.Printf("Failed to email refund %s: %v", completed.ID.Hex(), err)
+		}
+	}()
+
+	return rs.orderService.SyncStatus(ctx, refund.OrderID)
+}
+
+// fail records a refund the provider will not complete and frees its amount on the payment
+func (rs *RefundService) fail(ctx context.Context, refund *models.Refund, reason string) error {
+	result, err := rs.refundCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": refund.ID, "status": bson.M{"$in": []string{"requested", "processing"}}},
+		bson.M{"$set": bson.M{
+			"status":         "failed",
+			"failure_reason": reason,
+			"updated_at":     time.Now(),
+		}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update refund: %w", err)
+	}
+	if result.ModifiedCount == 0 {
+		return nil
+	}
+	refund.Status = "failed"
+	refund.FailureReason = reason
+
+	if ticketID, ok := refundedTicket(refund); ok {
+		if err := rs.releaseTicketAmount(ctx, ticketID, refund.Amount); err != nil {
+			return err
+		}
+	}
+	return rs.releaseAmount(ctx, refund.PaymentID, refund.Amount)
+}
+
+// markRefunded marks cancelled tickets refunded once their completed refunds
+// add up to their price; a partly refunded ticket stays cancelled so the rest
+// can still be refunded
+func (rs *RefundService) markRefunded(ctx context.Context, ticketIDs []primitive.ObjectID) error {
+	for _, ticketID := range ticketIDs {
+		var ticket models.Ticket
+		if err := rs.ticketCollection.FindOne(ctx, bson.M{"_id": ticketID}).Decode(&ticket); err != nil {
+			return fmt.Errorf("failed to fetch ticket: %w", err)
+		}
+		if ticket.Status != "cancelled" {
+			continue
+		}
+
+		completed, err := rs.sumRefunds(ctx, bson.M{"ticket_ids": ticketID, "status": "completed"})
+		if err != nil {
+			return err
+		}
+		if completed < ticket.Price {
+			continue
+		}
+
+		_, err = rs.ticketCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": ticketID, "status": "cancelled"},
+			bson.M{"$set": bson.M{
+				"status":     "refunded",
+				"updated_at": time.Now(),
+			}},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to update ticket: %w", err)
+		}
+	}
+	return nil
+}
+
+// sumRefunds adds up the amounts of the refunds matching filter
+func (rs *RefundService) sumRefunds(ctx context.Context, filter bson.M) (int64, error) {
+	cursor, err := rs.refundCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "total": bson.M{"$sum": "$amount"}}}},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to sum refunds: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var totals []struct {
+		Total int64 `bson:"total"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return 0, fmt.Errorf("failed to decode refund total: %w", err)
+	}
+	if len(totals) == 0 {
+		return 0, nil
+	}
+	return totals[0].Total, nil
+}
+
+// setStatus moves a refund between in-flight statuses if it is still in "from"
+func (rs *RefundService) setStatus(ctx context.Context, refund *models.Refund, from, to string) error {
+	_, err := rs.refundCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": refund.ID, "status": from},
+		bson.M{"$set": bson.M{
+			"status":     to,
+			"updated_at": time.Now(),
+		}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update refund: %w", err)
+	}
+	refund.Status = to
+	return nil
+}
+
+// reserveAmount commits amount of a payment to a refund, failing if that
+// would refund more than was paid. The check and increment are one
+// conditional update, so concurrent partial refunds cannot overdraw it.
+func (rs *RefundService) reserveAmount(ctx context.Context, payment *models.Payment, amount int64) error {
+	limit := payment.Amount - amount
+	if limit < 0 {
+		return ErrRefundExceedsPayment
+	}
+	result, err := rs.paymentCollection.UpdateOne(
+		ctx,
+		bson.M{
+			"_id": payment.ID,
+			"$or": []bson.M{
+				{"refunded_amount": bson.M{"$lte": limit}},
+				{"refunded_amount": bson.M{"$exists": false}},
+			},
+		},
+		bson.M{
+			"$inc": bson.M{"refunded_amount": amount},
+			"$set": bson.M{"updated_at": time.Now()},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update payment: %w", err)
+	}
+	if result.ModifiedCount == 0 {
+		return ErrRefundExceedsPayment
+	}
+	return nil
+}
+
+// reserveTicketAmount commits amount of a ticket's price to a refund, failing
+// if the ticket's refunds would add up to more than its price
+func (rs *RefundService) reserveTicketAmount(ctx context.Context, ticketID primitive.ObjectID, price, amount int64) error {
+	limit := price - amount
+	if limit < 0 {
+		return ErrRefundExceedsTicket
+	}
+	result, err := rs.ticketCollection.UpdateOne(
+		ctx,
+		bson.M{
+			"_id": ticketID,
+			"$or": []bson.M{
+				{"refunded_amount": bson.M{"$lte": limit}},
+				{"refunded_amount": bson.M{"$exists": false}},
+			},
+		},
+		bson.M{
+			"$inc": bson.M{"refunded_amount": amount},
+			"$set": bson.M{"updated_at": time.Now()},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update ticket: %w", err)
+	}
+	if result.ModifiedCount == 0 {
+		return ErrRefundExceedsTicket
+	}
+	return nil
+}
+
+// releaseTicketAmount returns the amount of a failed refund to its ticket
+func (rs *RefundService) releaseTicketAmount(ctx context.Context, ticketID primitive.ObjectID, amount int64) error {
+	_, err := rs.ticketCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": ticketID},
+		bson.M{
+			"$inc": bson.M{"refunded_amount": -amount},
+			"$set": bson.M{"updated_at": time.Now()},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update ticket: %w", err)
+	}
+	return nil
+}
+
+// ticketRefundScope prefixes the idempotency keys of a ticket's refunds
+func ticketRefundScope(ticketID primitive.ObjectID) string {
+	return "ticket:" + ticketID.Hex()
+}
+
+// refundedTicket returns the ticket a refund was requested for, when it was
+// requested for one ticket and so holds part of that ticket's price
+func refundedTicket(refund *models.Refund) (primitive.ObjectID, bool) {
+	if len(refund.TicketIDs) != 1 || !strings.HasPrefix(refund.IdempotencyKey, ticketRefundScope(refund.TicketIDs[0])) {
+		return primitive.NilObjectID, false
+	}
+	return refund.TicketIDs[0], true
+}
+
+// releaseAmount returns the amount of a failed refund to the payment
+func (rs *RefundService) releaseAmount(ctx context.Context, paymentID primitive.ObjectID, amount int64) error {
+	_, err := rs.paymentCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": paymentID},
+		bson.M{
+			"$inc": bson.M{"refunded_amount": -amount},
+			"$set": bson.M{"updated_at": time.Now()},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update payment: %w", err)
+	}
+	return nil
+}
+
+// findByKey returns the refund with an idempotency key, or nil if there is none
+func (rs *RefundService) findByKey(ctx context.Context, key string) (*models.Refund, error) {
+	var refund models.Refund
+	err := rs.refundCollection.FindOne(ctx, bson.M{"idempotency_key": key}).Decode(&refund)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch refund: %w", err)
+	}
+	return &refund, nil
+}
@@ -0,0 +1,270 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// setupRefundTest returns a refund service talking to a fake MoMo and a paid
+// order of quantity tickets at GHS 10.00 each, with the first ticket cancelled
+func setupRefundTest(t *testing.T, quantity int) (*RefundService, *fakeMoMo, []models.Ticket) {
+	t.Helper()
+	inventory := setupInventoryTest(t)
+	ctx := context.Background()
+
+	_, err := utils.GetCollection("refunds").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "idempotency_key", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		t.Fatalf("failed to create refund index: %v", err)
+	}
+
+	reservation, err := inventory.Reserve(ctx, insertTestEvent(t, 10), primitive.NilObjectID, quantity)
+	if err != nil {
+		t.Fatalf("failed to reserve: %v", err)
+	}
+	order, tickets, err := reservation.PlaceOrder(ctx, primitive.NewObjectID(), nil)
+	if err != nil {
+		t.Fatalf("failed to place order: %v", err)
+	}
+	reservation.Commit()
+	if err := NewOrderService().Confirm(ctx, order.ID); err != nil {
+		t.Fatalf("failed to confirm order: %v", err)
+	}
+
+	payment := models.Payment{
+		UserID:      order.UserID,
+		EventID:     order.EventID,
+		OrderID:     order.ID,
+		Amount:      order.TotalAmount,
+		Currency:    order.Currency,
+		Status:      "success",
+		PaymentType: "momo",
+		PhoneNumber: "233240000000",
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if _, err := utils.GetCollection("payments").InsertOne(ctx, payment); err != nil {
+		t.Fatalf("failed to insert payment: %v", err)
+	}
+
+	_, err = utils.GetCollection("tickets").UpdateOne(ctx, bson.M{"_id": tickets[0].ID}, bson.M{"$set": bson.M{"status": "cancelled"}})
+	if err != nil {
+		t.Fatalf("failed to cancel ticket: %v", err)
+	}
+	tickets[0].Status = "cancelled"
+
+	fake := newFakeMoMo(t)
+	refunds := NewRefundService()
+	refunds.providers = NewPaymentProviders(NewMoMoProvider(fake.service()))
+	return refunds, fake, tickets
+}
+
+func ticketStatus(t *testing.T, id primitive.ObjectID) string {
+	t.Helper()
+	var ticket models.Ticket
+	if err := utils.GetCollection("tickets").FindOne(context.Background(), bson.M{"_id": id}).Decode(&ticket); err != nil {
+		t.Fatalf("failed to load ticket: %v", err)
+	}
+	return ticket.Status
+}
+
+func refundedAmount(t *testing.T, orderID primitive.ObjectID) int64 {
+	t.Helper()
+	var payment models.Payment
+	if err := utils.GetCollection("payments").FindOne(context.Background(), bson.M{"order_id": orderID}).Decode(&payment); err != nil {
+		t.Fatalf("failed to load payment: %v", err)
+	}
+	return payment.RefundedAmount
+}
+
+func TestRefundCompletesOnlyAfterProviderConfirms(t *testing.T) {
+	refunds, fake, tickets := setupRefundTest(t, 2)
+	worker := &RefundWorker{refundCollection: refunds.refundCollection, refundService: refunds}
+	ctx := context.Background()
+
+	fake.outcome = "PENDING"
+	refund, err := refunds.RefundTicket(ctx, &tickets[0], 0, "", primitive.NewObjectID(), "")
+	if err != nil {
+		t.Fatalf("failed to request refund: %v", err)
+	}
+	if refund.Status != "processing" || refund.Amount != 1000 {
+		t.Fatalf("expected a processing refund of 1000, got %s of %v", refund.Status, refund.Amount)
+	}
+	if status := ticketStatus(t, tickets[0].ID); status != "cancelled" {
+		t.Errorf("ticket marked %s before MoMo confirmed", status)
+	}
+
+	if settled, err := worker.Poll(ctx); err != nil || settled != 0 {
+		t.Fatalf("expected nothing to settle while MoMo is pending, got %d, %v", settled, err)
+	}
+
+	fake.settle("SUCCESSFUL")
+	if settled, err := worker.Poll(ctx); err != nil || settled != 1 {
+		t.Fatalf("expected the refund to settle, got %d, %v", settled, err)
+	}
+
+	refund, _ = refunds.GetRefund(ctx, refund.ID)
+	if refund.Status != "completed" || refund.ProviderTransactionID == "" {
+		t.Errorf("unexpected refund after confirmation: %+v", refund)
+	}
+	if status := ticketStatus(t, tickets[0].ID); status != "refunded" {
+		t.Errorf("expected refunded ticket, got %s", status)
+	}
+	if status := ticketStatus(t, tickets[1].ID); status != "paid" {
+		t.Errorf("expected the other admission to stay paid, got %s", status)
+	}
+	order, _ := refunds.orderService.GetOrder(ctx, tickets[0].OrderID)
+	if order.Status != "partially_refunded" {
+		t.Errorf("expected partially_refunded order, got %s", order.Status)
+	}
+}
+
+func TestRefundRetriesAreIdempotent(t *testing.T) {
+	refunds, fake, tickets := setupRefundTest(t, 1)
+	worker := &RefundWorker{refundCollection: refunds.refundCollection, refundService: refunds}
+	ctx := context.Background()
+
+	// MoMo is down when the refund is requested
+	fake.failNext = 1
+	first, err := refunds.RefundTicket(ctx, &tickets[0], 0, "", primitive.NewObjectID(), "")
+	if err != nil {
+		t.Fatalf("failed to request refund: %v", err)
+	}
+	if first.Status != "requested" {
+		t.Fatalf("expected refund to wait for MoMo, got %s", first.Status)
+	}
+
+	// Asking again returns the same refund rather than refunding twice
+	second, err := refunds.RefundTicket(ctx, &tickets[0], 0, "", primitive.NewObjectID(), "")
+	if err != nil {
+		t.Fatalf("failed to repeat refund request: %v", err)
+	}
+	if second.ID != first.ID {
+		t.Fatalf("expected the same refund, got %s and %s", first.ID.Hex(), second.ID.Hex())
+	}
+	if n, _ := refunds.refundCollection.CountDocuments(ctx, bson.M{}); n != 1 {
+		t.Errorf("expected one refund record, got %d", n)
+	}
+
+	if _, err := worker.Poll(ctx); err != nil {
+		t.Fatalf("poll failed: %v", err)
+	}
+	if _, err := worker.Poll(ctx); err != nil {
+		t.Fatalf("poll failed: %v", err)
+	}
+
+	refund, _ := refunds.GetRefund(ctx, first.ID)
+	if refund.Status != "completed" {
+		t.Errorf("expected completed refund, got %s", refund.Status)
+	}
+	if len(fake.transfers) != 1 {
+		t.Errorf("expected exactly one transfer at MoMo, got %d", len(fake.transfers))
+	}
+	if amount := refundedAmount(t, tickets[0].OrderID); amount != 1000 {
+		t.Errorf("expected 1000 refunded on the payment, got %v", amount)
+	}
+}
+
+func TestRefundsAreLimitedPerTicket(t *testing.T) {
+	refunds, fake, tickets := setupRefundTest(t, 2)
+	ctx := context.Background()
+	admin := primitive.NewObjectID()
+
+	// A cancellation's refund is still with MoMo
+	fake.outcome = "PENDING"
+	pending, err := refunds.RefundTicket(ctx, &tickets[0], 0, "", admin, "")
+	if err != nil || pending.Status != "processing" {
+		t.Fatalf("expected a processing refund, got %+v, %v", pending, err)
+	}
+
+	// Another key cannot refund the same ticket again
+	if _, err := refunds.RefundTicket(ctx, &tickets[0], 1000, "", admin, "again"); !errors.Is(err, ErrRefundExceedsTicket) {
+		t.Errorf("expected ErrRefundExceedsTicket, got %v", err)
+	}
+	if _, err := refunds.RefundTicket(ctx, &tickets[0], 300, "", admin, "part"); !errors.Is(err, ErrRefundExceedsTicket) {
+		t.Errorf("expected ErrRefundExceedsTicket for a partial refund on top, got %v", err)
+	}
+	if amount := refundedAmount(t, tickets[0].OrderID); amount != 1000 {
+		t.Errorf("expected only the first ticket's price committed on the payment, got %v", amount)
+	}
+
+	// The same key on another ticket is a separate request
+	if _, err := utils.GetCollection("tickets").UpdateOne(ctx, bson.M{"_id": tickets[1].ID}, bson.M{"$set": bson.M{"status": "cancelled"}}); err != nil {
+		t.Fatalf("failed to cancel ticket: %v", err)
+	}
+	shared, err := refunds.RefundTicket(ctx, &tickets[0], 400, "", admin, "shared")
+	if !errors.Is(err, ErrRefundExceedsTicket) {
+		t.Fatalf("expected ErrRefundExceedsTicket, got %+v, %v", shared, err)
+	}
+	other, err := refunds.RefundTicket(ctx, &tickets[1], 400, "", admin, "shared")
+	if err != nil || other.ID == pending.ID || other.TicketIDs[0] != tickets[1].ID {
+		t.Errorf("expected a new refund for the second ticket, got %+v, %v", other, err)
+	}
+}
+
+func TestPartialAndFailedRefunds(t *testing.T) {
+	refunds, fake, tickets := setupRefundTest(t, 1)
+	ctx := context.Background()
+	admin := primitive.NewObjectID()
+
+	if _, err := refunds.RefundTicket(ctx, &tickets[0], 1100, "", admin, "too-much"); !errors.Is(err, ErrInvalidRefundAmount) {
+		t.Errorf("expected ErrInvalidRefundAmount, got %v", err)
+	}
+
+	if _, err := refunds.RefundTicket(ctx, &tickets[0], 400, "Goodwill", admin, ""); !errors.Is(err, ErrIdempotencyKeyRequired) {
+		t.Errorf("expected ErrIdempotencyKeyRequired for a partial refund without a key, got %v", err)
+	}
+
+	partial, err := refunds.RefundTicket(ctx, &tickets[0], 400, "Goodwill", admin, "partial-1")
+	if err != nil || partial.Status != "completed" {
+		t.Fatalf("expected completed partial refund, got %+v, %v", partial, err)
+	}
+	if status := ticketStatus(t, tickets[0].ID); status != "cancelled" {
+		t.Errorf("expected a partly refunded ticket to stay cancelled, got %s", status)
+	}
+	if _, err := refunds.RefundTicket(ctx, &tickets[0], 800, "", admin, "partial-2"); !errors.Is(err, ErrRefundExceedsTicket) {
+		t.Errorf("expected ErrRefundExceedsTicket, got %v", err)
+	}
+
+	fake.outcome = "FAILED"
+	failed, err := refunds.RefundTicket(ctx, &tickets[0], 600, "", admin, "partial-3")
+	if err != nil {
+		t.Fatalf("failed to request refund: %v", err)
+	}
+	if failed.Status != "failed" || failed.FailureReason == "" {
+		t.Errorf("expected failed refund with a reason, got %+v", failed)
+	}
+	if amount := refundedAmount(t, tickets[0].OrderID); amount != 400 {
+		t.Errorf("expected a failed refund to free its amount, refunded %v", amount)
+	}
+
+	fake.outcome = "SUCCESSFUL"
+	retried, err := refunds.Retry(ctx, failed.ID)
+	if err != nil {
+		t.Fatalf("retry failed: %v", err)
+	}
+	if retried.Status != "completed" || retried.Attempts != 2 || retried.ProviderRef == failed.ProviderRef {
+		t.Errorf("unexpected retried refund: %+v", retried)
+	}
+	if _, err := refunds.Retry(ctx, failed.ID); !errors.Is(err, ErrRefundNotRetryable) {
+		t.Errorf("expected ErrRefundNotRetryable, got %v", err)
+	}
+	if amount := refundedAmount(t, tickets[0].OrderID); amount != 1000 {
+		t.Errorf("expected 1000 refunded on the payment, got %v", amount)
+	}
+	if status := ticketStatus(t, tickets[0].ID); status != "refunded" {
+		t.Errorf("expected the ticket refunded once its refunds reach its price, got %s", status)
+	}
+}
@@ -0,0 +1,257 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	// ErrVenueNotFound is returned when an event's seat map does not exist
+	ErrVenueNotFound = errors.New("venue not found")
+	// ErrSeatNotFound is returned when a selected seat is not part of the venue
+	ErrSeatNotFound = errors.New("seat not found")
+	// ErrSeatUnavailable is returned when a selected seat is held or sold by someone else
+	ErrSeatUnavailable = errors.New("seat is no longer available")
+	// ErrSeatCountMismatch is returned when the number of selected seats differs from the quantity
+	ErrSeatCountMismatch = errors.New("number of seats must match the quantity")
+	// ErrSeatingNotAvailable is returned when seats are selected for a general admission event
+	ErrSeatingNotAvailable = errors.New("event does not have reserved seating")
+)
+
+// bestAvailableAttempts bounds retries when automatically picked seats are taken concurrently
+const bestAvailableAttempts = 3
+
+// SeatingService manages venue seat maps and the per-seat locks of reserved-seating events.
+// Every seat of an event has at most one lock document, enforced by a unique index,
+// so two buyers can never hold the same seat.
+type SeatingService struct {
+	venueCollection    *mongo.Collection
+	seatLockCollection *mongo.Collection
+}
+
+func NewSeatingService() *SeatingService {
+	return &SeatingService{
+		venueCollection:    utils.GetCollection("venues"),
+		seatLockCollection: utils.GetCollection("seat_locks"),
+	}
+}
+
+// LoadVenue fetches a venue's seat map
+func (ss *SeatingService) LoadVenue(ctx context.Context, venueID primitive.ObjectID) (*models.Venue, error) {
+	var venue models.Venue
+	err := ss.venueCollection.FindOne(ctx, bson.M{"_id": venueID}).Decode(&venue)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrVenueNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load venue: %w", err)
+	}
+	return &venue, nil
+}
+
+// ResolveSeats checks that the selected seats exist, can be sold and match the quantity
+func (ss *SeatingService) ResolveSeats(venue *models.Venue, seatIDs []string, quantity int) ([]models.TicketSeat, error) {
+	if len(seatIDs) != quantity {
+		return nil, ErrSeatCountMismatch
+	}
+
+	seen := make(map[string]bool, len(seatIDs))
+	seats := make([]models.TicketSeat, 0, len(seatIDs))
+	for _, seatID := range seatIDs {
+		seat, label, ok := venue.FindSeat(seatID)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrSeatNotFound, seatID)
+		}
+		if seat.Blocked || seen[seatID] {
+			return nil, fmt.Errorf("%w: %s", ErrSeatUnavailable, seatID)
+		}
+		seen[seatID] = true
+		seats = append(seats, models.TicketSeat{SeatID: seatID, Label: label})
+	}
+	return seats, nil
+}
+
+// Availability returns the event's seat map with the state of every seat
+func (ss *SeatingService) Availability(ctx context.Context, event *models.Event) ([]models.SectionAvailability, error) {
+	venue, err := ss.LoadVenue(ctx, event.VenueID)
+	if err != nil {
+		return nil, err
+	}
+	locks, err := ss.activeLocks(ctx, event.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	sections := make([]models.SectionAvailability, 0, len(venue.Sections))
+	for _, section := range venue.Sections {
+		sectionAvailability := models.SectionAvailability{Name: section.Name}
+		for _, row := range section.Rows {
+			rowAvailability := models.RowAvailability{Label: row.Label}
+			for _, seat := range row.Seats {
+				status := "available"
+				if seat.Blocked {
+					status = "blocked"
+				} else if lockStatus, locked := locks[seat.ID]; locked {
+					status = lockStatus
+				} else {
+					sectionAvailability.Available++
+				}
+				rowAvailability.Seats = append(rowAvailability.Seats, models.SeatAvailability{
+					Seat:   seat,
+					Label:  models.SeatLabel(section.Name, row.Label, seat.Number),
+					Status: status,
+				})
+			}
+			sectionAvailability.Rows = append(sectionAvailability.Rows, rowAvailability)
+		}
+		sections = append(sections, sectionAvailability)
+	}
+	return sections, nil
+}
+
+// activeLocks maps the seat IDs of an event that are sold or held to their lock status
+func (ss *SeatingService) activeLocks(ctx context.Context, eventID primitive.ObjectID) (map[string]string, error) {
+	cursor, err := ss.seatLockCollection.Find(ctx, bson.M{
+		"event_id": eventID,
+		"$or": []bson.M{
+			{"status": models.SeatSold},
+			{"status": models.SeatHeld, "expires_at": bson.M{"$gt": time.Now()}},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load seat locks: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var locks []models.SeatLock
+	if err := cursor.All(ctx, &locks); err != nil {
+		return nil, fmt.Errorf("failed to decode seat locks: %w", err)
+	}
+
+	statuses := make(map[string]string, len(locks))
+	for _, lock := range locks {
+		statuses[lock.SeatID] = lock.Status
+	}
+	return statuses, nil
+}
+
+// BestAvailable picks quantity free seats in seat map order. Wheelchair spaces
+// are left for buyers who select them explicitly.
+func (ss *SeatingService) BestAvailable(ctx context.Context, event *models.Event, venue *models.Venue, quantity int) ([]models.TicketSeat, error) {
+	locks, err := ss.activeLocks(ctx, event.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var seats []models.TicketSeat
+	for _, section := range venue.Sections {
+		for _, row := range section.Rows {
+			for _, seat := range row.Seats {
+				if seat.Blocked || seat.Wheelchair {
+					continue
+				}
+				if _, locked := locks[seat.ID]; locked {
+					continue
+				}
+				seats = append(seats, models.TicketSeat{
+					SeatID: seat.ID,
+					Label:  models.SeatLabel(section.Name, row.Label, seat.Number),
+				})
+				if len(seats) == quantity {
+					return seats, nil
+				}
+			}
+		}
+	}
+	return nil, ErrSeatUnavailable
+}
+
+// ClaimSeats locks every seat for a ticket or none of them. A seat can be
+// claimed if it is free, if its lock belongs to the same ticket, or if another
+// buyer's hold on it has lapsed. Pass SeatHeld with an expiry to hold seats
+// during checkout and SeatSold without one once the ticket is paid.
+func (ss *SeatingService) ClaimSeats(ctx context.Context, eventID, ticketID, userID primitive.ObjectID, seats []models.TicketSeat, status string, expiresAt *time.Time) error {
+	var claimed []string
+	for _, seat := range seats {
+		if err := ss.claimSeat(ctx, eventID, ticketID, userID, seat.SeatID, status, expiresAt); err != nil {
+			if len(claimed) > 0 {
+				ss.seatLockCollection.DeleteMany(ctx, bson.M{
+					"event_id":  eventID,
+					"ticket_id": ticketID,
+					"seat_id":   bson.M{"$in": claimed},
+				})
+			}
+			return err
+		}
+		claimed = append(claimed, seat.SeatID)
+	}
+	return nil
+}
+
+func (ss *SeatingService) claimSeat(ctx context.Context, eventID, ticketID, userID primitive.ObjectID, seatID, status string, expiresAt *time.Time) error {
+	now := time.Now()
+	lock := models.SeatLock{
+		EventID:   eventID,
+		SeatID:    seatID,
+		TicketID:  ticketID,
+		UserID:    userID,
+		Status:    status,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	_, err := ss.seatLockCollection.InsertOne(ctx, lock)
+	if err == nil {
+		return nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("failed to lock seat: %w", err)
+	}
+
+	// The seat already has a lock; take it over only if it is ours or has lapsed
+	update := bson.M{"$set": bson.M{
+		"ticket_id":  ticketID,
+		"user_id":    userID,
+		"status":     status,
+		"updated_at": now,
+	}}
+	if expiresAt != nil {
+		update["$set"].(bson.M)["expires_at"] = expiresAt
+	} else {
+		update["$unset"] = bson.M{"expires_at": ""}
+	}
+
+	result, err := ss.seatLockCollection.UpdateOne(ctx, bson.M{
+		"event_id": eventID,
+		"seat_id":  seatID,
+		"$or": []bson.M{
+			{"ticket_id": ticketID},
+			{"status": models.SeatHeld, "expires_at": bson.M{"$lte": now}},
+		},
+	}, update)
+	if err != nil {
+		return fmt.Errorf("failed to lock seat: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("%w: %s", ErrSeatUnavailable, seatID)
+	}
+	return nil
+}
+
+// ReleaseSeats frees every seat locked for a ticket
+func (ss *SeatingService) ReleaseSeats(ctx context.Context, ticketID primitive.ObjectID) error {
+	_, err := ss.seatLockCollection.DeleteMany(ctx, bson.M{"ticket_id": ticketID})
+	if err != nil {
+		return fmt.Errorf("failed to release seats: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func insertSeatedTestEvent(t *testing.T) (primitive.ObjectID, *models.Venue) {
+	t.Helper()
+	ctx := context.Background()
+
+	_, err := utils.GetCollection("seat_locks").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "event_id", Value: 1}, {Key: "seat_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		t.Fatalf("failed to create seat lock index: %v", err)
+	}
+
+	venue := models.Venue{
+		Name: "Test Theatre",
+		Sections: []models.Section{{
+			Name: "A",
+			Rows: []models.SeatRow{{
+				Label: "1",
+				Seats: []models.Seat{{Number: "1"}, {Number: "2"}, {Number: "3", Wheelchair: true}, {Number: "4", Blocked: true}},
+			}},
+		}},
+	}
+	if err := venue.Normalize(); err != nil {
+		t.Fatalf("invalid venue: %v", err)
+	}
+	result, err := utils.GetCollection("venues").InsertOne(ctx, venue)
+	if err != nil {
+		t.Fatalf("failed to insert venue: %v", err)
+	}
+	venue.ID = result.InsertedID.(primitive.ObjectID)
+
+	event := models.Event{
+		Title:      "Seated Test Event",
+		Date:       time.Now().AddDate(0, 1, 0),
+		Price:      10,
+		MaxTickets: venue.SellableSeats(),
+		Status:     "active",
+		VenueID:    venue.ID,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+	result, err = utils.GetCollection("events").InsertOne(ctx, event)
+	if err != nil {
+		t.Fatalf("failed to insert event: %v", err)
+	}
+	return result.InsertedID.(primitive.ObjectID), &venue
+}
+
+func TestAssignSeatsNeverDoubleBooks(t *testing.T) {
+	inventory := setupInventoryTest(t)
+	eventID, _ := insertSeatedTestEvent(t)
+
+	var succeeded, taken int64
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			ctx := context.Background()
+			reservation, err := inventory.Reserve(ctx, eventID, primitive.NilObjectID, 1)
+			if err != nil {
+				if !errors.Is(err, ErrTicketsUnavailable) {
+					t.Errorf("unexpected reserve error: %v", err)
+				}
+				return
+			}
+			defer reservation.Rollback(ctx)
+
+			_, _, err = reservation.PlaceOrder(ctx, primitive.NewObjectID(), []string{"A-1-1"})
+			if errors.Is(err, ErrSeatUnavailable) {
+				atomic.AddInt64(&taken, 1)
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected seat error: %v", err)
+				return
+			}
+			reservation.Commit()
+			atomic.AddInt64(&succeeded, 1)
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if succeeded != 1 {
+		t.Errorf("expected exactly one buyer to get seat A-1-1, got %d", succeeded)
+	}
+	locks, _ := utils.GetCollection("seat_locks").CountDocuments(context.Background(), bson.M{"event_id": eventID, "seat_id": "A-1-1"})
+	if locks != 1 {
+		t.Errorf("expected one lock on the seat, got %d", locks)
+	}
+	if sold := loadTestEvent(t, eventID).SoldTickets; sold != 1 {
+		t.Errorf("expected failed seat claims to release inventory, got sold=%d", sold)
+	}
+}
+
+func TestAssignSeatsRules(t *testing.T) {
+	inventory := setupInventoryTest(t)
+	eventID, _ := insertSeatedTestEvent(t)
+	ctx := context.Background()
+
+	reservation, err := inventory.Reserve(ctx, eventID, primitive.NilObjectID, 2)
+	if err != nil {
+		t.Fatalf("failed to reserve: %v", err)
+	}
+	defer reservation.Rollback(ctx)
+	order := reservation.NewOrder(primitive.NewObjectID())
+	tickets, err := reservation.NewTickets(&order)
+	if err != nil {
+		t.Fatalf("failed to build tickets: %v", err)
+	}
+
+	if err := reservation.AssignSeats(ctx, tickets, []string{"A-1-1"}); !errors.Is(err, ErrSeatCountMismatch) {
+		t.Errorf("expected ErrSeatCountMismatch, got %v", err)
+	}
+	if err := reservation.AssignSeats(ctx, tickets, []string{"A-1-1", "A-1-4"}); !errors.Is(err, ErrSeatUnavailable) {
+		t.Errorf("expected blocked seat to be unavailable, got %v", err)
+	}
+	if err := reservation.AssignSeats(ctx, tickets, []string{"A-1-1", "Z-9-9"}); !errors.Is(err, ErrSeatNotFound) {
+		t.Errorf("expected ErrSeatNotFound, got %v", err)
+	}
+
+	// Best available skips wheelchair spaces and blocked seats
+	if err := reservation.AssignSeats(ctx, tickets, nil); err != nil {
+		t.Fatalf("failed to assign best available seats: %v", err)
+	}
+	if len(tickets[0].Seats) != 1 || tickets[0].Seats[0].SeatID != "A-1-1" || len(tickets[1].Seats) != 1 || tickets[1].Seats[0].SeatID != "A-1-2" {
+		t.Errorf("unexpected best available seats: %+v, %+v", tickets[0].Seats, tickets[1].Seats)
+	}
+
+	if err := reservation.Rollback(ctx); err != nil {
+		t.Fatalf("rollback failed: %v", err)
+	}
+	if n, _ := utils.GetCollection("seat_locks").CountDocuments(ctx, bson.M{"event_id": eventID}); n != 0 {
+		t.Errorf("expected rollback to free all seats, %d still locked", n)
+	}
+}
+
+func TestLapsedSeatHoldCanBeTakenOver(t *testing.T) {
+	inventory := setupInventoryTest(t)
+	eventID, _ := insertSeatedTestEvent(t)
+	seating := inventory.seatingService
+	ctx := context.Background()
+
+	seats := []models.TicketSeat{{SeatID: "A-1-1"}}
+	lapsed := time.Now().Add(-time.Minute)
+	first, second := primitive.NewObjectID(), primitive.NewObjectID()
+	if err := seating.ClaimSeats(ctx, eventID, first, primitive.NewObjectID(), seats, models.SeatHeld, &lapsed); err != nil {
+		t.Fatalf("failed to hold seat: %v", err)
+	}
+	if err := seating.ClaimSeats(ctx, eventID, second, primitive.NewObjectID(), seats, models.SeatSold, nil); err != nil {
+		t.Fatalf("expected lapsed hold to be taken over, got %v", err)
+	}
+
+	// The original holder's payment arriving late must not steal the seat back
+	if err := seating.ClaimSeats(ctx, eventID, first, primitive.NewObjectID(), seats, models.SeatSold, nil); !errors.Is(err, ErrSeatUnavailable) {
+		t.Errorf("expected ErrSeatUnavailable, got %v", err)
+	}
+}
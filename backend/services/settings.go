@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"eventticketing/config"
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// settingsPollInterval is how often settings are reloaded when the database
+// cannot stream changes
+const settingsPollInterval = 10 * time.Second
+
+// ErrSettingsConflict is returned when settings changed since the version an update was based on
+var ErrSettingsConflict = errors.New("settings have changed since that version")
+
+// liveSettings are the settings this instance is running with
+var liveSettings atomic.Pointer[models.SystemSettings]
+
+// CurrentSettings returns the settings in effect: the stored settings once
+// they are loaded, the defaults from the environment until then
+func CurrentSettings() models.Settings {
+	if current := liveSettings.Load(); current != nil {
+		return current.Settings
+	}
+	return DefaultSettings()
+}
+
+// DefaultSettings are the settings configured by the environment. They are
+// stored as the first version when no settings exist yet.
+func DefaultSettings() models.Settings {
+	return models.Settings{
+		CommissionRate:     config.AppConfig.Features.CommissionRate,
+		EnableUSSD:         config.AppConfig.Features.EnableUSSD,
+		EnableQR:           config.AppConfig.Features.EnableQR,
+		EnableSMS:          config.AppConfig.Features.EnableSMS,
+		EnableEmail:        config.AppConfig.Features.EnableEmail,
+		TicketHoldSeconds:  int(config.AppConfig.Features.TicketHoldDuration / time.Second),
+		USSDSessionTimeout: config.AppConfig.USSD.SessionTimeout,
+	}
+}
+
+// setLive makes settings current unless this instance already runs a newer version
+func setLive(settings *models.SystemSettings) {
+	for {
+		current := liveSettings.Load()
+		if current != nil && current.Version >= settings.Version {
+			return
+		}
+		if liveSettings.CompareAndSwap(current, settings) {
+			if current != nil {
+				log.Printf("Settings updated to version %d", settings.Version)
+			}
+			return
+		}
+	}
+}
+
+// SettingsService stores the system settings with a history of every
+// version, and keeps every instance running the latest one
+type SettingsService struct {
+	settingsCollection *mongo.Collection
+	historyCollection  *mongo.Collection
+}
+
+func NewSettingsService() *SettingsService {
+	return &SettingsService{
+		settingsCollection: utils.GetCollection("settings"),
+		historyCollection:  utils.GetCollection("settings_history"),
+	}
+}
+
+// Load reads the stored settings and puts them into effect. The first
+// instance to start stores the environment's settings as version 1.
+func (ss *SettingsService) Load(ctx context.Context) (*models.SystemSettings, error) {
+	var settings models.SystemSettings
+	err := ss.settingsCollection.FindOne(ctx, bson.M{"_id": models.SettingsID}).Decode(&settings)
+	if err == mongo.ErrNoDocuments {
+		return ss.initialize(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch settings: %w", err)
+	}
+	setLive(&settings)
+	return &settings, nil
+}
+
+// initialize stores the default settings as version 1
+func (ss *SettingsService) initialize(ctx context.Context) (*models.SystemSettings, error) {
+	settings := models.SystemSettings{
+		ID:        models.SettingsID,
+		Settings:  DefaultSettings(),
+		Version:   1,
+		UpdatedAt: time.Now(),
+	}
+	if _, err := ss.settingsCollection.InsertOne(ctx, settings); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			// Another instance stored them first
+			return ss.Load(ctx)
+		}
+		return nil, fmt.Errorf("failed to store settings: %w", err)
+	}
+	ss.record(ctx, &settings, nil)
+	setLive(&settings)
+	return &settings, nil
+}
+
+// Update applies the changes in req and returns the new version. When
+// req.Version is set and the settings have moved past it, nothing changes
+// and ErrSettingsConflict is returned.
+func (ss *SettingsService) Update(ctx context.Context, req *models.UpdateSettingsRequest, updatedBy *models.User) (*models.SystemSettings, error) {
+	for {
+		current, err := ss.Load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if req.Version != nil && *req.Version != current.Version {
+			return nil, ErrSettingsConflict
+		}
+
+		changes := diffSettings(current.Settings, req.Apply(current.Settings))
+		if len(changes) == 0 {
+			return current, nil
+		}
+
+		updated := *current
+		updated.Settings = req.Apply(current.Settings)
+		updated.Version = current.Version + 1
+		updated.UpdatedBy = updatedBy.ID
+		updated.UpdatedAt = time.Now()
+
+		// Only the version that was read is replaced, so concurrent updates
+		// cannot overwrite each other's changes
+		result, err := ss.settingsCollection.ReplaceOne(ctx, bson.M{"_id": models.SettingsID, "version": current.Version}, updated)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update settings: %w", err)
+		}
+		if result.ModifiedCount == 0 {
+			if req.Version != nil {
+				return nil, ErrSettingsConflict
+			}
+			continue
+		}
+
+		ss.record(ctx, &updated, changes)
+		setLive(&updated)
+		return &updated, nil
+	}
+}
+
+// record adds a version to the settings history
+func (ss *SettingsService) record(ctx context.Context, settings *models.SystemSettings, changes []models.SettingChange) {
+	if changes == nil {
+		changes = []models.SettingChange{}
+	}
+	_, err := ss.historyCollection.InsertOne(ctx, models.SettingsChange{
+		Version:   settings.Version,
+		Settings:  settings.Settings,
+		Changes:   changes,
+		UpdatedBy: settings.UpdatedBy,
+		CreatedAt: settings.UpdatedAt,
+	})
+	if err != nil {
+		log.Printf("Failed to record settings version %d: %v", settings.Version, err)
+	}
+}
+
+// History returns versions of the settings, newest first
+func (ss *SettingsService) History(ctx context.Context, page, limit int) ([]models.SettingsChange, int64, error) {
+	opts := options.Find().
+		SetLimit(int64(limit)).
+		SetSkip(int64((page - 1) * limit)).
+		SetSort(bson.M{"version": -1})
+
+	cursor, err := ss.historyCollection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch settings history: %w", err)
+	}
+	var history []models.SettingsChange
+	if err := cursor.All(ctx, &history); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode settings history: %w", err)
+	}
+
+	total, err := ss.historyCollection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count settings history: %w", err)
+	}
+	return history, total, nil
+}
+
+// Start keeps this instance on the latest settings until ctx is cancelled.
+// It follows a change stream on the settings collection, and falls back to
+// reloading them every settingsPollInterval where change streams are not
+// available, as on a standalone MongoDB server.
+func (ss *SettingsService) Start(ctx context.Context) {
+	err := ss.watch(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+	log.Printf("Settings change stream unavailable, reloading settings every %s: %v", settingsPollInterval, err)
+
+	ticker := time.NewTicker(settingsPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := ss.Load(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to reload settings: %v", err)
+		}
+	}
+}
+
+// watch applies settings as they are written until the change stream fails
+func (ss *SettingsService) watch(ctx context.Context) error {
+	stream, err := ss.settingsCollection.Watch(ctx, mongo.Pipeline{}, options.ChangeStream().SetFullDocument(options.UpdateLookup))
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.Background())
+
+	// Pick up anything written before the stream opened
+	if _, err := ss.Load(ctx); err != nil {
+		log.Printf("Failed to reload settings: %v", err)
+	}
+
+	for stream.Next(ctx) {
+		var change struct {
+			FullDocument *models.SystemSettings `bson:"fullDocument"`
+		}
+		if err := stream.Decode(&change); err != nil || change.FullDocument == nil {
+			if _, err := ss.Load(ctx); err != nil {
+				log.Printf("Failed to reload settings: %v", err)
+			}
+			continue
+		}
+		setLive(change.FullDocument)
+	}
+	return stream.Err()
+}
+
+// diffSettings lists the settings that differ between from and to, named as in JSON
+func diffSettings(from, to models.Settings) []models.SettingChange {
+	var changes []models.SettingChange
+	before, after := reflect.ValueOf(from), reflect.ValueOf(to)
+	for i := 0; i < before.NumField(); i++ {
+		if before.Field(i).Interface() == after.Field(i).Interface() {
+			continue
+		}
+		name := strings.Split(before.Type().Field(i).Tag.Get("json"), ",")[0]
+		changes = append(changes, models.SettingChange{
+			Setting: name,
+			From:    before.Field(i).Interface(),
+			To:      after.Field(i).Interface(),
+		})
+	}
+	return changes
+}
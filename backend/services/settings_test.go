@@ -0,0 +1,71 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"eventticketing/config"
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDiffSettings(t *testing.T) {
+	rate, sms := 0.1, false
+	req := models.UpdateSettingsRequest{CommissionRate: &rate, EnableSMS: &sms}
+	before := models.Settings{CommissionRate: 0.05, EnableSMS: true, EnableQR: true, TicketHoldSeconds: 900}
+
+	after := req.Apply(before)
+	if !after.EnableQR || after.TicketHoldSeconds != 900 {
+		t.Errorf("expected settings missing from the request to be left alone, got %+v", after)
+	}
+
+	changes := diffSettings(before, after)
+	if len(changes) != 2 || changes[0].Setting != "commission_rate" || changes[0].To != 0.1 ||
+		changes[1].Setting != "enable_sms" || changes[1].From != true {
+		t.Errorf("unexpected changes %+v", changes)
+	}
+}
+
+func TestSettingsVersions(t *testing.T) {
+	setupInventoryTest(t)
+	utils.CreateIndexes()
+	config.AppConfig.Features = config.FeatureConfig{CommissionRate: 0.05, EnableSMS: true, TicketHoldDuration: 15 * time.Minute}
+	settings := NewSettingsService()
+	ctx := context.Background()
+	admin := &models.User{ID: primitive.NewObjectID()}
+
+	stored, err := settings.Load(ctx)
+	if err != nil || stored.Version != 1 || stored.CommissionRate != 0.05 || stored.TicketHoldSeconds != 900 {
+		t.Fatalf("expected the environment's settings as version 1, got %+v (%v)", stored, err)
+	}
+
+	rate := 0.08
+	stale := int64(1)
+	updated, err := settings.Update(ctx, &models.UpdateSettingsRequest{Version: &stale, CommissionRate: &rate}, admin)
+	if err != nil || updated.Version != 2 || CurrentSettings().CommissionRate != 0.08 {
+		t.Fatalf("expected version 2 in effect, got %+v (%v)", updated, err)
+	}
+	if _, err := settings.Update(ctx, &models.UpdateSettingsRequest{Version: &stale, CommissionRate: &rate}, admin); !errors.Is(err, ErrSettingsConflict) {
+		t.Errorf("expected an update based on version 1 to conflict, got %v", err)
+	}
+
+	// Another instance changes the settings; this one picks them up on reload
+	_, err = utils.GetCollection("settings").UpdateOne(ctx, bson.M{"_id": models.SettingsID},
+		bson.M{"$set": bson.M{"enable_sms": false, "version": 3}})
+	if err != nil {
+		t.Fatalf("failed to change settings: %v", err)
+	}
+	if _, err := settings.Load(ctx); err != nil || CurrentSettings().EnableSMS {
+		t.Errorf("expected the reloaded settings to disable SMS (%v)", err)
+	}
+
+	history, total, err := settings.History(ctx, 1, 10)
+	if err != nil || total != 2 || history[0].Version != 2 || len(history[0].Changes) != 1 || history[0].UpdatedBy != admin.ID {
+		t.Errorf("unexpected history %+v (%v)", history, err)
+	}
+}
@@ -1,121 +1,195 @@
 package services
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"net/http"
+	"strings"
 	"time"
 
 	"eventticketing/config"
+	"eventticketing/i18n"
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-type SMSService struct {
-	apiKey    string
-	apiSecret string
-	senderID  string
-	baseURL   string
-}
-
-type SMSRequest struct {
-	To      string `json:"to"`
-	From    string `json:"from"`
-	Message string `json:"message"`
-}
+var (
+	// ErrSMSDisabled is returned when SMS is switched off in the settings
+	ErrSMSDisabled = errors.New("SMS is disabled")
+	// ErrSMSNotFound is returned when a message is not in the outbox
+	ErrSMSNotFound = errors.New("SMS message not found")
+	// ErrSMSNotRetryable is returned when retrying a message that has not failed
+	ErrSMSNotRetryable = errors.New("only failed messages can be retried")
+)
 
-type SMSResponse struct {
-	Status  string `json:"status"`
-	Message string `json:"message"`
-	ID      string `json:"id"`
+// SMSService writes outgoing messages to the outbox, where the SMS workers
+// pick them up, and keeps track of what became of them
+type SMSService struct {
+	senderID          string
+	provider          string
+	messageCollection *mongo.Collection
+	userCollection    *mongo.Collection
 }
 
 func NewSMSService() *SMSService {
 	return &SMSService{
-		apiKey:    config.AppConfig.SMS.APIKey,
-		apiSecret: config.AppConfig.SMS.APISecret,
-		senderID:  config.AppConfig.SMS.SenderID,
-		baseURL:   "https://api.mtn.com/sms/v1", // Replace with actual MTN SMS API URL
+		senderID:          config.AppConfig.SMS.SenderID,
+		provider:          DefaultSMSProviderName(),
+		messageCollection: utils.GetCollection("sms_messages"),
+		userCollection:    utils.GetCollection("users"),
 	}
 }
 
-// SendSMS sends an SMS message
+// SendSMS queues an SMS message. It returns once the message is in the
+// outbox; the SMS workers send it, retrying until the provider takes it.
 func (ss *SMSService) SendSMS(to, message string) error {
-	// Create SMS request
-	smsReq := SMSRequest{
-		To:      to,
-		From:    ss.senderID,
-		Message: message,
-	}
+	_, err := ss.Enqueue(context.Background(), to, message)
+	return err
+}
 
-	// Convert to JSON
-	jsonData, err := json.Marshal(smsReq)
-	if err != nil {
-		return fmt.Errorf("failed to marshal SMS request: %w", err)
+// Enqueue writes a message to the outbox and wakes a worker to send it
+func (ss *SMSService) Enqueue(ctx context.Context, to, body string) (*models.SMSMessage, error) {
+	if !CurrentSettings().EnableSMS {
+		return nil, ErrSMSDisabled
 	}
 
-	// Create HTTP request
-	req, err := http.NewRequest("POST", ss.baseURL+"/messages", bytes.NewBuffer(jsonData))
+	now := time.Now()
+	message := &models.SMSMessage{
+		To:            to,
+		From:          ss.senderID,
+		Body:          body,
+		Provider:      ss.provider,
+		Status:        models.SMSQueued,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	result, err := ss.messageCollection.InsertOne(ctx, message)
 	if err != nil {
-		return fmt.Errorf("failed to create HTTP request: %w", err)
+		return nil, fmt.Errorf("failed to queue SMS: %w", err)
 	}
+	message.ID = result.InsertedID.(primitive.ObjectID)
 
-	// Add headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ss.apiKey))
-	req.Header.Set("X-API-Key", ss.apiSecret)
+	wakeSMSWorkers()
+	return message, nil
+}
 
-	// Make request
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send SMS: %w", err)
+// SendBulkSMS queues the same message to several recipients
+func (ss *SMSService) SendBulkSMS(recipients []string, message string) error {
+	for _, recipient := range recipients {
+		if err := ss.SendSMS(recipient, message); err != nil {
+			return err
+		}
 	}
-	defer resp.Body.Close()
+	return nil
+}
 
-	// Check response status
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("SMS API returned status: %d", resp.StatusCode)
+// GetMessage fetches a message from the outbox
+func (ss *SMSService) GetMessage(ctx context.Context, messageID primitive.ObjectID) (*models.SMSMessage, error) {
+	var message models.SMSMessage
+	err := ss.messageCollection.FindOne(ctx, bson.M{"_id": messageID}).Decode(&message)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrSMSNotFound
 	}
-
-	// Parse response
-	var smsResp SMSResponse
-	if err := json.NewDecoder(resp.Body).Decode(&smsResp); err != nil {
-		return fmt.Errorf("failed to decode SMS response: %w", err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch SMS: %w", err)
 	}
+	return &message, nil
+}
 
-	// Check if SMS was sent successfully
-	if smsResp.Status != "success" {
-		return fmt.Errorf("SMS failed: %s", smsResp.Message)
+// Retry queues a failed message to be sent again, with a fresh set of attempts
+func (ss *SMSService) Retry(ctx context.Context, messageID primitive.ObjectID) (*models.SMSMessage, error) {
+	var message models.SMSMessage
+	err := ss.messageCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": messageID, "status": models.SMSFailed},
+		bson.M{
+			"$set": bson.M{
+				"status":          models.SMSQueued,
+				"attempts":        0,
+				"next_attempt_at": time.Now(),
+				"updated_at":      time.Now(),
+			},
+			"$unset": bson.M{"failed_at": "", "provider_message_id": ""},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&message)
+	if err == mongo.ErrNoDocuments {
+		if _, err := ss.GetMessage(ctx, messageID); err != nil {
+			return nil, err
+		}
+		return nil, ErrSMSNotRetryable
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retry SMS: %w", err)
 	}
 
-	return nil
+	wakeSMSWorkers()
+	return &message, nil
 }
 
-// SendBulkSMS sends SMS messages to multiple recipients
-func (ss *SMSService) SendBulkSMS(recipients []string, message string) error {
-	for _, recipient := range recipients {
-		if err := ss.SendSMS(recipient, message); err != nil {
-			// Log error but continue with other recipients
-			fmt.Printf("Failed to send SMS to %s: %v\n", recipient, err)
+// ApplyReceipt records what a delivery receipt from a provider says about
+// one of its messages. Receipts for messages already delivered or failed
+// change nothing.
+func (ss *SMSService) ApplyReceipt(ctx context.Context, provider string, receipt *SMSReceipt) error {
+	filter := bson.M{"provider": provider, "provider_message_id": receipt.MessageID}
+	if receipt.Status == "" {
+		// Still in transit; only check the message exists
+		if err := ss.messageCollection.FindOne(ctx, filter).Err(); err == mongo.ErrNoDocuments {
+			return ErrSMSNotFound
+		} else if err != nil {
+			return fmt.Errorf("failed to fetch SMS: %w", err)
+		}
+		return nil
+	}
+
+	now := time.Now()
+	set := bson.M{"status": receipt.Status, "updated_at": now}
+	if receipt.Status == models.SMSDelivered {
+		set["delivered_at"] = now
+	} else {
+		set["failed_at"] = now
+		set["last_error"] = strings.TrimSpace(receipt.RawStatus + " " + receipt.Reason)
+	}
+	filter["status"] = models.SMSSent
+	result, err := ss.messageCollection.UpdateOne(ctx, filter, bson.M{"$set": set})
+	if err != nil {
+		return fmt.Errorf("failed to update SMS: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		delete(filter, "status")
+		if err := ss.messageCollection.FindOne(ctx, filter).Err(); err == mongo.ErrNoDocuments {
+			return ErrSMSNotFound
+		} else if err != nil {
+			return fmt.Errorf("failed to fetch SMS: %w", err)
 		}
-		// Add delay between SMS to avoid rate limiting
-		time.Sleep(100 * time.Millisecond)
 	}
 	return nil
 }
 
-// SendTicketConfirmation sends ticket confirmation SMS
-func (ss *SMSService) SendTicketConfirmation(phoneNumber, eventTitle, ticketCode, eventDate string) error {
-	message := fmt.Sprintf("Your ticket for %s has been confirmed. Ticket Code: %s. Event Date: %s. Thank you for using EventTix!", 
-		eventTitle, ticketCode, eventDate)
-	
+// SendTicketConfirmation sends ticket confirmation SMS in the given language
+func (ss *SMSService) SendTicketConfirmation(language, phoneNumber, eventTitle, ticketCode string, eventDate time.Time) error {
+	message := i18n.T(language, "sms.ticket_confirmed", eventTitle, ticketCode, i18n.Date(language, eventDate))
 	return ss.SendSMS(phoneNumber, message)
 }
 
-// SendPaymentReminder sends payment reminder SMS
-func (ss *SMSService) SendPaymentReminder(phoneNumber, eventTitle, amount string) error {
-	message := fmt.Sprintf("Payment reminder: Your ticket for %s is pending. Amount: %s. Please complete payment to confirm your ticket.", 
-		eventTitle, amount)
-	
+// SendPaymentReminder sends payment reminder SMS in the given language
+func (ss *SMSService) SendPaymentReminder(language, phoneNumber, eventTitle, amount string) error {
+	message := i18n.T(language, "sms.payment_reminder", eventTitle, amount)
 	return ss.SendSMS(phoneNumber, message)
-} 
\ No newline at end of file
+}
+
+// UserLanguage returns the language a user reads messages in, or the
+// default for users that have not chosen one
+func (ss *SMSService) UserLanguage(ctx context.Context, userID primitive.ObjectID) string {
+	var user models.User
+	opts := options.FindOne().SetProjection(bson.M{"language": 1})
+	if err := ss.userCollection.FindOne(ctx, bson.M{"_id": userID}, opts).Decode(&user); err != nil || user.Language == "" {
+		return i18n.DefaultLanguage
+	}
+	return user.Language
+}
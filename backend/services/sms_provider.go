@@ -0,0 +1,253 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"eventticketing/config"
+	"eventticketing/models"
+)
+
+var (
+	// ErrSMSRejected is returned when a provider refuses a message for good,
+	// e.g. for an invalid number, so sending it again will not help
+	ErrSMSRejected = errors.New("SMS rejected by provider")
+	// ErrMalformedSMSReceipt is returned for delivery receipts that cannot be read
+	ErrMalformedSMSReceipt = errors.New("malformed delivery receipt")
+)
+
+// SMSProvider is an SMS API messages are sent through
+type SMSProvider interface {
+	// Name is the key SMS_PROVIDER selects the provider by and messages record
+	Name() string
+	// Send hands a message to the provider and returns the provider's ID for it
+	Send(ctx context.Context, message *models.SMSMessage) (string, error)
+	// ParseReceipt reads a delivery receipt from the provider
+	ParseReceipt(r *http.Request, body []byte) (*SMSReceipt, error)
+}
+
+// SMSReceipt is what a delivery receipt says about a message
+type SMSReceipt struct {
+	MessageID string // the provider's ID, as returned by Send
+	Status    string // models.SMSDelivered, models.SMSFailed, or empty while still in transit
+	RawStatus string // as the provider reported it
+	Reason    string
+}
+
+// NewSMSProvider returns the provider with the given name, configured from
+// the SMS settings
+func NewSMSProvider(name string) (SMSProvider, error) {
+	cfg := config.AppConfig.SMS
+	client := &http.Client{Timeout: 30 * time.Second}
+	switch name {
+	case "mtn":
+		baseURL := cfg.BaseURL
+		if baseURL == "" {
+			baseURL = "https://api.mtn.com/sms/v1" // Replace with actual MTN SMS API URL
+		}
+		return &MTNSMSProvider{apiKey: cfg.APIKey, apiSecret: cfg.APISecret, baseURL: baseURL, client: client}, nil
+	case "africastalking":
+		baseURL := cfg.BaseURL
+		if baseURL == "" {
+			baseURL = "https://api.africastalking.com"
+			if cfg.Username == "sandbox" {
+				baseURL = "https://api.sandbox.africastalking.com"
+			}
+		}
+		return &AfricasTalkingSMSProvider{username: cfg.Username, apiKey: cfg.APIKey, baseURL: baseURL, client: client}, nil
+	}
+	return nil, fmt.Errorf("unknown SMS provider %q", name)
+}
+
+// DefaultSMSProviderName is the provider new messages are sent through: the
+// configured one, or mtn when the name is not known
+func DefaultSMSProviderName() string {
+	name := config.AppConfig.SMS.Provider
+	if _, err := NewSMSProvider(name); err != nil {
+		log.Printf("%v; using mtn", err)
+		return "mtn"
+	}
+	return name
+}
+
+// MTNSMSProvider sends through the MTN SMS API
+type MTNSMSProvider struct {
+	apiKey    string
+	apiSecret string
+	baseURL   string
+	client    *http.Client
+}
+
+type SMSRequest struct {
+	To      string `json:"to"`
+	From    string `json:"from"`
+	Message string `json:"message"`
+}
+
+type SMSResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	ID      string `json:"id"`
+}
+
+type mtnSMSReceipt struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+func (p *MTNSMSProvider) Name() string { return "mtn" }
+
+func (p *MTNSMSProvider) Send(ctx context.Context, message *models.SMSMessage) (string, error) {
+	jsonData, err := json.Marshal(SMSRequest{To: message.To, From: message.From, Message: message.Body})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal SMS request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/messages", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.apiKey))
+	req.Header.Set("X-API-Key", p.apiSecret)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send SMS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", smsStatusError(resp.StatusCode)
+	}
+
+	var smsResp SMSResponse
+	if err := json.NewDecoder(resp.Body).Decode(&smsResp); err != nil {
+		return "", fmt.Errorf("failed to decode SMS response: %w", err)
+	}
+	if smsResp.Status != "success" {
+		return "", fmt.Errorf("%w: %s", ErrSMSRejected, smsResp.Message)
+	}
+	return smsResp.ID, nil
+}
+
+func (p *MTNSMSProvider) ParseReceipt(r *http.Request, body []byte) (*SMSReceipt, error) {
+	var receipt mtnSMSReceipt
+	if err := json.Unmarshal(body, &receipt); err != nil || receipt.ID == "" {
+		return nil, ErrMalformedSMSReceipt
+	}
+
+	status := ""
+	switch strings.ToUpper(receipt.Status) {
+	case "DELIVERED", "DELIVEREDTOTERMINAL":
+		status = models.SMSDelivered
+	case "FAILED", "UNDELIVERABLE", "REJECTED", "EXPIRED", "DELIVERYIMPOSSIBLE":
+		status = models.SMSFailed
+	}
+	return &SMSReceipt{MessageID: receipt.ID, Status: status, RawStatus: receipt.Status, Reason: receipt.Reason}, nil
+}
+
+// AfricasTalkingSMSProvider sends through the Africa's Talking messaging API
+type AfricasTalkingSMSProvider struct {
+	username string
+	apiKey   string
+	baseURL  string
+	client   *http.Client
+}
+
+type africasTalkingSMSResponse struct {
+	SMSMessageData struct {
+		Message    string `json:"Message"`
+		Recipients []struct {
+			StatusCode int    `json:"statusCode"`
+			Number     string `json:"number"`
+			Status     string `json:"status"`
+			MessageID  string `json:"messageId"`
+		} `json:"Recipients"`
+	} `json:"SMSMessageData"`
+}
+
+// Africa's Talking recipient status codes that will not change on a retry:
+// invalid number, unsupported number type and blacklisted user
+var africasTalkingRejections = map[int]bool{403: true, 404: true, 406: true}
+
+func (p *AfricasTalkingSMSProvider) Name() string { return "africastalking" }
+
+func (p *AfricasTalkingSMSProvider) Send(ctx context.Context, message *models.SMSMessage) (string, error) {
+	form := url.Values{}
+	form.Set("username", p.username)
+	form.Set("to", message.To)
+	form.Set("message", message.Body)
+	if message.From != "" {
+		form.Set("from", message.From)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/version1/messaging", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("apiKey", p.apiKey)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send SMS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return "", smsStatusError(resp.StatusCode)
+	}
+
+	var smsResp africasTalkingSMSResponse
+	if err := json.NewDecoder(resp.Body).Decode(&smsResp); err != nil {
+		return "", fmt.Errorf("failed to decode SMS response: %w", err)
+	}
+	if len(smsResp.SMSMessageData.Recipients) == 0 {
+		return "", fmt.Errorf("%w: %s", ErrSMSRejected, smsResp.SMSMessageData.Message)
+	}
+	recipient := smsResp.SMSMessageData.Recipients[0]
+	switch {
+	case recipient.StatusCode >= 100 && recipient.StatusCode <= 102:
+		return recipient.MessageID, nil
+	case africasTalkingRejections[recipient.StatusCode]:
+		return "", fmt.Errorf("%w: %s", ErrSMSRejected, recipient.Status)
+	}
+	return "", fmt.Errorf("SMS not accepted: %s", recipient.Status)
+}
+
+func (p *AfricasTalkingSMSProvider) ParseReceipt(r *http.Request, body []byte) (*SMSReceipt, error) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil || form.Get("id") == "" {
+		return nil, ErrMalformedSMSReceipt
+	}
+
+	status := ""
+	switch form.Get("status") {
+	case "Success":
+		status = models.SMSDelivered
+	case "Failed", "Rejected", "AbsentSubscriber", "Expired":
+		status = models.SMSFailed
+	}
+	return &SMSReceipt{MessageID: form.Get("id"), Status: status, RawStatus: form.Get("status"), Reason: form.Get("failureReason")}, nil
+}
+
+// smsStatusError describes an unsuccessful HTTP status from an SMS API. A
+// bad request means the message itself was refused; anything else, such as
+// throttling or credentials being rotated, may pass on a later try.
+func smsStatusError(status int) error {
+	if status == http.StatusBadRequest || status == http.StatusUnprocessableEntity {
+		return fmt.Errorf("%w: SMS API returned status %d", ErrSMSRejected, status)
+	}
+	return fmt.Errorf("SMS API returned status: %d", status)
+}
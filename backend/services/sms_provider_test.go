@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"eventticketing/config"
+	"eventticketing/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeSMSGateway is a local stand-in for the MTN SMS API that keeps what it
+// is sent
+type fakeSMSGateway struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	sent     []SMSRequest
+	failNext int // requests to answer with 503
+	status   int // answers every request with this status when set
+}
+
+func newFakeSMSGateway(t *testing.T) *fakeSMSGateway {
+	t.Helper()
+	if config.AppConfig == nil {
+		config.AppConfig = &config.Config{}
+	}
+
+	fake := &fakeSMSGateway{}
+	fake.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		switch {
+		case fake.status != 0:
+			w.WriteHeader(fake.status)
+			return
+		case fake.failNext > 0:
+			fake.failNext--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var req SMSRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		fake.sent = append(fake.sent, req)
+		json.NewEncoder(w).Encode(SMSResponse{Status: "success", ID: primitive.NewObjectID().Hex()})
+	}))
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+func (f *fakeSMSGateway) messages() []SMSRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]SMSRequest(nil), f.sent...)
+}
+
+func TestMTNSMSProvider(t *testing.T) {
+	gateway := newFakeSMSGateway(t)
+	config.AppConfig.SMS.BaseURL = gateway.URL
+	provider, _ := NewSMSProvider("mtn")
+	message := &models.SMSMessage{To: "+233244123456", From: "EventTix", Body: "Hello"}
+
+	id, err := provider.Send(context.Background(), message)
+	if err != nil || id == "" {
+		t.Fatalf("expected the message to be accepted, got %q, %v", id, err)
+	}
+	if sent := gateway.messages(); len(sent) != 1 || sent[0] != (SMSRequest{To: "+233244123456", From: "EventTix", Message: "Hello"}) {
+		t.Errorf("unexpected request %+v", sent)
+	}
+
+	gateway.status = http.StatusServiceUnavailable
+	if _, err := provider.Send(context.Background(), message); err == nil || errors.Is(err, ErrSMSRejected) {
+		t.Errorf("expected an outage to be worth retrying, got %v", err)
+	}
+	gateway.status = http.StatusBadRequest
+	if _, err := provider.Send(context.Background(), message); !errors.Is(err, ErrSMSRejected) {
+		t.Errorf("expected a bad request to be rejected, got %v", err)
+	}
+
+	receipt, err := provider.ParseReceipt(nil, []byte(`{"id": "abc", "status": "DeliveredToTerminal"}`))
+	if err != nil || *receipt != (SMSReceipt{MessageID: "abc", Status: models.SMSDelivered, RawStatus: "DeliveredToTerminal"}) {
+		t.Errorf("unexpected receipt %+v, %v", receipt, err)
+	}
+	if _, err := provider.ParseReceipt(nil, []byte(`{"status": "FAILED"}`)); err != ErrMalformedSMSReceipt {
+		t.Errorf("expected a receipt without an ID to be refused, got %v", err)
+	}
+}
+
+func TestAfricasTalkingSMSProvider(t *testing.T) {
+	var form map[string]string
+	statusCode := 101
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = map[string]string{"username": r.PostForm.Get("username"), "to": r.PostForm.Get("to"), "apiKey": r.Header.Get("apiKey")}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(fmt.Sprintf(`{"SMSMessageData": {"Message": "Sent to 1/1", "Recipients": [{"statusCode": %d, "number": "+233244123456", "status": "Success", "messageId": "ATXid_1"}]}}`, statusCode)))
+	}))
+	defer server.Close()
+
+	config.AppConfig = &config.Config{SMS: config.SMSConfig{BaseURL: server.URL, Username: "eventtix", APIKey: "key"}}
+	provider, _ := NewSMSProvider("africastalking")
+	message := &models.SMSMessage{To: "+233244123456", Body: "Hello"}
+
+	id, err := provider.Send(context.Background(), message)
+	if err != nil || id != "ATXid_1" {
+		t.Fatalf("expected the message to be accepted, got %q, %v", id, err)
+	}
+	if form["username"] != "eventtix" || form["to"] != "+233244123456" || form["apiKey"] != "key" {
+		t.Errorf("unexpected request %v", form)
+	}
+
+	statusCode = 403
+	if _, err := provider.Send(context.Background(), message); !errors.Is(err, ErrSMSRejected) {
+		t.Errorf("expected an invalid number to be rejected, got %v", err)
+	}
+
+	receipt, err := provider.ParseReceipt(nil, []byte("id=ATXid_1&status=Failed&failureReason=UserInBlacklist"))
+	if err != nil || receipt.Status != models.SMSFailed || receipt.Reason != "UserInBlacklist" {
+		t.Errorf("unexpected receipt %+v, %v", receipt, err)
+	}
+	if receipt, _ := provider.ParseReceipt(nil, []byte("id=ATXid_1&status=Buffered")); receipt.Status != "" {
+		t.Errorf("expected a message in transit to keep its status, got %q", receipt.Status)
+	}
+}
+
+func TestSMSBackoff(t *testing.T) {
+	expected := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute}
+	for i, wait := range expected {
+		if got := smsBackoff(i + 1); got != wait {
+			t.Errorf("after %d attempts: expected %v, got %v", i+1, wait, got)
+		}
+	}
+	if got := smsBackoff(20); got != smsMaxRetry {
+		t.Errorf("expected the wait to stop growing at %v, got %v", smsMaxRetry, got)
+	}
+}
+
+func TestSMSRateLimiter(t *testing.T) {
+	limiter := newSMSRateLimiter(100) // one every 10ms
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		limiter.Wait(context.Background())
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("expected four sends to take at least 30ms, took %v", elapsed)
+	}
+
+	unlimited := newSMSRateLimiter(0)
+	start = time.Now()
+	for i := 0; i < 100; i++ {
+		unlimited.Wait(context.Background())
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Millisecond {
+		t.Errorf("expected no limit to mean no waiting, took %v", elapsed)
+	}
+}
+
+func TestSMSStatusError(t *testing.T) {
+	for status, rejected := range map[int]bool{400: true, 422: true, 401: false, 429: false, 500: false} {
+		if got := errors.Is(smsStatusError(status), ErrSMSRejected); got != rejected {
+			t.Errorf("status %d: expected rejected=%v", status, rejected)
+		}
+		if !strings.Contains(smsStatusError(status).Error(), "status") {
+			t.Errorf("status %d: expected the status in the error", status)
+		}
+	}
+}
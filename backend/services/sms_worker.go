@@ -0,0 +1,266 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"eventticketing/config"
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// smsPollInterval is how often idle workers look for messages that are due
+	smsPollInterval = 5 * time.Second
+	// smsLease is how long a worker has to send a message it claimed before
+	// another worker may take it over
+	smsLease = 2 * time.Minute
+	// smsFirstRetry and smsMaxRetry bound the wait between attempts, which
+	// doubles after each failure
+	smsFirstRetry = 30 * time.Second
+	smsMaxRetry   = time.Hour
+)
+
+// smsWake tells an idle worker on this instance that a message was queued
+var smsWake = make(chan struct{}, 1)
+
+// wakeSMSWorkers wakes an idle worker without waiting for one
+func wakeSMSWorkers() {
+	select {
+	case smsWake <- struct{}{}:
+	default:
+	}
+}
+
+// SMSWorker sends queued messages from the outbox with a pool of workers,
+// retrying failures with exponential backoff and keeping to each provider's
+// rate limit
+type SMSWorker struct {
+	messageCollection *mongo.Collection
+	workers           int
+	maxAttempts       int
+
+	mu        sync.Mutex
+	providers map[string]SMSProvider
+	limiters  map[string]*smsRateLimiter
+}
+
+func NewSMSWorker() *SMSWorker {
+	workers := config.AppConfig.SMS.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	maxAttempts := config.AppConfig.SMS.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &SMSWorker{
+		messageCollection: utils.GetCollection("sms_messages"),
+		workers:           workers,
+		maxAttempts:       maxAttempts,
+		providers:         make(map[string]SMSProvider),
+		limiters:          make(map[string]*smsRateLimiter),
+	}
+}
+
+// Start runs the workers until ctx is cancelled
+func (sw *SMSWorker) Start(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < sw.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sw.run(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (sw *SMSWorker) run(ctx context.Context) {
+	ticker := time.NewTicker(smsPollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Send until nothing is due, then wait. Messages queued while SMS is
+		// switched off stay in the outbox until it is back on.
+		for CurrentSettings().EnableSMS && ctx.Err() == nil {
+			sent, err := sw.ProcessNext(ctx)
+			if err != nil {
+				log.Printf("SMS worker: %v", err)
+				break
+			}
+			if !sent {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-smsWake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessNext claims the message that has waited longest and tries to send
+// it. It reports whether there was a message to send.
+func (sw *SMSWorker) ProcessNext(ctx context.Context) (bool, error) {
+	message, err := sw.claim(ctx)
+	if err != nil || message == nil {
+		return false, err
+	}
+
+	provider, limiter, err := sw.provider(message.Provider)
+	if err != nil {
+		return true, sw.finish(ctx, message, "", fmt.Errorf("%w: %v", ErrSMSRejected, err))
+	}
+	if err := limiter.Wait(ctx); err != nil {
+		// Shutting down; the lease lets another worker take it later
+		return true, nil
+	}
+
+	providerID, sendErr := provider.Send(ctx, message)
+	return true, sw.finish(ctx, message, providerID, sendErr)
+}
+
+// claim takes the next due message for this worker, including messages
+// whose worker stopped before finishing with them
+func (sw *SMSWorker) claim(ctx context.Context) (*models.SMSMessage, error) {
+	now := time.Now()
+	filter := bson.M{"$or": []bson.M{
+		{"status": models.SMSQueued, "next_attempt_at": bson.M{"$lte": now}},
+		{"status": models.SMSSending, "lease_expires_at": bson.M{"$lte": now}},
+	}}
+	update := bson.M{
+		"$set": bson.M{"status": models.SMSSending, "lease_expires_at": now.Add(smsLease), "updated_at": now},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.M{"next_attempt_at": 1}).
+		SetReturnDocument(options.After)
+
+	var message models.SMSMessage
+	err := sw.messageCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&message)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim SMS: %w", err)
+	}
+	return &message, nil
+}
+
+// finish records the outcome of an attempt: sent, queued again after a
+// backoff, or failed once attempts run out or the provider refuses it
+func (sw *SMSWorker) finish(ctx context.Context, message *models.SMSMessage, providerID string, sendErr error) error {
+	now := time.Now()
+	set := bson.M{"updated_at": now}
+	switch {
+	case sendErr == nil:
+		set["status"] = models.SMSSent
+		set["provider_message_id"] = providerID
+		set["sent_at"] = now
+	case errors.Is(sendErr, ErrSMSRejected) || message.Attempts >= sw.maxAttempts:
+		set["status"] = models.SMSFailed
+		set["last_error"] = sendErr.Error()
+		set["failed_at"] = now
+		log.Printf("SMS %s to %s failed after %d attempts: %v", message.ID.Hex(), message.To, message.Attempts, sendErr)
+	default:
+		set["status"] = models.SMSQueued
+		set["last_error"] = sendErr.Error()
+		set["next_attempt_at"] = now.Add(smsBackoff(message.Attempts))
+	}
+
+	_, err := sw.messageCollection.UpdateOne(ctx,
+		bson.M{"_id": message.ID, "status": models.SMSSending},
+		bson.M{"$set": set, "$unset": bson.M{"lease_expires_at": ""}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update SMS %s: %w", message.ID.Hex(), err)
+	}
+	return nil
+}
+
+// provider returns the named provider and its rate limiter
+func (sw *SMSWorker) provider(name string) (SMSProvider, *smsRateLimiter, error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	provider, ok := sw.providers[name]
+	if !ok {
+		var err error
+		if provider, err = NewSMSProvider(name); err != nil {
+			return nil, nil, err
+		}
+		sw.providers[name] = provider
+		sw.limiters[name] = newSMSRateLimiter(config.AppConfig.SMS.RateLimits[name])
+	}
+	return provider, sw.limiters[name], nil
+}
+
+// smsBackoff is the wait after a message's nth failed attempt
+func smsBackoff(attempts int) time.Duration {
+	wait := smsFirstRetry
+	for i := 1; i < attempts && wait < smsMaxRetry; i++ {
+		wait *= 2
+	}
+	if wait > smsMaxRetry {
+		wait = smsMaxRetry
+	}
+	return wait
+}
+
+// smsRateLimiter spaces out sends so there are at most perSecond a second.
+// Limits apply to each instance separately.
+type smsRateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// newSMSRateLimiter returns a limiter for perSecond messages a second, or
+// one that never waits when perSecond is not positive
+func newSMSRateLimiter(perSecond float64) *smsRateLimiter {
+	limiter := &smsRateLimiter{}
+	if perSecond > 0 {
+		limiter.interval = time.Duration(float64(time.Second) / perSecond)
+	}
+	return limiter
+}
+
+// Wait blocks until the caller may send, or ctx is cancelled
+func (l *smsRateLimiter) Wait(ctx context.Context) error {
+	if l.interval == 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"eventticketing/config"
+	"eventticketing/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func setupSMSTest(t *testing.T) (*SMSService, *SMSWorker, *fakeSMSGateway) {
+	t.Helper()
+	setupInventoryTest(t)
+	gateway := newFakeSMSGateway(t)
+	config.AppConfig.Features.EnableSMS = true
+	config.AppConfig.SMS = config.SMSConfig{Provider: "mtn", BaseURL: gateway.URL, SenderID: "EventTix", Workers: 1, MaxAttempts: 3}
+	return NewSMSService(), NewSMSWorker(), gateway
+}
+
+func TestSMSWorkerSendsQueuedMessages(t *testing.T) {
+	ss, sw, gateway := setupSMSTest(t)
+	ctx := context.Background()
+
+	queued, err := ss.Enqueue(ctx, "+233244123456", "Your ticket")
+	if err != nil {
+		t.Fatalf("failed to queue SMS: %v", err)
+	}
+	if queued.Status != models.SMSQueued || len(gateway.messages()) != 0 {
+		t.Fatalf("expected the message to wait in the outbox, got %+v", queued)
+	}
+
+	if sent, err := sw.ProcessNext(ctx); !sent || err != nil {
+		t.Fatalf("expected the message to be sent, got %v, %v", sent, err)
+	}
+	if sent, _ := sw.ProcessNext(ctx); sent {
+		t.Error("expected nothing left to send")
+	}
+
+	message, _ := ss.GetMessage(ctx, queued.ID)
+	if message.Status != models.SMSSent || message.ProviderMessageID == "" || message.Attempts != 1 || message.SentAt == nil {
+		t.Errorf("expected the message sent on the first attempt, got %+v", message)
+	}
+	if sent := gateway.messages(); len(sent) != 1 || sent[0].To != "+233244123456" || sent[0].From != "EventTix" {
+		t.Errorf("unexpected messages at the gateway: %+v", sent)
+	}
+
+	// The provider confirms delivery, twice
+	receipt := &SMSReceipt{MessageID: message.ProviderMessageID, Status: models.SMSDelivered}
+	for i := 0; i < 2; i++ {
+		if err := ss.ApplyReceipt(ctx, "mtn", receipt); err != nil {
+			t.Fatalf("failed to apply receipt: %v", err)
+		}
+	}
+	message, _ = ss.GetMessage(ctx, queued.ID)
+	if message.Status != models.SMSDelivered || message.DeliveredAt == nil {
+		t.Errorf("expected the message delivered, got %+v", message)
+	}
+	if err := ss.ApplyReceipt(ctx, "mtn", &SMSReceipt{MessageID: "unknown", Status: models.SMSFailed}); !errors.Is(err, ErrSMSNotFound) {
+		t.Errorf("expected a receipt for an unknown message to be refused, got %v", err)
+	}
+}
+
+func TestSMSWorkerRetriesWithBackoff(t *testing.T) {
+	ss, sw, gateway := setupSMSTest(t)
+	ctx := context.Background()
+	gateway.failNext = 3
+
+	queued, _ := ss.Enqueue(ctx, "+233244123456", "Your ticket")
+	sw.ProcessNext(ctx)
+
+	message, _ := ss.GetMessage(ctx, queued.ID)
+	if message.Status != models.SMSQueued || message.Attempts != 1 || message.LastError == "" {
+		t.Fatalf("expected the message queued again after a failure, got %+v", message)
+	}
+	if wait := time.Until(message.NextAttemptAt); wait < 25*time.Second || wait > smsFirstRetry {
+		t.Errorf("expected the next attempt in about %v, got %v", smsFirstRetry, wait)
+	}
+	if sent, _ := sw.ProcessNext(ctx); sent {
+		t.Error("expected the message to wait for its next attempt")
+	}
+
+	// Run out the attempts
+	for i := 1; i < 3; i++ {
+		sw.messageCollection.UpdateByID(ctx, queued.ID, bson.M{"$set": bson.M{"next_attempt_at": time.Now()}})
+		sw.ProcessNext(ctx)
+	}
+	message, _ = ss.GetMessage(ctx, queued.ID)
+	if message.Status != models.SMSFailed || message.Attempts != 3 || message.FailedAt == nil {
+		t.Fatalf("expected the message failed after 3 attempts, got %+v", message)
+	}
+
+	// Support retries it once the gateway is back
+	if _, err := ss.Retry(ctx, queued.ID); err != nil {
+		t.Fatalf("failed to retry: %v", err)
+	}
+	sw.ProcessNext(ctx)
+	message, _ = ss.GetMessage(ctx, queued.ID)
+	if message.Status != models.SMSSent || message.Attempts != 1 {
+		t.Errorf("expected the retried message sent, got %+v", message)
+	}
+	if _, err := ss.Retry(ctx, queued.ID); !errors.Is(err, ErrSMSNotRetryable) {
+		t.Errorf("expected a sent message not to be retried, got %v", err)
+	}
+}
+
+func TestSMSWorkerGivesUpOnRejectedMessages(t *testing.T) {
+	ss, sw, gateway := setupSMSTest(t)
+	ctx := context.Background()
+	gateway.status = 400
+
+	queued, _ := ss.Enqueue(ctx, "not-a-number", "Your ticket")
+	sw.ProcessNext(ctx)
+
+	message, _ := ss.GetMessage(ctx, queued.ID)
+	if message.Status != models.SMSFailed || message.Attempts != 1 {
+		t.Errorf("expected a rejected message failed at once, got %+v", message)
+	}
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"eventticketing/config"
+	"eventticketing/i18n"
+	"eventticketing/models"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// Ticket pages are A5, in points
+const (
+	ticketPageWidth  = 420
+	ticketPageHeight = 595
+	ticketQRSize     = 180
+)
+
+// ticketTerms are printed on PDF tickets and the back of wallet passes
+const ticketTerms = "This ticket admits one person, once. Its code is checked at the entrance, " +
+	"and copies of a code that has already been used are refused. Keep it private. " +
+	"Cancelled and refunded tickets are no longer valid. Refunds follow the organizer's policy."
+
+// TicketPDF lays out an event's tickets one to a page, each with its QR
+// code, for printing or showing on a phone at the door
+func TicketPDF(event *models.Event, tickets []models.Ticket) ([]byte, error) {
+	brand := "ADMISSION TICKET"
+	if config.AppConfig != nil && config.AppConfig.Passes.Brand != "" {
+		brand = config.AppConfig.Passes.Brand
+	}
+
+	var doc pdfDocument
+	for i := range tickets {
+		ticket := &tickets[i]
+		page := doc.AddPage(ticketPageWidth, ticketPageHeight)
+
+		page.Rect(0, ticketPageHeight-60, ticketPageWidth, 60, 0.15)
+		page.Text(36, ticketPageHeight-38, 18, true, 1, brand)
+		label := "Ticket"
+		if len(tickets) > 1 {
+			label = fmt.Sprintf("Ticket %d of %d", i+1, len(tickets))
+		}
+		page.Text(ticketPageWidth-110, ticketPageHeight-36, 11, false, 0.8, label)
+
+		y := float64(ticketPageHeight - 100)
+		title := wrapText(event.Title, 30)
+		if len(title) > 3 {
+			title = title[:3]
+		}
+		for _, line := range title {
+			page.Text(36, y, 20, true, 0, line)
+			y -= 24
+		}
+
+		y -= 6
+		details := [][2]string{
+			{"When", i18n.Date(i18n.DefaultLanguage, event.Date)},
+			{"Where", event.Location},
+		}
+		if ticket.TicketTypeName != "" {
+			details = append(details, [2]string{"Ticket", ticket.TicketTypeName})
+		}
+		if len(ticket.Seats) > 0 {
+			details = append(details, [2]string{"Seats", strings.Join(ticket.SeatLabels(), "; ")})
+		}
+		details = append(details, [2]string{"Price", models.FormatAmount(ticket.Price, event.Currency)})
+		for _, detail := range details {
+			page.Text(36, y, 11, true, 0.4, detail[0])
+			for _, line := range wrapText(detail[1], 48) {
+				page.Text(100, y, 11, false, 0, line)
+				y -= 16
+			}
+		}
+
+		if err := drawQRCode(page, ticket.TicketCode, (ticketPageWidth-ticketQRSize)/2, 130, ticketQRSize); err != nil {
+			return nil, err
+		}
+		// Ticket codes are capitals and digits, about 0.67em wide each in bold
+		codeWidth := float64(len(ticket.TicketCode)) * 14 * 0.67
+		page.Text((ticketPageWidth-codeWidth)/2, 108, 14, true, 0, ticket.TicketCode)
+
+		page.Rect(36, 92, ticketPageWidth-72, 0.5, 0.7)
+		y = 78
+		for _, line := range wrapText(ticketTerms, 95) {
+			page.Text(36, y, 7, false, 0.4, line)
+			y -= 10
+		}
+	}
+	return doc.Bytes(), nil
+}
+
+// drawQRCode draws the QR code of text as a size-point square with its
+// bottom left corner at x, y
+func drawQRCode(page *pdfPage, text string, x, y, size float64) error {
+	code, err := qrcode.New(text, qrcode.Medium)
+	if err != nil {
+		return fmt.Errorf("failed to generate QR code: %w", err)
+	}
+	code.DisableBorder = true
+	bitmap := code.Bitmap()
+	module := size / float64(len(bitmap))
+
+	// One rectangle per run of dark modules in a row keeps the page small
+	for row, cells := range bitmap {
+		top := y + size - float64(row+1)*module
+		for col := 0; col < len(cells); col++ {
+			if !cells[col] {
+				continue
+			}
+			start := col
+			for col+1 < len(cells) && cells[col+1] {
+				col++
+			}
+			page.Rect(x+float64(start)*module, top, float64(col-start+1)*module, module, 0)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"eventticketing/models"
+)
+
+func TestTicketPDF(t *testing.T) {
+	event := &models.Event{Title: "Highlife Night at the (Old) Arts Centre", Location: "Accra", Currency: "GHS", Date: time.Now()}
+	tickets := []models.Ticket{
+		{TicketCode: "TKT0001", Price: 5000, TicketTypeName: "VIP", Seats: []models.TicketSeat{{Label: "Row A, Seat 1"}}},
+		{TicketCode: "TKT0002", Price: 5000},
+	}
+
+	pdf, err := TicketPDF(event, tickets)
+	if err != nil {
+		t.Fatalf("failed to make PDF: %v", err)
+	}
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Fatal("expected a complete PDF")
+	}
+	for _, text := range []string{"/Count 2", "(TKT0001) Tj", "(TKT0002) Tj", "(Row A, Seat 1) Tj", "(GHS 50.00) Tj", `\(Old\)`} {
+		if !bytes.Contains(pdf, []byte(text)) {
+			t.Errorf("expected %q in the PDF", text)
+		}
+	}
+
+	// Every object must be where the cross-reference table says
+	startxref := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(pdf)
+	xref, _ := strconv.Atoi(string(startxref[1]))
+	if !bytes.HasPrefix(pdf[xref:], []byte("xref\n")) {
+		t.Fatal("startxref does not point at the cross-reference table")
+	}
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(pdf[xref:], -1)
+	if len(entries) != 4+2*len(tickets) {
+		t.Fatalf("expected %d objects, got %d", 4+2*len(tickets), len(entries))
+	}
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(string(entry[1]))
+		if want := fmt.Sprintf("%d 0 obj\n", i+1); !bytes.HasPrefix(pdf[offset:], []byte(want)) {
+			t.Errorf("object %d is not at offset %d", i+1, offset)
+		}
+	}
+}
+
+func TestPDFString(t *testing.T) {
+	cases := map[string]string{
+		`Plain`:          `Plain`,
+		`a (b) \ c`:      `a \(b\) \\ c`,
+		"Fête":           `F\352te`,
+		"Eʋegbe ₵10":     `E?egbe ?10`,
+		"tab\tnewline\n": "tab?newline?",
+	}
+	for in, want := range cases {
+		if got := pdfString(in); got != want {
+			t.Errorf("pdfString(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestWrapText(t *testing.T) {
+	cases := []struct {
+		text  string
+		width int
+		want  []string
+	}{
+		{"Highlife Night at the Arts Centre", 14, []string{"Highlife Night", "at the Arts", "Centre"}},
+		{"Short", 14, []string{"Short"}},
+		{"Supercalifragilistic night", 10, []string{"Supercalif", "ragilistic", "night"}},
+		{"  ", 10, nil},
+	}
+	for _, c := range cases {
+		if got := wrapText(c.text, c.width); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("wrapText(%q, %d) = %q, want %q", c.text, c.width, got, c.want)
+		}
+	}
+	if lines := wrapText(strings.Repeat("word ", 20), 12); len(lines) != 10 {
+		t.Errorf("expected two words a line, got %q", lines)
+	}
+}
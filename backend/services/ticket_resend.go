@@ -0,0 +1,215 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"eventticketing/i18n"
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrTicketNotFound is returned when resending a ticket that does not exist
+	ErrTicketNotFound = errors.New("ticket not found")
+	// ErrTicketNotResendable is returned for tickets that cannot get anyone in
+	ErrTicketNotResendable = errors.New("only paid, unused tickets can be resent")
+	// ErrNoPhoneNumber is returned when a ticket's buyer has no phone to send it to
+	ErrNoPhoneNumber = errors.New("no phone number to send the ticket to")
+	// ErrResendLimited is returned when a phone has asked for too many resends
+	ErrResendLimited = errors.New("too many resends for this phone")
+)
+
+// Customers may have tickets sent again ticketResendLimit times in
+// ticketResendWindow; support agents are not limited
+const (
+	ticketResendLimit  = 3
+	ticketResendWindow = time.Hour
+)
+
+// smsTicketsLimit caps the tickets listed in one SMS reply
+const smsTicketsLimit = 5
+
+// smsTicketsKeywords are the inbound SMS keywords that ask for ticket codes
+var smsTicketsKeywords = map[string]bool{"TIX": true, "TICKET": true, "TICKETS": true}
+
+// TicketResendService sends ticket codes again to customers who lost their
+// confirmation SMS, over USSD, by SMS keyword or through support
+type TicketResendService struct {
+	resendCollection *mongo.Collection
+	ticketCollection *mongo.Collection
+	eventCollection  *mongo.Collection
+	userCollection   *mongo.Collection
+	smsService       *SMSService
+}
+
+func NewTicketResendService() *TicketResendService {
+	return &TicketResendService{
+		resendCollection: utils.GetCollection("ticket_resends"),
+		ticketCollection: utils.GetCollection("tickets"),
+		eventCollection:  utils.GetCollection("events"),
+		userCollection:   utils.GetCollection("users"),
+		smsService:       NewSMSService(),
+	}
+}
+
+// ResendTicket texts a ticket's code to phone, or to the buyer's phone when
+// phone is empty. requestedBy is the agent for admin resends.
+func (rs *TicketResendService) ResendTicket(ctx context.Context, ticketID primitive.ObjectID, phone, channel string, requestedBy primitive.ObjectID) error {
+	var ticket models.Ticket
+	err := rs.ticketCollection.FindOne(ctx, bson.M{"_id": ticketID}).Decode(&ticket)
+	if err == mongo.ErrNoDocuments {
+		return ErrTicketNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch ticket: %w", err)
+	}
+	if !ticket.IsValid() {
+		return ErrTicketNotResendable
+	}
+
+	var buyer models.User
+	if err := rs.userCollection.FindOne(ctx, bson.M{"_id": ticket.UserID}).Decode(&buyer); err != nil && err != mongo.ErrNoDocuments {
+		return fmt.Errorf("failed to fetch buyer: %w", err)
+	}
+	if phone == "" {
+		phone = buyer.Phone
+	}
+	if phone == "" {
+		return ErrNoPhoneNumber
+	}
+
+	var event models.Event
+	if err := rs.eventCollection.FindOne(ctx, bson.M{"_id": ticket.EventID}).Decode(&event); err != nil {
+		return fmt.Errorf("failed to fetch event: %w", err)
+	}
+
+	message := i18n.T(buyer.Language, "sms.ticket_resent", event.Title, ticketCodeWithSeats(&ticket), i18n.Date(buyer.Language, event.Date))
+	return rs.send(ctx, &models.TicketResend{
+		Phone:       phone,
+		Channel:     channel,
+		TicketIDs:   []primitive.ObjectID{ticket.ID},
+		RequestedBy: requestedBy,
+	}, message)
+}
+
+// HandleInboundSMS answers a text sent to the service. TIX gets the
+// sender's upcoming tickets; anything else gets a hint about the keyword.
+func (rs *TicketResendService) HandleInboundSMS(ctx context.Context, from, text string) error {
+	from = internationalPhone(from)
+	var user models.User
+	err := rs.userCollection.FindOne(ctx, bson.M{"phone": from}).Decode(&user)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return fmt.Errorf("failed to fetch user: %w", err)
+	}
+
+	resend := &models.TicketResend{Phone: from, Channel: models.ResendChannelSMS}
+	words := strings.Fields(text)
+	if len(words) == 0 || !smsTicketsKeywords[strings.ToUpper(words[0])] {
+		return rs.send(ctx, resend, i18n.T(user.Language, "sms.keyword_help"))
+	}
+
+	var tickets []string
+	if !user.ID.IsZero() {
+		tickets, resend.TicketIDs, err = rs.upcomingTickets(ctx, &user)
+		if err != nil {
+			return err
+		}
+	}
+	if len(tickets) == 0 {
+		return rs.send(ctx, resend, i18n.T(user.Language, "sms.tickets_none"))
+	}
+	return rs.send(ctx, resend, i18n.T(user.Language, "sms.tickets_list", strings.Join(tickets, "; ")))
+}
+
+// upcomingTickets describes the user's valid tickets for events that have
+// not passed, soonest first, with their IDs
+func (rs *TicketResendService) upcomingTickets(ctx context.Context, user *models.User) ([]string, []primitive.ObjectID, error) {
+	cursor, err := rs.ticketCollection.Find(ctx, bson.M{"user_id": user.ID, "status": "paid", "used_at": nil})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch tickets: %w", err)
+	}
+	var tickets []models.Ticket
+	if err := cursor.All(ctx, &tickets); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode tickets: %w", err)
+	}
+	if len(tickets) == 0 {
+		return nil, nil, nil
+	}
+
+	eventIDs := make([]primitive.ObjectID, 0, len(tickets))
+	for _, ticket := range tickets {
+		eventIDs = append(eventIDs, ticket.EventID)
+	}
+	// Events earlier today may still be under way
+	opts := options.Find().SetSort(bson.D{{Key: "date", Value: 1}})
+	cursor, err = rs.eventCollection.Find(ctx, bson.M{
+		"_id":  bson.M{"$in": eventIDs},
+		"date": bson.M{"$gte": time.Now().Add(-12 * time.Hour)},
+	}, opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch events: %w", err)
+	}
+	var events []models.Event
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode events: %w", err)
+	}
+
+	var described []string
+	var ids []primitive.ObjectID
+	for _, event := range events {
+		for i := range tickets {
+			if tickets[i].EventID != event.ID || len(described) == smsTicketsLimit {
+				continue
+			}
+			described = append(described, fmt.Sprintf("%s %s: %s",
+				event.Title, i18n.ShortDate(user.Language, event.Date), ticketCodeWithSeats(&tickets[i])))
+			ids = append(ids, tickets[i].ID)
+		}
+	}
+	return described, ids, nil
+}
+
+// send texts message to the phone in resend and records it. Customers who
+// have reached the limit are refused before anything is sent.
+func (rs *TicketResendService) send(ctx context.Context, resend *models.TicketResend, message string) error {
+	if resend.Channel != models.ResendChannelAdmin {
+		recent, err := rs.resendCollection.CountDocuments(ctx, bson.M{
+			"phone":      resend.Phone,
+			"channel":    bson.M{"$ne": models.ResendChannelAdmin},
+			"created_at": bson.M{"$gte": time.Now().Add(-ticketResendWindow)},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to count resends: %w", err)
+		}
+		if recent >= ticketResendLimit {
+			return ErrResendLimited
+		}
+	}
+
+	if err := rs.smsService.SendSMS(resend.Phone, message); err != nil {
+		return fmt.Errorf("failed to send ticket: %w", err)
+	}
+
+	resend.CreatedAt = time.Now()
+	if _, err := rs.resendCollection.InsertOne(ctx, resend); err != nil {
+		return fmt.Errorf("failed to record resend: %w", err)
+	}
+	return nil
+}
+
+// ticketCodeWithSeats is a ticket's code followed by its seats, if any
+func ticketCodeWithSeats(ticket *models.Ticket) string {
+	if len(ticket.Seats) == 0 {
+		return ticket.TicketCode
+	}
+	return ticket.TicketCode + " (" + strings.Join(ticket.SeatLabels(), "; ") + ")"
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"eventticketing/config"
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// outbox returns the messages queued so far, oldest first
+func outbox(t *testing.T) []models.SMSMessage {
+	t.Helper()
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := utils.GetCollection("sms_messages").Find(context.Background(), bson.M{}, opts)
+	if err != nil {
+		t.Fatalf("failed to read the outbox: %v", err)
+	}
+	var messages []models.SMSMessage
+	if err := cursor.All(context.Background(), &messages); err != nil {
+		t.Fatalf("failed to decode the outbox: %v", err)
+	}
+	return messages
+}
+
+func insertPaidTicket(t *testing.T, userID, eventID primitive.ObjectID) primitive.ObjectID {
+	t.Helper()
+	ticket := models.Ticket{UserID: userID, EventID: eventID, TicketCode: models.GenerateTicketCode(), Status: "paid", Quantity: 1, CreatedAt: time.Now()}
+	result, err := utils.GetCollection("tickets").InsertOne(context.Background(), ticket)
+	if err != nil {
+		t.Fatalf("failed to insert ticket: %v", err)
+	}
+	return result.InsertedID.(primitive.ObjectID)
+}
+
+func TestResendTicket(t *testing.T) {
+	setupInventoryTest(t)
+	config.AppConfig.Features.EnableSMS = true
+	ctx := context.Background()
+	rs := NewTicketResendService()
+
+	phone := "+233200000020"
+	buyer, _ := NewAccountService().FindOrCreateByPhone(ctx, phone)
+	ticketID := insertPaidTicket(t, buyer.ID, insertTestEvent(t, 10))
+
+	for i := 0; i < ticketResendLimit; i++ {
+		if err := rs.ResendTicket(ctx, ticketID, "", models.ResendChannelUSSD, primitive.NilObjectID); err != nil {
+			t.Fatalf("resend %d: %v", i+1, err)
+		}
+	}
+	if err := rs.ResendTicket(ctx, ticketID, "", models.ResendChannelUSSD, primitive.NilObjectID); !errors.Is(err, ErrResendLimited) {
+		t.Errorf("expected resend %d to be limited, got %v", ticketResendLimit+1, err)
+	}
+	// Support can still send it
+	if err := rs.ResendTicket(ctx, ticketID, "", models.ResendChannelAdmin, primitive.NewObjectID()); err != nil {
+		t.Errorf("expected an admin resend to go through the limit, got %v", err)
+	}
+
+	sent := outbox(t)
+	if len(sent) != ticketResendLimit+1 {
+		t.Fatalf("expected %d messages queued, got %d", ticketResendLimit+1, len(sent))
+	}
+	if sent[0].To != phone || !strings.Contains(sent[0].Body, "Concurrency Test Event") {
+		t.Errorf("expected the ticket sent to the buyer, got %+v", sent[0])
+	}
+
+	utils.GetCollection("tickets").UpdateByID(ctx, ticketID, bson.M{"$set": bson.M{"status": "cancelled"}})
+	if err := rs.ResendTicket(ctx, ticketID, "", models.ResendChannelAdmin, primitive.NewObjectID()); !errors.Is(err, ErrTicketNotResendable) {
+		t.Errorf("expected a cancelled ticket not to be resent, got %v", err)
+	}
+}
+
+func TestHandleInboundSMS(t *testing.T) {
+	setupInventoryTest(t)
+	config.AppConfig.Features.EnableSMS = true
+	ctx := context.Background()
+	rs := NewTicketResendService()
+
+	buyer, _ := NewAccountService().FindOrCreateByPhone(ctx, "+233200000021")
+	eventID := insertTestEvent(t, 10)
+	insertPaidTicket(t, buyer.ID, eventID)
+	insertPaidTicket(t, buyer.ID, eventID)
+
+	// Gateways send the number without the plus
+	if err := rs.HandleInboundSMS(ctx, "233200000021", " tix "); err != nil {
+		t.Fatalf("failed to answer TIX: %v", err)
+	}
+	if err := rs.HandleInboundSMS(ctx, "+233200000022", "TIX"); err != nil {
+		t.Fatalf("failed to answer an unknown number: %v", err)
+	}
+	if err := rs.HandleInboundSMS(ctx, "+233200000022", "hello"); err != nil {
+		t.Fatalf("failed to answer another keyword: %v", err)
+	}
+
+	sent := outbox(t)
+	if len(sent) != 3 {
+		t.Fatalf("expected 3 replies queued, got %d", len(sent))
+	}
+	if sent[0].To != "+233200000021" || strings.Count(sent[0].Body, "Concurrency Test Event") != 2 {
+		t.Errorf("expected both tickets in the reply, got %+v", sent[0])
+	}
+	if !strings.Contains(sent[1].Body, "no upcoming tickets") {
+		t.Errorf("expected an unknown number to be told it has no tickets, got %q", sent[1].Body)
+	}
+	if !strings.Contains(sent[2].Body, "Reply TIX") {
+		t.Errorf("expected a hint about the keyword, got %q", sent[2].Body)
+	}
+}
@@ -0,0 +1,428 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"eventticketing/i18n"
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Inputs that navigate on every USSD screen
+const (
+	USSDBack = "0"
+	USSDMore = "00"
+)
+
+// ussdPageSize is how many options a USSD screen lists before "00 More"
+const ussdPageSize = 5
+
+// ErrUSSDInvalidChoice is returned by menus for input they cannot act on.
+// The caller sees the same screen again.
+var ErrUSSDInvalidChoice = errors.New("invalid USSD choice")
+
+// ErrUSSDPhoneMismatch is returned for a request that names a session
+// started from another phone number
+var ErrUSSDPhoneMismatch = errors.New("USSD session belongs to another phone number")
+
+// USSDInput is one request from a USSD gateway. Text holds everything the
+// caller has entered in the session, separated by "*", or with Incremental
+// set only what they entered since the gateway's previous request.
+type USSDInput struct {
+	SessionID   string
+	ServiceCode string
+	PhoneNumber string
+	Text        string
+	Incremental bool
+	Sequence    int  // the gateway's count of requests in the session, when it sends one
+	Release     bool // the caller or the network has ended the session
+}
+
+// USSDReply is what the caller sees next
+type USSDReply struct {
+	Text string
+	End  bool // the session is over
+}
+
+// String formats the reply the way USSD gateways expect it
+func (r USSDReply) String() string {
+	if r.End {
+		return "END " + r.Text
+	}
+	return "CON " + r.Text
+}
+
+// USSDMenu is one screen of the USSD service. Show renders a page of the
+// screen; Choose acts on the value of the option the caller picked, or on
+// what they typed when the screen lists no options, and says where to go.
+// A menu without Choose only offers navigation.
+type USSDMenu struct {
+	Show   func(ctx context.Context, session *models.USSDSession, page int) (*USSDScreen, error)
+	Choose func(ctx context.Context, session *models.USSDSession, value string) (*USSDStep, error)
+}
+
+// USSDScreen is a rendered page of a menu
+type USSDScreen struct {
+	Text    string
+	Options []USSDOption // numbered from 1 on each page
+	More    bool         // whether another page follows
+	End     bool         // show Text and end the session
+}
+
+// USSDOption is a numbered choice on a screen
+type USSDOption struct {
+	Label string
+	Value string
+}
+
+// USSDStep is where a choice leads: to the menu named by Next, which "0 Back"
+// returns from unless Reset clears the way back, or to the end of the
+// session with the message End
+type USSDStep struct {
+	Next  string
+	Reset bool
+	End   string
+}
+
+// nextMenu moves the caller on to a menu
+func nextMenu(name string) *USSDStep {
+	return &USSDStep{Next: name}
+}
+
+// startOver moves the caller on to a menu with nothing to go back to
+func startOver(name string) *USSDStep {
+	return &USSDStep{Next: name, Reset: true}
+}
+
+// endSession ends the session with a message
+func endSession(message string) *USSDStep {
+	return &USSDStep{End: message}
+}
+
+// ussdText returns a message in the session's language
+func ussdText(session *models.USSDSession, key string, args ...interface{}) string {
+	return i18n.T(session.Language, key, args...)
+}
+
+// ussdPage returns a page of options and whether more follow
+func ussdPage(all []USSDOption, page int) ([]USSDOption, bool) {
+	start := page * ussdPageSize
+	if start >= len(all) {
+		return nil, false
+	}
+	end := start + ussdPageSize
+	if end >= len(all) {
+		return all[start:], false
+	}
+	return all[start:end], true
+}
+
+// USSDService runs USSD sessions through the registered menus, keeping each
+// session's place between the gateway's requests
+type USSDService struct {
+	sessionCollection *mongo.Collection
+	eventCollection   *mongo.Collection
+	ticketCollection  *mongo.Collection
+	userCollection    *mongo.Collection
+	inventoryService  *InventoryService
+	providers         *PaymentProviders
+	paymentService    *PaymentService
+	smsService        *SMSService
+	accountService    *AccountService
+	resendService     *TicketResendService
+	menus             map[string]USSDMenu
+}
+
+func NewUSSDService() *USSDService {
+	us := &USSDService{
+		sessionCollection: utils.GetCollection("ussd_sessions"),
+		eventCollection:   utils.GetCollection("events"),
+		ticketCollection:  utils.GetCollection("tickets"),
+		userCollection:    utils.GetCollection("users"),
+		inventoryService:  NewInventoryService(),
+		providers:         DefaultPaymentProviders(),
+		paymentService:    NewPaymentService(),
+		smsService:        NewSMSService(),
+		accountService:    NewAccountService(),
+		resendService:     NewTicketResendService(),
+		menus:             make(map[string]USSDMenu),
+	}
+	us.registerMenus()
+	return us
+}
+
+// Register adds a menu, replacing any menu of the same name
+func (us *USSDService) Register(name string, menu USSDMenu) {
+	us.menus[name] = menu
+}
+
+// Handle answers a gateway request. A new session starts at the main menu
+// and then takes any inputs the caller dialed with the service code. A
+// session only ever answers the phone number that started it.
+func (us *USSDService) Handle(ctx context.Context, input USSDInput) (USSDReply, error) {
+	if input.Release {
+		return USSDReply{End: true}, us.closeSession(ctx, input.SessionID, input.PhoneNumber)
+	}
+
+	session, err := us.loadSession(ctx, input.SessionID)
+	if err != nil {
+		return USSDReply{}, err
+	}
+	if session != nil && session.PhoneNumber != input.PhoneNumber {
+		return USSDReply{}, ErrUSSDPhoneMismatch
+	}
+
+	// The gateway is retrying a request that was already answered
+	if session != nil && (session.Ended || input.Sequence > 0 && input.Sequence <= session.Sequence) {
+		return storedUSSDReply(session), nil
+	}
+
+	previous := ""
+	if session != nil {
+		previous = session.Text
+	}
+	text := ussdSessionText(previous, input)
+
+	var reply USSDReply
+	var inputs []string
+	switch {
+	case session == nil:
+		session, err = us.newSession(ctx, input)
+		if err != nil {
+			return USSDReply{}, err
+		}
+		reply = us.show(ctx, session, "")
+		inputs = newUSSDInputs("", text)
+	default:
+		inputs = newUSSDInputs(session.Text, text)
+		if len(inputs) == 0 {
+			reply = us.show(ctx, session, "")
+		}
+	}
+
+	for _, in := range inputs {
+		if reply.End {
+			break
+		}
+		reply = us.step(ctx, session, in)
+	}
+
+	session.Text = text
+	session.Sequence = input.Sequence
+	session.Response = reply.String()
+	session.Ended = reply.End
+	if err := us.saveSession(ctx, session); err != nil {
+		return USSDReply{}, err
+	}
+	return reply, nil
+}
+
+// newSession starts a session in the caller's language. Callers dialing
+// for the first time get an account for their phone and choose their
+// language first.
+func (us *USSDService) newSession(ctx context.Context, input USSDInput) (*models.USSDSession, error) {
+	session := &models.USSDSession{
+		ID:          input.SessionID,
+		PhoneNumber: input.PhoneNumber,
+		ServiceCode: input.ServiceCode,
+		Menu:        ussdMainMenu,
+		CreatedAt:   time.Now(),
+	}
+
+	user, err := us.accountService.FindOrCreateByPhone(ctx, input.PhoneNumber)
+	if err != nil {
+		return nil, err
+	}
+	session.Language = user.Language
+	if user.USSDOnly && user.Language == "" {
+		session.Menu = ussdLanguageMenu
+	}
+	return session, nil
+}
+
+// Notice ends a session with a message in the caller's language, for
+// replies that do not come from the menus
+func (us *USSDService) Notice(ctx context.Context, input USSDInput, key string) USSDReply {
+	language := i18n.DefaultLanguage
+	if session, err := us.loadSession(ctx, input.SessionID); err == nil && session != nil && session.PhoneNumber == input.PhoneNumber {
+		language = session.Language
+	} else {
+		var user models.User
+		if err := us.userCollection.FindOne(ctx, bson.M{"phone": input.PhoneNumber}).Decode(&user); err == nil {
+			language = user.Language
+		}
+	}
+	return USSDReply{Text: i18n.T(language, key), End: true}
+}
+
+// ussdSessionText is everything entered in the session once input is added
+func ussdSessionText(previous string, input USSDInput) string {
+	switch {
+	case !input.Incremental:
+		return input.Text
+	case input.Text == "":
+		return previous
+	case previous == "":
+		return input.Text
+	}
+	return previous + "*" + input.Text
+}
+
+// storedUSSDReply is the reply last sent in the session
+func storedUSSDReply(session *models.USSDSession) USSDReply {
+	if text, ok := strings.CutPrefix(session.Response, "END "); ok {
+		return USSDReply{Text: text, End: true}
+	}
+	return USSDReply{Text: strings.TrimPrefix(session.Response, "CON ")}
+}
+
+// newUSSDInputs returns what the caller entered since the previous request
+func newUSSDInputs(previous, text string) []string {
+	switch {
+	case text == "" || text == previous:
+		return nil
+	case previous == "":
+		return strings.Split(text, "*")
+	case strings.HasPrefix(text, previous+"*"):
+		return strings.Split(strings.TrimPrefix(text, previous+"*"), "*")
+	}
+	// The gateway's text does not continue the session's; take its last input
+	parts := strings.Split(text, "*")
+	return parts[len(parts)-1:]
+}
+
+// step applies one input to the session and renders the screen it leads to
+func (us *USSDService) step(ctx context.Context, session *models.USSDSession, input string) USSDReply {
+	input = strings.TrimSpace(input)
+	switch {
+	case input == USSDMore && session.More:
+		session.Page++
+		return us.show(ctx, session, "")
+	case input == USSDBack && len(session.History) > 0:
+		previous := session.History[len(session.History)-1]
+		session.History = session.History[:len(session.History)-1]
+		session.Menu, session.Page = previous.Menu, previous.Page
+		return us.show(ctx, session, "")
+	}
+
+	value := input
+	if len(session.Options) > 0 {
+		choice, err := strconv.Atoi(input)
+		if err != nil || choice < 1 || choice > len(session.Options) {
+			return us.show(ctx, session, ussdText(session, "ussd.invalid_option"))
+		}
+		value = session.Options[choice-1]
+	}
+
+	menu := us.menus[session.Menu]
+	if menu.Choose == nil {
+		return us.show(ctx, session, ussdText(session, "ussd.invalid_option"))
+	}
+	next, err := menu.Choose(ctx, session, value)
+	if errors.Is(err, ErrUSSDInvalidChoice) {
+		return us.show(ctx, session, ussdText(session, "ussd.invalid_option"))
+	}
+	if err != nil {
+		log.Printf("USSD session %s failed in menu %s: %v", session.ID, session.Menu, err)
+		return USSDReply{Text: ussdText(session, "ussd.error"), End: true}
+	}
+	if next.Next == "" {
+		return USSDReply{Text: next.End, End: true}
+	}
+
+	if next.Reset {
+		session.History = nil
+	} else {
+		session.History = append(session.History, models.USSDPosition{Menu: session.Menu, Page: session.Page})
+	}
+	session.Menu, session.Page = next.Next, 0
+	return us.show(ctx, session, "")
+}
+
+// show renders the session's current screen, headed by notice when set
+func (us *USSDService) show(ctx context.Context, session *models.USSDSession, notice string) USSDReply {
+	menu, ok := us.menus[session.Menu]
+	if !ok {
+		log.Printf("USSD session %s is on unknown menu %s", session.ID, session.Menu)
+		return USSDReply{Text: ussdText(session, "ussd.error"), End: true}
+	}
+	screen, err := menu.Show(ctx, session, session.Page)
+	if err != nil {
+		log.Printf("USSD session %s failed to show menu %s: %v", session.ID, session.Menu, err)
+		return USSDReply{Text: ussdText(session, "ussd.error"), End: true}
+	}
+	if screen.End {
+		return USSDReply{Text: screen.Text, End: true}
+	}
+
+	var lines []string
+	if notice != "" {
+		lines = append(lines, notice)
+	}
+	lines = append(lines, screen.Text)
+
+	session.Options = session.Options[:0]
+	for i, option := range screen.Options {
+		lines = append(lines, fmt.Sprintf("%d. %s", i+1, option.Label))
+		session.Options = append(session.Options, option.Value)
+	}
+	session.More = screen.More
+	if screen.More {
+		lines = append(lines, USSDMore+". "+ussdText(session, "ussd.more"))
+	}
+	if len(session.History) > 0 {
+		lines = append(lines, USSDBack+". "+ussdText(session, "ussd.back"))
+	}
+	return USSDReply{Text: strings.Join(lines, "\n")}
+}
+
+// loadSession returns the session with the given id, or nil when there is
+// none or it has expired
+func (us *USSDService) loadSession(ctx context.Context, id string) (*models.USSDSession, error) {
+	var session models.USSDSession
+	err := us.sessionCollection.FindOne(ctx, bson.M{
+		"_id":        id,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch USSD session: %w", err)
+	}
+	return &session, nil
+}
+
+// closeSession ends a session the gateway reports closed for its phone number
+func (us *USSDService) closeSession(ctx context.Context, id, phoneNumber string) error {
+	_, err := us.sessionCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": id, "phone_number": phoneNumber},
+		bson.M{"$set": bson.M{"ended": true, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to close USSD session: %w", err)
+	}
+	return nil
+}
+
+// saveSession stores the session and extends its expiry by the session timeout
+func (us *USSDService) saveSession(ctx context.Context, session *models.USSDSession) error {
+	session.UpdatedAt = time.Now()
+	session.ExpiresAt = session.UpdatedAt.Add(CurrentSettings().USSDSessionDuration())
+	_, err := us.sessionCollection.ReplaceOne(ctx, bson.M{"_id": session.ID}, session, options.Replace().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to store USSD session: %w", err)
+	}
+	return nil
+}
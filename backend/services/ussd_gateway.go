@@ -0,0 +1,202 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"eventticketing/config"
+)
+
+// ErrMalformedUSSDRequest is returned for gateway requests that cannot be read
+var ErrMalformedUSSDRequest = errors.New("malformed USSD request")
+
+// USSDGateway is an aggregator that relays USSD sessions from the mobile
+// networks. Each speaks its own request and reply format.
+type USSDGateway interface {
+	// Name is the key USSD_GATEWAY selects the gateway by
+	Name() string
+	// ParseRequest reads a request from the gateway
+	ParseRequest(r *http.Request, body []byte) (*USSDInput, error)
+	// FormatReply writes the reply to a request as the gateway expects it
+	FormatReply(input *USSDInput, reply USSDReply) (contentType string, body []byte)
+}
+
+// NewUSSDGateway returns the gateway with the given name
+func NewUSSDGateway(name string) (USSDGateway, error) {
+	switch name {
+	case "json":
+		return JSONGateway{}, nil
+	case "africastalking":
+		return AfricasTalkingGateway{}, nil
+	case "hubtel":
+		return HubtelGateway{}, nil
+	}
+	return nil, fmt.Errorf("unknown USSD gateway %q", name)
+}
+
+// DefaultUSSDGateway returns the gateway named in the configuration, or the
+// JSON gateway when the name is not known
+func DefaultUSSDGateway() USSDGateway {
+	gateway, err := NewUSSDGateway(config.AppConfig.USSD.Gateway)
+	if err != nil {
+		log.Printf("%v; using the JSON gateway", err)
+		return JSONGateway{}
+	}
+	return gateway
+}
+
+// JSONGateway speaks the service's own JSON format, with cumulative text
+// as Africa's Talking sends it
+type JSONGateway struct{}
+
+type jsonUSSDRequest struct {
+	SessionID   string `json:"sessionId"`
+	ServiceCode string `json:"serviceCode"`
+	PhoneNumber string `json:"phoneNumber"`
+	Text        string `json:"text"`
+}
+
+type jsonUSSDResponse struct {
+	SessionID   string `json:"sessionId"`
+	ServiceCode string `json:"serviceCode"`
+	Response    string `json:"response"`
+}
+
+func (JSONGateway) Name() string { return "json" }
+
+func (JSONGateway) ParseRequest(r *http.Request, body []byte) (*USSDInput, error) {
+	var req jsonUSSDRequest
+	if err := json.Unmarshal(body, &req); err != nil || req.SessionID == "" {
+		return nil, ErrMalformedUSSDRequest
+	}
+	return &USSDInput{
+		SessionID:   req.SessionID,
+		ServiceCode: req.ServiceCode,
+		PhoneNumber: req.PhoneNumber,
+		Text:        req.Text,
+	}, nil
+}
+
+func (JSONGateway) FormatReply(input *USSDInput, reply USSDReply) (string, []byte) {
+	body, _ := json.Marshal(jsonUSSDResponse{
+		SessionID:   input.SessionID,
+		ServiceCode: input.ServiceCode,
+		Response:    reply.String(),
+	})
+	return "application/json; charset=utf-8", body
+}
+
+// AfricasTalkingGateway posts form fields with cumulative text and takes
+// the reply as plain text starting with CON or END
+type AfricasTalkingGateway struct{}
+
+func (AfricasTalkingGateway) Name() string { return "africastalking" }
+
+func (AfricasTalkingGateway) ParseRequest(r *http.Request, body []byte) (*USSDInput, error) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil || form.Get("sessionId") == "" {
+		return nil, ErrMalformedUSSDRequest
+	}
+	return &USSDInput{
+		SessionID:   form.Get("sessionId"),
+		ServiceCode: form.Get("serviceCode"),
+		PhoneNumber: form.Get("phoneNumber"),
+		Text:        form.Get("text"),
+	}, nil
+}
+
+func (AfricasTalkingGateway) FormatReply(input *USSDInput, reply USSDReply) (string, []byte) {
+	return "text/plain; charset=utf-8", []byte(reply.String())
+}
+
+// HubtelGateway posts JSON with only the caller's latest entry and a
+// request type, and takes a JSON reply whose type says whether the session
+// goes on
+type HubtelGateway struct{}
+
+// Hubtel request and reply types
+const (
+	hubtelInitiation = "Initiation"
+	hubtelResponse   = "Response"
+	hubtelRelease    = "Release"
+	hubtelTimeout    = "Timeout"
+)
+
+type hubtelUSSDRequest struct {
+	Type        string `json:"Type"`
+	Mobile      string `json:"Mobile"`
+	SessionID   string `json:"SessionId"`
+	ServiceCode string `json:"ServiceCode"`
+	Message     string `json:"Message"`
+	Operator    string `json:"Operator"`
+	Sequence    int    `json:"Sequence"`
+	ClientState string `json:"ClientState"`
+}
+
+type hubtelUSSDResponse struct {
+	Type        string `json:"Type"`
+	Message     string `json:"Message"`
+	ClientState string `json:"ClientState"`
+}
+
+func (HubtelGateway) Name() string { return "hubtel" }
+
+func (HubtelGateway) ParseRequest(r *http.Request, body []byte) (*USSDInput, error) {
+	var req hubtelUSSDRequest
+	if err := json.Unmarshal(body, &req); err != nil || req.SessionID == "" {
+		return nil, ErrMalformedUSSDRequest
+	}
+
+	input := &USSDInput{
+		SessionID:   req.SessionID,
+		ServiceCode: req.ServiceCode,
+		PhoneNumber: internationalPhone(req.Mobile),
+		Incremental: true,
+		Sequence:    req.Sequence,
+	}
+	switch req.Type {
+	case hubtelInitiation:
+		// The message is what was dialed, e.g. *713*2#; keep what follows the code
+		input.Text = dialedEntries(req.Message, req.ServiceCode)
+	case hubtelResponse:
+		input.Text = req.Message
+	case hubtelRelease, hubtelTimeout:
+		input.Release = true
+	default:
+		return nil, ErrMalformedUSSDRequest
+	}
+	return input, nil
+}
+
+func (HubtelGateway) FormatReply(input *USSDInput, reply USSDReply) (string, []byte) {
+	response := hubtelUSSDResponse{Type: hubtelResponse, Message: reply.Text}
+	if reply.End {
+		response.Type = hubtelRelease
+	}
+	body, _ := json.Marshal(response)
+	return "application/json; charset=utf-8", body
+}
+
+// dialedEntries returns the entries dialed after the service code, joined by "*"
+func dialedEntries(dialed, serviceCode string) string {
+	entries := strings.Split(strings.Trim(dialed, "*#"), "*")
+	code := strings.Split(strings.Trim(serviceCode, "*#"), "*")
+	for len(code) > 0 && len(entries) > 0 && entries[0] == code[0] {
+		entries, code = entries[1:], code[1:]
+	}
+	return strings.Join(entries, "*")
+}
+
+// internationalPhone writes a number such as 233244123456 as +233244123456,
+// the way Africa's Talking sends it and phone numbers are stored
+func internationalPhone(number string) string {
+	if number == "" || strings.HasPrefix(number, "+") {
+		return number
+	}
+	return "+" + number
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readUSSDFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "ussd", name))
+	if err != nil {
+		t.Fatalf("failed to read fixture %s: %v", name, err)
+	}
+	return data
+}
+
+func TestUSSDGateways(t *testing.T) {
+	cases := []struct {
+		gateway     string
+		request     string
+		input       USSDInput
+		reply       USSDReply
+		response    string
+		contentType string
+	}{
+		{
+			gateway: "africastalking",
+			request: "africastalking_request.txt",
+			input: USSDInput{
+				SessionID:   "ATUid_4bd4f1a2c3",
+				ServiceCode: "*384*123#",
+				PhoneNumber: "+233244123456",
+				Text:        "2*00*1",
+			},
+			reply:       USSDReply{Text: "Select Event:\n1. Concert - Jan 2\n00. More\n0. Back"},
+			response:    "africastalking_response.txt",
+			contentType: "text/plain",
+		},
+		{
+			gateway: "json",
+			request: "json_request.json",
+			input: USSDInput{
+				SessionID:   "session_123",
+				ServiceCode: "*123#",
+				PhoneNumber: "+233244123456",
+				Text:        "1*2",
+			},
+			reply:       USSDReply{Text: "Ticket purchased successfully!", End: true},
+			response:    "json_response.json",
+			contentType: "application/json",
+		},
+		{
+			gateway: "hubtel",
+			request: "hubtel_initiation.json",
+			input: USSDInput{
+				SessionID:   "3c796dac28174f739de4262d08409c51",
+				ServiceCode: "713",
+				PhoneNumber: "+233244123456",
+				Text:        "2", // dialed as *713*2#
+				Incremental: true,
+				Sequence:    1,
+			},
+			reply:       USSDReply{Text: "Select Event:\n1. Concert - Jan 2\n0. Back"},
+			response:    "hubtel_response.json",
+			contentType: "application/json",
+		},
+		{
+			gateway: "hubtel",
+			request: "hubtel_request.json",
+			input: USSDInput{
+				SessionID:   "3c796dac28174f739de4262d08409c51",
+				ServiceCode: "713",
+				PhoneNumber: "+233244123456",
+				Text:        "00",
+				Incremental: true,
+				Sequence:    2,
+			},
+			reply:       USSDReply{Text: "Purchase cancelled.", End: true},
+			response:    "hubtel_release_response.json",
+			contentType: "application/json",
+		},
+		{
+			gateway: "hubtel",
+			request: "hubtel_release.json",
+			input: USSDInput{
+				SessionID:   "3c796dac28174f739de4262d08409c51",
+				ServiceCode: "713",
+				PhoneNumber: "+233244123456",
+				Incremental: true,
+				Sequence:    3,
+				Release:     true,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		gateway, err := NewUSSDGateway(tc.gateway)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.gateway, err)
+		}
+
+		body := readUSSDFixture(t, tc.request)
+		r := httptest.NewRequest("POST", "/api/ussd/entry", strings.NewReader(string(body)))
+		input, err := gateway.ParseRequest(r, body)
+		if err != nil {
+			t.Fatalf("%s: failed to parse %s: %v", tc.gateway, tc.request, err)
+		}
+		if *input != tc.input {
+			t.Errorf("%s: expected %+v from %s, got %+v", tc.gateway, tc.input, tc.request, *input)
+		}
+
+		if tc.response == "" {
+			continue
+		}
+		contentType, response := gateway.FormatReply(input, tc.reply)
+		if expected := readUSSDFixture(t, tc.response); string(response) != string(expected) {
+			t.Errorf("%s: expected reply %s, got %s", tc.gateway, expected, response)
+		}
+		if !strings.HasPrefix(contentType, tc.contentType) {
+			t.Errorf("%s: expected content type %s, got %s", tc.gateway, tc.contentType, contentType)
+		}
+	}
+}
+
+func TestUSSDGatewayRejectsMalformedRequests(t *testing.T) {
+	requests := map[string]string{
+		"json":           `{"serviceCode": "*123#"}`,
+		"africastalking": "serviceCode=%2A123%23&text=1",
+		"hubtel":         `{"Type": "Unknown", "SessionId": "abc"}`,
+	}
+	for name, body := range requests {
+		gateway, _ := NewUSSDGateway(name)
+		r := httptest.NewRequest("POST", "/api/ussd/entry", strings.NewReader(body))
+		if _, err := gateway.ParseRequest(r, []byte(body)); err != ErrMalformedUSSDRequest {
+			t.Errorf("%s: expected a malformed request error, got %v", name, err)
+		}
+	}
+
+	if _, err := NewUSSDGateway("nalo"); err == nil {
+		t.Error("expected an unknown gateway to be refused")
+	}
+}
@@ -0,0 +1,518 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"eventticketing/i18n"
+	"eventticketing/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// USSD menus. A session starts at ussdMainMenu, or at ussdLanguageMenu for
+// callers who have not chosen a language yet.
+const (
+	ussdLanguageMenu   = "language"
+	ussdMainMenu       = "main"
+	ussdEventsMenu     = "events"
+	ussdEventMenu      = "event"
+	ussdBuyMenu        = "buy"
+	ussdTicketTypeMenu = "ticket_type"
+	ussdQuantityMenu   = "quantity"
+	ussdConfirmMenu    = "confirm"
+	ussdTicketsMenu    = "tickets"
+	ussdTicketMenu     = "ticket"
+	ussdHelpMenu       = "help"
+)
+
+// ussdMaxQuantity caps how many tickets one USSD purchase can buy
+const ussdMaxQuantity = 10
+
+// registerMenus adds the menus of the EventTix USSD service
+func (us *USSDService) registerMenus() {
+	us.Register(ussdLanguageMenu, USSDMenu{
+		Show: func(ctx context.Context, s *models.USSDSession, page int) (*USSDScreen, error) {
+			var all []USSDOption
+			for _, language := range i18n.Languages {
+				all = append(all, USSDOption{Label: language.Name, Value: language.Code})
+			}
+			shown, more := ussdPage(all, page)
+			return &USSDScreen{Text: ussdText(s, "ussd.language.title"), Options: shown, More: more}, nil
+		},
+		Choose: func(ctx context.Context, s *models.USSDSession, value string) (*USSDStep, error) {
+			s.Language = value
+			// The caller's account keeps the choice for SMS and later sessions
+			_, err := us.userCollection.UpdateOne(ctx, bson.M{"phone": s.PhoneNumber}, bson.M{"$set": bson.M{"language": value, "updated_at": time.Now()}})
+			if err != nil {
+				return nil, fmt.Errorf("failed to save language: %w", err)
+			}
+			return startOver(ussdMainMenu), nil
+		},
+	})
+
+	us.Register(ussdMainMenu, USSDMenu{
+		Show: func(ctx context.Context, s *models.USSDSession, page int) (*USSDScreen, error) {
+			return &USSDScreen{
+				Text: ussdText(s, "ussd.main.title"),
+				Options: []USSDOption{
+					{Label: ussdText(s, "ussd.main.events"), Value: ussdEventsMenu},
+					{Label: ussdText(s, "ussd.main.buy"), Value: ussdBuyMenu},
+					{Label: ussdText(s, "ussd.main.tickets"), Value: ussdTicketsMenu},
+					{Label: ussdText(s, "ussd.main.help"), Value: ussdHelpMenu},
+					{Label: ussdText(s, "ussd.main.language"), Value: ussdLanguageMenu},
+				},
+			}, nil
+		},
+		Choose: func(ctx context.Context, s *models.USSDSession, value string) (*USSDStep, error) {
+			return nextMenu(value), nil
+		},
+	})
+
+	us.Register(ussdEventsMenu, USSDMenu{
+		Show: func(ctx context.Context, s *models.USSDSession, page int) (*USSDScreen, error) {
+			return us.showActiveEvents(ctx, s, "ussd.events.title", page)
+		},
+		Choose: func(ctx context.Context, s *models.USSDSession, value string) (*USSDStep, error) {
+			s.Set("event_id", value)
+			return nextMenu(ussdEventMenu), nil
+		},
+	})
+
+	us.Register(ussdEventMenu, USSDMenu{
+		Show: func(ctx context.Context, s *models.USSDSession, page int) (*USSDScreen, error) {
+			event, err := us.sessionEvent(ctx, s)
+			if err != nil {
+				return nil, err
+			}
+			return &USSDScreen{
+				Text: ussdText(s, "ussd.event.details",
+					event.Title, i18n.Date(s.Language, event.Date), event.Location, models.FormatAmount(event.LivePrice(event.Price), event.Currency), event.GetAvailableTickets()),
+				Options: []USSDOption{{Label: ussdText(s, "ussd.main.buy"), Value: ussdBuyMenu}},
+			}, nil
+		},
+		Choose: func(ctx context.Context, s *models.USSDSession, value string) (*USSDStep, error) {
+			return us.startPurchase(ctx, s)
+		},
+	})
+
+	us.Register(ussdBuyMenu, USSDMenu{
+		Show: func(ctx context.Context, s *models.USSDSession, page int) (*USSDScreen, error) {
+			return us.showActiveEvents(ctx, s, "ussd.buy.title", page)
+		},
+		Choose: func(ctx context.Context, s *models.USSDSession, value string) (*USSDStep, error) {
+			s.Set("event_id", value)
+			return us.startPurchase(ctx, s)
+		},
+	})
+
+	us.Register(ussdTicketTypeMenu, USSDMenu{
+		Show: func(ctx context.Context, s *models.USSDSession, page int) (*USSDScreen, error) {
+			event, err := us.sessionEvent(ctx, s)
+			if err != nil {
+				return nil, err
+			}
+			var all []USSDOption
+			for _, tt := range onSaleTicketTypes(event) {
+				all = append(all, USSDOption{
+					Label: fmt.Sprintf("%s - %s", tt.Name, models.FormatAmount(event.LivePrice(tt.Price), event.Currency)),
+					Value: tt.ID.Hex(),
+				})
+			}
+			if len(all) == 0 {
+				return &USSDScreen{Text: ussdText(s, "ussd.sold_out"), End: true}, nil
+			}
+			shown, more := ussdPage(all, page)
+			return &USSDScreen{Text: ussdText(s, "ussd.ticket_type.title", event.Title), Options: shown, More: more}, nil
+		},
+		Choose: func(ctx context.Context, s *models.USSDSession, value string) (*USSDStep, error) {
+			s.Set("ticket_type_id", value)
+			return nextMenu(ussdQuantityMenu), nil
+		},
+	})
+
+	us.Register(ussdQuantityMenu, USSDMenu{
+		Show: func(ctx context.Context, s *models.USSDSession, page int) (*USSDScreen, error) {
+			event, ticketType, err := us.sessionPurchase(ctx, s)
+			if err != nil {
+				return nil, err
+			}
+			limit := ussdPurchaseLimit(event, ticketType)
+			if limit < 1 {
+				return &USSDScreen{Text: ussdText(s, "ussd.sold_out"), End: true}, nil
+			}
+			if limit == 1 {
+				return &USSDScreen{Text: ussdText(s, "ussd.quantity.one")}, nil
+			}
+			return &USSDScreen{Text: ussdText(s, "ussd.quantity.range", limit)}, nil
+		},
+		Choose: func(ctx context.Context, s *models.USSDSession, value string) (*USSDStep, error) {
+			event, ticketType, err := us.sessionPurchase(ctx, s)
+			if err != nil {
+				return nil, err
+			}
+			quantity, err := strconv.Atoi(value)
+			if err != nil || quantity < 1 || quantity > ussdPurchaseLimit(event, ticketType) {
+				return nil, ErrUSSDInvalidChoice
+			}
+			s.Set("quantity", value)
+			return nextMenu(ussdConfirmMenu), nil
+		},
+	})
+
+	us.Register(ussdConfirmMenu, USSDMenu{
+		Show: func(ctx context.Context, s *models.USSDSession, page int) (*USSDScreen, error) {
+			event, ticketType, err := us.sessionPurchase(ctx, s)
+			if err != nil {
+				return nil, err
+			}
+			quantity, _ := strconv.Atoi(s.Get("quantity"))
+
+			price := event.LivePrice(event.Price)
+			text := ussdText(s, "ussd.confirm.summary", event.Title,
+				models.FormatAmount(price, event.Currency), quantity, models.FormatAmount(price*int64(quantity), event.Currency))
+			if ticketType != nil {
+				price = event.LivePrice(ticketType.Price)
+				text = ussdText(s, "ussd.confirm.summary_type", event.Title, ticketType.Name,
+					models.FormatAmount(price, event.Currency), quantity, models.FormatAmount(price*int64(quantity), event.Currency))
+			}
+			return &USSDScreen{
+				Text:    text,
+				Options: []USSDOption{{Label: ussdText(s, "ussd.confirm.purchase"), Value: "confirm"}},
+			}, nil
+		},
+		Choose: func(ctx context.Context, s *models.USSDSession, value string) (*USSDStep, error) {
+			return us.purchase(ctx, s)
+		},
+	})
+
+	us.Register(ussdTicketsMenu, USSDMenu{
+		Show: func(ctx context.Context, s *models.USSDSession, page int) (*USSDScreen, error) {
+			return us.showTickets(ctx, s, page)
+		},
+		Choose: func(ctx context.Context, s *models.USSDSession, value string) (*USSDStep, error) {
+			s.Set("ticket_id", value)
+			return nextMenu(ussdTicketMenu), nil
+		},
+	})
+
+	us.Register(ussdTicketMenu, USSDMenu{
+		Show: func(ctx context.Context, s *models.USSDSession, page int) (*USSDScreen, error) {
+			ticketID, err := primitive.ObjectIDFromHex(s.Get("ticket_id"))
+			if err != nil {
+				return nil, err
+			}
+			var ticket models.Ticket
+			if err := us.ticketCollection.FindOne(ctx, bson.M{"_id": ticketID}).Decode(&ticket); err != nil {
+				return nil, fmt.Errorf("failed to fetch ticket: %w", err)
+			}
+			var event models.Event
+			if err := us.eventCollection.FindOne(ctx, bson.M{"_id": ticket.EventID}).Decode(&event); err != nil {
+				return nil, fmt.Errorf("failed to fetch event: %w", err)
+			}
+			screen := &USSDScreen{
+				Text: ussdText(s, "ussd.ticket.details",
+					ticket.TicketCode, event.Title, i18n.TicketStatus(s.Language, ticket.Status), i18n.Date(s.Language, event.Date)),
+			}
+			if ticket.IsValid() {
+				screen.Options = []USSDOption{{Label: ussdText(s, "ussd.ticket.resend"), Value: "resend"}}
+			} else {
+				screen.End = true
+			}
+			return screen, nil
+		},
+		Choose: func(ctx context.Context, s *models.USSDSession, value string) (*USSDStep, error) {
+			ticketID, err := primitive.ObjectIDFromHex(s.Get("ticket_id"))
+			if err != nil {
+				return nil, err
+			}
+			err = us.resendService.ResendTicket(ctx, ticketID, s.PhoneNumber, models.ResendChannelUSSD, primitive.NilObjectID)
+			switch {
+			case err == nil:
+				return endSession(ussdText(s, "ussd.ticket.resent")), nil
+			case errors.Is(err, ErrResendLimited):
+				return endSession(ussdText(s, "ussd.ticket.resend_limited")), nil
+			case errors.Is(err, ErrSMSDisabled):
+				return endSession(ussdText(s, "ussd.ticket.resend_unavailable")), nil
+			}
+			return nil, err
+		},
+	})
+
+	us.Register(ussdHelpMenu, USSDMenu{
+		Show: func(ctx context.Context, s *models.USSDSession, page int) (*USSDScreen, error) {
+			return &USSDScreen{Text: ussdText(s, "ussd.help")}, nil
+		},
+	})
+}
+
+// showActiveEvents lists a page of the active events, soonest first
+func (us *USSDService) showActiveEvents(ctx context.Context, s *models.USSDSession, heading string, page int) (*USSDScreen, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "date", Value: 1}, {Key: "_id", Value: 1}}).
+		SetSkip(int64(page * ussdPageSize)).
+		SetLimit(ussdPageSize + 1)
+
+	cursor, err := us.eventCollection.Find(ctx, bson.M{"status": "active"}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch events: %w", err)
+	}
+	var events []models.Event
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, fmt.Errorf("failed to decode events: %w", err)
+	}
+
+	if len(events) == 0 && page == 0 {
+		return &USSDScreen{Text: ussdText(s, "ussd.events.none"), End: true}, nil
+	}
+
+	screen := &USSDScreen{Text: ussdText(s, heading)}
+	if len(events) > ussdPageSize {
+		events = events[:ussdPageSize]
+		screen.More = true
+	}
+	for _, event := range events {
+		screen.Options = append(screen.Options, USSDOption{
+			Label: fmt.Sprintf("%s - %s", event.Title, i18n.ShortDate(s.Language, event.Date)),
+			Value: event.ID.Hex(),
+		})
+	}
+	return screen, nil
+}
+
+// showTickets lists a page of the caller's tickets, newest first
+func (us *USSDService) showTickets(ctx context.Context, s *models.USSDSession, page int) (*USSDScreen, error) {
+	user, err := us.accountService.FindOrCreateByPhone(ctx, s.PhoneNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64(page * ussdPageSize)).
+		SetLimit(ussdPageSize + 1)
+
+	cursor, err := us.ticketCollection.Find(ctx, bson.M{"user_id": user.ID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tickets: %w", err)
+	}
+	var tickets []models.Ticket
+	if err := cursor.All(ctx, &tickets); err != nil {
+		return nil, fmt.Errorf("failed to decode tickets: %w", err)
+	}
+
+	if len(tickets) == 0 && page == 0 {
+		return &USSDScreen{Text: ussdText(s, "ussd.tickets.none"), End: true}, nil
+	}
+
+	screen := &USSDScreen{Text: ussdText(s, "ussd.tickets.title")}
+	if len(tickets) > ussdPageSize {
+		tickets = tickets[:ussdPageSize]
+		screen.More = true
+	}
+	for _, ticket := range tickets {
+		var event models.Event
+		if err := us.eventCollection.FindOne(ctx, bson.M{"_id": ticket.EventID}).Decode(&event); err != nil {
+			continue
+		}
+		screen.Options = append(screen.Options, USSDOption{
+			Label: fmt.Sprintf("%s - %s", event.Title, i18n.TicketStatus(s.Language, ticket.Status)),
+			Value: ticket.ID.Hex(),
+		})
+	}
+	return screen, nil
+}
+
+// startPurchase asks for the ticket type on events sold by tier, and for
+// the quantity on the rest
+func (us *USSDService) startPurchase(ctx context.Context, s *models.USSDSession) (*USSDStep, error) {
+	event, err := us.sessionEvent(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	// A queue's admission tokens cannot be used over USSD
+	if event.HasQueue() {
+		return endSession(ussdText(s, "ussd.purchase.queued")), nil
+	}
+	s.Set("ticket_type_id", "")
+	s.Set("quantity", "")
+	if event.HasTicketTypes() {
+		return nextMenu(ussdTicketTypeMenu), nil
+	}
+	return nextMenu(ussdQuantityMenu), nil
+}
+
+// sessionEvent loads the event chosen in the session
+func (us *USSDService) sessionEvent(ctx context.Context, s *models.USSDSession) (*models.Event, error) {
+	eventID, err := primitive.ObjectIDFromHex(s.Get("event_id"))
+	if err != nil {
+		return nil, fmt.Errorf("no event chosen: %w", err)
+	}
+	var event models.Event
+	if err := us.eventCollection.FindOne(ctx, bson.M{"_id": eventID}).Decode(&event); err != nil {
+		return nil, fmt.Errorf("failed to fetch event: %w", err)
+	}
+	return &event, nil
+}
+
+// sessionPurchase loads the event and, for tiered events, the ticket type
+// chosen in the session
+func (us *USSDService) sessionPurchase(ctx context.Context, s *models.USSDSession) (*models.Event, *models.TicketType, error) {
+	event, err := us.sessionEvent(ctx, s)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !event.HasTicketTypes() {
+		return event, nil, nil
+	}
+	ticketTypeID, err := primitive.ObjectIDFromHex(s.Get("ticket_type_id"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("no ticket type chosen: %w", err)
+	}
+	ticketType := event.FindTicketType(ticketTypeID)
+	if ticketType == nil {
+		return nil, nil, ErrTicketTypeNotFound
+	}
+	return event, ticketType, nil
+}
+
+// onSaleTicketTypes returns the event's tiers that can currently be bought, in menu order
+func onSaleTicketTypes(event *models.Event) []models.TicketType {
+	var ticketTypes []models.TicketType
+	for _, tt := range event.TicketTypes {
+		if tt.IsOnSale(time.Now()) && tt.GetAvailableTickets() > 0 {
+			ticketTypes = append(ticketTypes, tt)
+		}
+	}
+	return ticketTypes
+}
+
+// ussdPurchaseLimit is the most tickets the caller can buy in one go
+func ussdPurchaseLimit(event *models.Event, ticketType *models.TicketType) int {
+	limit := ussdMaxQuantity
+	available := event.GetAvailableTickets()
+	if ticketType != nil {
+		available = ticketType.GetAvailableTickets()
+		if ticketType.MaxPerOrder > 0 && ticketType.MaxPerOrder < limit {
+			limit = ticketType.MaxPerOrder
+		}
+	}
+	if available < limit {
+		limit = available
+	}
+	return limit
+}
+
+// purchase places the order chosen in the session and ends it with the result
+func (us *USSDService) purchase(ctx context.Context, s *models.USSDSession) (*USSDStep, error) {
+	event, ticketType, err := us.sessionPurchase(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	if event.HasQueue() {
+		return endSession(ussdText(s, "ussd.purchase.queued")), nil
+	}
+	quantity, err := strconv.Atoi(s.Get("quantity"))
+	if err != nil {
+		return nil, fmt.Errorf("no quantity chosen: %w", err)
+	}
+
+	user, err := us.accountService.FindOrCreateByPhone(ctx, s.PhoneNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	var ticketTypeID primitive.ObjectID
+	if ticketType != nil {
+		ticketTypeID = ticketType.ID
+	}
+
+	// Claim the tickets atomically so the menu cannot oversell a sold-out event
+	reservation, err := us.inventoryService.Reserve(ctx, event.ID, ticketTypeID, quantity)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrTicketsUnavailable):
+			return endSession(ussdText(s, "ussd.purchase.not_enough")), nil
+		case errors.Is(err, ErrTicketTypeNotOnSale):
+			return endSession(ussdText(s, "ussd.purchase.not_on_sale")), nil
+		case errors.Is(err, ErrOrderLimitExceeded):
+			return endSession(ussdText(s, "ussd.purchase.order_limit")), nil
+		}
+		return endSession(ussdText(s, "ussd.purchase.reserve_failed")), nil
+	}
+	defer reservation.Rollback(context.Background())
+
+	event = reservation.Event
+
+	// USSD callers pay from the phone they dialed with
+	provider, err := us.providers.Select(event, "momo")
+	if err != nil {
+		return endSession(ussdText(s, "ussd.purchase.payment_failed")), nil
+	}
+
+	// Create the order; USSD cannot show a seat map, so reserved-seating
+	// events get the best available seats
+	order, tickets, err := reservation.PlaceOrder(ctx, user.ID, nil)
+	if err != nil {
+		if errors.Is(err, ErrSeatUnavailable) {
+			return endSession(ussdText(s, "ussd.purchase.no_seats")), nil
+		}
+		return endSession(ussdText(s, "ussd.purchase.order_failed")), nil
+	}
+	ticket := tickets[0]
+
+	payment := models.Payment{
+		UserID:      user.ID,
+		EventID:     event.ID,
+		OrderID:     order.ID,
+		Amount:      order.TotalAmount,
+		Currency:    order.Currency,
+		Status:      "pending",
+		PaymentType: "ussd",
+		Provider:    provider.Name(),
+		MoMoRef:     provider.NewReference(),
+		PhoneNumber: s.PhoneNumber,
+		Description: fmt.Sprintf("USSD payment for %d %s ticket(s) - %s", quantity, ticket.GetTicketTypeName(), event.Title),
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if err := reservation.InsertPayment(ctx, &payment); err != nil {
+		return endSession(ussdText(s, "ussd.purchase.payment_failed")), nil
+	}
+
+	// Once the provider has been asked it may take the money, so the order is
+	// kept and left pending for the callback, the reconciler or the hold
+	// sweeper. Ticket codes are sent when the payment is confirmed.
+	reservation.Commit()
+
+	if _, err := provider.Initiate(ctx, &payment, event); err != nil {
+		log.Printf("Failed to initiate USSD payment %s: %v", payment.ID.Hex(), err)
+		if errors.Is(err, ErrProviderRejected) {
+			// A refused request took nothing, so the tickets go straight back
+			if _, err := us.paymentService.MarkFailed(ctx, &payment); err != nil {
+				log.Printf("Failed to release rejected payment %s: %v", payment.ID.Hex(), err)
+			}
+		}
+		return endSession(ussdText(s, "ussd.purchase.payment_failed")), nil
+	}
+
+	var seats []string
+	for _, t := range tickets {
+		seats = append(seats, t.SeatLabels()...)
+	}
+	seatLine := ""
+	if len(seats) == 1 {
+		seatLine = ussdText(s, "ussd.purchase.seat", seats[0])
+	} else if len(seats) > 1 {
+		seatLine = ussdText(s, "ussd.purchase.seats", strings.Join(seats, ", "))
+	}
+	return endSession(ussdText(s, "ussd.purchase.approve",
+		len(tickets), event.Title, ticket.GetTicketTypeName(), seatLine, models.FormatAmount(order.TotalAmount, order.Currency))), nil
+}
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"eventticketing/config"
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestNewUSSDInputs(t *testing.T) {
+	cases := []struct {
+		previous, text string
+		expected       []string
+	}{
+		{"", "", nil},
+		{"", "1", []string{"1"}},
+		{"", "2*1", []string{"2", "1"}}, // dialed with the service code
+		{"1", "1*00", []string{"00"}},
+		{"1*00", "1*00*3", []string{"3"}},
+		{"1*2", "1*2", nil}, // a retried request
+		{"1*2", "4", []string{"4"}},
+	}
+	for _, tc := range cases {
+		if got := newUSSDInputs(tc.previous, tc.text); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("inputs from %q to %q: expected %v, got %v", tc.previous, tc.text, tc.expected, got)
+		}
+	}
+}
+
+func TestUSSDSessionText(t *testing.T) {
+	cases := []struct {
+		previous string
+		input    USSDInput
+		expected string
+	}{
+		{"1*2", USSDInput{Text: "1*2*3"}, "1*2*3"},
+		{"", USSDInput{Text: "2", Incremental: true}, "2"},
+		{"2", USSDInput{Text: "00", Incremental: true}, "2*00"},
+		{"2*00", USSDInput{Incremental: true}, "2*00"},
+	}
+	for _, tc := range cases {
+		if got := ussdSessionText(tc.previous, tc.input); got != tc.expected {
+			t.Errorf("text after %q with %+v: expected %q, got %q", tc.previous, tc.input, tc.expected, got)
+		}
+	}
+}
+
+func TestUSSDNavigation(t *testing.T) {
+	us := &USSDService{menus: make(map[string]USSDMenu)}
+	us.Register(ussdMainMenu, USSDMenu{
+		Show: func(ctx context.Context, s *models.USSDSession, page int) (*USSDScreen, error) {
+			return &USSDScreen{Text: "Main", Options: []USSDOption{{Label: "List", Value: "list"}}}, nil
+		},
+		Choose: func(ctx context.Context, s *models.USSDSession, value string) (*USSDStep, error) {
+			return nextMenu(value), nil
+		},
+	})
+	us.Register("list", USSDMenu{
+		Show: func(ctx context.Context, s *models.USSDSession, page int) (*USSDScreen, error) {
+			var all []USSDOption
+			for i := 1; i <= 7; i++ {
+				all = append(all, USSDOption{Label: fmt.Sprintf("Item %d", i), Value: fmt.Sprint(i)})
+			}
+			shown, more := ussdPage(all, page)
+			return &USSDScreen{Text: "Items", Options: shown, More: more}, nil
+		},
+		Choose: func(ctx context.Context, s *models.USSDSession, value string) (*USSDStep, error) {
+			return endSession("Picked " + value), nil
+		},
+	})
+
+	ctx := context.Background()
+	session := &models.USSDSession{Menu: ussdMainMenu}
+	if reply := us.show(ctx, session, ""); reply.String() != "CON Main\n1. List" {
+		t.Fatalf("unexpected main menu: %q", reply.String())
+	}
+
+	reply := us.step(ctx, session, "1")
+	if !strings.Contains(reply.Text, "5. Item 5\n00. More\n0. Back") || strings.Contains(reply.Text, "Item 6") {
+		t.Fatalf("expected the first page of five items, got %q", reply.Text)
+	}
+	reply = us.step(ctx, session, "00")
+	if reply.Text != "Items\n1. Item 6\n2. Item 7\n0. Back" {
+		t.Fatalf("expected the last page, got %q", reply.Text)
+	}
+	if reply = us.step(ctx, session, "00"); !strings.HasPrefix(reply.Text, "Invalid option") {
+		t.Errorf("expected More on the last page to be refused, got %q", reply.Text)
+	}
+	if reply = us.step(ctx, session, "0"); reply.String() != "CON Main\n1. List" {
+		t.Errorf("expected Back to return to the main menu, got %q", reply.String())
+	}
+
+	us.step(ctx, session, "1")
+	us.step(ctx, session, "00")
+	if reply = us.step(ctx, session, "2"); !reply.End || reply.Text != "Picked 7" {
+		t.Errorf("expected the second option of page two to pick item 7, got %+v", reply)
+	}
+}
+
+func TestUSSDPurchase(t *testing.T) {
+	setupInventoryTest(t)
+	config.AppConfig.Features.EnableUSSD = true
+	config.AppConfig.USSD.SessionTimeout = 300
+	ctx := context.Background()
+
+	phone := "+233200000000"
+	if _, err := utils.GetCollection("users").InsertOne(ctx, models.User{Phone: phone, CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("failed to insert user: %v", err)
+	}
+	// Six events fill more than one page; the test event is the last
+	for i := 0; i < ussdPageSize; i++ {
+		utils.GetCollection("events").InsertOne(ctx, models.Event{
+			Title:      fmt.Sprintf("Earlier Event %d", i),
+			Date:       time.Now().AddDate(0, 0, i+1),
+			MaxTickets: 10,
+			Status:     "active",
+		})
+	}
+	eventID := insertTestEvent(t, 10)
+
+	fake := newFakeMoMo(t)
+	us := NewUSSDService()
+	us.providers = NewPaymentProviders(NewMoMoProvider(fake.service()))
+	steps := []struct {
+		text, expected string
+	}{
+		{"", "CON Welcome to EventTix"},
+		{"2", "00. More"},
+		{"2*00", "1. Concurrency Test Event"},
+		{"2*00*1", "Enter 1 to 10"},
+		{"2*00*1*11", "CON Invalid option"},
+		{"2*00*1*11*2", "Total: GHS 20.00"},
+		{"2*00*1*11*2*1", "END Approve the payment prompt on your phone"},
+		{"2*00*1*11*2*1", "END Approve the payment prompt on your phone"}, // a retry buys nothing more
+	}
+	for _, step := range steps {
+		reply, err := us.Handle(ctx, USSDInput{SessionID: "session-1", PhoneNumber: phone, Text: step.text})
+		if err != nil {
+			t.Fatalf("text %q: %v", step.text, err)
+		}
+		if !strings.Contains(reply.String(), step.expected) {
+			t.Fatalf("text %q: expected %q in %q", step.text, step.expected, reply.String())
+		}
+	}
+
+	var event models.Event
+	utils.GetCollection("events").FindOne(ctx, bson.M{"_id": eventID}).Decode(&event)
+	if event.SoldTickets != 2 {
+		t.Errorf("expected 2 tickets sold, got %d", event.SoldTickets)
+	}
+
+	// The sale waits for the caller to approve the request MoMo was sent
+	var payment models.Payment
+	if err := utils.GetCollection("payments").FindOne(ctx, bson.M{"event_id": eventID}).Decode(&payment); err != nil {
+		t.Fatalf("expected a payment: %v", err)
+	}
+	if payment.Status != "pending" || payment.MoMoRef == "" || fake.payments[payment.MoMoRef] == nil {
+		t.Errorf("expected a pending payment sent to MoMo, got %+v", payment)
+	}
+	if count, _ := utils.GetCollection("payments").CountDocuments(ctx, bson.M{}); count != 1 {
+		t.Errorf("expected one payment, got %d", count)
+	}
+
+	var session models.USSDSession
+	if err := utils.GetCollection("ussd_sessions").FindOne(ctx, bson.M{"_id": "session-1"}).Decode(&session); err != nil {
+		t.Fatalf("expected the session to be stored: %v", err)
+	}
+	if !session.Ended || session.ExpiresAt.Before(time.Now().Add(4*time.Minute)) {
+		t.Errorf("expected an ended session kept for the timeout, got %+v", session)
+	}
+}
+
+func TestUSSDLanguageChoice(t *testing.T) {
+	setupInventoryTest(t)
+	config.AppConfig.USSD.SessionTimeout = 300
+	ctx := context.Background()
+	us := NewUSSDService()
+
+	steps := []struct {
+		text, expected string
+	}{
+		{"", "CON Choose your language:\n1. English\n2. Twi"},
+		{"5", "CON Bienvenue sur EventTix"},
+		{"5*9", "CON Option invalide. Veuillez réessayer."},
+	}
+	for _, step := range steps {
+		reply, err := us.Handle(ctx, USSDInput{SessionID: "session-2", PhoneNumber: "+233200000001", Text: step.text})
+		if err != nil {
+			t.Fatalf("text %q: %v", step.text, err)
+		}
+		if !strings.HasPrefix(reply.String(), step.expected) {
+			t.Fatalf("text %q: expected %q, got %q", step.text, step.expected, reply.String())
+		}
+		if strings.Contains(reply.Text, "0. Retour") {
+			t.Errorf("text %q: expected no way back to the language menu, got %q", step.text, reply.Text)
+		}
+	}
+
+	var user models.User
+	if err := utils.GetCollection("users").FindOne(ctx, bson.M{"phone": "+233200000001"}).Decode(&user); err != nil {
+		t.Fatalf("expected an account for the caller: %v", err)
+	}
+	if !user.USSDOnly || user.Language != "fr" {
+		t.Errorf("expected a USSD account in French, got %+v", user)
+	}
+}
+
+func TestUSSDSessionKeepsToItsPhone(t *testing.T) {
+	setupInventoryTest(t)
+	config.AppConfig.USSD.SessionTimeout = 300
+	ctx := context.Background()
+	us := NewUSSDService()
+
+	caller, other := "+233200000002", "+233200000003"
+	if _, err := us.Handle(ctx, USSDInput{SessionID: "session-3", PhoneNumber: caller, Text: ""}); err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+
+	// Another phone cannot continue or end the caller's session
+	if _, err := us.Handle(ctx, USSDInput{SessionID: "session-3", PhoneNumber: other, Text: "1"}); !errors.Is(err, ErrUSSDPhoneMismatch) {
+		t.Errorf("expected ErrUSSDPhoneMismatch, got %v", err)
+	}
+	if _, err := us.Handle(ctx, USSDInput{SessionID: "session-3", PhoneNumber: other, Release: true}); err != nil {
+		t.Fatalf("release failed: %v", err)
+	}
+
+	session, err := us.loadSession(ctx, "session-3")
+	if err != nil || session == nil {
+		t.Fatalf("failed to load session: %+v, %v", session, err)
+	}
+	if session.Ended || session.Text != "" {
+		t.Errorf("expected the session untouched by another phone, got %+v", session)
+	}
+	if _, err := us.Handle(ctx, USSDInput{SessionID: "session-3", PhoneNumber: caller, Text: "9"}); err != nil {
+		t.Errorf("expected the caller to carry on, got %v", err)
+	}
+}
@@ -0,0 +1,374 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"eventticketing/config"
+	"eventticketing/models"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrAppleWalletNotConfigured is returned when Apple Wallet passes are
+	// asked for without a pass type certificate
+	ErrAppleWalletNotConfigured = errors.New("Apple Wallet passes are not configured")
+	// ErrGoogleWalletNotConfigured is returned when Google Wallet passes are
+	// asked for without an issuer and service account
+	ErrGoogleWalletNotConfigured = errors.New("Google Wallet passes are not configured")
+)
+
+// googleWalletSaveURL is where a signed pass JWT is opened to add the pass
+const googleWalletSaveURL = "https://pay.google.com/gp/v/save/"
+
+// Wallet pass colours, matching PDF tickets and emails
+var walletBackground = color.RGBA{R: 39, G: 39, B: 42, A: 255}
+
+// WalletService makes Apple Wallet passes and Google Wallet links for
+// tickets. The signing credentials are read when the service is created;
+// a kind of pass whose credentials are missing or unreadable is not offered.
+type WalletService struct {
+	brand string
+
+	passTypeID string
+	teamID     string
+	appleCert  *x509.Certificate
+	appleKey   crypto.Signer
+	wwdrCert   *x509.Certificate
+
+	googleIssuerID string
+	googleEmail    string
+	googleKey      *rsa.PrivateKey
+}
+
+func NewWalletService() *WalletService {
+	cfg := config.AppConfig.Passes
+	ws := &WalletService{
+		brand:          cfg.Brand,
+		passTypeID:     cfg.ApplePassTypeID,
+		teamID:         cfg.AppleTeamID,
+		googleIssuerID: cfg.GoogleIssuerID,
+	}
+
+	if cfg.ApplePassTypeID != "" && cfg.AppleTeamID != "" {
+		var err error
+		if ws.appleCert, ws.appleKey, ws.wwdrCert, err = loadApplePassCredentials(cfg); err != nil {
+			log.Printf("Apple Wallet passes disabled: %v", err)
+			ws.appleCert, ws.appleKey, ws.wwdrCert = nil, nil, nil
+		}
+	}
+	if cfg.GoogleIssuerID != "" && cfg.GoogleCredentialsFile != "" {
+		var err error
+		if ws.googleEmail, ws.googleKey, err = loadGoogleWalletCredentials(cfg.GoogleCredentialsFile); err != nil {
+			log.Printf("Google Wallet passes disabled: %v", err)
+		}
+	}
+	return ws
+}
+
+// AppleEnabled reports whether Apple Wallet passes can be made
+func (ws *WalletService) AppleEnabled() bool {
+	return ws.appleCert != nil
+}
+
+// GoogleEnabled reports whether Google Wallet links can be made
+func (ws *WalletService) GoogleEnabled() bool {
+	return ws.googleKey != nil
+}
+
+// applePass is pass.json of an Apple Wallet event ticket
+type applePass struct {
+	FormatVersion      int                `json:"formatVersion"`
+	PassTypeIdentifier string             `json:"passTypeIdentifier"`
+	TeamIdentifier     string             `json:"teamIdentifier"`
+	SerialNumber       string             `json:"serialNumber"`
+	OrganizationName   string             `json:"organizationName"`
+	Description        string             `json:"description"`
+	LogoText           string             `json:"logoText"`
+	ForegroundColor    string             `json:"foregroundColor"`
+	BackgroundColor    string             `json:"backgroundColor"`
+	LabelColor         string             `json:"labelColor"`
+	RelevantDate       string             `json:"relevantDate"`
+	Barcode            applePassBarcode   `json:"barcode"` // for iOS 8 and earlier
+	Barcodes           []applePassBarcode `json:"barcodes"`
+	EventTicket        applePassFields    `json:"eventTicket"`
+}
+
+type applePassBarcode struct {
+	Format          string `json:"format"`
+	Message         string `json:"message"`
+	MessageEncoding string `json:"messageEncoding"`
+	AltText         string `json:"altText"`
+}
+
+type applePassFields struct {
+	PrimaryFields   []applePassField `json:"primaryFields"`
+	SecondaryFields []applePassField `json:"secondaryFields"`
+	AuxiliaryFields []applePassField `json:"auxiliaryFields,omitempty"`
+	BackFields      []applePassField `json:"backFields"`
+}
+
+type applePassField struct {
+	Key       string `json:"key"`
+	Label     string `json:"label"`
+	Value     string `json:"value"`
+	DateStyle string `json:"dateStyle,omitempty"`
+	TimeStyle string `json:"timeStyle,omitempty"`
+}
+
+// ApplePass makes the signed .pkpass bundle of a ticket
+func (ws *WalletService) ApplePass(event *models.Event, ticket *models.Ticket) ([]byte, error) {
+	if !ws.AppleEnabled() {
+		return nil, ErrAppleWalletNotConfigured
+	}
+
+	barcode := applePassBarcode{
+		Format:          "PKBarcodeFormatQR",
+		Message:         ticket.TicketCode,
+		MessageEncoding: "iso-8859-1",
+		AltText:         ticket.TicketCode,
+	}
+	fields := applePassFields{
+		PrimaryFields: []applePassField{{Key: "event", Label: "EVENT", Value: event.Title}},
+		SecondaryFields: []applePassField{
+			{Key: "date", Label: "DATE", Value: event.Date.Format(time.RFC3339), DateStyle: "PKDateStyleMedium", TimeStyle: "PKDateStyleShort"},
+			{Key: "location", Label: "LOCATION", Value: event.Location},
+		},
+		BackFields: []applePassField{
+			{Key: "code", Label: "Ticket code", Value: ticket.TicketCode},
+			{Key: "price", Label: "Price", Value: models.FormatAmount(ticket.Price, event.Currency)},
+			{Key: "terms", Label: "Terms", Value: ticketTerms},
+		},
+	}
+	if ticket.TicketTypeName != "" {
+		fields.AuxiliaryFields = append(fields.AuxiliaryFields, applePassField{Key: "type", Label: "TICKET", Value: ticket.TicketTypeName})
+	}
+	if len(ticket.Seats) > 0 {
+		fields.AuxiliaryFields = append(fields.AuxiliaryFields, applePassField{Key: "seats", Label: "SEATS", Value: strings.Join(ticket.SeatLabels(), "; ")})
+	}
+
+	passJSON, err := json.Marshal(applePass{
+		FormatVersion:      1,
+		PassTypeIdentifier: ws.passTypeID,
+		TeamIdentifier:     ws.teamID,
+		SerialNumber:       ticket.ID.Hex(),
+		OrganizationName:   ws.brand,
+		Description:        "Ticket for " + event.Title,
+		LogoText:           ws.brand,
+		ForegroundColor:    "rgb(255, 255, 255)",
+		BackgroundColor:    fmt.Sprintf("rgb(%d, %d, %d)", walletBackground.R, walletBackground.G, walletBackground.B),
+		LabelColor:         "rgb(161, 161, 170)",
+		RelevantDate:       event.Date.Format(time.RFC3339),
+		Barcode:            barcode,
+		Barcodes:           []applePassBarcode{barcode},
+		EventTicket:        fields,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode pass: %w", err)
+	}
+
+	files := map[string][]byte{
+		"pass.json":   passJSON,
+		"icon.png":    walletImage(29, 29),
+		"icon@2x.png": walletImage(58, 58),
+		"logo.png":    walletImage(50, 50),
+		"logo@2x.png": walletImage(100, 100),
+	}
+
+	// The manifest lists every file's SHA-1, and the signature covers the manifest
+	manifest := make(map[string]string, len(files))
+	for name, data := range files {
+		sum := sha1.Sum(data)
+		manifest[name] = hex.EncodeToString(sum[:])
+	}
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode pass manifest: %w", err)
+	}
+	signature, err := signDetachedPKCS7(manifestJSON, ws.appleCert, ws.appleKey, ws.wwdrCert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign pass: %w", err)
+	}
+	files["manifest.json"] = manifestJSON
+	files["signature"] = signature
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, name := range []string{"pass.json", "icon.png", "icon@2x.png", "logo.png", "logo@2x.png", "manifest.json", "signature"} {
+		w, err := archive.Create(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write pass: %w", err)
+		}
+		if _, err := w.Write(files[name]); err != nil {
+			return nil, fmt.Errorf("failed to write pass: %w", err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write pass: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// GoogleWalletURL returns a link that adds a ticket to Google Wallet. The
+// link carries the event class and ticket object in a JWT signed by the
+// service account, so nothing needs creating through the API beforehand.
+func (ws *WalletService) GoogleWalletURL(event *models.Event, ticket *models.Ticket) (string, error) {
+	if !ws.GoogleEnabled() {
+		return "", ErrGoogleWalletNotConfigured
+	}
+
+	text := func(value string) map[string]interface{} {
+		return map[string]interface{}{"defaultValue": map[string]string{"language": "en", "value": value}}
+	}
+	classID := ws.googleIssuerID + "." + event.ID.Hex()
+	eventClass := map[string]interface{}{
+		"id":                 classID,
+		"issuerName":         ws.brand,
+		"reviewStatus":       "UNDER_REVIEW",
+		"eventName":          text(event.Title),
+		"venue":              map[string]interface{}{"name": text(event.Location), "address": text(event.Location)},
+		"dateTime":           map[string]string{"start": event.Date.Format(time.RFC3339)},
+		"hexBackgroundColor": fmt.Sprintf("#%02x%02x%02x", walletBackground.R, walletBackground.G, walletBackground.B),
+	}
+	ticketObject := map[string]interface{}{
+		"id":           ws.googleIssuerID + "." + ticket.ID.Hex(),
+		"classId":      classID,
+		"state":        "ACTIVE",
+		"ticketNumber": ticket.TicketCode,
+		"barcode": map[string]string{
+			"type":          "QR_CODE",
+			"value":         ticket.TicketCode,
+			"alternateText": ticket.TicketCode,
+		},
+		"textModulesData": []map[string]string{{"id": "terms", "header": "Terms", "body": ticketTerms}},
+	}
+	if ticket.TicketTypeName != "" {
+		ticketObject["ticketType"] = text(ticket.TicketTypeName)
+	}
+	if len(ticket.Seats) > 0 {
+		ticketObject["seatInfo"] = map[string]interface{}{"seat": text(strings.Join(ticket.SeatLabels(), "; "))}
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":     ws.googleEmail,
+		"aud":     "google",
+		"typ":     "savetowallet",
+		"iat":     time.Now().Unix(),
+		"origins": []string{},
+		"payload": map[string]interface{}{
+			"eventTicketClasses": []interface{}{eventClass},
+			"eventTicketObjects": []interface{}{ticketObject},
+		},
+	})
+	signed, err := token.SignedString(ws.googleKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign wallet pass: %w", err)
+	}
+	return googleWalletSaveURL + signed, nil
+}
+
+// loadApplePassCredentials reads the pass type certificate, its key and the
+// WWDR intermediate certificate
+func loadApplePassCredentials(cfg config.PassConfig) (*x509.Certificate, crypto.Signer, *x509.Certificate, error) {
+	cert, err := readCertificate(cfg.AppleCertFile)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	wwdr, err := readCertificate(cfg.AppleWWDRCertFile)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	keyPEM, err := os.ReadFile(cfg.AppleKeyFile)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to read pass key: %w", err)
+	}
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return cert, key, wwdr, nil
+}
+
+func readCertificate(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s is not a PEM certificate", path)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// parsePrivateKey reads an RSA key in PKCS#1 or PKCS#8 PEM
+func parsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("private key is not PEM")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// loadGoogleWalletCredentials reads a service account JSON key
+func loadGoogleWalletCredentials(path string) (string, *rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read service account key: %w", err)
+	}
+	var account struct {
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+	}
+	if err := json.Unmarshal(data, &account); err != nil || account.ClientEmail == "" {
+		return "", nil, errors.New("service account key must have client_email and private_key")
+	}
+	key, err := parsePrivateKey([]byte(account.PrivateKey))
+	if err != nil {
+		return "", nil, err
+	}
+	return account.ClientEmail, key, nil
+}
+
+// walletImage is a plain image in the pass colour, for the icon and logo
+// every pass must have
+func walletImage(width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, walletBackground)
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
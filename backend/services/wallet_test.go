@@ -0,0 +1,277 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"eventticketing/config"
+	"eventticketing/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// writeTestCertificate writes a self-signed certificate for key to dir and
+// returns its path
+func writeTestCertificate(t *testing.T, dir, name string, key *rsa.PrivateKey) string {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	path := filepath.Join(dir, name+".pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// setupWalletTest configures both kinds of wallet pass with throwaway keys
+// and returns the pass signing key and the Google service account key
+func setupWalletTest(t *testing.T) (*rsa.PrivateKey, *rsa.PrivateKey) {
+	t.Helper()
+	dir := t.TempDir()
+	passKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wwdrKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	googleKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyPath := filepath.Join(dir, "pass.key")
+	keyDER, _ := x509.MarshalPKCS8PrivateKey(passKey)
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	accountPath := filepath.Join(dir, "account.json")
+	account, _ := json.Marshal(map[string]string{
+		"client_email": "wallet@eventticketing.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(googleKey)})),
+	})
+	if err := os.WriteFile(accountPath, account, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := config.AppConfig
+	config.AppConfig = &config.Config{Passes: config.PassConfig{
+		Brand:                 "EventTix",
+		ApplePassTypeID:       "pass.com.eventticketing.ticket",
+		AppleTeamID:           "TEAM123456",
+		AppleCertFile:         writeTestCertificate(t, dir, "pass", passKey),
+		AppleKeyFile:          keyPath,
+		AppleWWDRCertFile:     writeTestCertificate(t, dir, "wwdr", wwdrKey),
+		GoogleIssuerID:        "3388000000012345678",
+		GoogleCredentialsFile: accountPath,
+	}}
+	t.Cleanup(func() { config.AppConfig = previous })
+	return passKey, googleKey
+}
+
+func walletTestTicket() (*models.Event, *models.Ticket) {
+	event := &models.Event{ID: primitive.NewObjectID(), Title: "Highlife Night", Location: "Accra", Currency: "GHS", Date: time.Now().Add(24 * time.Hour)}
+	ticket := &models.Ticket{
+		ID:             primitive.NewObjectID(),
+		EventID:        event.ID,
+		TicketCode:     "TKT0001",
+		Price:          5000,
+		TicketTypeName: "VIP",
+		Seats:          []models.TicketSeat{{Label: "Row A, Seat 1"}},
+	}
+	return event, ticket
+}
+
+func TestApplePass(t *testing.T) {
+	passKey, _ := setupWalletTest(t)
+	event, ticket := walletTestTicket()
+
+	ws := NewWalletService()
+	if !ws.AppleEnabled() {
+		t.Fatal("expected Apple Wallet passes to be enabled")
+	}
+	pkpass, err := ws.ApplePass(event, ticket)
+	if err != nil {
+		t.Fatalf("failed to make pass: %v", err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(pkpass), int64(len(pkpass)))
+	if err != nil {
+		t.Fatalf("pass is not a zip: %v", err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], _ = io.ReadAll(r)
+		r.Close()
+	}
+
+	var pass applePass
+	if err := json.Unmarshal(files["pass.json"], &pass); err != nil {
+		t.Fatalf("failed to decode pass.json: %v", err)
+	}
+	if pass.PassTypeIdentifier != "pass.com.eventticketing.ticket" || pass.TeamIdentifier != "TEAM123456" || pass.SerialNumber != ticket.ID.Hex() {
+		t.Errorf("unexpected pass identifiers: %+v", pass)
+	}
+	if len(pass.Barcodes) != 1 || pass.Barcodes[0].Message != "TKT0001" || pass.Barcodes[0].Format != "PKBarcodeFormatQR" {
+		t.Errorf("unexpected barcodes: %+v", pass.Barcodes)
+	}
+	if len(pass.EventTicket.AuxiliaryFields) != 2 || pass.EventTicket.AuxiliaryFields[1].Value != "Row A, Seat 1" {
+		t.Errorf("expected ticket type and seats, got %+v", pass.EventTicket.AuxiliaryFields)
+	}
+
+	// Every file but the manifest and signature must be listed with its hash
+	var manifest map[string]string
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		t.Fatalf("failed to decode manifest.json: %v", err)
+	}
+	if len(manifest) != len(files)-2 {
+		t.Errorf("expected %d files in the manifest, got %d", len(files)-2, len(manifest))
+	}
+	for name, hash := range manifest {
+		sum := sha1.Sum(files[name])
+		if hex.EncodeToString(sum[:]) != hash {
+			t.Errorf("manifest hash of %s does not match", name)
+		}
+	}
+
+	verifyPKCS7(t, files["signature"], files["manifest.json"], &passKey.PublicKey)
+}
+
+// verifyPKCS7 checks a detached signature made by signDetachedPKCS7
+func verifyPKCS7(t *testing.T, signature, content []byte, key *rsa.PublicKey) {
+	t.Helper()
+	var info pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(signature, &info); err != nil || !info.ContentType.Equal(oidSignedData) {
+		t.Fatalf("signature is not PKCS#7 signed data: %v", err)
+	}
+	var signed pkcs7SignedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &signed); err != nil {
+		t.Fatalf("failed to decode signed data: %v", err)
+	}
+	if len(signed.SignerInfos) != 1 {
+		t.Fatalf("expected one signer, got %d", len(signed.SignerInfos))
+	}
+	certs, err := x509.ParseCertificates(signed.Certificates.Bytes)
+	if err != nil || len(certs) != 2 {
+		t.Fatalf("expected the pass and WWDR certificates, got %d: %v", len(certs), err)
+	}
+	signer := signed.SignerInfos[0]
+	if signer.IssuerAndSerialNumber.SerialNumber.Cmp(certs[0].SerialNumber) != 0 {
+		t.Error("signer does not identify the pass certificate")
+	}
+
+	// The attributes are signed as a SET and must carry the content's digest
+	attributes := signer.AuthenticatedAttributes.Bytes
+	set, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: attributes})
+	setDigest := sha256.Sum256(set)
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, setDigest[:], signer.EncryptedDigest); err != nil {
+		t.Errorf("signature does not verify: %v", err)
+	}
+	digest := sha256.Sum256(content)
+	found := false
+	for rest := attributes; len(rest) > 0; {
+		var attribute pkcs7Attribute
+		rest, err = asn1.Unmarshal(rest, &attribute)
+		if err != nil {
+			t.Fatalf("failed to decode attributes: %v", err)
+		}
+		if attribute.Type.Equal(oidAttributeMessageDigest) {
+			var value []byte
+			asn1.Unmarshal(attribute.Values.Bytes, &value)
+			found = bytes.Equal(value, digest[:])
+		}
+	}
+	if !found {
+		t.Error("expected the content's digest in the signed attributes")
+	}
+}
+
+func TestGoogleWalletURL(t *testing.T) {
+	_, googleKey := setupWalletTest(t)
+	event, ticket := walletTestTicket()
+
+	ws := NewWalletService()
+	url, err := ws.GoogleWalletURL(event, ticket)
+	if err != nil {
+		t.Fatalf("failed to make link: %v", err)
+	}
+	if !strings.HasPrefix(url, googleWalletSaveURL) {
+		t.Fatalf("unexpected link %s", url)
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(strings.TrimPrefix(url, googleWalletSaveURL), claims, func(*jwt.Token) (interface{}, error) {
+		return &googleKey.PublicKey, nil
+	}, jwt.WithValidMethods([]string{"RS256"}))
+	if err != nil {
+		t.Fatalf("link does not carry a valid JWT: %v", err)
+	}
+	if claims["typ"] != "savetowallet" || claims["aud"] != "google" || claims["iss"] != "wallet@eventticketing.iam.gserviceaccount.com" {
+		t.Errorf("unexpected claims: %v", claims)
+	}
+	payload, _ := claims["payload"].(map[string]interface{})
+	objects, _ := payload["eventTicketObjects"].([]interface{})
+	if len(objects) != 1 {
+		t.Fatalf("expected one ticket object, got %v", payload)
+	}
+	object := objects[0].(map[string]interface{})
+	if object["id"] != "3388000000012345678."+ticket.ID.Hex() || object["classId"] != "3388000000012345678."+event.ID.Hex() {
+		t.Errorf("unexpected ticket object IDs: %v", object)
+	}
+	if barcode := object["barcode"].(map[string]interface{}); barcode["value"] != "TKT0001" {
+		t.Errorf("unexpected barcode: %v", barcode)
+	}
+}
+
+func TestWalletNotConfigured(t *testing.T) {
+	previous := config.AppConfig
+	config.AppConfig = &config.Config{Passes: config.PassConfig{
+		Brand:           "EventTix",
+		ApplePassTypeID: "pass.com.eventticketing.ticket",
+		AppleTeamID:     "TEAM123456",
+		AppleCertFile:   filepath.Join(t.TempDir(), "missing.pem"),
+	}}
+	defer func() { config.AppConfig = previous }()
+	event, ticket := walletTestTicket()
+
+	ws := NewWalletService()
+	if _, err := ws.ApplePass(event, ticket); !errors.Is(err, ErrAppleWalletNotConfigured) {
+		t.Errorf("expected ErrAppleWalletNotConfigured, got %v", err)
+	}
+	if _, err := ws.GoogleWalletURL(event, ticket); !errors.Is(err, ErrGoogleWalletNotConfigured) {
+		t.Errorf("expected ErrGoogleWalletNotConfigured, got %v", err)
+	}
+}
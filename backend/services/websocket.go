@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -8,22 +9,68 @@ import (
 	"sync"
 	"time"
 
+	"eventticketing/config"
+	"eventticketing/models"
+	"eventticketing/utils"
+
 	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// webSocketWriteTimeout bounds how long a message to one client may take
+	webSocketWriteTimeout = 10 * time.Second
+	// webSocketPongTimeout is how long a client may go without answering a ping
+	webSocketPongTimeout = 60 * time.Second
+	// webSocketPingInterval is how often clients are pinged to check they are there
+	webSocketPingInterval = webSocketPongTimeout * 9 / 10
+	// webSocketMaxMessage is the largest message a client may send
+	webSocketMaxMessage = 4096
+	// brokerRetryDelay is how long to wait before resubscribing to the broker
+	brokerRetryDelay = 30 * time.Second
+	// lowTicketFraction is the share of an event's tickets left at which
+	// its organizer is alerted
+	lowTicketFraction = 0.1
 )
 
+// WebSocketService pushes notifications and live event figures to connected
+// clients. Connections are authenticated before they are upgraded, so each
+// client is a known user; organizers can follow their own events and admins
+// any event, and buyers waiting in an event's queue can watch their place.
+//
+// Messages go through a Broker so they reach clients on every instance.
+// Each client has a buffer of messages waiting to be written; a client too
+// slow to keep its buffer from filling is disconnected rather than allowed
+// to hold up everyone else.
 type WebSocketService struct {
-	clients    map[*websocket.Conn]ClientInfo
-	broadcast  chan Message
-	register   chan *websocket.Conn
-	unregister chan *websocket.Conn
-	mutex      sync.RWMutex
+	instance         string
+	broker           Broker
+	sendBuffer       int
+	clients          map[*wsClient]struct{}
+	mutex            sync.RWMutex
+	upgrader         websocket.Upgrader
+	eventCollection  *mongo.Collection
+	ticketCollection *mongo.Collection
+	queueService     *QueueService
 }
 
 type ClientInfo struct {
-	UserID   string `json:"user_id"`
-	Role     string `json:"role"`
-	EventID  string `json:"event_id,omitempty"`
-	JoinedAt time.Time
+	UserID       string `json:"user_id"`
+	Role         string `json:"role"`
+	EventID      string `json:"event_id,omitempty"`
+	QueueEventID string `json:"queue_event_id,omitempty"` // the event whose queue the user is waiting in
+	JoinedAt     time.Time
+}
+
+// wsClient is a connection, the user on it and the messages waiting to be
+// written to it. Only its writer goroutine writes to the connection.
+type wsClient struct {
+	conn          *websocket.Conn
+	info          ClientInfo
+	queuePosition int64 // the user's number in the queue they watch
+	send          chan []byte
 }
 
 type Message struct {
@@ -35,150 +82,413 @@ type Message struct {
 }
 
 type NotificationData struct {
-	Title       string `json:"title"`
-	Message     string `json:"message"`
-	Action      string `json:"action,omitempty"`
-	ActionURL   string `json:"action_url,omitempty"`
-	Priority    string `json:"priority"` // low, medium, high, urgent
-	Category    string `json:"category"` // ticket, payment, event, system
+	Title     string `json:"title"`
+	Message   string `json:"message"`
+	Action    string `json:"action,omitempty"`
+	ActionURL string `json:"action_url,omitempty"`
+	Priority  string `json:"priority"` // low, medium, high, urgent
+	Category  string `json:"category"` // ticket, payment, event, system
 }
 
+// LiveEventData are an event's running totals
 type LiveEventData struct {
-	EventID     string `json:"event_id"`
-	TicketsSold int    `json:"tickets_sold"`
-	Revenue     float64 `json:"revenue"`
-	Attendees   int    `json:"attendees"`
+	EventID     string    `json:"event_id"`
+	TicketsSold int       `json:"tickets_sold"` // paid tickets, including those used
+	Revenue     int64     `json:"revenue"`      // in minor units of Currency
+	Currency    string    `json:"currency"`
+	Attendees   int       `json:"attendees"` // tickets scanned at the door
 	LastUpdated time.Time `json:"last_updated"`
 }
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // In production, implement proper origin checking
-	},
+func NewWebSocketService() *WebSocketService {
+	return newWebSocketService(DefaultBroker(), config.AppConfig.Live.SendBuffer)
 }
 
-func NewWebSocketService() *WebSocketService {
-	return &WebSocketService{
-		clients:    make(map[*websocket.Conn]ClientInfo),
-		broadcast:  make(chan Message),
-		register:   make(chan *websocket.Conn),
-		unregister: make(chan *websocket.Conn),
+func newWebSocketService(broker Broker, sendBuffer int) *WebSocketService {
+	ws := &WebSocketService{
+		instance:   primitive.NewObjectID().Hex(),
+		broker:     broker,
+		sendBuffer: sendBuffer,
+		clients:    make(map[*wsClient]struct{}),
+		upgrader:   websocket.Upgrader{CheckOrigin: checkWebSocketOrigin},
+	}
+	// The database is only needed to check who may follow an event or queue
+	if utils.DB != nil {
+		ws.eventCollection = utils.GetCollection("events")
+		ws.ticketCollection = utils.GetCollection("tickets")
+		ws.queueService = NewQueueService(ws)
 	}
+	return ws
 }
 
-func (ws *WebSocketService) Start() {
+// checkWebSocketOrigin accepts browsers on the origins CORS allows. Requests
+// without an Origin header do not come from a browser page, so there is no
+// other site they could be made on behalf of.
+func checkWebSocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range config.AppConfig.CORS.AllowedOrigins {
+		if origin == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// Start receives the messages other instances publish until ctx is
+// cancelled, then disconnects every client. While the broker cannot be
+// reached, clients still get the messages published on this instance.
+func (ws *WebSocketService) Start(ctx context.Context) {
+	failing := false
 	for {
+		subscribed := time.Now()
+		err := ws.broker.Subscribe(ctx, ws.deliverRemote)
+		if ctx.Err() != nil {
+			break
+		}
+		// A broker that fails again as soon as it is retried is reported once
+		if !failing || time.Since(subscribed) > brokerRetryDelay {
+			log.Printf("Live message broker unavailable, retrying every %s: %v", brokerRetryDelay, err)
+		}
+		failing = true
 		select {
-		case client := <-ws.register:
-			ws.mutex.Lock()
-			ws.clients[client] = ClientInfo{JoinedAt: time.Now()}
-			ws.mutex.Unlock()
-			log.Printf("Client connected. Total clients: %d", len(ws.clients))
-
-		case client := <-ws.unregister:
-			ws.mutex.Lock()
-			delete(ws.clients, client)
-			ws.mutex.Unlock()
-			log.Printf("Client disconnected. Total clients: %d", len(ws.clients))
-
-		case message := <-ws.broadcast:
-			ws.mutex.RLock()
-			for client, info := range ws.clients {
-				// Send to specific user if specified
-				if message.UserID != "" && info.UserID != message.UserID {
-					continue
-				}
-				// Send to specific event if specified
-				if message.EventID != "" && info.EventID != message.EventID {
-					continue
-				}
-
-				err := client.WriteJSON(message)
-				if err != nil {
-					log.Printf("Error sending message to client: %v", err)
-					client.Close()
-					delete(ws.clients, client)
-				}
-			}
-			ws.mutex.RUnlock()
+		case <-ctx.Done():
+		case <-time.After(brokerRetryDelay):
+		}
+		if ctx.Err() != nil {
+			break
 		}
 	}
+
+	ws.mutex.RLock()
+	clients := make([]*wsClient, 0, len(ws.clients))
+	for client := range ws.clients {
+		clients = append(clients, client)
+	}
+	ws.mutex.RUnlock()
+	ws.disconnect(clients, websocket.CloseGoingAway, "server shutting down")
 }
 
-func (ws *WebSocketService) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+// HandleWebSocket upgrades an authenticated request and serves the
+// connection for user
+func (ws *WebSocketService) HandleWebSocket(w http.ResponseWriter, r *http.Request, user *models.User) {
+	conn, err := ws.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade failed: %v", err)
 		return
 	}
 
-	// Register client
-	ws.register <- conn
+	client := &wsClient{
+		conn: conn,
+		info: ClientInfo{UserID: user.ID.Hex(), Role: user.Role, JoinedAt: time.Now()},
+		send: make(chan []byte, ws.sendBuffer),
+	}
+	ws.mutex.Lock()
+	ws.clients[client] = struct{}{}
+	count := len(ws.clients)
+	ws.mutex.Unlock()
+	log.Printf("Client connected. Total clients: %d", count)
 
-	// Handle incoming messages
-	go func() {
-		defer func() {
-			ws.unregister <- conn
-			conn.Close()
-		}()
+	go ws.writeLoop(client)
+	go ws.readLoop(client)
+}
 
-		for {
-			var msg map[string]interface{}
-			err := conn.ReadJSON(&msg)
-			if err != nil {
-				log.Printf("Error reading message: %v", err)
-				break
+// writeLoop writes the client's messages, and pings it, until its buffer is
+// closed or a write fails
+func (ws *WebSocketService) writeLoop(client *wsClient) {
+	ticker := time.NewTicker(webSocketPingInterval)
+	defer func() {
+		ticker.Stop()
+		client.conn.Close()
+	}()
+
+	for {
+		select {
+		case payload, ok := <-client.send:
+			client.conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+			if !ok {
+				client.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			if err := client.conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+				ws.remove(client)
+				return
+			}
+		case <-ticker.C:
+			client.conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+			if err := client.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				ws.remove(client)
+				return
 			}
+		}
+	}
+}
+
+// readLoop handles the client's messages until it disconnects
+func (ws *WebSocketService) readLoop(client *wsClient) {
+	defer ws.remove(client)
 
-			// Handle different message types
-			switch msg["type"] {
-			case "auth":
-				ws.handleAuth(conn, msg)
-			case "subscribe_event":
-				ws.handleSubscribeEvent(conn, msg)
-			case "unsubscribe_event":
-				ws.handleUnsubscribeEvent(conn, msg)
-			case "ping":
-				conn.WriteJSON(map[string]string{"type": "pong"})
+	client.conn.SetReadLimit(webSocketMaxMessage)
+	client.conn.SetReadDeadline(time.Now().Add(webSocketPongTimeout))
+	client.conn.SetPongHandler(func(string) error {
+		return client.conn.SetReadDeadline(time.Now().Add(webSocketPongTimeout))
+	})
+
+	for {
+		var msg map[string]interface{}
+		if err := client.conn.ReadJSON(&msg); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("Error reading message: %v", err)
 			}
+			return
 		}
-	}()
+		client.conn.SetReadDeadline(time.Now().Add(webSocketPongTimeout))
+
+		// Handle different message types
+		switch msg["type"] {
+		case "subscribe_event":
+			ws.handleSubscribeEvent(client, msg)
+		case "unsubscribe_event":
+			ws.handleUnsubscribeEvent(client)
+		case "watch_queue":
+			ws.handleWatchQueue(client, msg)
+		case "ping":
+			ws.reply(client, map[string]string{"type": "pong"})
+		}
+	}
 }
 
-func (ws *WebSocketService) handleAuth(conn *websocket.Conn, msg map[string]interface{}) {
+// remove forgets a client and closes its buffer, which ends its writer
+func (ws *WebSocketService) remove(client *wsClient) {
 	ws.mutex.Lock()
 	defer ws.mutex.Unlock()
+	if _, ok := ws.clients[client]; !ok {
+		return
+	}
+	delete(ws.clients, client)
+	close(client.send)
+	log.Printf("Client disconnected. Total clients: %d", len(ws.clients))
+}
+
+// evict disconnects clients whose buffers are full
+func (ws *WebSocketService) evict(clients []*wsClient) {
+	for _, client := range clients {
+		log.Printf("Disconnecting user %s: too slow to receive live updates", client.info.UserID)
+	}
+	ws.disconnect(clients, websocket.CloseTryAgainLater, "too slow")
+}
 
-	if client, exists := ws.clients[conn]; exists {
-		if userID, ok := msg["user_id"].(string); ok {
-			client.UserID = userID
+// disconnect closes connections at once, sending the close frame ahead of
+// any messages still waiting
+func (ws *WebSocketService) disconnect(clients []*wsClient, code int, reason string) {
+	for _, client := range clients {
+		client.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+		client.conn.Close()
+		ws.remove(client)
+	}
+}
+
+// reply sends a message to one client
+func (ws *WebSocketService) reply(client *wsClient, v interface{}) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	ws.mutex.RLock()
+	_, connected := ws.clients[client]
+	full := false
+	if connected {
+		select {
+		case client.send <- payload:
+		default:
+			full = true
 		}
-		if role, ok := msg["role"].(string); ok {
-			client.Role = role
+	}
+	ws.mutex.RUnlock()
+	if full {
+		ws.evict([]*wsClient{client})
+	}
+}
+
+// deliver queues a message for the clients on this instance it is meant for
+func (ws *WebSocketService) deliver(envelope *Envelope) {
+	if envelope.QueueEventID != "" {
+		ws.deliverQueue(envelope)
+		return
+	}
+
+	var slow []*wsClient
+	ws.mutex.RLock()
+	for client := range ws.clients {
+		// Send to specific user if specified
+		if envelope.UserID != "" && client.info.UserID != envelope.UserID {
+			continue
+		}
+		// Send to specific event if specified
+		if envelope.EventID != "" && client.info.EventID != envelope.EventID {
+			continue
+		}
+
+		select {
+		case client.send <- envelope.Payload:
+		default:
+			slow = append(slow, client)
 		}
-		ws.clients[conn] = client
+	}
+	ws.mutex.RUnlock()
+	ws.evict(slow)
+}
+
+// deliverRemote delivers the messages published on other instances; this
+// instance's own were delivered when they were published
+func (ws *WebSocketService) deliverRemote(envelope *Envelope) {
+	if envelope.Instance != ws.instance {
+		ws.deliver(envelope)
 	}
 }
 
-func (ws *WebSocketService) handleSubscribeEvent(conn *websocket.Conn, msg map[string]interface{}) {
+// handleSubscribeEvent follows an event the client's user may watch: their
+// own event for organizers, any event for admins
+func (ws *WebSocketService) handleSubscribeEvent(client *wsClient, msg map[string]interface{}) {
+	eventID, _ := msg["event_id"].(string)
+	if !ws.canWatchEvent(client.info, eventID) {
+		ws.reply(client, map[string]string{"type": "error", "message": "You cannot follow this event"})
+		return
+	}
+
 	ws.mutex.Lock()
-	defer ws.mutex.Unlock()
+	client.info.EventID = eventID
+	ws.mutex.Unlock()
+	ws.reply(client, map[string]string{"type": "subscribed", "event_id": eventID})
+}
 
-	if client, exists := ws.clients[conn]; exists {
-		if eventID, ok := msg["event_id"].(string); ok {
-			client.EventID = eventID
-			ws.clients[conn] = client
+func (ws *WebSocketService) handleUnsubscribeEvent(client *wsClient) {
+	ws.mutex.Lock()
+	client.info.EventID = ""
+	ws.mutex.Unlock()
+}
+
+// handleWatchQueue follows the user's place in an event's queue; they must
+// have joined it first
+func (ws *WebSocketService) handleWatchQueue(client *wsClient, msg map[string]interface{}) {
+	eventID, _ := msg["event_id"].(string)
+	objectID, err := primitive.ObjectIDFromHex(eventID)
+	if err != nil {
+		ws.reply(client, map[string]string{"type": "error", "message": "Invalid event ID"})
+		return
+	}
+	userID, _ := primitive.ObjectIDFromHex(client.info.UserID)
+	position, err := ws.queueService.Status(context.Background(), objectID, userID)
+	if err != nil {
+		ws.reply(client, map[string]string{"type": "error", "message": "You are not in this queue"})
+		return
+	}
+
+	ws.mutex.Lock()
+	client.info.QueueEventID = eventID
+	client.queuePosition = position.Position
+	ws.mutex.Unlock()
+	ws.reply(client, queueMessage(position))
+}
+
+// deliverQueue tells each client watching a queue where its user now
+// stands. Users already let through before the latest batch hear nothing
+// more.
+func (ws *WebSocketService) deliverQueue(envelope *Envelope) {
+	var progress QueueProgress
+	if err := json.Unmarshal(envelope.Payload, &progress); err != nil {
+		log.Printf("Failed to decode queue progress: %v", err)
+		return
+	}
+	now := time.Now()
+
+	var slow []*wsClient
+	ws.mutex.RLock()
+	for client := range ws.clients {
+		if client.info.QueueEventID != envelope.QueueEventID || client.queuePosition <= progress.AdmittedFrom {
+			continue
+		}
+
+		position := progress.waiting(client.queuePosition, now)
+		if client.queuePosition <= progress.Admitted {
+			var err error
+			position, err = admitted(progress.EventID, client.info.UserID, client.queuePosition, progress.ExpiresAt)
+			if err != nil {
+				log.Printf("Failed to admit user %s from the queue: %v", client.info.UserID, err)
+				continue
+			}
+		}
+		payload, err := json.Marshal(queueMessage(position))
+		if err != nil {
+			continue
+		}
+
+		select {
+		case client.send <- payload:
+		default:
+			slow = append(slow, client)
 		}
 	}
+	ws.mutex.RUnlock()
+	ws.evict(slow)
 }
 
-func (ws *WebSocketService) handleUnsubscribeEvent(conn *websocket.Conn, msg map[string]interface{}) {
-	ws.mutex.Lock()
-	defer ws.mutex.Unlock()
+// queueMessage tells a user where they stand in a queue
+func queueMessage(position *QueuePosition) Message {
+	messageType := "queue_position"
+	if position.Status == "admitted" {
+		messageType = "queue_admitted"
+	}
+	return Message{
+		Type:      messageType,
+		Data:      position,
+		EventID:   position.EventID,
+		Timestamp: time.Now(),
+	}
+}
+
+func (ws *WebSocketService) canWatchEvent(info ClientInfo, eventID string) bool {
+	objectID, err := primitive.ObjectIDFromHex(eventID)
+	if err != nil {
+		return false
+	}
+	filter := bson.M{"_id": objectID}
+	switch info.Role {
+	case "admin":
+	case "organizer":
+		organizerID, err := primitive.ObjectIDFromHex(info.UserID)
+		if err != nil {
+			return false
+		}
+		filter["organizer_id"] = organizerID
+	default:
+		return false
+	}
+	count, err := ws.eventCollection.CountDocuments(context.Background(), filter)
+	return err == nil && count > 0
+}
+
+// publish encodes a message and shares it
+func (ws *WebSocketService) publish(message Message) {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Failed to encode live message: %v", err)
+		return
+	}
+	ws.share(&Envelope{Instance: ws.instance, UserID: message.UserID, EventID: message.EventID, Payload: payload})
+}
+
+// share delivers a message to the clients on this instance and hands it to
+// the broker for the others
+func (ws *WebSocketService) share(envelope *Envelope) {
+	ws.deliver(envelope)
 
-	if client, exists := ws.clients[conn]; exists {
-		client.EventID = ""
-		ws.clients[conn] = client
+	ctx, cancel := context.WithTimeout(context.Background(), webSocketWriteTimeout)
+	defer cancel()
+	if err := ws.broker.Publish(ctx, envelope); err != nil {
+		log.Printf("Failed to share live message with other instances: %v", err)
 	}
 }
 
@@ -191,7 +501,7 @@ func (ws *WebSocketService) BroadcastNotification(notification NotificationData,
 		EventID:   eventID,
 		Timestamp: time.Now(),
 	}
-	ws.broadcast <- message
+	ws.publish(message)
 }
 
 // BroadcastLiveEventData sends live event updates to subscribed clients
@@ -202,58 +512,134 @@ func (ws *WebSocketService) BroadcastLiveEventData(eventData LiveEventData) {
 		EventID:   eventData.EventID,
 		Timestamp: time.Now(),
 	}
-	ws.broadcast <- message
+	ws.publish(message)
 }
 
-// BroadcastTicketPurchase sends ticket purchase notification
-func (ws *WebSocketService) BroadcastTicketPurchase(eventID, eventTitle string, ticketCount int, revenue float64) {
-	// Notify event organizers
+// BroadcastQueueProgress tells the users waiting in an event's queue where
+// they now stand, each on the instance they are connected to
+func (ws *WebSocketService) BroadcastQueueProgress(progress *QueueProgress) {
+	payload, err := json.Marshal(progress)
+	if err != nil {
+		log.Printf("Failed to encode queue progress: %v", err)
+		return
+	}
+	ws.share(&Envelope{Instance: ws.instance, QueueEventID: progress.EventID, Payload: payload})
+}
+
+// BroadcastTicketScan tells an event's followers about a scan at its door
+func (ws *WebSocketService) BroadcastTicketScan(scan *TicketScan) {
+	message := Message{
+		Type:      "ticket_scan",
+		Data:      scan,
+		EventID:   scan.EventID,
+		Timestamp: time.Now(),
+	}
+	ws.publish(message)
+}
+
+// BroadcastDoorStats sends an event's followers its figures at the door
+func (ws *WebSocketService) BroadcastDoorStats(stats *DoorStats) {
+	message := Message{
+		Type:      "door_stats",
+		Data:      stats,
+		EventID:   stats.EventID,
+		Timestamp: time.Now(),
+	}
+	ws.publish(message)
+}
+
+// BroadcastTicketPurchase tells an event's followers about a sale, and
+// alerts them when it leaves few tickets
+func (ws *WebSocketService) BroadcastTicketPurchase(event *models.Event, ticketCount int, amount int64, currency string) {
+	eventID := event.ID.Hex()
 	notification := NotificationData{
-		Title:    "New Ticket Purchase",
-		Message:  fmt.Sprintf("%d tickets sold for %s", ticketCount, eventTitle),
-		Action:   "View Details",
+		Title:     "New Ticket Purchase",
+		Message:   fmt.Sprintf("%d tickets sold for %s (%s)", ticketCount, event.Title, models.FormatAmount(amount, currency)),
+		Action:    "View Details",
 		ActionURL: fmt.Sprintf("/events/%s/tickets", eventID),
-		Priority: "medium",
-		Category: "ticket",
+		Priority:  "medium",
+		Category:  "ticket",
 	}
 	ws.BroadcastNotification(notification, "", eventID)
 
-	// Broadcast live event data
-	liveData := LiveEventData{
-		EventID:     eventID,
-		TicketsSold: ticketCount,
-		Revenue:     revenue,
-		LastUpdated: time.Now(),
+	// Alert once, on the sale that crosses the threshold
+	remaining := event.MaxTickets - event.SoldTickets
+	threshold := int(float64(event.MaxTickets) * lowTicketFraction)
+	if remaining <= threshold && remaining+ticketCount > threshold {
+		ws.BroadcastLowTicketAlert(eventID, event.Title, remaining)
 	}
-	ws.BroadcastLiveEventData(liveData)
 }
 
 // BroadcastLowTicketAlert sends low ticket alert to organizers
 func (ws *WebSocketService) BroadcastLowTicketAlert(eventID, eventTitle string, remainingTickets int) {
 	notification := NotificationData{
-		Title:    "Low Ticket Alert",
-		Message:  fmt.Sprintf("Only %d tickets remaining for %s", remainingTickets, eventTitle),
-		Action:   "View Event",
+		Title:     "Low Ticket Alert",
+		Message:   fmt.Sprintf("Only %d tickets remaining for %s", remainingTickets, eventTitle),
+		Action:    "View Event",
 		ActionURL: fmt.Sprintf("/events/%s", eventID),
-		Priority: "high",
-		Category: "event",
+		Priority:  "high",
+		Category:  "event",
 	}
 	ws.BroadcastNotification(notification, "", eventID)
 }
 
 // BroadcastPaymentStatus sends payment status updates
-func (ws *WebSocketService) BroadcastPaymentStatus(userID, eventTitle, status string, amount float64) {
+func (ws *WebSocketService) BroadcastPaymentStatus(userID, eventTitle, status string, amount int64, currency string) {
 	notification := NotificationData{
-		Title:    "Payment " + status,
-		Message:  fmt.Sprintf("Payment of $%.2f for %s has been %s", amount, eventTitle, status),
-		Action:   "View Tickets",
+		Title:     "Payment " + status,
+		Message:   fmt.Sprintf("Payment of %s for %s has been %s", models.FormatAmount(amount, currency), eventTitle, status),
+		Action:    "View Tickets",
 		ActionURL: "/user/tickets",
-		Priority: "medium",
-		Category: "payment",
+		Priority:  "medium",
+		Category:  "payment",
 	}
 	ws.BroadcastNotification(notification, userID, "")
 }
 
+// PublishEventStats sends an event's followers its current totals
+func (ws *WebSocketService) PublishEventStats(ctx context.Context, event *models.Event) error {
+	stats, err := ws.EventStats(ctx, event)
+	if err != nil {
+		return err
+	}
+	ws.BroadcastLiveEventData(*stats)
+	return nil
+}
+
+// EventStats totals an event's paid tickets, revenue and attendance
+func (ws *WebSocketService) EventStats(ctx context.Context, event *models.Event) (*LiveEventData, error) {
+	cursor, err := ws.ticketCollection.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"event_id": event.ID, "status": bson.M{"$in": []string{"paid", "used"}}}},
+		{"$group": bson.M{
+			"_id":       nil,
+			"sold":      bson.M{"$sum": 1},
+			"revenue":   bson.M{"$sum": "$price"},
+			"attendees": bson.M{"$sum": bson.M{"$cond": []interface{}{bson.M{"$eq": []string{"$status", "used"}}, 1, 0}}},
+		}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to total event tickets: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var totals []struct {
+		Sold      int   `bson:"sold"`
+		Revenue   int64 `bson:"revenue"`
+		Attendees int   `bson:"attendees"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return nil, fmt.Errorf("failed to decode event totals: %w", err)
+	}
+
+	stats := &LiveEventData{EventID: event.ID.Hex(), Currency: event.Currency, LastUpdated: time.Now()}
+	if len(totals) > 0 {
+		stats.TicketsSold = totals[0].Sold
+		stats.Revenue = totals[0].Revenue
+		stats.Attendees = totals[0].Attendees
+	}
+	return stats, nil
+}
+
 // GetConnectedClientsCount returns the number of connected clients
 func (ws *WebSocketService) GetConnectedClientsCount() int {
 	ws.mutex.RLock()
@@ -265,12 +651,12 @@ func (ws *WebSocketService) GetConnectedClientsCount() int {
 func (ws *WebSocketService) GetClientsByEvent(eventID string) []ClientInfo {
 	ws.mutex.RLock()
 	defer ws.mutex.RUnlock()
-	
+
 	var clients []ClientInfo
-	for _, client := range ws.clients {
-		if client.EventID == eventID {
-			clients = append(clients, client)
+	for client := range ws.clients {
+		if client.info.EventID == eventID {
+			clients = append(clients, client.info)
 		}
 	}
 	return clients
-} 
\ No newline at end of file
+}
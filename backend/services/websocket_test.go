@@ -0,0 +1,265 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"eventticketing/config"
+	"eventticketing/models"
+	"eventticketing/utils"
+
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCheckWebSocketOrigin(t *testing.T) {
+	previous := config.AppConfig
+	config.AppConfig = &config.Config{CORS: config.CORSConfig{AllowedOrigins: []string{"https://tickets.example.com"}}}
+	defer func() { config.AppConfig = previous }()
+
+	cases := map[string]bool{
+		"":                             true, // not a browser
+		"https://tickets.example.com":  true,
+		"https://evil.example.com":     false,
+		"http://tickets.example.com":   false,
+		"https://tickets.example.com.": false,
+	}
+	for origin, want := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if got := checkWebSocketOrigin(r); got != want {
+			t.Errorf("checkWebSocketOrigin(%q) = %v, want %v", origin, got, want)
+		}
+	}
+}
+
+// startWebSocketTest runs ws behind a server that connects as the user
+// whose ID and role are in the query, in place of the JWT middleware
+func startWebSocketTest(t *testing.T, ws *WebSocketService) string {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	go ws.Start(ctx)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := primitive.ObjectIDFromHex(r.URL.Query().Get("user"))
+		ws.HandleWebSocket(w, r, &models.User{ID: userID, Role: r.URL.Query().Get("role")})
+	}))
+	t.Cleanup(func() {
+		cancel()
+		server.Close()
+	})
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func dialWebSocket(t *testing.T, url string, user *models.User) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?user="+user.ID.Hex()+"&role="+user.Role, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readWebSocket(t *testing.T, conn *websocket.Conn) map[string]interface{} {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg map[string]interface{}
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("failed to read message: %v", err)
+	}
+	return msg
+}
+
+func waitForClients(t *testing.T, ws *WebSocketService, count int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for ws.GetConnectedClientsCount() != count {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d clients, got %d", count, ws.GetConnectedClientsCount())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebSocketDelivery(t *testing.T) {
+	ws := newWebSocketService(NewMemoryBroker(), 16)
+	url := startWebSocketTest(t, ws)
+
+	alice := &models.User{ID: primitive.NewObjectID(), Role: "user"}
+	bob := &models.User{ID: primitive.NewObjectID(), Role: "user"}
+	aliceConn := dialWebSocket(t, url, alice)
+	bobConn := dialWebSocket(t, url, bob)
+	waitForClients(t, ws, 2)
+
+	// A notification for one user reaches only them
+	ws.BroadcastPaymentStatus(alice.ID.Hex(), "Highlife Night", "successful", 5000, "GHS")
+	ws.BroadcastNotification(NotificationData{Title: "Everyone"}, "", "")
+	if msg := readWebSocket(t, aliceConn); msg["user_id"] != alice.ID.Hex() {
+		t.Errorf("expected Alice's payment first, got %v", msg)
+	}
+	readWebSocket(t, aliceConn)
+	msg := readWebSocket(t, bobConn)
+	if data, _ := msg["data"].(map[string]interface{}); data["title"] != "Everyone" {
+		t.Errorf("expected Bob to get only the notification for everyone, got %v", msg)
+	}
+
+	// Customers cannot follow an event's sales
+	aliceConn.WriteJSON(map[string]string{"type": "subscribe_event", "event_id": primitive.NewObjectID().Hex()})
+	if msg := readWebSocket(t, aliceConn); msg["type"] != "error" {
+		t.Errorf("expected the subscription to be refused, got %v", msg)
+	}
+
+	aliceConn.WriteJSON(map[string]string{"type": "ping"})
+	if msg := readWebSocket(t, aliceConn); msg["type"] != "pong" {
+		t.Errorf("expected pong, got %v", msg)
+	}
+
+	bobConn.Close()
+	waitForClients(t, ws, 1)
+}
+
+func TestWebSocketFanOut(t *testing.T) {
+	// Two instances behind a load balancer, sharing a broker
+	broker := NewMemoryBroker()
+	first := newWebSocketService(broker, 16)
+	second := newWebSocketService(broker, 16)
+	firstURL := startWebSocketTest(t, first)
+	secondURL := startWebSocketTest(t, second)
+
+	user := &models.User{ID: primitive.NewObjectID(), Role: "user"}
+	onFirst := dialWebSocket(t, firstURL, user)
+	onSecond := dialWebSocket(t, secondURL, user)
+	waitForClients(t, first, 1)
+	waitForClients(t, second, 1)
+	waitForSubscribers(t, broker, 2)
+
+	first.BroadcastNotification(NotificationData{Title: "One"}, user.ID.Hex(), "")
+	second.BroadcastNotification(NotificationData{Title: "Two"}, user.ID.Hex(), "")
+
+	// Each message arrives once on each instance, whichever published it
+	for _, conn := range []*websocket.Conn{onFirst, onSecond} {
+		for _, want := range []string{"One", "Two"} {
+			msg := readWebSocket(t, conn)
+			if data, _ := msg["data"].(map[string]interface{}); data["title"] != want {
+				t.Errorf("expected %q, got %v", want, msg)
+			}
+		}
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if _, _, err := conn.ReadMessage(); err == nil {
+			t.Error("expected no more messages")
+		}
+	}
+}
+
+func waitForSubscribers(t *testing.T, broker *MemoryBroker, count int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		broker.mutex.RLock()
+		subscribers := len(broker.subscribers)
+		broker.mutex.RUnlock()
+		if subscribers == count {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d subscribers, got %d", count, subscribers)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebSocketSlowConsumer(t *testing.T) {
+	ws := newWebSocketService(NewMemoryBroker(), 2)
+
+	// A client whose messages are never written, as if its network stalled
+	upgraded := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := ws.upgrader.Upgrade(w, r, nil)
+		if err == nil {
+			upgraded <- conn
+		}
+	}))
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	slow := &wsClient{conn: <-upgraded, send: make(chan []byte, 2)}
+	ws.clients[slow] = struct{}{}
+
+	for i := 0; i < 2; i++ {
+		ws.BroadcastNotification(NotificationData{Title: "Queued"}, "", "")
+	}
+	if ws.GetConnectedClientsCount() != 1 {
+		t.Fatal("expected the client to stay while its buffer has room")
+	}
+	ws.BroadcastNotification(NotificationData{Title: "Overflow"}, "", "")
+	if ws.GetConnectedClientsCount() != 0 {
+		t.Fatal("expected the client to be dropped once its buffer is full")
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseTryAgainLater) {
+		t.Errorf("expected to be told to reconnect later, got %v", err)
+	}
+}
+
+func TestWebSocketEventStats(t *testing.T) {
+	setupInventoryTest(t)
+	ctx := context.Background()
+	eventID := insertTestEvent(t, 100)
+	event := loadTestEvent(t, eventID)
+	organizerID := primitive.NewObjectID()
+	utils.GetCollection("events").UpdateOne(ctx, bson.M{"_id": eventID}, bson.M{"$set": bson.M{"organizer_id": organizerID}})
+
+	for _, status := range []string{"paid", "paid", "used", "pending", "cancelled"} {
+		utils.GetCollection("tickets").InsertOne(ctx, models.Ticket{
+			ID:      primitive.NewObjectID(),
+			EventID: eventID,
+			UserID:  primitive.NewObjectID(),
+			Price:   2500,
+			Status:  status,
+		})
+	}
+
+	ws := NewWebSocketService()
+	url := startWebSocketTest(t, ws)
+	organizer := dialWebSocket(t, url, &models.User{ID: organizerID, Role: "organizer"})
+	other := dialWebSocket(t, url, &models.User{ID: primitive.NewObjectID(), Role: "organizer"})
+	admin := dialWebSocket(t, url, &models.User{ID: primitive.NewObjectID(), Role: "admin"})
+
+	// Organizers follow only their own events; admins any event
+	for _, conn := range []*websocket.Conn{organizer, other, admin} {
+		conn.WriteJSON(map[string]string{"type": "subscribe_event", "event_id": eventID.Hex()})
+	}
+	if msg := readWebSocket(t, organizer); msg["type"] != "subscribed" {
+		t.Fatalf("expected the organizer to follow their event, got %v", msg)
+	}
+	if msg := readWebSocket(t, other); msg["type"] != "error" {
+		t.Errorf("expected another organizer to be refused, got %v", msg)
+	}
+	if msg := readWebSocket(t, admin); msg["type"] != "subscribed" {
+		t.Fatalf("expected an admin to follow any event, got %v", msg)
+	}
+
+	if err := ws.PublishEventStats(ctx, &event); err != nil {
+		t.Fatalf("failed to publish stats: %v", err)
+	}
+	for _, conn := range []*websocket.Conn{organizer, admin} {
+		msg := readWebSocket(t, conn)
+		data, _ := msg["data"].(map[string]interface{})
+		if msg["type"] != "live_event_update" || data["tickets_sold"] != float64(3) || data["revenue"] != float64(7500) || data["attendees"] != float64(1) {
+			t.Errorf("unexpected live update: %v", msg)
+		}
+	}
+}
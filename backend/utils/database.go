@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"eventticketing/config"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -59,11 +60,18 @@ func CreateIndexes() {
 
 	// User indexes
 	userCollection := GetCollection("users")
+	// Accounts created over USSD have no email, so emails are only unique
+	// when set. The earlier index on every email is replaced; dropping it
+	// fails harmlessly once it is gone.
+	userCollection.Indexes().DropOne(ctx, "email_1")
 	_, err := userCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
 		Keys: map[string]interface{}{
 			"email": 1,
 		},
-		Options: options.Index().SetUnique(true),
+		Options: options.Index().
+			SetName("email_unique").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"email": bson.M{"$gt": ""}}),
 	})
 	if err != nil {
 		log.Println("Error creating user email index:", err)
@@ -138,6 +146,81 @@ func CreateIndexes() {
 		log.Println("Error creating ticket event index:", err)
 	}
 
+	_, err = ticketCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: map[string]interface{}{
+			"order_id": 1,
+		},
+	})
+	if err != nil {
+		log.Println("Error creating ticket order index:", err)
+	}
+
+	// Lets the hold sweeper find tickets whose order was closed without them
+	_, err = ticketCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "status", Value: 1},
+			{Key: "expires_at", Value: 1},
+		},
+	})
+	if err != nil {
+		log.Println("Error creating ticket hold expiry index:", err)
+	}
+
+	_, err = ticketCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: map[string]interface{}{
+			"release_due": 1,
+		},
+		Options: options.Index().SetSparse(true),
+	})
+	if err != nil {
+		log.Println("Error creating ticket release index:", err)
+	}
+
+	// Order indexes
+	orderCollection := GetCollection("orders")
+	_, err = orderCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: map[string]interface{}{
+			"order_number": 1,
+		},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Println("Error creating order number index:", err)
+	}
+
+	// Each queue admission places one order; orders placed without one have none
+	_, err = orderCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: map[string]interface{}{
+			"admission_id": 1,
+		},
+		Options: options.Index().
+			SetName("admission_id_unique").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"admission_id": bson.M{"$gt": ""}}),
+	})
+	if err != nil {
+		log.Println("Error creating order admission index:", err)
+	}
+
+	_, err = orderCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: map[string]interface{}{
+			"user_id": 1,
+		},
+	})
+	if err != nil {
+		log.Println("Error creating order user index:", err)
+	}
+
+	_, err = orderCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "status", Value: 1},
+			{Key: "expires_at", Value: 1},
+		},
+	})
+	if err != nil {
+		log.Println("Error creating order hold expiry index:", err)
+	}
+
 	// Payment indexes
 	paymentCollection := GetCollection("payments")
 	_, err = paymentCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
@@ -149,15 +232,322 @@ func CreateIndexes() {
 		log.Println("Error creating payment user index:", err)
 	}
 
+	_, err = paymentCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: map[string]interface{}{
+			"order_id": 1,
+		},
+	})
+	if err != nil {
+		log.Println("Error creating payment order index:", err)
+	}
+
+	_, err = paymentCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "status", Value: 1},
+			{Key: "created_at", Value: 1},
+		},
+	})
+	if err != nil {
+		log.Println("Error creating payment reconciliation index:", err)
+	}
+
+	// Payments with providers that keep no reference have none, so references
+	// are only unique when set. The earlier index on every reference is
+	// replaced; dropping it fails harmlessly once it is gone.
+	paymentCollection.Indexes().DropOne(ctx, "momo_ref_1")
 	_, err = paymentCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
 		Keys: map[string]interface{}{
 			"momo_ref": 1,
 		},
-		Options: options.Index().SetUnique(true),
+		Options: options.Index().
+			SetName("momo_ref_unique").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"momo_ref": bson.M{"$gt": ""}}),
 	})
 	if err != nil {
 		log.Println("Error creating payment momo_ref index:", err)
 	}
 
+	// Refund indexes
+	refundCollection := GetCollection("refunds")
+	_, err = refundCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: map[string]interface{}{
+			"idempotency_key": 1,
+		},
+		// Repeated refund requests resolve to the same refund
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Println("Error creating refund idempotency index:", err)
+	}
+
+	_, err = refundCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: map[string]interface{}{
+			"status": 1,
+		},
+	})
+	if err != nil {
+		log.Println("Error creating refund status index:", err)
+	}
+
+	// Callback audit log indexes
+	callbackLogCollection := GetCollection("callback_logs")
+	_, err = callbackLogCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "outcome", Value: 1},
+			{Key: "received_at", Value: -1},
+		},
+	})
+	if err != nil {
+		log.Println("Error creating callback log index:", err)
+	}
+
+	// Nonces only need to outlive the callback timestamp tolerance (5 minutes)
+	_, err = GetCollection("callback_nonces").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: map[string]interface{}{
+			"created_at": 1,
+		},
+		Options: options.Index().SetExpireAfterSeconds(15 * 60),
+	})
+	if err != nil {
+		log.Println("Error creating callback nonce expiry index:", err)
+	}
+
+	// Reconciliation report indexes
+	_, err = GetCollection("reconciliation_reports").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: map[string]interface{}{
+			"started_at": -1,
+		},
+	})
+	if err != nil {
+		log.Println("Error creating reconciliation report index:", err)
+	}
+
+	// Seat lock indexes
+	seatLockCollection := GetCollection("seat_locks")
+	_, err = seatLockCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "event_id", Value: 1},
+			{Key: "seat_id", Value: 1},
+		},
+		// One lock per seat is what stops two buyers taking the same seat
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Println("Error creating seat lock index:", err)
+	}
+
+	_, err = seatLockCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: map[string]interface{}{
+			"ticket_id": 1,
+		},
+	})
+	if err != nil {
+		log.Println("Error creating seat lock ticket index:", err)
+	}
+
+	// Venue indexes
+	venueCollection := GetCollection("venues")
+	_, err = venueCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: map[string]interface{}{
+			"organizer_id": 1,
+		},
+	})
+	if err != nil {
+		log.Println("Error creating venue organizer index:", err)
+	}
+
+	// Ledger indexes
+	ledgerCollection := GetCollection("ledger_entries")
+	_, err = ledgerCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: map[string]interface{}{
+			"key": 1,
+		},
+		// Each sale, refund and payout is posted once however often it is reported
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Println("Error creating ledger key index:", err)
+	}
+
+	_, err = ledgerCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "organizer_id", Value: 1},
+			{Key: "currency", Value: 1},
+			{Key: "created_at", Value: 1},
+		},
+	})
+	if err != nil {
+		log.Println("Error creating ledger organizer index:", err)
+	}
+
+	// Payout indexes
+	payoutCollection := GetCollection("payouts")
+	_, err = payoutCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "organizer_id", Value: 1},
+			{Key: "currency", Value: 1},
+		},
+		// One pending payout per organizer and currency, so batches never pay the same balance twice
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"status": "pending"}),
+	})
+	if err != nil {
+		log.Println("Error creating pending payout index:", err)
+	}
+
+	_, err = payoutCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "status", Value: 1},
+			{Key: "created_at", Value: -1},
+		},
+	})
+	if err != nil {
+		log.Println("Error creating payout status index:", err)
+	}
+
+	// Settings history indexes
+	_, err = GetCollection("settings_history").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: map[string]interface{}{
+			"version": -1,
+		},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Println("Error creating settings version index:", err)
+	}
+
+	// USSD sessions are removed once they pass their expiry
+	_, err = GetCollection("ussd_sessions").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: map[string]interface{}{
+			"expires_at": 1,
+		},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		log.Println("Error creating USSD session expiry index:", err)
+	}
+
+	// Phone verification codes are removed once they expire
+	_, err = GetCollection("phone_verifications").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: map[string]interface{}{
+			"expires_at": 1,
+		},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		log.Println("Error creating phone verification expiry index:", err)
+	}
+
+	// Ticket resends are counted per phone for rate limiting and kept a month
+	resendCollection := GetCollection("ticket_resends")
+	_, err = resendCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "phone", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+	if err != nil {
+		log.Println("Error creating ticket resend phone index:", err)
+	}
+
+	_, err = resendCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: map[string]interface{}{
+			"created_at": 1,
+		},
+		Options: options.Index().SetExpireAfterSeconds(30 * 24 * 60 * 60),
+	})
+	if err != nil {
+		log.Println("Error creating ticket resend expiry index:", err)
+	}
+
+	// SMS workers look for due messages; receipts find messages by provider ID
+	smsCollection := GetCollection("sms_messages")
+	_, err = smsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}},
+	})
+	if err != nil {
+		log.Println("Error creating SMS status index:", err)
+	}
+
+	_, err = smsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "provider", Value: 1}, {Key: "provider_message_id", Value: 1}},
+	})
+	if err != nil {
+		log.Println("Error creating SMS provider message index:", err)
+	}
+
+	// Live messages are only needed while instances pass them on
+	_, err = GetCollection("live_messages").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: map[string]interface{}{
+			"created_at": 1,
+		},
+		Options: options.Index().SetExpireAfterSeconds(5 * 60),
+	})
+	if err != nil {
+		log.Println("Error creating live message expiry index:", err)
+	}
+
+	// Door counters are one per event, minute and entrance, and kept a month
+	doorCollection := GetCollection("door_counters")
+	_, err = doorCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "event_id", Value: 1}, {Key: "minute", Value: 1}, {Key: "entrance", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Println("Error creating door counter index:", err)
+	}
+
+	_, err = doorCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: map[string]interface{}{
+			"minute": 1,
+		},
+		Options: options.Index().SetExpireAfterSeconds(30 * 24 * 60 * 60),
+	})
+	if err != nil {
+		log.Println("Error creating door counter expiry index:", err)
+	}
+
+	// Queues are checked for batches due; each buyer has one place per queue
+	_, err = GetCollection("queues").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: map[string]interface{}{
+			"next_batch_at": 1,
+		},
+	})
+	if err != nil {
+		log.Println("Error creating queue batch index:", err)
+	}
+
+	entryCollection := GetCollection("queue_entries")
+	_, err = entryCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "event_id", Value: 1}, {Key: "user_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Println("Error creating queue entry user index:", err)
+	}
+
+	_, err = entryCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "event_id", Value: 1}, {Key: "position", Value: 1}},
+	})
+	if err != nil {
+		log.Println("Error creating queue entry position index:", err)
+	}
+
+	// Dynamically priced events are recalculated on a schedule; their price
+	// history is read newest first
+	_, err = eventCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: map[string]interface{}{
+			"pricing.enabled": 1,
+		},
+		Options: options.Index().SetSparse(true),
+	})
+	if err != nil {
+		log.Println("Error creating event pricing index:", err)
+	}
+
+	_, err = GetCollection("price_history").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "event_id", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+	if err != nil {
+		log.Println("Error creating price history index:", err)
+	}
+
 	log.Println("Database indexes created successfully")
-} 
\ No newline at end of file
+}
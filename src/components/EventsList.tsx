@@ -1,7 +1,7 @@
 import React, { useState, useEffect } from 'react';
 import { useNavigate } from 'react-router-dom';
 import { toast } from 'react-toastify';
-import { eventsAPI, Event } from '../services/api';
+import { eventsAPI, Event, formatAmount } from '../services/api';
 import { Calendar, MapPin, Users, DollarSign, Search, Filter } from 'lucide-react';
 
 const EventsList: React.FC = () => {
@@ -193,7 +193,7 @@ const EventsList: React.FC = () => {
                     <div className="flex items-center">
                       <DollarSign className="h-4 w-4 text-green-600 mr-1" />
                       <span className="text-lg font-bold text-green-600">
-                        ${event.price}
+                        {formatAmount(event.price, event.currency)}
                       </span>
                     </div>
                     
@@ -3,7 +3,7 @@ import { useParams, useNavigate } from 'react-router-dom';
 import { useForm } from 'react-hook-form';
 import { toast } from 'react-toastify';
 import { useAuth } from '../context/AuthContext';
-import { eventsAPI, ticketsAPI, paymentsAPI, Event, Payment } from '../services/api';
+import { eventsAPI, ticketsAPI, paymentsAPI, Event, Payment, formatAmount } from '../services/api';
 import { Calendar, MapPin, Users, DollarSign, CreditCard, Smartphone, QrCode } from 'lucide-react';
 
 interface TicketPurchaseForm {
@@ -187,7 +187,7 @@ const TicketPurchase: React.FC = () => {
                 
                 <div className="flex items-center text-sm text-gray-500">
                   <DollarSign className="h-4 w-4 mr-2" />
-                  ${event.price} per ticket
+                  {formatAmount(event.price, event.currency)} per ticket
                 </div>
               </div>
             </div>
@@ -300,7 +300,7 @@ const TicketPurchase: React.FC = () => {
               <div className="border-t pt-4">
                 <div className="flex justify-between items-center mb-2">
                   <span className="text-sm text-gray-600">Price per ticket:</span>
-                  <span className="text-sm text-gray-900">${event.price}</span>
+                  <span className="text-sm text-gray-900">{formatAmount(event.price, event.currency)}</span>
                 </div>
                 <div className="flex justify-between items-center mb-2">
                   <span className="text-sm text-gray-600">Quantity:</span>
@@ -308,7 +308,7 @@ const TicketPurchase: React.FC = () => {
                 </div>
                 <div className="flex justify-between items-center text-lg font-semibold">
                   <span className="text-gray-900">Total:</span>
-                  <span className="text-green-600">${calculateTotal().toFixed(2)}</span>
+                  <span className="text-green-600">{formatAmount(calculateTotal(), event.currency)}</span>
                 </div>
               </div>
 
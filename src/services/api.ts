@@ -70,6 +70,7 @@ export interface Event {
   date: string;
   location: string;
   price: number;
+  currency: string;
   max_tickets: number;
   sold_tickets: number;
   status: 'active' | 'upcoming' | 'ongoing' | 'completed' | 'cancelled';
@@ -103,6 +104,7 @@ export interface Payment {
   event_id: string;
   ticket_id: string;
   amount: number;
+  currency: string;
   status: 'pending' | 'success' | 'failed' | 'cancelled';
   payment_type: 'momo' | 'ussd';
   momo_ref?: string;
@@ -121,6 +123,7 @@ export interface CreateEventRequest {
   date: string;
   location: string;
   price: number;
+  currency?: string;
   max_tickets: number;
   category: string;
   image_url?: string;
@@ -376,6 +379,15 @@ export const adminAPI = {
 };
 
 // Utility functions
+// Amounts from the API are integers in the currency's minor unit (cents, pesewas)
+const zeroDecimalCurrencies = ['UGX', 'RWF', 'XOF', 'XAF'];
+
+export const formatAmount = (amount: number, currency: string): string => {
+  const digits = zeroDecimalCurrencies.includes(currency) ? 0 : 2;
+  const major = amount / Math.pow(10, digits);
+  return `${currency} ${major.toLocaleString(undefined, { minimumFractionDigits: digits, maximumFractionDigits: digits })}`;
+};
+
 export const setAuthToken = (token: string) => {
   localStorage.setItem('token', token);
 };